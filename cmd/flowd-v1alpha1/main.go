@@ -61,7 +61,7 @@ func main() {
 	if syscfg.Admin.Enabled {
 		var err error
 		logger.Info("starting admin interface on %s", syscfg.Admin.Bind)
-		adminmux, err = webapp.NewHttpMux(context)
+		adminmux, err = webapp.NewHttpMux(context, pipeline)
 		if err != nil {
 			logger.Fatal("failed to initialize admin interface: %s", err)
 		}
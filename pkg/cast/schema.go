@@ -24,6 +24,30 @@ func init() {
 				"__self__": &CfgBlockSystemAdminMapper{},
 				"enabled":  ToBool,
 				"bind":     ToStr,
+				"auth": map[string]Schema{
+					"__self__": &CfgBlockSystemAdminAuthMapper{},
+					"basic":    &ArrStrMapper{},
+					"tokens":   &ArrStrMapper{},
+					"certs":    ToBool,
+					"readonly": &ArrStrMapper{},
+				},
+				"tls": map[string]Schema{
+					"__self__": &CfgBlockSystemAdminTLSMapper{},
+					"cert":     ToStr,
+					"key":      ToStr,
+					"ca":       ToStr,
+				},
+			},
+			"log": map[string]Schema{
+				"__self__": &CfgBlockSystemLogMapper{},
+				"level":    ToStr,
+				"format":   ToStr,
+				"output":   ToStr,
+				"sampling": map[string]Schema{
+					"__self__":   &CfgBlockSystemLogSamplingMapper{},
+					"initial":    ToInt,
+					"thereafter": ToInt,
+				},
 			},
 			"metrics": map[string]Schema{
 				"__self__": &CfgBlockSystemMetricsMapper{},
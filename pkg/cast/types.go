@@ -79,6 +79,7 @@ var _ Mapper = (*CfgBlockSystemMapper)(nil)
 // Lookup keys are:
 // * maxprocs
 // * admin
+// * log
 // * metrics
 // No extra keys are allowed under this section.
 func (*CfgBlockSystemMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
@@ -98,6 +99,10 @@ func (*CfgBlockSystemMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
 			delete(keys, "admin")
 			res.Admin = admin.(types.CfgBlockSystemAdmin)
 		}
+		if log, ok := vmap["log"]; ok {
+			delete(keys, "log")
+			res.Log = log.(types.CfgBlockSystemLog)
+		}
 		if metrics, ok := vmap["metrics"]; ok {
 			delete(keys, "metrics")
 			res.Metrics = metrics.(types.CfgBlockSystemMetrics)
@@ -127,6 +132,8 @@ var _ Mapper = (*CfgBlockSystemAdminMapper)(nil)
 // Lookup keys are:
 // * enabled
 // * bind_addr
+// * auth
+// * tls
 // No extra keys are allowed under this section.
 func (*CfgBlockSystemAdminMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
 	var resKV *types.KeyValue
@@ -145,6 +152,14 @@ func (*CfgBlockSystemAdminMapper) Map(kv *types.KeyValue) (*types.KeyValue, erro
 			delete(keys, "bind")
 			res.Bind = bind.(string)
 		}
+		if auth, ok := vmap["auth"]; ok {
+			delete(keys, "auth")
+			res.Auth = auth.(types.CfgBlockSystemAdminAuth)
+		}
+		if tls, ok := vmap["tls"]; ok {
+			delete(keys, "tls")
+			res.TLS = tls.(types.CfgBlockSystemAdminTLS)
+		}
 		if len(keys) > 0 {
 			err = errUnknownKeys("CfgBlockSystemAdmin", kv, keys)
 		} else {
@@ -161,6 +176,207 @@ func (*CfgBlockSystemAdminMapper) Map(kv *types.KeyValue) (*types.KeyValue, erro
 
 //============================================================================//
 
+// CfgBlockSystemAdminAuthMapper represents a mapper for system.admin.auth
+// config section.
+type CfgBlockSystemAdminAuthMapper struct{}
+
+var _ Mapper = (*CfgBlockSystemAdminAuthMapper)(nil)
+
+// Map converts map[string]Value to types.CfgBlockSystemAdminAuth{} structure.
+// Lookup keys are:
+// * basic
+// * tokens
+// * certs
+// * readonly
+// No extra keys are allowed under this section.
+func (*CfgBlockSystemAdminAuthMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
+	var resKV *types.KeyValue
+	var err error
+	if vmap, ok := kv.Value.(map[string]types.Value); ok {
+		res := types.CfgBlockSystemAdminAuth{}
+		keys := make(map[string]struct{})
+		for k := range vmap {
+			keys[k] = struct{}{}
+		}
+		if basic, ok := vmap["basic"]; ok {
+			delete(keys, "basic")
+			res.Basic = basic.([]string)
+		}
+		if tokens, ok := vmap["tokens"]; ok {
+			delete(keys, "tokens")
+			res.Tokens = tokens.([]string)
+		}
+		if certs, ok := vmap["certs"]; ok {
+			delete(keys, "certs")
+			res.Certs = certs.(bool)
+		}
+		if readonly, ok := vmap["readonly"]; ok {
+			delete(keys, "readonly")
+			res.Readonly = readonly.([]string)
+		}
+		if len(keys) > 0 {
+			err = errUnknownKeys("CfgBlockSystemAdminAuth", kv, keys)
+		} else {
+			resKV = &types.KeyValue{Key: kv.Key, Value: res}
+		}
+	} else {
+		err = errUnknownValType("CfgBlockSystemAdminAuth", kv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resKV, nil
+}
+
+//============================================================================//
+
+// CfgBlockSystemAdminTLSMapper represents a mapper for system.admin.tls
+// config section.
+type CfgBlockSystemAdminTLSMapper struct{}
+
+var _ Mapper = (*CfgBlockSystemAdminTLSMapper)(nil)
+
+// Map converts map[string]Value to types.CfgBlockSystemAdminTLS{} structure.
+// Lookup keys are:
+// * cert
+// * key
+// * ca
+// No extra keys are allowed under this section.
+func (*CfgBlockSystemAdminTLSMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
+	var resKV *types.KeyValue
+	var err error
+	if vmap, ok := kv.Value.(map[string]types.Value); ok {
+		res := types.CfgBlockSystemAdminTLS{}
+		keys := make(map[string]struct{})
+		for k := range vmap {
+			keys[k] = struct{}{}
+		}
+		if cert, ok := vmap["cert"]; ok {
+			delete(keys, "cert")
+			res.Cert = cert.(string)
+		}
+		if key, ok := vmap["key"]; ok {
+			delete(keys, "key")
+			res.Key = key.(string)
+		}
+		if ca, ok := vmap["ca"]; ok {
+			delete(keys, "ca")
+			res.CA = ca.(string)
+		}
+		if len(keys) > 0 {
+			err = errUnknownKeys("CfgBlockSystemAdminTLS", kv, keys)
+		} else {
+			resKV = &types.KeyValue{Key: kv.Key, Value: res}
+		}
+	} else {
+		err = errUnknownValType("CfgBlockSystemAdminTLS", kv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resKV, nil
+}
+
+//============================================================================//
+
+// CfgBlockSystemLogMapper represents a mapper for system.log config section.
+type CfgBlockSystemLogMapper struct{}
+
+var _ Mapper = (*CfgBlockSystemLogMapper)(nil)
+
+// Map converts map[string]Value to types.CfgBlockSystemLog{} structure.
+// Lookup keys are:
+// * level
+// * format
+// * output
+// * sampling
+// No extra keys are allowed under this section.
+func (*CfgBlockSystemLogMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
+	var resKV *types.KeyValue
+	var err error
+	if vmap, ok := kv.Value.(map[string]types.Value); ok {
+		res := types.CfgBlockSystemLog{}
+		keys := make(map[string]struct{})
+		for k := range vmap {
+			keys[k] = struct{}{}
+		}
+		if level, ok := vmap["level"]; ok {
+			delete(keys, "level")
+			res.Level = level.(string)
+		}
+		if format, ok := vmap["format"]; ok {
+			delete(keys, "format")
+			res.Format = format.(string)
+		}
+		if output, ok := vmap["output"]; ok {
+			delete(keys, "output")
+			res.Output = output.(string)
+		}
+		if sampling, ok := vmap["sampling"]; ok {
+			delete(keys, "sampling")
+			res.Sampling = sampling.(types.CfgBlockSystemLogSampling)
+		}
+		if len(keys) > 0 {
+			err = errUnknownKeys("CfgBlockSystemLog", kv, keys)
+		} else {
+			resKV = &types.KeyValue{Key: kv.Key, Value: res}
+		}
+	} else {
+		err = errUnknownValType("CfgBlockSystemLog", kv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resKV, nil
+}
+
+//============================================================================//
+
+// CfgBlockSystemLogSamplingMapper represents a mapper for system.log.sampling
+// config section.
+type CfgBlockSystemLogSamplingMapper struct{}
+
+var _ Mapper = (*CfgBlockSystemLogSamplingMapper)(nil)
+
+// Map converts map[string]Value to types.CfgBlockSystemLogSampling{}
+// structure.
+// Lookup keys are:
+// * initial
+// * thereafter
+// No extra keys are allowed under this section.
+func (*CfgBlockSystemLogSamplingMapper) Map(kv *types.KeyValue) (*types.KeyValue, error) {
+	var resKV *types.KeyValue
+	var err error
+	if vmap, ok := kv.Value.(map[string]types.Value); ok {
+		res := types.CfgBlockSystemLogSampling{}
+		keys := make(map[string]struct{})
+		for k := range vmap {
+			keys[k] = struct{}{}
+		}
+		if initial, ok := vmap["initial"]; ok {
+			delete(keys, "initial")
+			res.Initial = initial.(int)
+		}
+		if thereafter, ok := vmap["thereafter"]; ok {
+			delete(keys, "thereafter")
+			res.Thereafter = thereafter.(int)
+		}
+		if len(keys) > 0 {
+			err = errUnknownKeys("CfgBlockSystemLogSampling", kv, keys)
+		} else {
+			resKV = &types.KeyValue{Key: kv.Key, Value: res}
+		}
+	} else {
+		err = errUnknownValType("CfgBlockSystemLogSampling", kv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resKV, nil
+}
+
+//============================================================================//
+
 // CfgBlockSystemMetricsMapper represents a mapper for system.metrics section.
 type CfgBlockSystemMetricsMapper struct{}
 
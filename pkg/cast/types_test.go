@@ -117,6 +117,11 @@ func TestCfgBlockSystemMapper(t *testing.T) {
 		Bind:    "123.45.67.89",
 		Enabled: true,
 	}
+	log := types.CfgBlockSystemLog{
+		Format: "json",
+		Level:  "info",
+		Output: "stderr",
+	}
 	metrics := types.CfgBlockSystemMetrics{
 		Enabled:  true,
 		Interval: 1e3,
@@ -164,6 +169,16 @@ func TestCfgBlockSystemMapper(t *testing.T) {
 			}},
 			nil,
 		},
+		{
+			"Log defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"log": log,
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystem{
+				Log: log,
+			}},
+			nil,
+		},
 		{
 			"Metrics defined",
 			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
@@ -240,6 +255,18 @@ func TestCfgBlockSystemAdminMapper(t *testing.T) {
 			}},
 			nil,
 		},
+		{
+			"Auth and TLS defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"auth": types.CfgBlockSystemAdminAuth{Tokens: []string{"ops:t0ken"}},
+				"tls":  types.CfgBlockSystemAdminTLS{Cert: "cert.pem", Key: "key.pem"},
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemAdmin{
+				Auth: types.CfgBlockSystemAdminAuth{Tokens: []string{"ops:t0ken"}},
+				TLS:  types.CfgBlockSystemAdminTLS{Cert: "cert.pem", Key: "key.pem"},
+			}},
+			nil,
+		},
 		{
 			"Unknown keys defined",
 			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
@@ -267,6 +294,246 @@ func TestCfgBlockSystemAdminMapper(t *testing.T) {
 	}
 }
 
+func TestCfgBlockSystemAdminAuthMapper(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputKV *types.KeyValue
+		wantKV  *types.KeyValue
+		wantErr error
+	}{
+		{
+			"Empty map",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemAdminAuth{}},
+			nil,
+		},
+		{
+			"Nil-value",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: nil},
+			nil,
+			fmt.Errorf("CfgBlockSystemAdminAuth cast failed for key: %q, val: %#v: unknown value type", types.NewKey("foo"), nil),
+		},
+		{
+			"All defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"basic":    []string{"alice:passw0rd"},
+				"tokens":   []string{"ci:t0ken"},
+				"certs":    true,
+				"readonly": []string{"ci"},
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemAdminAuth{
+				Basic:    []string{"alice:passw0rd"},
+				Tokens:   []string{"ci:t0ken"},
+				Certs:    true,
+				Readonly: []string{"ci"},
+			}},
+			nil,
+		},
+		{
+			"Unknown keys defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"unknown1": "v1",
+			}},
+			nil,
+			fmt.Errorf("CfgBlockSystemAdminAuth cast failed for key: %q: unknown attributes: [%s]", types.NewKey("foo"), "unknown1"),
+		},
+	}
+
+	t.Parallel()
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			mpr := &CfgBlockSystemAdminAuthMapper{}
+			gotKV, gotErr := mpr.Map(testCase.inputKV)
+			if !reflect.DeepEqual(gotErr, testCase.wantErr) {
+				t.Fatalf("Unexpected error: Map(%#v) = _, %s, want: %s", testCase.inputKV, gotErr, testCase.wantErr)
+			}
+			if testCase.wantKV != nil && !reflect.DeepEqual(gotKV, testCase.wantKV) {
+				t.Fatalf("Unexpected value: Map(%#v) = %#v, want: %#v", testCase.inputKV, gotKV, testCase.wantKV)
+			}
+		})
+	}
+}
+
+func TestCfgBlockSystemAdminTLSMapper(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputKV *types.KeyValue
+		wantKV  *types.KeyValue
+		wantErr error
+	}{
+		{
+			"Empty map",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemAdminTLS{}},
+			nil,
+		},
+		{
+			"Nil-value",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: nil},
+			nil,
+			fmt.Errorf("CfgBlockSystemAdminTLS cast failed for key: %q, val: %#v: unknown value type", types.NewKey("foo"), nil),
+		},
+		{
+			"All defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"cert": "cert.pem",
+				"key":  "key.pem",
+				"ca":   "ca.pem",
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemAdminTLS{
+				Cert: "cert.pem",
+				Key:  "key.pem",
+				CA:   "ca.pem",
+			}},
+			nil,
+		},
+		{
+			"Unknown keys defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"unknown1": "v1",
+			}},
+			nil,
+			fmt.Errorf("CfgBlockSystemAdminTLS cast failed for key: %q: unknown attributes: [%s]", types.NewKey("foo"), "unknown1"),
+		},
+	}
+
+	t.Parallel()
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			mpr := &CfgBlockSystemAdminTLSMapper{}
+			gotKV, gotErr := mpr.Map(testCase.inputKV)
+			if !reflect.DeepEqual(gotErr, testCase.wantErr) {
+				t.Fatalf("Unexpected error: Map(%#v) = _, %s, want: %s", testCase.inputKV, gotErr, testCase.wantErr)
+			}
+			if testCase.wantKV != nil && !reflect.DeepEqual(gotKV, testCase.wantKV) {
+				t.Fatalf("Unexpected value: Map(%#v) = %#v, want: %#v", testCase.inputKV, gotKV, testCase.wantKV)
+			}
+		})
+	}
+}
+
+func TestCfgBlockSystemLogMapper(t *testing.T) {
+	sampling := types.CfgBlockSystemLogSampling{Initial: 100, Thereafter: 10}
+
+	tests := []struct {
+		name    string
+		inputKV *types.KeyValue
+		wantKV  *types.KeyValue
+		wantErr error
+	}{
+		{
+			"Empty map",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemLog{}},
+			nil,
+		},
+		{
+			"Nil-value",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: nil},
+			nil,
+			fmt.Errorf("CfgBlockSystemLog cast failed for key: %q, val: %#v: unknown value type", types.NewKey("foo"), nil),
+		},
+		{
+			"All defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"level":    "warn",
+				"format":   "json",
+				"output":   "/var/log/flow.log",
+				"sampling": sampling,
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemLog{
+				Format:   "json",
+				Level:    "warn",
+				Output:   "/var/log/flow.log",
+				Sampling: sampling,
+			}},
+			nil,
+		},
+		{
+			"Unknown keys defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"unknown1": "v1",
+			}},
+			nil,
+			fmt.Errorf("CfgBlockSystemLog cast failed for key: %q: unknown attributes: [%s]", types.NewKey("foo"), "unknown1"),
+		},
+	}
+
+	t.Parallel()
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			mpr := &CfgBlockSystemLogMapper{}
+			gotKV, gotErr := mpr.Map(testCase.inputKV)
+			if !reflect.DeepEqual(gotErr, testCase.wantErr) {
+				t.Fatalf("Unexpected error: Map(%#v) = _, %s, want: %s", testCase.inputKV, gotErr, testCase.wantErr)
+			}
+			if testCase.wantKV != nil && !reflect.DeepEqual(gotKV, testCase.wantKV) {
+				t.Fatalf("Unexpected value: Map(%#v) = %#v, want: %#v", testCase.inputKV, gotKV, testCase.wantKV)
+			}
+		})
+	}
+}
+
+func TestCfgBlockSystemLogSamplingMapper(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputKV *types.KeyValue
+		wantKV  *types.KeyValue
+		wantErr error
+	}{
+		{
+			"Empty map",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemLogSampling{}},
+			nil,
+		},
+		{
+			"Nil-value",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: nil},
+			nil,
+			fmt.Errorf("CfgBlockSystemLogSampling cast failed for key: %q, val: %#v: unknown value type", types.NewKey("foo"), nil),
+		},
+		{
+			"All defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"initial":    100,
+				"thereafter": 10,
+			}},
+			&types.KeyValue{Key: types.NewKey("foo"), Value: types.CfgBlockSystemLogSampling{
+				Initial:    100,
+				Thereafter: 10,
+			}},
+			nil,
+		},
+		{
+			"Unknown keys defined",
+			&types.KeyValue{Key: types.NewKey("foo"), Value: map[string]types.Value{
+				"unknown1": "v1",
+			}},
+			nil,
+			fmt.Errorf("CfgBlockSystemLogSampling cast failed for key: %q: unknown attributes: [%s]", types.NewKey("foo"), "unknown1"),
+		},
+	}
+
+	t.Parallel()
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			mpr := &CfgBlockSystemLogSamplingMapper{}
+			gotKV, gotErr := mpr.Map(testCase.inputKV)
+			if !reflect.DeepEqual(gotErr, testCase.wantErr) {
+				t.Fatalf("Unexpected error: Map(%#v) = _, %s, want: %s", testCase.inputKV, gotErr, testCase.wantErr)
+			}
+			if testCase.wantKV != nil && !reflect.DeepEqual(gotKV, testCase.wantKV) {
+				t.Fatalf("Unexpected value: Map(%#v) = %#v, want: %#v", testCase.inputKV, gotKV, testCase.wantKV)
+			}
+		})
+	}
+}
+
 func TestCfgBlockSystemMetricsMapper(t *testing.T) {
 	rcv := types.CfgBlockSystemMetricsReceiver{
 		Params: map[string]types.Value{"p1": "v1", "p2": 2, "p3": true},
@@ -20,9 +20,12 @@ var (
 
 func init() {
 	defaults = map[string]types.Value{
-		CfgPathKey:     "/etc/flowd/flow-config.yaml",
-		PluginPathKey:  "/etc/flowd/plugins",
-		SystemMaxprocs: 1,
+		CfgPathKey:      "/etc/flowd/flow-config.yaml",
+		PluginPathKey:   "/etc/flowd/plugins",
+		SystemMaxprocs:  1,
+		SystemLogLevel:  "info",
+		SystemLogFormat: "text",
+		SystemLogOutput: "stdout",
 	}
 }
 
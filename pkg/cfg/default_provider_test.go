@@ -26,6 +26,9 @@ func TestDefaultProviderSetUp(t *testing.T) {
 			[]string{
 				"config.path",
 				"plugin.path",
+				"system.log.format",
+				"system.log.level",
+				"system.log.output",
 				"system.maxprocs",
 			},
 		},
@@ -19,6 +19,13 @@ const (
 	PluginPathKey = "plugin.path"
 
 	SystemMaxprocs = "system.maxprocs"
+
+	// SystemLog is the system logger settings block key, the keys below are
+	// it's individual settings.
+	SystemLog       = "system.log"
+	SystemLogLevel  = "system.log.level"
+	SystemLogFormat = "system.log.format"
+	SystemLogOutput = "system.log.output"
 )
 
 // TODO(olegs): implement listener interface
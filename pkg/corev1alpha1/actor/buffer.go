@@ -29,6 +29,7 @@ type Buffer struct {
 }
 
 var _ core.Actor = (*Buffer)(nil)
+var _ core.QueueReporter = (*Buffer)(nil)
 
 func NewBuffer(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
 	return &Buffer{
@@ -42,6 +43,15 @@ func (b *Buffer) Name() string {
 	return b.name
 }
 
+// QueueLen returns the number of messages awaiting a retry or a delivery.
+func (b *Buffer) QueueLen() int {
+	return len(b.queue)
+}
+
+func (b *Buffer) QueueCap() int {
+	return cap(b.queue)
+}
+
 func (b *Buffer) Start() error {
 	return nil
 }
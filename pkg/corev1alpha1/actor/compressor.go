@@ -149,7 +149,7 @@ func (c *Compressor) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range c.queue {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					c.ctx.Logger().Error(err.Error())
+					c.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			c.wg.Done()
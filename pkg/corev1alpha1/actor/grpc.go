@@ -0,0 +1,173 @@
+package actor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// The gRPC receiver implements the following service. There is no protobuf
+// dependency in the tree, messages are encoded and decoded by hand.
+//
+//	syntax = "proto3";
+//	package flow.v1alpha1;
+//
+//	message Message {
+//	  bytes body = 1;
+//	  map<string, string> meta = 2;
+//	}
+//
+//	message Ack {
+//	  // The numeric value of core.MsgStatus
+//	  uint32 status = 1;
+//	}
+//
+//	service Flow {
+//	  rpc Send(Message) returns (Ack);
+//	  rpc Stream(stream Message) returns (stream Ack);
+//	}
+
+const (
+	DefaultGRPCMaxMessageSize = 4 * 1024 * 1024
+	DefaultGRPCMaxInFlight    = 64
+
+	grpcSendPath   = "/flow.v1alpha1.Flow/Send"
+	grpcStreamPath = "/flow.v1alpha1.Flow/Stream"
+
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// grpcError is reported to the client as the call status.
+type grpcError struct {
+	code int
+	text string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("grpc: %s", e.text)
+}
+
+var errProtoMalformed = &grpcError{grpcInvalidArgument, "malformed protobuf message"}
+
+// readGRPCFrame reads a single length-prefixed message. A clean end of the
+// stream is reported as io.EOF.
+func readGRPCFrame(reader io.Reader, maxsize int) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if uint64(size) > uint64(maxsize) {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("message size %d exceeds the limit of %d", size, maxsize)}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return payload, nil
+}
+
+func encodeGRPCFrame(payload []byte) []byte {
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// nextProtoField reads a single field off data and returns its number, wire
+// type, raw value and the remaining bytes. Length-delimited values are
+// returned without the length prefix.
+func nextProtoField(data []byte) (uint64, byte, []byte, []byte, error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 || tag>>3 == 0 {
+		return 0, 0, nil, nil, errProtoMalformed
+	}
+	data = data[n:]
+	num, wiretype := tag>>3, byte(tag&0x7)
+	size := 0
+	switch wiretype {
+	case 0:
+		if _, size = binary.Uvarint(data); size <= 0 {
+			return 0, 0, nil, nil, errProtoMalformed
+		}
+	case 1:
+		size = 8
+	case 2:
+		l, n := binary.Uvarint(data)
+		if n <= 0 || l > uint64(len(data)-n) {
+			return 0, 0, nil, nil, errProtoMalformed
+		}
+		data = data[n:]
+		size = int(l)
+	case 5:
+		size = 4
+	default:
+		return 0, 0, nil, nil, errProtoMalformed
+	}
+	if size > len(data) {
+		return 0, 0, nil, nil, errProtoMalformed
+	}
+	return num, wiretype, data[:size], data[size:], nil
+}
+
+// decodeGRPCMessage decodes flow.v1alpha1.Message, unknown fields are
+// skipped.
+func decodeGRPCMessage(data []byte) ([]byte, map[string]string, error) {
+	var body []byte
+	meta := make(map[string]string)
+	for len(data) > 0 {
+		num, wiretype, val, rest, err := nextProtoField(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = rest
+		if num != 1 && num != 2 {
+			continue
+		}
+		if wiretype != 2 {
+			return nil, nil, errProtoMalformed
+		}
+		if num == 1 {
+			body = val
+			continue
+		}
+		var key, value string
+		for len(val) > 0 {
+			num, wiretype, v, rest, err := nextProtoField(val)
+			if err != nil {
+				return nil, nil, err
+			}
+			val = rest
+			if num != 1 && num != 2 {
+				continue
+			}
+			if wiretype != 2 {
+				return nil, nil, errProtoMalformed
+			}
+			if num == 1 {
+				key = string(v)
+			} else {
+				value = string(v)
+			}
+		}
+		meta[key] = value
+	}
+	return body, meta, nil
+}
+
+// encodeGRPCAck encodes flow.v1alpha1.Ack.
+func encodeGRPCAck(status core.MsgStatus) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = 0x08
+	n := binary.PutUvarint(buf[1:], uint64(status))
+	return buf[:1+n]
+}
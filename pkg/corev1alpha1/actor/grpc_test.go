@@ -0,0 +1,150 @@
+package actor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func appendProtoBytes(buf []byte, num int, val []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(num<<3|2))
+	buf = append(buf, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], uint64(len(val)))
+	buf = append(buf, tmp[:n]...)
+	return append(buf, val...)
+}
+
+// encodeGRPCMessage encodes flow.v1alpha1.Message, the meta keys are
+// sorted to keep the encoding stable.
+func encodeGRPCMessage(body string, meta map[string]string) []byte {
+	var buf []byte
+	if len(body) > 0 {
+		buf = appendProtoBytes(buf, 1, []byte(body))
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendProtoBytes(nil, 1, []byte(k))
+		entry = appendProtoBytes(entry, 2, []byte(meta[k]))
+		buf = appendProtoBytes(buf, 2, entry)
+	}
+	return buf
+}
+
+func decodeGRPCAck(t *testing.T, data []byte) core.MsgStatus {
+	num, wiretype, val, rest, err := nextProtoField(data)
+	if err != nil || num != 1 || wiretype != 0 || len(rest) != 0 {
+		t.Fatalf("malformed ack: %x", data)
+	}
+	status, _ := binary.Uvarint(val)
+	return core.MsgStatus(status)
+}
+
+func TestDecodeGRPCMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantbody []byte
+		wantmeta map[string]string
+		wanterr  error
+	}{
+		{
+			name:     "body and meta",
+			input:    encodeGRPCMessage("hello", map[string]string{"source": "web", "level": "error"}),
+			wantbody: []byte("hello"),
+			wantmeta: map[string]string{"source": "web", "level": "error"},
+		},
+		{
+			name:     "empty",
+			input:    []byte{},
+			wantmeta: map[string]string{},
+		},
+		{
+			name: "unknown fields are skipped",
+			input: append(append([]byte{
+				0x18, 0x96, 0x01, // field 3, varint 150
+				0x21, 1, 2, 3, 4, 5, 6, 7, 8, // field 4, fixed64
+				0x2d, 1, 2, 3, 4, // field 5, fixed32
+			}, appendProtoBytes(nil, 6, []byte("skip"))...), encodeGRPCMessage("body", nil)...),
+			wantbody: []byte("body"),
+			wantmeta: map[string]string{},
+		},
+		{
+			name:     "meta entry without a value",
+			input:    appendProtoBytes(nil, 2, appendProtoBytes(nil, 1, []byte("key"))),
+			wantmeta: map[string]string{"key": ""},
+		},
+		{
+			name:    "wrong wire type",
+			input:   []byte{0x08, 0x01},
+			wanterr: errProtoMalformed,
+		},
+		{
+			name:    "truncated",
+			input:   encodeGRPCMessage("hello", nil)[:4],
+			wanterr: errProtoMalformed,
+		},
+		{
+			name:    "zero field number",
+			input:   []byte{0x02, 0x00},
+			wanterr: errProtoMalformed,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			body, meta, err := decodeGRPCMessage(testCase.input)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %v, want: %v", err, testCase.wanterr)
+			}
+			if !bytes.Equal(body, testCase.wantbody) || !reflect.DeepEqual(meta, testCase.wantmeta) {
+				t.Fatalf("unexpected message: got: %q %v, want: %q %v", body, meta, testCase.wantbody, testCase.wantmeta)
+			}
+		})
+	}
+}
+
+func TestReadGRPCFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wanterr error
+	}{
+		{"frame", encodeGRPCFrame([]byte("hello")), []byte("hello"), nil},
+		{"empty frame", encodeGRPCFrame(nil), []byte{}, nil},
+		{"end of stream", []byte{}, nil, io.EOF},
+		{"truncated header", []byte{0, 0}, nil, io.ErrUnexpectedEOF},
+		{"truncated payload", encodeGRPCFrame([]byte("hello"))[:7], nil, io.ErrUnexpectedEOF},
+		{"compressed", []byte{1, 0, 0, 0, 0}, nil, fmt.Errorf("grpc: compressed messages are not supported")},
+		{"too big", encodeGRPCFrame(make([]byte, 17)), nil, fmt.Errorf("grpc: message size 17 exceeds the limit of 16")},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := readGRPCFrame(bytes.NewReader(testCase.input), 16)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %v, want: %v", err, testCase.wanterr)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected payload: got: %q, want: %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestEncodeGRPCAck(t *testing.T) {
+	for _, status := range []core.MsgStatus{core.MsgStatusDone, core.MsgStatusThrottled} {
+		if got := encodeGRPCAck(status); !bytes.Equal(got, []byte{0x08, byte(status)}) {
+			t.Fatalf("unexpected ack encoding for %d: %x", status, got)
+		}
+	}
+}
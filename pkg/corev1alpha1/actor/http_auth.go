@@ -0,0 +1,281 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaHTTPPrincipal = "auth.principal"
+
+	DefaultHTTPAuthHMACWindow = 300
+
+	HTTPAuthHMACKeyHeader       = "X-Flow-Key"
+	HTTPAuthHMACTimestampHeader = "X-Flow-Timestamp"
+	HTTPAuthHMACSignatureHeader = "X-Flow-Signature"
+)
+
+// httpAuthError is replied with the code: 401 if the principal is unknown,
+// 403 if it's not allowed to do what it asks for.
+type httpAuthError struct {
+	code int
+	text string
+}
+
+func (e *httpAuthError) Error() string {
+	return e.text
+}
+
+type httpAuthToken struct {
+	principal string
+	token     []byte
+}
+
+// httpAuth authenticates requests with one of the configured methods:
+//
+//   - `auth_tokens` and `auth_tokens_file`: static bearer tokens, provided
+//     as `principal:token` entries (one per line in the file, lines starting
+//     with # are ignored). The request carries `Authorization: Bearer token`.
+//   - `auth_hmac_keys`: shared secrets, provided as `principal:secret`
+//     entries. The request carries the principal in X-Flow-Key, the unix
+//     time in X-Flow-Timestamp and the hex-encoded HMAC-SHA256 of
+//     "timestamp\nmethod\nrequest URI\nbody" in X-Flow-Signature. The
+//     timestamp should be within `auth_hmac_window` seconds from now.
+//   - `auth_mtls`: the common name of the verified client certificate
+//     (requires `tls_ca`).
+//
+// `auth_policy` restricts the meta values a principal might set, the
+// entries look like `principal:key=pattern`, where pattern follows
+// path.Match syntax and the principal might be `*`. A meta key mentioned
+// in the policy might only be set to the values matching the principal
+// patterns, the rest of the keys are not restricted.
+type httpAuth struct {
+	tokens   []httpAuthToken
+	hmackeys map[string][]byte
+	window   time.Duration
+	mtls     bool
+	policy   map[string]map[string][]string
+	timefun  func() time.Time
+}
+
+// splitAuthEntry splits `principal:value` entry.
+func splitAuthEntry(entry string) (string, string, error) {
+	ix := strings.IndexByte(entry, ':')
+	if ix <= 0 || ix == len(entry)-1 {
+		return "", "", fmt.Errorf("malformed entry %q, want: principal:value", entry)
+	}
+	return entry[:ix], entry[ix+1:], nil
+}
+
+// newHTTPAuth returns nil if no authentication method is configured.
+func newHTTPAuth(params core.Params) (*httpAuth, error) {
+	a := &httpAuth{
+		hmackeys: make(map[string][]byte),
+		window:   DefaultHTTPAuthHMACWindow * time.Second,
+		policy:   make(map[string]map[string][]string),
+		timefun:  time.Now,
+	}
+	var tokens []string
+	if v, ok := params["auth_tokens"]; ok {
+		entries, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed `auth_tokens` config: %s", err)
+		}
+		tokens = append(tokens, entries...)
+	}
+	if v, ok := params["auth_tokens_file"]; ok {
+		entries, err := readAuthTokensFile(v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read `auth_tokens_file`: %s", err)
+		}
+		tokens = append(tokens, entries...)
+	}
+	for _, entry := range tokens {
+		principal, token, err := splitAuthEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("malformed token: %s", err)
+		}
+		a.tokens = append(a.tokens, httpAuthToken{principal, []byte(token)})
+	}
+	if v, ok := params["auth_hmac_keys"]; ok {
+		entries, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed `auth_hmac_keys` config: %s", err)
+		}
+		for _, entry := range entries {
+			principal, secret, err := splitAuthEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("malformed hmac key: %s", err)
+			}
+			a.hmackeys[principal] = []byte(secret)
+		}
+	}
+	if v, ok := params["auth_hmac_window"]; ok {
+		window := v.(int)
+		if window <= 0 {
+			return nil, fmt.Errorf("`auth_hmac_window` should be a positive integer, got: %d", window)
+		}
+		a.window = time.Duration(window) * time.Second
+	}
+	if v, ok := params["auth_mtls"]; ok {
+		if a.mtls, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("unexpected (non-bool) value for `auth_mtls`: %v", v)
+		}
+		if _, ok := params["tls_ca"]; a.mtls && !ok {
+			return nil, fmt.Errorf("`auth_mtls` requires `tls_ca` config")
+		}
+	}
+	if len(a.tokens) == 0 && len(a.hmackeys) == 0 && !a.mtls {
+		if _, ok := params["auth_policy"]; ok {
+			return nil, fmt.Errorf("`auth_policy` requires an authentication method")
+		}
+		return nil, nil
+	}
+	if v, ok := params["auth_policy"]; ok {
+		entries, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed `auth_policy` config: %s", err)
+		}
+		for _, entry := range entries {
+			principal, rule, err := splitAuthEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("malformed policy: %s", err)
+			}
+			ix := strings.IndexByte(rule, '=')
+			if ix <= 0 {
+				return nil, fmt.Errorf("malformed policy rule %q, want: key=pattern", rule)
+			}
+			key, pattern := rule[:ix], rule[ix+1:]
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("malformed policy pattern %q: %s", pattern, err)
+			}
+			if _, ok := a.policy[key]; !ok {
+				a.policy[key] = make(map[string][]string)
+			}
+			a.policy[key][principal] = append(a.policy[key][principal], pattern)
+		}
+	}
+	return a, nil
+}
+
+func readAuthTokensFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// authenticate returns the request principal. Verifying the HMAC signature
+// requires the body, it's read (up to maxbody bytes) and put back.
+func (a *httpAuth) authenticate(req *http.Request, maxbody int64) (string, error) {
+	if auth := req.Header.Get("Authorization"); len(auth) > 0 {
+		if len(a.tokens) == 0 || !strings.HasPrefix(auth, "Bearer ") {
+			return "", &httpAuthError{http.StatusUnauthorized, "Unsupported authorization scheme"}
+		}
+		token := []byte(strings.TrimSpace(auth[len("Bearer "):]))
+		principal := ""
+		for _, t := range a.tokens {
+			// Not breaking out of the loop keeps the check time constant
+			if subtle.ConstantTimeCompare(t.token, token) == 1 && len(principal) == 0 {
+				principal = t.principal
+			}
+		}
+		if len(principal) == 0 {
+			return "", &httpAuthError{http.StatusUnauthorized, "Invalid token"}
+		}
+		return principal, nil
+	}
+
+	if sig := req.Header.Get(HTTPAuthHMACSignatureHeader); len(sig) > 0 && len(a.hmackeys) > 0 {
+		principal := req.Header.Get(HTTPAuthHMACKeyHeader)
+		secret, ok := a.hmackeys[principal]
+		if !ok {
+			return "", &httpAuthError{http.StatusUnauthorized, "Unknown signature key"}
+		}
+		ts := req.Header.Get(HTTPAuthHMACTimestampHeader)
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return "", &httpAuthError{http.StatusUnauthorized, "Malformed signature timestamp"}
+		}
+		if lag := a.timefun().Sub(time.Unix(unix, 0)); lag > a.window || lag < -a.window {
+			return "", &httpAuthError{http.StatusUnauthorized, "Signature timestamp is out of the window"}
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxbody))
+		req.Body.Close()
+		if err != nil {
+			return "", &httpAuthError{http.StatusBadRequest, "Failed to read the body"}
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		want, err := hex.DecodeString(sig)
+		if err != nil || !hmac.Equal(signHTTPRequest(secret, ts, req, body), want) {
+			return "", &httpAuthError{http.StatusUnauthorized, "Invalid signature"}
+		}
+		return principal, nil
+	}
+
+	if a.mtls && req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		if cn := req.TLS.PeerCertificates[0].Subject.CommonName; len(cn) > 0 {
+			return cn, nil
+		}
+	}
+
+	return "", &httpAuthError{http.StatusUnauthorized, "Authentication required"}
+}
+
+func signHTTPRequest(secret []byte, ts string, req *http.Request, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", ts, req.Method, req.URL.RequestURI())
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// authorize checks the message meta against the policy and stamps the
+// principal into the meta.
+func (a *httpAuth) authorize(principal string, msg *core.Message) error {
+	for key, rules := range a.policy {
+		v, ok := msg.Meta(key)
+		if !ok {
+			continue
+		}
+		value := fmt.Sprintf("%v", v)
+		if !matchHTTPPolicy(rules[principal], value) && !matchHTTPPolicy(rules["*"], value) {
+			return &httpAuthError{http.StatusForbidden, fmt.Sprintf("Principal %q is not allowed to set %s=%q", principal, key, value)}
+		}
+	}
+	msg.SetMeta(MetaHTTPPrincipal, principal)
+	return nil
+}
+
+func matchHTTPPolicy(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
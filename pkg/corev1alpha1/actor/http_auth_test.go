@@ -0,0 +1,198 @@
+package actor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func signTestHTTPRequest(req *http.Request, principal, secret string, ts time.Time, body []byte) {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set(HTTPAuthHMACKeyHeader, principal)
+	req.Header.Set(HTTPAuthHMACTimestampHeader, unix)
+	req.Header.Set(HTTPAuthHMACSignatureHeader, hex.EncodeToString(signHTTPRequest([]byte(secret), unix, req, body)))
+}
+
+func TestHTTPAuthAuthenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-http-auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tokensfile := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokensfile, []byte("# partner tokens\n\npartner:p4rtn3r\n"), 0600); err != nil {
+		t.Fatalf("failed to write tokens file: %s", err)
+	}
+
+	auth, err := newHTTPAuth(core.Params{
+		"auth_tokens":      []interface{}{"ingest:s3cr3t"},
+		"auth_tokens_file": tokensfile,
+		"auth_hmac_keys":   "signer:k3y",
+		"auth_hmac_window": 60,
+		"auth_mtls":        true,
+		"tls_ca":           "ca.pem",
+	})
+	if err != nil {
+		t.Fatalf("failed to create auth: %s", err)
+	}
+	now := time.Unix(1500000000, 0)
+	auth.timefun = func() time.Time { return now }
+
+	body := []byte("hello")
+	tests := []struct {
+		name          string
+		prepare       func(req *http.Request)
+		wantprincipal string
+		wanterr       error
+	}{
+		{
+			name:          "bearer token",
+			prepare:       func(req *http.Request) { req.Header.Set("Authorization", "Bearer s3cr3t") },
+			wantprincipal: "ingest",
+		},
+		{
+			name:          "bearer token from the file",
+			prepare:       func(req *http.Request) { req.Header.Set("Authorization", "Bearer p4rtn3r") },
+			wantprincipal: "partner",
+		},
+		{
+			name:    "invalid bearer token",
+			prepare: func(req *http.Request) { req.Header.Set("Authorization", "Bearer guess") },
+			wanterr: fmt.Errorf("Invalid token"),
+		},
+		{
+			name:    "basic auth",
+			prepare: func(req *http.Request) { req.SetBasicAuth("ingest", "s3cr3t") },
+			wanterr: fmt.Errorf("Unsupported authorization scheme"),
+		},
+		{
+			name:          "hmac signature",
+			prepare:       func(req *http.Request) { signTestHTTPRequest(req, "signer", "k3y", now.Add(-30*time.Second), body) },
+			wantprincipal: "signer",
+		},
+		{
+			name:    "hmac signature with a wrong secret",
+			prepare: func(req *http.Request) { signTestHTTPRequest(req, "signer", "guess", now, body) },
+			wanterr: fmt.Errorf("Invalid signature"),
+		},
+		{
+			name:    "hmac signature of another body",
+			prepare: func(req *http.Request) { signTestHTTPRequest(req, "signer", "k3y", now, []byte("other")) },
+			wanterr: fmt.Errorf("Invalid signature"),
+		},
+		{
+			name:    "stale hmac signature",
+			prepare: func(req *http.Request) { signTestHTTPRequest(req, "signer", "k3y", now.Add(-2*time.Minute), body) },
+			wanterr: fmt.Errorf("Signature timestamp is out of the window"),
+		},
+		{
+			name:    "unknown hmac key",
+			prepare: func(req *http.Request) { signTestHTTPRequest(req, "stranger", "k3y", now, body) },
+			wanterr: fmt.Errorf("Unknown signature key"),
+		},
+		{
+			name: "client certificate",
+			prepare: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "billing"}}}}
+			},
+			wantprincipal: "billing",
+		},
+		{
+			name:    "anonymous",
+			prepare: func(req *http.Request) {},
+			wanterr: fmt.Errorf("Authentication required"),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://example.com/v1alpha1?sendto=a", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("failed to build request: %s", err)
+			}
+			testCase.prepare(req)
+			principal, err := auth.authenticate(req, 1024)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %v, want: %v", err, testCase.wanterr)
+			}
+			if principal != testCase.wantprincipal {
+				t.Fatalf("unexpected principal: got: %q, want: %q", principal, testCase.wantprincipal)
+			}
+			// The body is still readable once the signature is verified
+			if got, _ := ioutil.ReadAll(req.Body); err == nil && !bytes.Equal(got, body) {
+				t.Fatalf("unexpected body: %q", got)
+			}
+		})
+	}
+}
+
+func TestHTTPAuthAuthorize(t *testing.T) {
+	auth, err := newHTTPAuth(core.Params{
+		"auth_tokens": []interface{}{"ingest:s3cr3t", "partner:p4rtn3r"},
+		"auth_policy": []interface{}{"partner:sendto=partner-*", "*:sendto=common", "ingest:sendto=*"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create auth: %s", err)
+	}
+	tests := []struct {
+		principal string
+		meta      map[string]string
+		wanterr   error
+	}{
+		{"partner", map[string]string{"sendto": "partner-logs"}, nil},
+		{"partner", map[string]string{"sendto": "common"}, nil},
+		{"partner", map[string]string{"other": "anything"}, nil},
+		{"partner", map[string]string{"sendto": "billing"}, fmt.Errorf("Principal \"partner\" is not allowed to set sendto=\"billing\"")},
+		{"ingest", map[string]string{"sendto": "billing"}, nil},
+	}
+	for _, testCase := range tests {
+		msg := core.NewMessage(nil)
+		for k, v := range testCase.meta {
+			msg.SetMeta(k, v)
+		}
+		if err := auth.authorize(testCase.principal, msg); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %q %v: got: %v, want: %v", testCase.principal, testCase.meta, err, testCase.wanterr)
+		}
+		if principal, _ := msg.Meta(MetaHTTPPrincipal); testCase.wanterr == nil && principal != testCase.principal {
+			t.Fatalf("unexpected principal meta: %v", principal)
+		}
+	}
+}
+
+func TestNewHTTPAuth(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wantnil bool
+		wanterr error
+	}{
+		{core.Params{}, true, nil},
+		{core.Params{"auth_tokens": "s3cr3t"}, false, fmt.Errorf("malformed token: malformed entry \"s3cr3t\", want: principal:value")},
+		{core.Params{"auth_hmac_keys": "signer:"}, false, fmt.Errorf("malformed hmac key: malformed entry \"signer:\", want: principal:value")},
+		{core.Params{"auth_mtls": true}, false, fmt.Errorf("`auth_mtls` requires `tls_ca` config")},
+		{core.Params{"auth_mtls": "yes"}, false, fmt.Errorf("unexpected (non-bool) value for `auth_mtls`: yes")},
+		{core.Params{"auth_policy": "a:sendto=b"}, false, fmt.Errorf("`auth_policy` requires an authentication method")},
+		{core.Params{"auth_tokens": "a:b", "auth_policy": "a:sendto"}, false, fmt.Errorf("malformed policy rule \"sendto\", want: key=pattern")},
+		{core.Params{"auth_tokens": "a:b", "auth_policy": "a:sendto=["}, false, fmt.Errorf("malformed policy pattern \"[\": syntax error in pattern")},
+		{core.Params{"auth_tokens_file": "/nonexistent"}, false, fmt.Errorf("failed to read `auth_tokens_file`: open /nonexistent: no such file or directory")},
+	}
+	for _, testCase := range tests {
+		auth, err := newHTTPAuth(testCase.params)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %v: got: %v, want: %v", testCase.params, err, testCase.wanterr)
+		}
+		if err == nil && (auth == nil) != testCase.wantnil {
+			t.Fatalf("unexpected auth for %v: %+v", testCase.params, auth)
+		}
+	}
+}
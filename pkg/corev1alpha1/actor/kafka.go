@@ -0,0 +1,719 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/zstd"
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/golang/snappy"
+)
+
+// This file implements the subset of the Kafka wire protocol used by the
+// Kafka sink head and receiver. See https://kafka.apache.org/protocol for
+// the reference.
+
+const (
+	MetaKafkaTopic        = "kafka.topic"
+	MetaKafkaPartition    = "kafka.partition"
+	MetaKafkaOffset       = "kafka.offset"
+	MetaKafkaKey          = "kafka.key"
+	MetaKafkaHeaderPrefix = "kafka.header."
+
+	DefaultKafkaClientID = "flowd"
+
+	kafkaAPIProduce         int16 = 0
+	kafkaAPIFetch           int16 = 1
+	kafkaAPIListOffsets     int16 = 2
+	kafkaAPIMetadata        int16 = 3
+	kafkaAPIOffsetCommit    int16 = 8
+	kafkaAPIOffsetFetch     int16 = 9
+	kafkaAPIFindCoordinator int16 = 10
+	kafkaAPIJoinGroup       int16 = 11
+	kafkaAPIHeartbeat       int16 = 12
+	kafkaAPILeaveGroup      int16 = 13
+	kafkaAPISyncGroup       int16 = 14
+
+	kafkaMaxMessageSize = 64 * 1024 * 1024
+)
+
+// Kafka compression codecs as encoded in the record batch attributes.
+const (
+	KafkaCompressionNone   int8 = 0
+	KafkaCompressionGzip   int8 = 1
+	KafkaCompressionSnappy int8 = 2
+	KafkaCompressionLZ4    int8 = 3
+	KafkaCompressionZstd   int8 = 4
+)
+
+var KafkaCompressionCodecs = map[string]int8{
+	"none":   KafkaCompressionNone,
+	"gzip":   KafkaCompressionGzip,
+	"snappy": KafkaCompressionSnappy,
+	"zstd":   KafkaCompressionZstd,
+}
+
+// kafkaCoders reuse DefaultCoders where the output is Kafka-compatible. The
+// default snappy coder produces the framing format, Kafka expects raw
+// snappy blocks instead.
+var kafkaCoders = map[int8]CoderFunc{
+	KafkaCompressionGzip: DefaultCoders["gzip"],
+	KafkaCompressionSnappy: func(payload []byte, _ int) ([]byte, error) {
+		return snappy.Encode(nil, payload), nil
+	},
+	KafkaCompressionZstd: DefaultCoders["zstd"],
+}
+
+// Kafka error codes the client reacts on. See KafkaErrToMsgStatus for the
+// message status mapping.
+const (
+	KafkaErrNone                         int16 = 0
+	KafkaErrOffsetOutOfRange             int16 = 1
+	KafkaErrCorruptMessage               int16 = 2
+	KafkaErrUnknownTopicOrPartition      int16 = 3
+	KafkaErrLeaderNotAvailable           int16 = 5
+	KafkaErrNotLeaderForPartition        int16 = 6
+	KafkaErrRequestTimedOut              int16 = 7
+	KafkaErrMessageTooLarge              int16 = 10
+	KafkaErrNetworkException             int16 = 13
+	KafkaErrCoordinatorLoadInProgress    int16 = 14
+	KafkaErrCoordinatorNotAvailable      int16 = 15
+	KafkaErrNotCoordinator               int16 = 16
+	KafkaErrRecordListTooLarge           int16 = 18
+	KafkaErrNotEnoughReplicas            int16 = 19
+	KafkaErrNotEnoughReplicasAfterAppend int16 = 20
+	KafkaErrIllegalGeneration            int16 = 22
+	KafkaErrUnknownMemberID              int16 = 25
+	KafkaErrRebalanceInProgress          int16 = 27
+	KafkaErrTopicAuthorizationFailed     int16 = 29
+	KafkaErrUnsupportedCompressionType   int16 = 76
+	KafkaErrInvalidRecord                int16 = 87
+	KafkaErrThrottlingQuotaExceeded      int16 = 89
+)
+
+// KafkaErrToMsgStatus maps Kafka partition error codes to message statuses.
+// Unlisted errors fail the message.
+var KafkaErrToMsgStatus = map[int16]core.MsgStatus{
+	KafkaErrNone:                         core.MsgStatusDone,
+	KafkaErrCorruptMessage:               core.MsgStatusInvalid,
+	KafkaErrUnknownTopicOrPartition:      core.MsgStatusUnroutable,
+	KafkaErrRequestTimedOut:              core.MsgStatusTimedOut,
+	KafkaErrMessageTooLarge:              core.MsgStatusInvalid,
+	KafkaErrRecordListTooLarge:           core.MsgStatusInvalid,
+	KafkaErrNotEnoughReplicasAfterAppend: core.MsgStatusPartialSend,
+	KafkaErrTopicAuthorizationFailed:     core.MsgStatusUnroutable,
+	KafkaErrInvalidRecord:                core.MsgStatusInvalid,
+	KafkaErrThrottlingQuotaExceeded:      core.MsgStatusThrottled,
+}
+
+// kafkaError is a non-zero error code returned by a broker.
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	return fmt.Sprintf("kafka: broker returned error code %d", int16(e))
+}
+
+// kafkaEncoder appends Kafka protocol primitives to a buffer.
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// varbytes encodes a varint-prefixed byte sequence as used in records.
+func (e *kafkaEncoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads Kafka protocol primitives from a buffer. The first
+// error sticks: all the subsequent reads return zero values.
+type kafkaDecoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.off+n > len(d.buf) {
+		d.err = fmt.Errorf("kafka: malformed response: unexpected end of data")
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.off:])
+	if n <= 0 {
+		d.err = fmt.Errorf("kafka: malformed varint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *kafkaDecoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen reads an array length, null arrays are treated as empty ones.
+func (d *kafkaDecoder) arrayLen() int {
+	n := int(d.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(d.buf)-d.off {
+		// Every element takes at least a byte
+		d.err = fmt.Errorf("kafka: malformed array length: %d", n)
+		return 0
+	}
+	return n
+}
+
+type kafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+type kafkaRecord struct {
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   []kafkaHeader
+}
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+func kafkaTimestamp(ts time.Time) int64 {
+	return ts.UnixNano() / int64(time.Millisecond)
+}
+
+// encodeKafkaRecordBatch builds a v2 (magic 2) record batch. The records
+// section is compressed with the codec if it's not none.
+func encodeKafkaRecordBatch(records []kafkaRecord, codec int8, level int) ([]byte, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("kafka: empty record batch")
+	}
+	first := kafkaTimestamp(records[0].Timestamp)
+	maxts := first
+	for _, rec := range records[1:] {
+		if ts := kafkaTimestamp(rec.Timestamp); ts > maxts {
+			maxts = ts
+		}
+	}
+	recs := &kafkaEncoder{}
+	rec := &kafkaEncoder{}
+	for i, r := range records {
+		rec.buf = rec.buf[:0]
+		rec.int8(0) // attributes
+		rec.varint(kafkaTimestamp(r.Timestamp) - first)
+		rec.varint(int64(i))
+		rec.varbytes(r.Key)
+		rec.varbytes(r.Value)
+		rec.varint(int64(len(r.Headers)))
+		for _, h := range r.Headers {
+			rec.varbytes([]byte(h.Key))
+			rec.varbytes(h.Value)
+		}
+		recs.varint(int64(len(rec.buf)))
+		recs.buf = append(recs.buf, rec.buf...)
+	}
+	payload := recs.buf
+	if codec != KafkaCompressionNone {
+		coder, ok := kafkaCoders[codec]
+		if !ok {
+			return nil, fmt.Errorf("kafka: unsupported compression codec %d", codec)
+		}
+		var err error
+		if payload, err = coder(payload, level); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &kafkaEncoder{}
+	e.int64(0)  // base offset
+	e.int32(0)  // batch length, set below
+	e.int32(-1) // partition leader epoch
+	e.int8(2)   // magic
+	e.int32(0)  // crc, set below
+	crcstart := len(e.buf)
+	e.int16(int16(codec))
+	e.int32(int32(len(records) - 1))
+	e.int64(first)
+	e.int64(maxts)
+	e.int64(-1) // producer id
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(int32(len(records)))
+	e.buf = append(e.buf, payload...)
+
+	binary.BigEndian.PutUint32(e.buf[8:12], uint32(len(e.buf)-12))
+	binary.BigEndian.PutUint32(e.buf[crcstart-4:crcstart], crc32.Checksum(e.buf[crcstart:], kafkaCRCTable))
+
+	return e.buf, nil
+}
+
+// decodeKafkaRecordBatches decodes a sequence of v2 record batches. A
+// trailing partial batch (brokers might return one) is ignored.
+func decodeKafkaRecordBatches(data []byte) ([]kafkaRecord, error) {
+	var res []kafkaRecord
+	for len(data) >= 12 {
+		size := int(int32(binary.BigEndian.Uint32(data[8:12])))
+		if size < 49 || 12+size > len(data) {
+			break
+		}
+		batch := data[:12+size]
+		data = data[12+size:]
+
+		d := &kafkaDecoder{buf: batch}
+		baseoffset := d.int64()
+		d.int32() // batch length
+		d.int32() // partition leader epoch
+		if magic := d.int8(); magic != 2 {
+			return nil, fmt.Errorf("kafka: unsupported record batch magic %d", magic)
+		}
+		crc := uint32(d.int32())
+		if crc32.Checksum(batch[d.off:], kafkaCRCTable) != crc {
+			return nil, fmt.Errorf("kafka: record batch crc mismatch")
+		}
+		attrs := d.int16()
+		d.int32() // last offset delta
+		first := d.int64()
+		d.int64() // max timestamp
+		d.int64() // producer id
+		d.int16() // producer epoch
+		d.int32() // base sequence
+		cnt := int(d.int32())
+		if d.err != nil {
+			return nil, d.err
+		}
+		if attrs&0x20 != 0 {
+			// Control batches (transaction markers) carry no user data
+			continue
+		}
+		payload, err := decompressKafka(int8(attrs&0x7), batch[d.off:])
+		if err != nil {
+			return nil, err
+		}
+		rd := &kafkaDecoder{buf: payload}
+		for i := 0; i < cnt; i++ {
+			rd.varint() // record length
+			rd.int8()   // attributes
+			tsdelta := rd.varint()
+			offdelta := rd.varint()
+			rec := kafkaRecord{
+				Offset:    baseoffset + offdelta,
+				Timestamp: time.Unix(0, (first+tsdelta)*int64(time.Millisecond)),
+				Key:       rd.varbytes(),
+				Value:     rd.varbytes(),
+			}
+			nheaders := int(rd.varint())
+			for j := 0; j < nheaders && rd.err == nil; j++ {
+				rec.Headers = append(rec.Headers, kafkaHeader{Key: string(rd.varbytes()), Value: rd.varbytes()})
+			}
+			if rd.err != nil {
+				return nil, rd.err
+			}
+			res = append(res, rec)
+		}
+	}
+	return res, nil
+}
+
+// xerialHeader prefixes snappy payloads produced by the Java client.
+var xerialHeader = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+func decompressKafka(codec int8, payload []byte) ([]byte, error) {
+	switch codec {
+	case KafkaCompressionNone:
+		return payload, nil
+	case KafkaCompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case KafkaCompressionSnappy:
+		if !bytes.HasPrefix(payload, xerialHeader) {
+			return snappy.Decode(nil, payload)
+		}
+		// xerial framing: a 16 byte header followed by size-prefixed
+		// blocks
+		var res []byte
+		for payload = payload[16:]; len(payload) >= 4; {
+			size := int(binary.BigEndian.Uint32(payload))
+			if 4+size > len(payload) {
+				return nil, fmt.Errorf("kafka: malformed xerial snappy block")
+			}
+			block, err := snappy.Decode(nil, payload[4:4+size])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, block...)
+			payload = payload[4+size:]
+		}
+		return res, nil
+	case KafkaCompressionZstd:
+		return zstd.Decompress(nil, payload)
+	}
+	return nil, fmt.Errorf("kafka: unsupported compression codec %d", codec)
+}
+
+// kafkaConn is a connection to a single broker. Requests are serialized.
+type kafkaConn struct {
+	addr     string
+	clientid string
+	conn     net.Conn
+	reader   *bufio.Reader
+	corrid   int32
+	timeout  time.Duration
+	lock     sync.Mutex
+}
+
+func dialKafka(addr, clientid string, timeout time.Duration) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{
+		addr:     addr,
+		clientid: clientid,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		timeout:  timeout,
+	}, nil
+}
+
+// roundTrip sends the request and reads the response body. If noresp is
+// set, the response is not awaited (e.g. produce requests with acks=0).
+func (c *kafkaConn) roundTrip(apikey, apiversion int16, body []byte, noresp bool) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.corrid++
+	e := &kafkaEncoder{}
+	e.int32(0) // size, set below
+	e.int16(apikey)
+	e.int16(apiversion)
+	e.int32(c.corrid)
+	e.string(c.clientid)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf[:4], uint32(len(e.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(e.buf); err != nil {
+		return nil, err
+	}
+	if noresp {
+		return nil, nil
+	}
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.reader, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(hdr[:4]))
+	if size < 4 || size > kafkaMaxMessageSize {
+		return nil, fmt.Errorf("kafka: malformed response size %d", size)
+	}
+	if corrid := int32(binary.BigEndian.Uint32(hdr[4:])); corrid != c.corrid {
+		return nil, fmt.Errorf("kafka: unexpected correlation id: got: %d, want: %d", corrid, c.corrid)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *kafkaConn) Close() error {
+	return c.conn.Close()
+}
+
+// kafkaCluster keeps track of the brokers and the connections to them. It's
+// not safe for concurrent use.
+type kafkaCluster struct {
+	addrs    []string
+	clientid string
+	timeout  time.Duration
+	brokers  map[int32]string
+	conns    map[int32]*kafkaConn
+}
+
+func newKafkaCluster(addrs []string, clientid string, timeout time.Duration) *kafkaCluster {
+	return &kafkaCluster{
+		addrs:    addrs,
+		clientid: clientid,
+		timeout:  timeout,
+		brokers:  make(map[int32]string),
+		conns:    make(map[int32]*kafkaConn),
+	}
+}
+
+// bootstrap drops the connections and fetches the metadata from the first
+// reachable bootstrap broker.
+func (c *kafkaCluster) bootstrap(topics []string) (*kafkaMetadata, error) {
+	c.close()
+	var lasterr error
+	for _, addr := range c.addrs {
+		conn, err := dialKafka(addr, c.clientid, c.timeout)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		md, err := fetchKafkaMetadata(conn, topics)
+		conn.Close()
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		c.brokers = md.Brokers
+		return md, nil
+	}
+	return nil, lasterr
+}
+
+// metadata fetches the metadata from any known broker.
+func (c *kafkaCluster) metadata(topics []string) (*kafkaMetadata, error) {
+	conn, err := c.anyConn()
+	if err != nil {
+		return nil, err
+	}
+	md, err := fetchKafkaMetadata(conn, topics)
+	if err != nil {
+		c.dropConn(conn)
+		return nil, err
+	}
+	for id, addr := range md.Brokers {
+		c.brokers[id] = addr
+	}
+	return md, nil
+}
+
+func (c *kafkaCluster) anyConn() (*kafkaConn, error) {
+	for _, conn := range c.conns {
+		return conn, nil
+	}
+	for id := range c.brokers {
+		return c.conn(id)
+	}
+	return nil, fmt.Errorf("kafka: no known brokers")
+}
+
+// conn returns the broker connection, dialing it if needed.
+func (c *kafkaCluster) conn(id int32) (*kafkaConn, error) {
+	if conn, ok := c.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := c.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", id)
+	}
+	conn, err := dialKafka(addr, c.clientid, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[id] = conn
+	return conn, nil
+}
+
+func (c *kafkaCluster) dropConn(conn *kafkaConn) {
+	conn.Close()
+	for id, cn := range c.conns {
+		if cn == conn {
+			delete(c.conns, id)
+		}
+	}
+}
+
+func (c *kafkaCluster) close() {
+	for id, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, id)
+	}
+}
+
+type kafkaPartition struct {
+	ID     int32
+	Leader int32
+	Err    int16
+}
+
+type kafkaMetadata struct {
+	Brokers map[int32]string
+	Topics  map[string][]kafkaPartition
+	// TopicErrs keeps the topic-level errors, e.g.
+	// KafkaErrUnknownTopicOrPartition.
+	TopicErrs map[string]int16
+}
+
+// fetchKafkaMetadata sends a v1 metadata request for the topics.
+func fetchKafkaMetadata(c *kafkaConn, topics []string) (*kafkaMetadata, error) {
+	e := &kafkaEncoder{}
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+	resp, err := c.roundTrip(kafkaAPIMetadata, 1, e.buf, false)
+	if err != nil {
+		return nil, err
+	}
+	d := &kafkaDecoder{buf: resp}
+	md := &kafkaMetadata{
+		Brokers:   make(map[int32]string),
+		Topics:    make(map[string][]kafkaPartition),
+		TopicErrs: make(map[string]int16),
+	}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		if rack := d.int16(); rack > 0 {
+			d.next(int(rack))
+		}
+		md.Brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topicerr := d.int16()
+		topic := d.string()
+		d.int8() // is internal
+		partitions := make([]kafkaPartition, d.arrayLen())
+		for j := range partitions {
+			partitions[j].Err = d.int16()
+			partitions[j].ID = d.int32()
+			partitions[j].Leader = d.int32()
+			for k, nr := 0, d.arrayLen(); k < nr; k++ {
+				d.int32() // replicas
+			}
+			for k, ni := 0, d.arrayLen(); k < ni; k++ {
+				d.int32() // isr
+			}
+		}
+		if topicerr != KafkaErrNone {
+			md.TopicErrs[topic] = topicerr
+			continue
+		}
+		// Partitions are picked by index
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+		md.Topics[topic] = partitions
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return md, nil
+}
@@ -0,0 +1,506 @@
+package actor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type kafkaStubBatch struct {
+	topic     string
+	partition int32
+	acks      int16
+	records   []kafkaRecord
+}
+
+// kafkaStub is a fake single-node Kafka broker. Every topic has nparts
+// partitions led by the stub itself. The produced records are appended to
+// the partition logs which are served to the consumers. The stub is also
+// the coordinator of a single-member consumer group.
+type kafkaStub struct {
+	t          *testing.T
+	listener   net.Listener
+	nparts     int32
+	topicerrs  map[string]int16
+	codefunc   func(batch kafkaStubBatch) int16
+	produced   chan kafkaStubBatch
+	nproduce   int32
+	lock       sync.Mutex
+	conns      []net.Conn
+	logs       map[kafkaTopicPartition][]kafkaRecord
+	committed  map[kafkaTopicPartition]int64
+	generation int32
+	assignment []byte
+	hberr      int16
+	njoin      int
+	nleave     int
+}
+
+func newKafkaStub(t *testing.T, nparts int32) *kafkaStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	s := &kafkaStub{
+		t:         t,
+		listener:  l,
+		nparts:    nparts,
+		topicerrs: make(map[string]int16),
+		codefunc:  func(kafkaStubBatch) int16 { return KafkaErrNone },
+		produced:  make(chan kafkaStubBatch, 1024),
+		logs:      make(map[kafkaTopicPartition][]kafkaRecord),
+		committed: make(map[kafkaTopicPartition]int64),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *kafkaStub) addr() string {
+	return s.listener.Addr().String()
+}
+
+// dropConns closes the accepted connections, the listener keeps accepting.
+func (s *kafkaStub) dropConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *kafkaStub) close() {
+	s.listener.Close()
+	s.dropConns()
+}
+
+func (s *kafkaStub) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &kafkaDecoder{buf: req}
+		apikey, version, corrid := d.int16(), d.int16(), d.int32()
+		d.string() // client id
+		var resp []byte
+		switch apikey {
+		case kafkaAPIMetadata:
+			resp = s.metadata(d)
+		case kafkaAPIProduce:
+			resp = s.produce(d, version)
+		case kafkaAPIFetch:
+			resp = s.fetch(d)
+		case kafkaAPIListOffsets:
+			resp = s.listOffsets(d)
+		case kafkaAPIFindCoordinator:
+			resp = s.findCoordinator(d)
+		case kafkaAPIJoinGroup:
+			resp = s.joinGroup(d)
+		case kafkaAPISyncGroup:
+			resp = s.syncGroup(d)
+		case kafkaAPIHeartbeat:
+			resp = s.heartbeat(d)
+		case kafkaAPILeaveGroup:
+			resp = s.leaveGroup(d)
+		case kafkaAPIOffsetFetch:
+			resp = s.offsetFetch(d)
+		case kafkaAPIOffsetCommit:
+			resp = s.offsetCommit(d)
+		default:
+			s.t.Errorf("unexpected api key: %d", apikey)
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		e := &kafkaEncoder{}
+		e.int32(int32(len(resp) + 4))
+		e.int32(corrid)
+		if _, err := conn.Write(append(e.buf, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *kafkaStub) metadata(d *kafkaDecoder) []byte {
+	host, port, _ := net.SplitHostPort(s.addr())
+	portnum, _ := strconv.Atoi(port)
+	e := &kafkaEncoder{}
+	e.int32(1)
+	e.int32(0) // node id
+	e.string(host)
+	e.int32(int32(portnum))
+	e.nullableString(nil)
+	e.int32(0) // controller id
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.int16(s.topicerrs[topic])
+		e.string(topic)
+		e.int8(0)
+		e.int32(s.nparts)
+		// Partitions are listed in the reverse order on purpose
+		for p := s.nparts - 1; p >= 0; p-- {
+			e.int16(KafkaErrNone)
+			e.int32(p)
+			e.int32(0) // leader
+			e.int32(1)
+			e.int32(0) // replicas
+			e.int32(1)
+			e.int32(0) // isr
+		}
+	}
+	return e.buf
+}
+
+func (s *kafkaStub) produce(d *kafkaDecoder, version int16) []byte {
+	atomic.AddInt32(&s.nproduce, 1)
+	if n := d.int16(); n >= 0 {
+		d.next(int(n)) // transactional id
+	}
+	acks := d.int16()
+	d.int32() // timeout
+	e := &kafkaEncoder{}
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.string(topic)
+		nparts := d.arrayLen()
+		e.int32(int32(nparts))
+		for j := 0; j < nparts; j++ {
+			partition := d.int32()
+			records, err := decodeKafkaRecordBatches(d.bytes())
+			if err != nil {
+				s.t.Errorf("failed to decode records: %s", err)
+			}
+			batch := kafkaStubBatch{topic: topic, partition: partition, acks: acks, records: records}
+			s.produced <- batch
+			s.append(topic, partition, records...)
+			e.int32(partition)
+			e.int16(s.codefunc(batch))
+			e.int64(0)  // base offset
+			e.int64(-1) // log append time
+			if version >= 5 {
+				e.int64(0) // log start offset
+			}
+		}
+	}
+	e.int32(0) // throttle time
+	if d.err != nil {
+		s.t.Errorf("failed to decode produce request: %s", d.err)
+	}
+	if acks == KafkaAcksNone {
+		return nil
+	}
+	return e.buf
+}
+
+func (s *kafkaStub) expect(t *testing.T) kafkaStubBatch {
+	select {
+	case batch := <-s.produced:
+		return batch
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a produce request")
+	}
+	return kafkaStubBatch{}
+}
+
+// append adds the records to the partition log, the offsets are reassigned.
+func (s *kafkaStub) append(topic string, partition int32, records ...kafkaRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tp := kafkaTopicPartition{topic, partition}
+	for _, rec := range records {
+		rec.Offset = int64(len(s.logs[tp]))
+		s.logs[tp] = append(s.logs[tp], rec)
+	}
+}
+
+func (s *kafkaStub) fetch(d *kafkaDecoder) []byte {
+	d.int32() // replica id
+	maxwait := d.int32()
+	d.int32() // min bytes
+	d.int32() // max bytes
+	d.int8()  // isolation level
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	empty := true
+	s.lock.Lock()
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.string(topic)
+		nparts := d.arrayLen()
+		e.int32(int32(nparts))
+		for j := 0; j < nparts; j++ {
+			partition := d.int32()
+			offset := d.int64()
+			d.int32() // max bytes
+			log := s.logs[kafkaTopicPartition{topic, partition}]
+			code := KafkaErrNone
+			if offset < 0 || offset > int64(len(log)) {
+				code = KafkaErrOffsetOutOfRange
+			}
+			e.int32(partition)
+			e.int16(code)
+			e.int64(int64(len(log))) // high watermark
+			e.int64(int64(len(log))) // last stable offset
+			e.int32(-1)              // aborted transactions
+			// The whole log is returned just like a broker returns the
+			// entire batch containing the requested offset
+			var data []byte
+			if code == KafkaErrNone && offset < int64(len(log)) {
+				data, _ = encodeKafkaRecordBatch(log, KafkaCompressionNone, -1)
+				empty = false
+			}
+			e.bytes(data)
+		}
+	}
+	s.lock.Unlock()
+	if empty {
+		time.Sleep(time.Duration(maxwait) * time.Millisecond)
+	}
+	return e.buf
+}
+
+func (s *kafkaStub) listOffsets(d *kafkaDecoder) []byte {
+	d.int32() // replica id
+	e := &kafkaEncoder{}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.string(topic)
+		nparts := d.arrayLen()
+		e.int32(int32(nparts))
+		for j := 0; j < nparts; j++ {
+			partition := d.int32()
+			offset := int64(0)
+			if ts := d.int64(); ts == kafkaOffsetLatest {
+				offset = int64(len(s.logs[kafkaTopicPartition{topic, partition}]))
+			}
+			e.int32(partition)
+			e.int16(KafkaErrNone)
+			e.int64(-1) // timestamp
+			e.int64(offset)
+		}
+	}
+	return e.buf
+}
+
+func (s *kafkaStub) findCoordinator(d *kafkaDecoder) []byte {
+	host, port, _ := net.SplitHostPort(s.addr())
+	portnum, _ := strconv.Atoi(port)
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	e.int16(KafkaErrNone)
+	e.nullableString(nil)
+	e.int32(0) // node id
+	e.string(host)
+	e.int32(int32(portnum))
+	return e.buf
+}
+
+func (s *kafkaStub) joinGroup(d *kafkaDecoder) []byte {
+	d.string() // group
+	d.int32()  // session timeout
+	d.int32()  // rebalance timeout
+	d.string() // member id
+	d.string() // protocol type
+	d.arrayLen()
+	d.string() // protocol name
+	metadata := d.bytes()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.generation++
+	s.njoin++
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	e.int16(KafkaErrNone)
+	e.int32(s.generation)
+	e.string(kafkaAssignorRange)
+	e.string("member-1") // leader
+	e.string("member-1")
+	e.int32(1)
+	e.string("member-1")
+	e.bytes(metadata)
+	return e.buf
+}
+
+func (s *kafkaStub) syncGroup(d *kafkaDecoder) []byte {
+	d.string() // group
+	d.int32()  // generation
+	d.string() // member id
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		if member := d.string(); member == "member-1" {
+			s.assignment = d.bytes()
+		} else {
+			d.bytes()
+		}
+	}
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	e.int16(KafkaErrNone)
+	e.bytes(s.assignment)
+	return e.buf
+}
+
+func (s *kafkaStub) heartbeat(d *kafkaDecoder) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	e.int16(s.hberr)
+	s.hberr = KafkaErrNone
+	return e.buf
+}
+
+func (s *kafkaStub) leaveGroup(d *kafkaDecoder) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nleave++
+	e := &kafkaEncoder{}
+	e.int32(0) // throttle time
+	e.int16(KafkaErrNone)
+	return e.buf
+}
+
+func (s *kafkaStub) offsetFetch(d *kafkaDecoder) []byte {
+	d.string() // group
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := &kafkaEncoder{}
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.string(topic)
+		nparts := d.arrayLen()
+		e.int32(int32(nparts))
+		for j := 0; j < nparts; j++ {
+			partition := d.int32()
+			offset, ok := s.committed[kafkaTopicPartition{topic, partition}]
+			if !ok {
+				offset = -1
+			}
+			e.int32(partition)
+			e.int64(offset)
+			e.nullableString(nil) // metadata
+			e.int16(KafkaErrNone)
+		}
+	}
+	e.int16(KafkaErrNone)
+	return e.buf
+}
+
+func (s *kafkaStub) offsetCommit(d *kafkaDecoder) []byte {
+	d.string() // group
+	d.int32()  // generation
+	d.string() // member id
+	d.int64()  // retention time
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := &kafkaEncoder{}
+	ntopics := d.arrayLen()
+	e.int32(int32(ntopics))
+	for i := 0; i < ntopics; i++ {
+		topic := d.string()
+		e.string(topic)
+		nparts := d.arrayLen()
+		e.int32(int32(nparts))
+		for j := 0; j < nparts; j++ {
+			partition := d.int32()
+			s.committed[kafkaTopicPartition{topic, partition}] = d.int64()
+			d.string() // metadata
+			e.int32(partition)
+			e.int16(KafkaErrNone)
+		}
+	}
+	return e.buf
+}
+
+// expectCommitted waits for the partition offset to be committed.
+func (s *kafkaStub) expectCommitted(t *testing.T, topic string, partition int32, offset int64) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.lock.Lock()
+		got, ok := s.committed[kafkaTopicPartition{topic, partition}]
+		s.lock.Unlock()
+		if ok && got == offset {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s/%d commit: got: %d, want: %d", topic, partition, got, offset)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	records := []kafkaRecord{
+		{Offset: 0, Timestamp: time.Unix(1500000000, 0), Key: []byte("key"), Value: []byte("value 1")},
+		{
+			Offset:    1,
+			Timestamp: time.Unix(1500000001, 0),
+			Value:     []byte("value 2"),
+			Headers:   []kafkaHeader{{Key: "h1", Value: []byte("v1")}, {Key: "h2", Value: nil}},
+		},
+	}
+	for name, codec := range KafkaCompressionCodecs {
+		t.Run(name, func(t *testing.T) {
+			data, err := encodeKafkaRecordBatch(records, codec, -1)
+			if err != nil {
+				t.Fatalf("failed to encode: %s", err)
+			}
+			if got := int8(binary.BigEndian.Uint16(data[21:23]) & 0x7); got != codec {
+				t.Fatalf("unexpected codec attribute: got: %d, want: %d", got, codec)
+			}
+			// Two batches followed by a partial one
+			data = append(append(data, data...), data[:20]...)
+			got, err := decodeKafkaRecordBatches(data)
+			if err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+			if want := append(records, records...); !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected records: got: %+v, want: %+v", got, want)
+			}
+		})
+	}
+
+	data, _ := encodeKafkaRecordBatch(records, KafkaCompressionNone, -1)
+	data[len(data)-1] ^= 0xff
+	if _, err := decodeKafkaRecordBatches(data); !eqErr(err, fmt.Errorf("kafka: record batch crc mismatch")) {
+		t.Fatalf("unexpected error for a corrupted batch: %v", err)
+	}
+}
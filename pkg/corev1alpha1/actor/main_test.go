@@ -2,11 +2,21 @@ package actor
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
+	"path/filepath"
 	"sync"
+	"testing"
 	"time"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
@@ -154,3 +164,110 @@ func eqErr(e1, e2 error) bool {
 	}
 	return e1.Error() == e2.Error()
 }
+
+type testSinkHead struct {
+	bind    string
+	lock    sync.Mutex
+	writes  [][]byte
+	conns   int
+	starts  int
+	failcon bool
+	failwr  bool
+}
+
+var _ SinkHead = (*testSinkHead)(nil)
+
+func newTestSinkHead(bind string) *testSinkHead {
+	return &testSinkHead{
+		bind:   bind,
+		writes: make([][]byte, 0),
+	}
+}
+
+func (h *testSinkHead) Connect() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.failcon {
+		return fmt.Errorf("%s: connection refused", h.bind)
+	}
+	h.conns++
+	return nil
+}
+
+func (h *testSinkHead) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.starts++
+	return nil
+}
+
+func (h *testSinkHead) Stop() error {
+	return nil
+}
+
+func (h *testSinkHead) Write(data []byte) (int, error, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.failwr {
+		return 0, fmt.Errorf("%s: broken pipe", h.bind), true
+	}
+	h.writes = append(h.writes, data)
+	return len(data), nil, false
+}
+
+func (h *testSinkHead) Starts() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.starts
+}
+
+func (h *testSinkHead) Conns() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.conns
+}
+
+func (h *testSinkHead) Writes() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.writes)
+}
+
+// newTestCert generates a self-signed certificate for 127.0.0.1 and
+// localhost. The certificate and the key are stored as PEM files in dir.
+// The certificate file doubles as a CA bundle.
+func newTestCert(t *testing.T, dir string) (certfile, keyfile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	certfile = filepath.Join(dir, "cert.pem")
+	keyfile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err := ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+	return certfile, keyfile
+}
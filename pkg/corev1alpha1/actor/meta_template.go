@@ -0,0 +1,65 @@
+package actor
+
+import (
+	"fmt"
+	"strings"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// metaTemplate renders a string from the message meta: every `{key}`
+// placeholder is substituted with the corresponding meta value, e.g.
+// `events:{redis.key}`. A template with no placeholders renders as is.
+type metaTemplate struct {
+	// parts alternate literals and meta keys, literals come first.
+	parts []string
+}
+
+func newMetaTemplate(tmpl string) (*metaTemplate, error) {
+	t := &metaTemplate{}
+	for {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			if strings.IndexByte(tmpl, '}') >= 0 {
+				return nil, fmt.Errorf("unexpected `}` in template")
+			}
+			t.parts = append(t.parts, tmpl)
+			break
+		}
+		end := strings.IndexByte(tmpl[open+1:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed `{` in template")
+		}
+		key := tmpl[open+1 : open+1+end]
+		if len(key) == 0 || strings.IndexByte(key, '{') >= 0 {
+			return nil, fmt.Errorf("malformed placeholder `{%s}` in template", key)
+		}
+		if strings.IndexByte(tmpl[:open], '}') >= 0 {
+			return nil, fmt.Errorf("unexpected `}` in template")
+		}
+		t.parts = append(t.parts, tmpl[:open], key)
+		tmpl = tmpl[open+end+2:]
+	}
+	return t, nil
+}
+
+// render substitutes the placeholders, a meta key missing in the message is
+// an error.
+func (t *metaTemplate) render(msg *core.Message) (string, error) {
+	if len(t.parts) == 1 {
+		return t.parts[0], nil
+	}
+	var b strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+			continue
+		}
+		v, ok := msg.Meta(part)
+		if !ok {
+			return "", fmt.Errorf("message meta is missing %q", part)
+		}
+		fmt.Fprintf(&b, "%v", v)
+	}
+	return b.String(), nil
+}
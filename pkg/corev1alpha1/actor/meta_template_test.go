@@ -0,0 +1,49 @@
+package actor
+
+import (
+	"fmt"
+	"testing"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func TestMetaTemplate(t *testing.T) {
+	msg := core.NewMessage(nil)
+	msg.SetMeta("redis.key", "jobs")
+	msg.SetMeta("shard", 3)
+
+	tests := []struct {
+		tmpl       string
+		want       string
+		wanterr    error
+		wantrender error
+	}{
+		{"static", "static", nil, nil},
+		{"", "", nil, nil},
+		{"{redis.key}", "jobs", nil, nil},
+		{"events:{redis.key}:{shard}", "events:jobs:3", nil, nil},
+		{"{missing}", "", nil, fmt.Errorf("message meta is missing \"missing\"")},
+		{"events:{redis.key", "", fmt.Errorf("unclosed `{` in template"), nil},
+		{"events}", "", fmt.Errorf("unexpected `}` in template"), nil},
+		{"a}{shard}", "", fmt.Errorf("unexpected `}` in template"), nil},
+		{"{}", "", fmt.Errorf("malformed placeholder `{}` in template"), nil},
+		{"{a{b}", "", fmt.Errorf("malformed placeholder `{a{b}` in template"), nil},
+	}
+
+	for _, testCase := range tests {
+		tmpl, err := newMetaTemplate(testCase.tmpl)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %q: got: %v, want: %v", testCase.tmpl, err, testCase.wanterr)
+		}
+		if err != nil {
+			continue
+		}
+		got, err := tmpl.render(msg)
+		if !eqErr(err, testCase.wantrender) {
+			t.Fatalf("unexpected render error for %q: got: %v, want: %v", testCase.tmpl, err, testCase.wantrender)
+		}
+		if got != testCase.want {
+			t.Fatalf("unexpected render result for %q: got: %q, want: %q", testCase.tmpl, got, testCase.want)
+		}
+	}
+}
@@ -44,7 +44,7 @@ func (m *Mux) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range m.queue {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					m.ctx.Logger().Error(err.Error())
+					m.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			m.wg.Done()
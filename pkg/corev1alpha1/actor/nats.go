@@ -0,0 +1,139 @@
+package actor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaNATSSubject = "nats.subject"
+	MetaNATSReply   = "nats.reply"
+
+	DefaultNATSClientName = "flowd"
+
+	natsMaxLineLen = 4 * 1024
+)
+
+// natsOptions is the CONNECT payload.
+type natsOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+}
+
+func natsOptionsFromParams(params core.Params) natsOptions {
+	opts := natsOptions{
+		Name:    DefaultNATSClientName,
+		Lang:    "go",
+		Version: "0.0.0",
+	}
+	if v, ok := params["client_name"]; ok {
+		opts.Name = v.(string)
+	}
+	if v, ok := params["username"]; ok {
+		opts.User = v.(string)
+	}
+	if v, ok := params["password"]; ok {
+		opts.Pass = v.(string)
+	}
+	if v, ok := params["token"]; ok {
+		opts.Token = v.(string)
+	}
+	return opts
+}
+
+// dialNATS connects to the server: it reads the INFO greeting, sends
+// CONNECT and makes sure the server accepted it with a PING round trip.
+func dialNATS(addr string, opts natsOptions, timeout time.Duration) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(err error) (net.Conn, *bufio.Reader, error) {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReaderSize(conn, natsMaxLineLen)
+	line, err := readNATSLine(reader)
+	if err != nil {
+		return fail(err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail(fmt.Errorf("nats: unexpected greeting: %q", line))
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := conn.Write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n")); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return fail(err)
+		}
+		switch {
+		case line == "PONG":
+			conn.SetDeadline(time.Time{})
+			return conn, reader, nil
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return fail(err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fail(natsErr(line))
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		default:
+			return fail(fmt.Errorf("nats: unexpected reply: %q", line))
+		}
+	}
+}
+
+// readNATSLine reads a protocol line with no trailing CRLF.
+func readNATSLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("nats: protocol line is too long")
+		}
+		return "", err
+	}
+	return string(dropCR(line[:len(line)-1])), nil
+}
+
+// natsErrText extracts the message from an `-ERR 'message'` line.
+func natsErrText(line string) string {
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")
+}
+
+func natsErr(line string) error {
+	return fmt.Errorf("nats: server error: %s", natsErrText(line))
+}
+
+// validNATSSubject tells if the subject is non-empty and has no whitespace
+// or empty tokens.
+func validNATSSubject(subject string) bool {
+	if len(subject) == 0 || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	for _, token := range strings.Split(subject, ".") {
+		if len(token) == 0 {
+			return false
+		}
+	}
+	return true
+}
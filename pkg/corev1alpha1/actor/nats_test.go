@@ -0,0 +1,259 @@
+package actor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type natsStubPub struct {
+	subject string
+	payload string
+}
+
+type natsStubSub struct {
+	conn    *natsStubConn
+	subject string
+	group   string
+	sid     string
+}
+
+type natsStubConn struct {
+	conn    net.Conn
+	lock    sync.Mutex
+	verbose bool
+}
+
+func (c *natsStubConn) write(data string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.conn.Write([]byte(data))
+}
+
+// natsStub is a fake nats server. It routes the published messages to the
+// matching subscriptions (a single member of every queue group gets the
+// message) and records them.
+type natsStub struct {
+	t        *testing.T
+	listener net.Listener
+	token    string
+	// errfunc returns the error reply to a PUB, if any
+	errfunc  func(subject, payload string) string
+	pinger   bool
+	lock     sync.Mutex
+	conns    []*natsStubConn
+	subs     []*natsStubSub
+	nsub     int
+	rr       int
+	options  []natsOptions
+	received chan natsStubPub
+}
+
+func newNATSStub(t *testing.T) *natsStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	s := &natsStub{
+		t:        t,
+		listener: l,
+		errfunc:  func(string, string) string { return "" },
+		received: make(chan natsStubPub, 1024),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := &natsStubConn{conn: conn}
+			s.lock.Lock()
+			s.conns = append(s.conns, c)
+			s.lock.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *natsStub) addr() string {
+	return s.listener.Addr().String()
+}
+
+// dropConns closes the accepted connections along with their
+// subscriptions, the listener keeps accepting.
+func (s *natsStub) dropConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.conns {
+		c.conn.Close()
+	}
+	s.conns = nil
+	s.subs = nil
+}
+
+func (s *natsStub) close() {
+	s.listener.Close()
+	s.dropConns()
+}
+
+func natsSubjectMatch(pattern, subject string) bool {
+	ptokens, stokens := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, pt := range ptokens {
+		if pt == ">" {
+			return len(stokens) > i
+		}
+		if i >= len(stokens) || (pt != "*" && pt != stokens[i]) {
+			return false
+		}
+	}
+	return len(ptokens) == len(stokens)
+}
+
+// publish routes the message to the subscribers.
+func (s *natsStub) publish(subject, reply, payload string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	groups := make(map[string][]*natsStubSub)
+	var targets []*natsStubSub
+	for _, sub := range s.subs {
+		if !natsSubjectMatch(sub.subject, subject) {
+			continue
+		}
+		if len(sub.group) == 0 {
+			targets = append(targets, sub)
+			continue
+		}
+		groups[sub.group] = append(groups[sub.group], sub)
+	}
+	for _, members := range groups {
+		targets = append(targets, members[s.rr%len(members)])
+		s.rr++
+	}
+	for _, sub := range targets {
+		if len(reply) > 0 {
+			sub.conn.write(fmt.Sprintf("MSG %s %s %s %d\r\n%s\r\n", subject, sub.sid, reply, len(payload), payload))
+		} else {
+			sub.conn.write(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sub.sid, len(payload), payload))
+		}
+	}
+}
+
+func (s *natsStub) serve(c *natsStubConn) {
+	defer c.conn.Close()
+	c.write("INFO {\"server_id\":\"stub\",\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return
+		}
+		op := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch op {
+		case "CONNECT":
+			var opts natsOptions
+			if err := json.Unmarshal([]byte(line[8:]), &opts); err != nil {
+				s.t.Errorf("failed to parse CONNECT: %s", err)
+				return
+			}
+			s.lock.Lock()
+			s.options = append(s.options, opts)
+			s.lock.Unlock()
+			if opts.Token != s.token {
+				c.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			c.verbose = opts.Verbose
+			if c.verbose {
+				c.write("+OK\r\n")
+			}
+		case "PING":
+			c.write("PONG\r\n")
+		case "PONG":
+		case "SUB":
+			args := strings.Fields(line[4:])
+			sub := &natsStubSub{conn: c, subject: args[0], sid: args[len(args)-1]}
+			if len(args) == 3 {
+				sub.group = args[1]
+			}
+			s.lock.Lock()
+			s.subs = append(s.subs, sub)
+			s.nsub++
+			s.lock.Unlock()
+		case "PUB":
+			args := strings.Fields(line[4:])
+			size, _ := strconv.Atoi(args[len(args)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			pub := natsStubPub{subject: args[0], payload: string(payload[:size])}
+			if s.pinger {
+				c.write("PING\r\n")
+			}
+			if errreply := s.errfunc(pub.subject, pub.payload); len(errreply) > 0 {
+				c.write("-ERR '" + errreply + "'\r\n")
+				continue
+			}
+			s.received <- pub
+			s.publish(pub.subject, "", pub.payload)
+			if c.verbose {
+				c.write("+OK\r\n")
+			}
+		default:
+			c.write("-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+func (s *natsStub) expect(t *testing.T) natsStubPub {
+	select {
+	case pub := <-s.received:
+		return pub
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a PUB")
+	}
+	return natsStubPub{}
+}
+
+// waitSubs waits for the total number of SUBs to reach n.
+func (s *natsStub) waitSubs(t *testing.T, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.lock.Lock()
+		nsub := s.nsub
+		s.lock.Unlock()
+		if nsub >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for subscriptions: got: %d, want: %d", nsub, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNATSSubject(t *testing.T) {
+	tests := map[string]bool{
+		"events":        true,
+		"events.web.>":  true,
+		"events.*.info": true,
+		"":              false,
+		"events..web":   false,
+		"events.":       false,
+		"events web":    false,
+	}
+	for subject, want := range tests {
+		if got := validNATSSubject(subject); got != want {
+			t.Fatalf("unexpected validity of %q: got: %t, want: %t", subject, got, want)
+		}
+	}
+}
@@ -27,11 +27,44 @@ func ReceiverFactory(name string, ctx *core.Context, params core.Params) (core.A
 	case strings.HasPrefix(bind, "http://"):
 		bind = bind[7:]
 		builder = NewReceiverHTTP
+	case strings.HasPrefix(bind, "tail://"):
+		bind = bind[7:]
+		builder = NewReceiverTail
+	case strings.HasPrefix(bind, "syslog://"):
+		bind = bind[9:]
+		builder = NewReceiverSyslog
+	case strings.HasPrefix(bind, "statsd://"):
+		bind = bind[9:]
+		builder = NewReceiverStatsd
+	case strings.HasPrefix(bind, "redis://"):
+		bind = bind[8:]
+		builder = NewReceiverRESP
+	case strings.HasPrefix(bind, "kafka://"):
+		bind = bind[8:]
+		builder = NewReceiverKafka
+	case strings.HasPrefix(bind, "mqtt://"):
+		bind = bind[7:]
+		builder = NewReceiverMQTT
+	case strings.HasPrefix(bind, "nats://"):
+		bind = bind[7:]
+		builder = NewReceiverNATS
+	case strings.HasPrefix(bind, "ws://"):
+		bind = bind[5:]
+		builder = NewReceiverWebSocket
+	case strings.HasPrefix(bind, "grpc://"):
+		bind = bind[7:]
+		builder = NewReceiverGRPC
 	default:
 		return nil, fmt.Errorf("receiver %q has unrecognised `bind` protocol: %q", name, bind)
 	}
 
-	params["bind"] = bind
+	// The params are shared with the config: the receiver gets a copy so
+	// the actor config keeps the original bind address.
+	rcvparams := make(core.Params, len(params))
+	for k, v := range params {
+		rcvparams[k] = v
+	}
+	rcvparams["bind"] = bind
 
-	return builder(name, ctx, params)
+	return builder(name, ctx, rcvparams)
 }
@@ -0,0 +1,256 @@
+package actor
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// ReceiverGRPC serves the flow.v1alpha1.Flow service (see grpc.go). Send
+// accepts a single message and replies with its status, Stream accepts a
+// sequence of messages and replies with their statuses in the same order.
+// The call status is OK unless the request itself is malformed: a message
+// that failed to send is still acknowledged with the corresponding status.
+//
+// No more than `max_in_flight` stream messages are awaiting their status at
+// once. The receiver stops reading the request once the limit is reached,
+// so HTTP/2 flow control holds the client back.
+//
+// The standard library serves HTTP/2 over TLS only, therefore `tls_cert`
+// and `tls_key` are mandatory. If `tls_ca` is provided, the clients are
+// required to present a certificate signed by this CA. Compressed messages
+// are not supported.
+type ReceiverGRPC struct {
+	name        string
+	ctx         *core.Context
+	addr        string
+	tlsconf     *tls.Config
+	maxsize     int
+	maxinflight int
+	queue       chan *core.Message
+	httpsrv     *http.Server
+	listener    net.Listener
+	done        chan struct{}
+	wgconn      sync.WaitGroup
+	wgpeer      sync.WaitGroup
+}
+
+var _ core.Actor = (*ReceiverGRPC)(nil)
+
+func NewReceiverGRPC(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	bind, ok := params["bind"]
+	if !ok {
+		return nil, fmt.Errorf("grpc receiver %q is missing `bind` config", name)
+	}
+	tlsconf, err := buildServerTLSConfig(params)
+	if err != nil {
+		return nil, fmt.Errorf("grpc receiver %q failed to configure tls: %s", name, err)
+	}
+	maxsize := DefaultGRPCMaxMessageSize
+	if v, ok := params["max_message_size"]; ok {
+		if maxsize = v.(int); maxsize <= 0 {
+			return nil, fmt.Errorf("grpc receiver %q `max_message_size` should be a positive integer, got: %d", name, maxsize)
+		}
+	}
+	maxinflight := DefaultGRPCMaxInFlight
+	if v, ok := params["max_in_flight"]; ok {
+		if maxinflight = v.(int); maxinflight <= 0 {
+			return nil, fmt.Errorf("grpc receiver %q `max_in_flight` should be a positive integer, got: %d", name, maxinflight)
+		}
+	}
+
+	r := &ReceiverGRPC{
+		name:        name,
+		ctx:         ctx,
+		addr:        bind.(string),
+		tlsconf:     tlsconf,
+		maxsize:     maxsize,
+		maxinflight: maxinflight,
+		queue:       make(chan *core.Message),
+		done:        make(chan struct{}),
+	}
+	r.httpsrv = &http.Server{
+		Handler:   http.HandlerFunc(r.handleCall),
+		TLSConfig: tlsconf,
+	}
+
+	return r, nil
+}
+
+func (r *ReceiverGRPC) Name() string {
+	return r.name
+}
+
+func (r *ReceiverGRPC) Start() error {
+	l, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	r.listener = l
+	r.ctx.Logger().Info("starting grpc listener at %s", r.addr)
+	go func() {
+		// ServeTLS enables HTTP/2 on the listener
+		if err := r.httpsrv.ServeTLS(l, "", ""); err != nil && err != http.ErrServerClosed {
+			r.ctx.Logger().Error("grpc receiver %q failed to serve: %s", r.name, err)
+		}
+	}()
+
+	return nil
+}
+
+func (r *ReceiverGRPC) Stop() error {
+	close(r.done)
+	// Streams are long-living, the connections are not drained gracefully
+	err := r.httpsrv.Close()
+	r.wgconn.Wait()
+	close(r.queue)
+	r.wgpeer.Wait()
+
+	return err
+}
+
+func (r *ReceiverGRPC) Connect(nthreads int, peer core.Receiver) error {
+	for i := 0; i < nthreads; i++ {
+		r.wgpeer.Add(1)
+		go func() {
+			for msg := range r.queue {
+				if err := peer.Receive(msg); err != nil {
+					msg.Complete(core.MsgStatusFailed)
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
+				}
+			}
+			r.wgpeer.Done()
+		}()
+	}
+
+	return nil
+}
+
+func (r *ReceiverGRPC) Receive(msg *core.Message) error {
+	return fmt.Errorf("grpc receiver %q can not receive internal messages", r.name)
+}
+
+func (r *ReceiverGRPC) handleCall(rw http.ResponseWriter, req *http.Request) {
+	r.wgconn.Add(1)
+	defer r.wgconn.Done()
+
+	if req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.ProtoMajor != 2 {
+		http.Error(rw, "grpc requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+proto") {
+		http.Error(rw, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var err error
+	rw.Header().Set("Content-Type", "application/grpc")
+	switch req.URL.Path {
+	case grpcSendPath:
+		err = r.serveCall(rw, req, true)
+	case grpcStreamPath:
+		err = r.serveCall(rw, req, false)
+	default:
+		err = &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %s", req.URL.Path)}
+	}
+
+	code, text := grpcOK, ""
+	if err != nil {
+		r.ctx.Logger().Debug("grpc call %s from %s failed: %s", req.URL.Path, req.RemoteAddr, err)
+		code, text = grpcInternal, err.Error()
+		if gerr, ok := err.(*grpcError); ok {
+			code, text = gerr.code, gerr.text
+		}
+	}
+	rw.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if len(text) > 0 {
+		rw.Header().Set(http.TrailerPrefix+"Grpc-Message", text)
+	}
+}
+
+// serveCall reads the request messages and sends the acks back in order.
+// A unary call is expected to carry exactly one message.
+func (r *ReceiverGRPC) serveCall(rw http.ResponseWriter, req *http.Request, unary bool) error {
+	flusher, _ := rw.(http.Flusher)
+	rw.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	pending := make(chan *core.Message, r.maxinflight)
+	writeover := make(chan error, 1)
+	go func() {
+		var err error
+		for msg := range pending {
+			if err != nil {
+				// The client is gone, draining the queue
+				continue
+			}
+			var status core.MsgStatus
+			select {
+			case status = <-msg.AwaitChan():
+			case <-time.After(MsgSendTimeout):
+				status = core.MsgStatusTimedOut
+			}
+			if _, err = rw.Write(encodeGRPCFrame(encodeGRPCAck(status))); err == nil && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		writeover <- err
+	}()
+
+	err := r.readCall(req, unary, pending)
+	close(pending)
+	if werr := <-writeover; err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (r *ReceiverGRPC) readCall(req *http.Request, unary bool, pending chan<- *core.Message) error {
+	defer req.Body.Close()
+	cnt := 0
+	for {
+		payload, err := readGRPCFrame(req.Body, r.maxsize)
+		if err == io.EOF {
+			if unary && cnt == 0 {
+				return &grpcError{grpcInternal, "unary call carries no message"}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if cnt++; unary && cnt > 1 {
+			return &grpcError{grpcInternal, "unary call carries more than one message"}
+		}
+		body, meta, err := decodeGRPCMessage(payload)
+		if err != nil {
+			return err
+		}
+		msg := core.NewMessage(body)
+		for k, v := range meta {
+			msg.SetMeta(k, v)
+		}
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return &grpcError{grpcUnavailable, "receiver is shutting down"}
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+		pending <- msg
+	}
+}
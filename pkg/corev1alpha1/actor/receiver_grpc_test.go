@@ -0,0 +1,293 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func newTestReceiverGRPC(t *testing.T, params core.Params, mailbox chan *core.Message) *ReceiverGRPC {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverGRPC("receiver", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		switch string(msg.Body()) {
+		case "throttle-me":
+			msg.Complete(core.MsgStatusThrottled)
+		case "hold-me":
+			// Never completed
+		default:
+			msg.Complete(core.MsgStatusDone)
+		}
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	return rcv.(*ReceiverGRPC)
+}
+
+func newTestGRPCClient(t *testing.T, certfile string) *http.Client {
+	pem, err := ioutil.ReadFile(certfile)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: 2 * time.Second,
+	}
+}
+
+func grpcCall(t *testing.T, client *http.Client, rcv *ReceiverGRPC, method string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, "https://"+rcv.listener.Addr().String()+method, body)
+	if err != nil {
+		t.Fatalf("failed to build request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to call %s: %s", method, err)
+	}
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response: %s %s", resp.Proto, resp.Status)
+	}
+	return resp
+}
+
+// readGRPCAcks reads the response to the end and returns the acks and the
+// call status.
+func readGRPCAcks(t *testing.T, resp *http.Response) ([]core.MsgStatus, string, string) {
+	defer resp.Body.Close()
+	var acks []core.MsgStatus
+	for {
+		payload, err := readGRPCFrame(resp.Body, DefaultGRPCMaxMessageSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read ack: %s", err)
+		}
+		acks = append(acks, decodeGRPCAck(t, payload))
+	}
+	return acks, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+func TestReceiverGRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile := newTestCert(t, dir)
+
+	mailbox := make(chan *core.Message, 16)
+	rcv := newTestReceiverGRPC(t, core.Params{
+		"bind":             "127.0.0.1:0",
+		"tls_cert":         certfile,
+		"tls_key":          keyfile,
+		"max_message_size": 1024,
+	}, mailbox)
+	defer rcv.Stop()
+	client := newTestGRPCClient(t, certfile)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       []byte
+		wantacks   []core.MsgStatus
+		wantstatus string
+		wantmsg    string
+	}{
+		{
+			name:       "send",
+			method:     grpcSendPath,
+			body:       encodeGRPCFrame(encodeGRPCMessage("hello", map[string]string{"source": "web"})),
+			wantacks:   []core.MsgStatus{core.MsgStatusDone},
+			wantstatus: "0",
+		},
+		{
+			name:       "send throttled",
+			method:     grpcSendPath,
+			body:       encodeGRPCFrame(encodeGRPCMessage("throttle-me", nil)),
+			wantacks:   []core.MsgStatus{core.MsgStatusThrottled},
+			wantstatus: "0",
+		},
+		{
+			name:       "send without a message",
+			method:     grpcSendPath,
+			wantstatus: "13",
+			wantmsg:    "unary call carries no message",
+		},
+		{
+			name:       "malformed message",
+			method:     grpcStreamPath,
+			body:       encodeGRPCFrame([]byte{0x08, 0x01}),
+			wantstatus: "3",
+			wantmsg:    "malformed protobuf message",
+		},
+		{
+			name:       "too big",
+			method:     grpcStreamPath,
+			body:       encodeGRPCFrame(encodeGRPCMessage(string(make([]byte, 2048)), nil)),
+			wantstatus: "8",
+			wantmsg:    "message size 2051 exceeds the limit of 1024",
+		},
+		{
+			name:       "unknown method",
+			method:     "/flow.v1alpha1.Flow/Publish",
+			wantstatus: "12",
+			wantmsg:    "unknown method /flow.v1alpha1.Flow/Publish",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			resp := grpcCall(t, client, rcv, testCase.method, bytes.NewReader(testCase.body))
+			acks, status, message := readGRPCAcks(t, resp)
+			if !reflect.DeepEqual(acks, testCase.wantacks) || status != testCase.wantstatus || message != testCase.wantmsg {
+				t.Fatalf("unexpected response: got: %v %q %q, want: %v %q %q", acks, status, message,
+					testCase.wantacks, testCase.wantstatus, testCase.wantmsg)
+			}
+		})
+	}
+
+	msg := <-mailbox
+	if source, _ := msg.Meta("source"); string(msg.Body()) != "hello" || source != "web" {
+		t.Fatalf("unexpected message: %q %v", msg.Body(), source)
+	}
+}
+
+func TestReceiverGRPCStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile := newTestCert(t, dir)
+
+	mailbox := make(chan *core.Message, 16)
+	rcv := newTestReceiverGRPC(t, core.Params{
+		"bind":          "127.0.0.1:0",
+		"tls_cert":      certfile,
+		"tls_key":       keyfile,
+		"max_in_flight": 2,
+	}, mailbox)
+	defer rcv.Stop()
+	client := newTestGRPCClient(t, certfile)
+
+	reqr, reqw := io.Pipe()
+	resp := grpcCall(t, client, rcv, grpcStreamPath, reqr)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	// The acks are sent as soon as the status is known, in the order of
+	// the messages
+	for _, testCase := range []struct {
+		body string
+		want core.MsgStatus
+	}{
+		{"first", core.MsgStatusDone},
+		{"hold-me", core.MsgStatusTimedOut},
+		{"throttle-me", core.MsgStatusThrottled},
+	} {
+		if _, err := reqw.Write(encodeGRPCFrame(encodeGRPCMessage(testCase.body, nil))); err != nil {
+			t.Fatalf("failed to write message: %s", err)
+		}
+		payload, err := readGRPCFrame(reader, DefaultGRPCMaxMessageSize)
+		if err != nil {
+			t.Fatalf("failed to read ack: %s", err)
+		}
+		if got := decodeGRPCAck(t, payload); got != testCase.want {
+			t.Fatalf("unexpected ack for %q: got: %d, want: %d", testCase.body, got, testCase.want)
+		}
+	}
+
+	// The window is filled up by the messages awaiting their status, the
+	// acks still come in order
+	go func() {
+		for _, body := range []string{"hold-me", "hold-me", "last"} {
+			reqw.Write(encodeGRPCFrame(encodeGRPCMessage(body, nil)))
+		}
+		reqw.Close()
+	}()
+	var acks []core.MsgStatus
+	for {
+		payload, err := readGRPCFrame(reader, DefaultGRPCMaxMessageSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read ack: %s", err)
+		}
+		acks = append(acks, decodeGRPCAck(t, payload))
+	}
+	if want := []core.MsgStatus{core.MsgStatusTimedOut, core.MsgStatusTimedOut, core.MsgStatusDone}; !reflect.DeepEqual(acks, want) {
+		t.Fatalf("unexpected acks: got: %v, want: %v", acks, want)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("unexpected call status: %q", status)
+	}
+	if got := len(mailbox); got != 6 {
+		t.Fatalf("unexpected number of received messages: %d", got)
+	}
+}
+
+func TestNewReceiverGRPCMalformed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile := newTestCert(t, dir)
+
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{}, fmt.Errorf("grpc receiver \"receiver\" is missing `bind` config")},
+		{core.Params{"bind": ":4317"}, fmt.Errorf("grpc receiver \"receiver\" failed to configure tls: missing `tls_cert` config")},
+		{core.Params{"bind": ":4317", "tls_cert": certfile, "tls_key": keyfile, "max_in_flight": 0}, fmt.Errorf("grpc receiver \"receiver\" `max_in_flight` should be a positive integer, got: 0")},
+		{core.Params{"bind": ":4317", "tls_cert": certfile, "tls_key": keyfile, "max_message_size": -1}, fmt.Errorf("grpc receiver \"receiver\" `max_message_size` should be a positive integer, got: -1")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverGRPC("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
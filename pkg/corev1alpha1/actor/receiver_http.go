@@ -1,19 +1,32 @@
 package actor
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/DataDog/zstd"
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
 )
 
 const (
 	ShutdownTimeout    = 5 * time.Second
 	HttpMsgSendTimeout = 50 * time.Millisecond
+
+	DefaultHttpMaxBatchSize = 1000
+	DefaultHttpMaxBodySize  = 32 * 1024 * 1024
 )
 
 type codetext struct {
@@ -31,13 +44,36 @@ var MsgStatusToHttpResp = map[core.MsgStatus]codetext{
 	core.MsgStatusThrottled:   {http.StatusTooManyRequests, []byte("Message throttled")},
 }
 
+// ReceiverHTTP accepts a single message per request on `/v1alpha1` and
+// batches on `/v1alpha1/batch`. The query parameters and the request
+// headers listed in `headers` are copied into the message meta, the header
+// meta keys are lowercased.
+//
+// A batch is either an NDJSON body (`application/x-ndjson`), every
+// non-empty line of which is a message (lines that are not valid JSON are
+// reported invalid and not sent), or a multipart body, every part of which
+// is a message. Part headers listed in `headers` override the request
+// ones. The body might be gzip or zstd encoded (see Content-Encoding). A
+// batch is limited to `max_batch_size` messages and `max_body_size` bytes
+// once decoded. The response is a JSON array of per-message statuses, the
+// response code is 207 unless all the messages were sent.
+//
+// The receiver serves HTTPS if `tls_cert` and `tls_key` are provided (see
+// buildServerTLSConfig). Once an authentication method is configured, the
+// requests are authenticated and the messages are authorized as described
+// in httpAuth. The principal is stamped into the meta as auth.principal.
 type ReceiverHTTP struct {
-	name    string
-	bind    string
-	ctx     *core.Context
-	queue   chan *core.Message
-	httpsrv *http.Server
-	wg      sync.WaitGroup
+	name     string
+	bind     string
+	ctx      *core.Context
+	queue    chan *core.Message
+	httpsrv  *http.Server
+	headers  []string
+	maxbatch int
+	maxbody  int64
+	tlsconf  *tls.Config
+	auth     *httpAuth
+	wg       sync.WaitGroup
 }
 
 var _ core.Actor = (*ReceiverHTTP)(nil)
@@ -49,18 +85,54 @@ func NewReceiverHTTP(name string, ctx *core.Context, params core.Params) (core.A
 	}
 
 	r := &ReceiverHTTP{
-		name:  name,
-		ctx:   ctx,
-		bind:  bind.(string),
-		queue: make(chan *core.Message),
+		name:     name,
+		ctx:      ctx,
+		bind:     bind.(string),
+		queue:    make(chan *core.Message),
+		maxbatch: DefaultHttpMaxBatchSize,
+		maxbody:  DefaultHttpMaxBodySize,
 	}
 
+	if v, ok := params["headers"]; ok {
+		headers, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("http receiver %q got malformed `headers` config: %s", name, err)
+		}
+		r.headers = headers
+	}
+	if v, ok := params["max_batch_size"]; ok {
+		if r.maxbatch = v.(int); r.maxbatch <= 0 {
+			return nil, fmt.Errorf("http receiver %q `max_batch_size` should be a positive integer, got: %d", name, r.maxbatch)
+		}
+	}
+	if v, ok := params["max_body_size"]; ok {
+		maxbody := v.(int)
+		if maxbody <= 0 {
+			return nil, fmt.Errorf("http receiver %q `max_body_size` should be a positive integer, got: %d", name, maxbody)
+		}
+		r.maxbody = int64(maxbody)
+	}
+	if _, ok := params["tls_cert"]; ok {
+		tlsconf, err := buildServerTLSConfig(params)
+		if err != nil {
+			return nil, fmt.Errorf("http receiver %q failed to configure tls: %s", name, err)
+		}
+		r.tlsconf = tlsconf
+	}
+	auth, err := newHTTPAuth(params)
+	if err != nil {
+		return nil, fmt.Errorf("http receiver %q failed to configure auth: %s", name, err)
+	}
+	r.auth = auth
+
 	srvmx := http.NewServeMux()
 	srvmx.HandleFunc("/v1alpha1", r.handleReqV1alpha1)
+	srvmx.HandleFunc("/v1alpha1/batch", r.handleBatchV1alpha1)
 
 	srv := &http.Server{
-		Addr:    bind.(string),
-		Handler: srvmx,
+		Addr:      bind.(string),
+		Handler:   srvmx,
+		TLSConfig: r.tlsconf,
 	}
 
 	r.httpsrv = srv
@@ -80,7 +152,13 @@ func (r *ReceiverHTTP) Start() error {
 
 func (r *ReceiverHTTP) runsrv() {
 	r.wg.Add(1)
-	if err := r.httpsrv.ListenAndServe(); err != nil {
+	var err error
+	if r.tlsconf != nil {
+		err = r.httpsrv.ListenAndServeTLS("", "")
+	} else {
+		err = r.httpsrv.ListenAndServe()
+	}
+	if err != nil {
 		switch err {
 		case http.ErrServerClosed:
 			r.ctx.Logger().Trace("http receiver %q was successfully terminated", r.name)
@@ -108,7 +186,7 @@ func (r *ReceiverHTTP) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range r.queue {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					r.ctx.Logger().Error(err.Error())
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			r.wg.Done()
@@ -129,6 +207,11 @@ func (r *ReceiverHTTP) handleReqV1alpha1(rw http.ResponseWriter, req *http.Reque
 		return
 	}
 
+	principal, ok := r.authenticate(rw, req)
+	if !ok {
+		return
+	}
+
 	body, err := ioutil.ReadAll(req.Body)
 	defer req.Body.Close()
 	if err != nil {
@@ -137,8 +220,9 @@ func (r *ReceiverHTTP) handleReqV1alpha1(rw http.ResponseWriter, req *http.Reque
 	}
 
 	msg := core.NewMessage(body)
-	for k, v := range req.URL.Query() {
-		msg.SetMeta(k, v[0])
+	r.setReqMeta(msg, req)
+	if !r.authorize(rw, principal, []*core.Message{msg}) {
+		return
 	}
 
 	r.queue <- msg
@@ -156,3 +240,240 @@ func (r *ReceiverHTTP) handleReqV1alpha1(rw http.ResponseWriter, req *http.Reque
 		rw.Write([]byte("Timed out to send message"))
 	}
 }
+
+// authenticate replies with an error and returns false unless the request
+// is authenticated. The principal is empty if no authentication is
+// configured.
+func (r *ReceiverHTTP) authenticate(rw http.ResponseWriter, req *http.Request) (string, bool) {
+	if r.auth == nil {
+		return "", true
+	}
+	principal, err := r.auth.authenticate(req, r.maxbody)
+	if err != nil {
+		r.ctx.Logger().Debug("http receiver %q rejected a request from %s: %s", r.name, req.RemoteAddr, err)
+		code := http.StatusUnauthorized
+		if aerr, ok := err.(*httpAuthError); ok {
+			code = aerr.code
+		}
+		if code == http.StatusUnauthorized {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="flow"`)
+		}
+		http.Error(rw, err.Error(), code)
+		return "", false
+	}
+	return principal, true
+}
+
+// authorize replies with an error and returns false if any of the messages
+// is not allowed by the policy. The messages are stamped with the
+// principal otherwise.
+func (r *ReceiverHTTP) authorize(rw http.ResponseWriter, principal string, msgs []*core.Message) bool {
+	if r.auth == nil {
+		return true
+	}
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		if err := r.auth.authorize(principal, msg); err != nil {
+			r.ctx.Logger().With(msg.LogFields()).Debug("http receiver %q rejected a message: %s", r.name, err)
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// setReqMeta copies the first value of every query parameter and the
+// selected request headers into the message meta.
+func (r *ReceiverHTTP) setReqMeta(msg *core.Message, req *http.Request) {
+	for k, v := range req.URL.Query() {
+		msg.SetMeta(k, v[0])
+	}
+	r.setHeaderMeta(msg, req.Header)
+}
+
+func (r *ReceiverHTTP) setHeaderMeta(msg *core.Message, header map[string][]string) {
+	for _, name := range r.headers {
+		if v := http.Header(header).Get(name); len(v) > 0 {
+			msg.SetMeta(strings.ToLower(name), v)
+		}
+	}
+}
+
+type httpBatchStatus struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+}
+
+func (r *ReceiverHTTP) handleBatchV1alpha1(rw http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal, ok := r.authenticate(rw, req)
+	if !ok {
+		return
+	}
+	mediatype, mtparams, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(rw, "Malformed content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var body io.Reader = req.Body
+	switch enc := strings.ToLower(req.Header.Get("Content-Encoding")); enc {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(rw, "Malformed gzip body", http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	case "zstd":
+		zr := zstd.NewReader(req.Body)
+		defer zr.Close()
+		body = zr
+	default:
+		http.Error(rw, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	// One extra byte tells an oversized body apart
+	lbody := &io.LimitedReader{R: body, N: r.maxbody + 1}
+
+	var msgs []*core.Message
+	switch {
+	case mediatype == "application/x-ndjson" || mediatype == "application/ndjson":
+		msgs, err = r.readNDJSONBatch(lbody, req)
+	case strings.HasPrefix(mediatype, "multipart/"):
+		msgs, err = r.readMultipartBatch(multipart.NewReader(lbody, mtparams["boundary"]), req)
+	default:
+		http.Error(rw, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	switch {
+	case lbody.N <= 0:
+		http.Error(rw, fmt.Sprintf("Batch body exceeds the limit of %d bytes", r.maxbody), http.StatusRequestEntityTooLarge)
+		return
+	case err == errHttpBatchTooBig:
+		http.Error(rw, fmt.Sprintf("Batch exceeds the limit of %d messages", r.maxbatch), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		r.ctx.Logger().Debug("http receiver %q failed to read a batch: %s", r.name, err)
+		http.Error(rw, "Malformed batch", http.StatusBadRequest)
+		return
+	case len(msgs) == 0:
+		http.Error(rw, "Empty batch, ignored", http.StatusBadRequest)
+		return
+	}
+	if !r.authorize(rw, principal, msgs) {
+		return
+	}
+
+	statuses := r.sendBatch(msgs)
+	resp := make([]httpBatchStatus, len(statuses))
+	code := http.StatusOK
+	for i, s := range statuses {
+		ct, ok := MsgStatusToHttpResp[s]
+		if !ok {
+			ct = codetext{http.StatusTeapot, []byte("This should not happen")}
+		}
+		resp[i] = httpBatchStatus{ct.code, string(ct.text)}
+		if s != core.MsgStatusDone {
+			code = http.StatusMultiStatus
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		r.ctx.Logger().Error(err.Error())
+		http.Error(rw, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(data)
+}
+
+var errHttpBatchTooBig = fmt.Errorf("batch is too big")
+
+// readNDJSONBatch builds a message of every non-empty line. Lines that are
+// not valid JSON are kept as nil, they are reported as invalid.
+func (r *ReceiverHTTP) readNDJSONBatch(body io.Reader, req *http.Request) ([]*core.Message, error) {
+	var msgs []*core.Message
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, int(r.maxbody)+1)
+	for scanner.Scan() {
+		line := dropCR(scanner.Bytes())
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if len(msgs) == r.maxbatch {
+			return nil, errHttpBatchTooBig
+		}
+		if !json.Valid(line) {
+			msgs = append(msgs, nil)
+			continue
+		}
+		msg := core.NewMessage(line)
+		r.setReqMeta(msg, req)
+		msgs = append(msgs, msg)
+	}
+	return msgs, scanner.Err()
+}
+
+func (r *ReceiverHTTP) readMultipartBatch(mr *multipart.Reader, req *http.Request) ([]*core.Message, error) {
+	var msgs []*core.Message
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == r.maxbatch {
+			return nil, errHttpBatchTooBig
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		msg := core.NewMessage(body)
+		r.setReqMeta(msg, req)
+		r.setHeaderMeta(msg, part.Header)
+		msgs = append(msgs, msg)
+	}
+}
+
+// sendBatch sends the messages and awaits them at once. The messages which
+// are not completed within HttpMsgSendTimeout are reported as timed out.
+func (r *ReceiverHTTP) sendBatch(msgs []*core.Message) []core.MsgStatus {
+	statuses := make([]core.MsgStatus, len(msgs))
+	for i, msg := range msgs {
+		if msg == nil {
+			statuses[i] = core.MsgStatusInvalid
+			continue
+		}
+		r.queue <- msg
+	}
+	timeout := time.After(HttpMsgSendTimeout)
+	for i, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		select {
+		case s := <-msg.AwaitChan():
+			statuses[i] = s
+		case <-timeout:
+			for j := i; j < len(msgs); j++ {
+				if msgs[j] != nil {
+					statuses[j] = core.MsgStatusTimedOut
+				}
+			}
+			return statuses
+		}
+	}
+	return statuses
+}
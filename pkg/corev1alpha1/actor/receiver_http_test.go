@@ -2,13 +2,21 @@ package actor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DataDog/zstd"
+
 	"github.com/awesome-flow/flow/pkg/cfg"
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
 	testutil "github.com/awesome-flow/flow/pkg/util/test"
@@ -113,3 +121,320 @@ func TestHandleReqV1alpha1(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleBatchV1alpha1(t *testing.T) {
+	gzipped := func(data string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(data))
+		w.Close()
+		return buf.Bytes()
+	}
+	zstded := func(data string) []byte {
+		res, err := zstd.Compress(nil, []byte(data))
+		if err != nil {
+			t.Fatalf("failed to compress: %s", err)
+		}
+		return res
+	}
+	var mpbody bytes.Buffer
+	mpw := multipart.NewWriter(&mpbody)
+	for _, part := range []struct {
+		body   string
+		source string
+	}{{"first", "part"}, {"throttle", ""}} {
+		header := textproto.MIMEHeader{}
+		if len(part.source) > 0 {
+			header.Set("X-Source", part.source)
+		}
+		w, _ := mpw.CreatePart(header)
+		w.Write([]byte(part.body))
+	}
+	mpw.Close()
+
+	ok := httpBatchStatus{http.StatusOK, "OK"}
+	throttled := httpBatchStatus{http.StatusTooManyRequests, "Message throttled"}
+	invalid := httpBatchStatus{http.StatusBadRequest, "Invalid message"}
+	tests := []struct {
+		name      string
+		method    string
+		headers   map[string]string
+		body      []byte
+		wantcode  int
+		wantresp  string
+		wantmsgs  []string
+		wantmetas []map[string]interface{}
+	}{
+		{
+			name:     "ndjson",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson", "X-Source": "req"},
+			body:     []byte("{\"a\":1}\r\n\n{\"b\":2}\n"),
+			wantcode: http.StatusOK,
+			wantresp: toBatchResp(t, ok, ok),
+			wantmsgs: []string{`{"a":1}`, `{"b":2}`},
+			wantmetas: []map[string]interface{}{
+				{"q": "1", "x-source": "req"},
+				{"q": "1", "x-source": "req"},
+			},
+		},
+		{
+			name:     "ndjson with an invalid line",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson; charset=utf-8"},
+			body:     []byte("{\"a\":1}\nnot json\n\"throttle\""),
+			wantcode: http.StatusMultiStatus,
+			wantresp: toBatchResp(t, ok, invalid, throttled),
+			wantmsgs: []string{`{"a":1}`, `"throttle"`},
+		},
+		{
+			name:     "gzip ndjson",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson", "Content-Encoding": "gzip"},
+			body:     gzipped("1\n2\n"),
+			wantcode: http.StatusOK,
+			wantresp: toBatchResp(t, ok, ok),
+			wantmsgs: []string{"1", "2"},
+		},
+		{
+			name:     "zstd multipart",
+			headers:  map[string]string{"Content-Type": mpw.FormDataContentType(), "Content-Encoding": "zstd", "X-Source": "req"},
+			body:     zstded(mpbody.String()),
+			wantcode: http.StatusMultiStatus,
+			wantresp: toBatchResp(t, ok, throttled),
+			wantmsgs: []string{"first", "throttle"},
+			wantmetas: []map[string]interface{}{
+				{"q": "1", "x-source": "part"},
+				{"q": "1", "x-source": "req"},
+			},
+		},
+		{
+			name:     "too many messages",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson"},
+			body:     []byte("1\n2\n3\n4\n"),
+			wantcode: http.StatusRequestEntityTooLarge,
+			wantresp: "Batch exceeds the limit of 3 messages\n",
+		},
+		{
+			name:     "too big body",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson"},
+			body:     []byte("\"" + strings.Repeat("a", 512) + "\""),
+			wantcode: http.StatusRequestEntityTooLarge,
+			wantresp: "Batch body exceeds the limit of 512 bytes\n",
+		},
+		{
+			name:     "empty",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson"},
+			body:     []byte("\n\n"),
+			wantcode: http.StatusBadRequest,
+			wantresp: "Empty batch, ignored\n",
+		},
+		{
+			name:     "unsupported encoding",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson", "Content-Encoding": "br"},
+			body:     []byte("1"),
+			wantcode: http.StatusUnsupportedMediaType,
+			wantresp: "Unsupported content encoding\n",
+		},
+		{
+			name:     "unsupported content type",
+			headers:  map[string]string{"Content-Type": "text/plain"},
+			body:     []byte("1"),
+			wantcode: http.StatusUnsupportedMediaType,
+			wantresp: "Unsupported content type\n",
+		},
+		{
+			name:     "malformed gzip",
+			headers:  map[string]string{"Content-Type": "application/x-ndjson", "Content-Encoding": "gzip"},
+			body:     []byte("1"),
+			wantcode: http.StatusBadRequest,
+			wantresp: "Malformed gzip body\n",
+		},
+		{
+			name:     "wrong method",
+			method:   http.MethodGet,
+			wantcode: http.StatusMethodNotAllowed,
+			wantresp: "Method not allowed\n",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, err := core.NewContext(core.NewConfig(cfg.NewRepository()))
+			if err != nil {
+				t.Fatalf("failed to create context: %s", err)
+			}
+			if err := ctx.Start(); err != nil {
+				t.Fatalf("failed to start context: %s", err)
+			}
+			rcv, err := NewReceiverHTTP("receiver-http", ctx, core.Params{
+				"bind":           "0.0.0.0:8080",
+				"headers":        []interface{}{"X-Source"},
+				"max_batch_size": 3,
+				"max_body_size":  512,
+			})
+			if err != nil {
+				t.Fatalf("failed to create receiver: %s", err)
+			}
+			peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+			if err != nil {
+				t.Fatalf("failed to create test actor: %s", err)
+			}
+			if err := rcv.Connect(1, peer); err != nil {
+				t.Fatalf("failed to connect test actor: %s", err)
+			}
+			var msgs []string
+			var metas []map[string]interface{}
+			peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+				msgs = append(msgs, string(msg.Body()))
+				meta := make(map[string]interface{})
+				for _, k := range msg.MetaKeys() {
+					meta[k.(string)], _ = msg.Meta(k)
+				}
+				metas = append(metas, meta)
+				status := core.MsgStatusDone
+				if strings.Contains(string(msg.Body()), "throttle") {
+					status = core.MsgStatusThrottled
+				}
+				msg.Complete(status)
+				peer.(*flowtest.TestActor).Flush()
+			})
+
+			method := testCase.method
+			if len(method) == 0 {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, "http://example.com/v1alpha1/batch?q=1", bytes.NewReader(testCase.body))
+			if err != nil {
+				t.Fatalf("failed to build request: %s", err)
+			}
+			for k, v := range testCase.headers {
+				req.Header.Set(k, v)
+			}
+			rw := &testResponseWriter{headers: make(http.Header)}
+			rcv.(*ReceiverHTTP).handleBatchV1alpha1(rw, req)
+
+			if rw.status != testCase.wantcode || rw.String() != testCase.wantresp {
+				t.Fatalf("unexpected response: got: %d %q, want: %d %q", rw.status, rw.String(), testCase.wantcode, testCase.wantresp)
+			}
+			if !reflect.DeepEqual(msgs, testCase.wantmsgs) {
+				t.Fatalf("unexpected messages: got: %q, want: %q", msgs, testCase.wantmsgs)
+			}
+			if testCase.wantmetas != nil && !reflect.DeepEqual(metas, testCase.wantmetas) {
+				t.Fatalf("unexpected meta: got: %v, want: %v", metas, testCase.wantmetas)
+			}
+		})
+	}
+}
+
+func toBatchResp(t *testing.T, statuses ...httpBatchStatus) string {
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		t.Fatalf("failed to marshal statuses: %s", err)
+	}
+	return string(data)
+}
+
+func TestReceiverHTTPAuth(t *testing.T) {
+	ctx, err := core.NewContext(core.NewConfig(cfg.NewRepository()))
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverHTTP("receiver-http", ctx, core.Params{
+		"bind":           "0.0.0.0:8080",
+		"auth_tokens":    []interface{}{"partner:p4rtn3r"},
+		"auth_hmac_keys": []interface{}{"signer:k3y"},
+		"auth_policy":    []interface{}{"partner:sendto=partner-*", "signer:sendto=*"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect test actor: %s", err)
+	}
+	var principals []interface{}
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		principal, _ := msg.Meta(MetaHTTPPrincipal)
+		principals = append(principals, principal)
+		msg.Complete(core.MsgStatusDone)
+		peer.(*flowtest.TestActor).Flush()
+	})
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		batch    bool
+		prepare  func(req *http.Request)
+		wantcode int
+		wantresp string
+	}{
+		{
+			name:     "anonymous",
+			url:      "http://example.com/v1alpha1",
+			body:     "hello",
+			prepare:  func(req *http.Request) {},
+			wantcode: http.StatusUnauthorized,
+			wantresp: "Authentication required\n",
+		},
+		{
+			name:     "forbidden destination",
+			url:      "http://example.com/v1alpha1?sendto=billing",
+			body:     "hello",
+			prepare:  func(req *http.Request) { req.Header.Set("Authorization", "Bearer p4rtn3r") },
+			wantcode: http.StatusForbidden,
+			wantresp: "Principal \"partner\" is not allowed to set sendto=\"billing\"\n",
+		},
+		{
+			name:     "allowed destination",
+			url:      "http://example.com/v1alpha1?sendto=partner-logs&auth.principal=admin",
+			body:     "hello",
+			prepare:  func(req *http.Request) { req.Header.Set("Authorization", "Bearer p4rtn3r") },
+			wantcode: http.StatusOK,
+			wantresp: "OK",
+		},
+		{
+			name:  "signed batch",
+			url:   "http://example.com/v1alpha1/batch?sendto=billing",
+			body:  "1\n2\n",
+			batch: true,
+			prepare: func(req *http.Request) {
+				req.Header.Set("Content-Type", "application/x-ndjson")
+				signTestHTTPRequest(req, "signer", "k3y", time.Now(), []byte("1\n2\n"))
+			},
+			wantcode: http.StatusOK,
+			wantresp: toBatchResp(t, httpBatchStatus{http.StatusOK, "OK"}, httpBatchStatus{http.StatusOK, "OK"}),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			principals = nil
+			req, err := http.NewRequest(http.MethodPost, testCase.url, strings.NewReader(testCase.body))
+			if err != nil {
+				t.Fatalf("failed to build request: %s", err)
+			}
+			testCase.prepare(req)
+			rw := &testResponseWriter{headers: make(http.Header)}
+			if testCase.batch {
+				rcv.(*ReceiverHTTP).handleBatchV1alpha1(rw, req)
+			} else {
+				rcv.(*ReceiverHTTP).handleReqV1alpha1(rw, req)
+			}
+			if rw.status != testCase.wantcode || rw.String() != testCase.wantresp {
+				t.Fatalf("unexpected response: got: %d %q, want: %d %q", rw.status, rw.String(), testCase.wantcode, testCase.wantresp)
+			}
+			if rw.status == http.StatusUnauthorized && len(rw.Header().Get("WWW-Authenticate")) == 0 {
+				t.Fatalf("missing WWW-Authenticate header")
+			}
+			for _, principal := range principals {
+				if principal != "partner" && principal != "signer" {
+					t.Fatalf("unexpected principal: %v", principal)
+				}
+			}
+		})
+	}
+}
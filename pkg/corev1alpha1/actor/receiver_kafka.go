@@ -0,0 +1,887 @@
+package actor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	KafkaOffsetResetEarliest = "earliest"
+	KafkaOffsetResetLatest   = "latest"
+
+	DefaultKafkaSessionTimeout    = 10 * time.Second
+	DefaultKafkaRebalanceTimeout  = 60 * time.Second
+	DefaultKafkaHeartbeatInterval = 3 * time.Second
+	DefaultKafkaMaxWait           = 500 * time.Millisecond
+	DefaultKafkaMaxBytes          = 1024 * 1024
+	DefaultKafkaRetryBackoff      = 500 * time.Millisecond
+
+	kafkaAssignorRange    = "range"
+	kafkaOffsetEarliest   = -2
+	kafkaOffsetLatest     = -1
+	kafkaProtocolConsumer = "consumer"
+)
+
+// ReceiverKafka consumes `topics` as a member of the consumer `group`.
+// Every record becomes a message with the kafka.topic, kafka.partition,
+// kafka.offset and kafka.key meta attributes, the record headers are stored
+// as kafka.header.<name>.
+// Offsets are committed once the messages complete with MsgStatusDone,
+// in the offset order: if a message fails, the partition is rewound to it
+// and the records starting from it are redelivered after a backoff. This
+// makes the forwarding at-least-once.
+// Partitions with no committed offset start from `offset_reset`: earliest
+// or latest (the default). The partitions are assigned with the range
+// assignor, just like the Java client does by default.
+type ReceiverKafka struct {
+	name       string
+	ctx        *core.Context
+	cluster    *kafkaCluster
+	group      string
+	topics     []string
+	reset      string
+	session    time.Duration
+	rebalance  time.Duration
+	heartbeat  time.Duration
+	maxwait    time.Duration
+	maxbytes   int32
+	backoff    time.Duration
+	coord      *kafkaConn
+	memberid   string
+	generation int32
+	leaders    map[kafkaTopicPartition]int32
+	positions  map[kafkaTopicPartition]int64
+	queue      chan *core.Message
+	done       chan struct{}
+	wgloop     sync.WaitGroup
+	wgpeer     sync.WaitGroup
+}
+
+var _ core.Actor = (*ReceiverKafka)(nil)
+
+func NewReceiverKafka(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	var addrs []string
+	if bind, ok := params["bind"]; ok && len(bind.(string)) > 0 {
+		addrs = append(addrs, bind.(string))
+	}
+	if v, ok := params["brokers"]; ok {
+		brokers, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("kafka receiver %q got malformed `brokers` config: %s", name, err)
+		}
+		for _, broker := range brokers {
+			addrs = append(addrs, strings.TrimPrefix(broker, "kafka://"))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("kafka receiver %q is missing `bind` config", name)
+	}
+	clientid := DefaultKafkaClientID
+	if v, ok := params["client_id"]; ok {
+		clientid = v.(string)
+	}
+	group, ok := params["group"]
+	if !ok {
+		return nil, fmt.Errorf("kafka receiver %q is missing `group` config", name)
+	}
+	v, ok := params["topics"]
+	if !ok {
+		return nil, fmt.Errorf("kafka receiver %q is missing `topics` config", name)
+	}
+	topics, err := toStrList(v)
+	if err != nil || len(topics) == 0 {
+		return nil, fmt.Errorf("kafka receiver %q got malformed `topics` config", name)
+	}
+	sort.Strings(topics)
+
+	r := &ReceiverKafka{
+		name:      name,
+		ctx:       ctx,
+		cluster:   newKafkaCluster(addrs, clientid, TCPConnTimeout),
+		group:     group.(string),
+		topics:    topics,
+		reset:     KafkaOffsetResetLatest,
+		session:   DefaultKafkaSessionTimeout,
+		rebalance: DefaultKafkaRebalanceTimeout,
+		heartbeat: DefaultKafkaHeartbeatInterval,
+		maxwait:   DefaultKafkaMaxWait,
+		maxbytes:  DefaultKafkaMaxBytes,
+		backoff:   DefaultKafkaRetryBackoff,
+		queue:     make(chan *core.Message),
+		done:      make(chan struct{}),
+	}
+	if v, ok := params["offset_reset"]; ok {
+		r.reset = v.(string)
+	}
+	if r.reset != KafkaOffsetResetEarliest && r.reset != KafkaOffsetResetLatest {
+		return nil, fmt.Errorf("kafka receiver %q got an unknown `offset_reset`: %q", name, r.reset)
+	}
+	for param, field := range map[string]*time.Duration{
+		"session_timeout":    &r.session,
+		"rebalance_timeout":  &r.rebalance,
+		"heartbeat_interval": &r.heartbeat,
+		"max_wait":           &r.maxwait,
+		"retry_backoff":      &r.backoff,
+	} {
+		if v, ok := params[param]; ok {
+			*field = time.Duration(v.(int)) * time.Millisecond
+		}
+	}
+	if v, ok := params["max_bytes"]; ok {
+		r.maxbytes = int32(v.(int))
+	}
+
+	return r, nil
+}
+
+func (r *ReceiverKafka) Name() string {
+	return r.name
+}
+
+func (r *ReceiverKafka) Start() error {
+	r.wgloop.Add(1)
+	go r.run()
+
+	return nil
+}
+
+func (r *ReceiverKafka) Stop() error {
+	close(r.done)
+	r.wgloop.Wait()
+	close(r.queue)
+	r.wgpeer.Wait()
+
+	return nil
+}
+
+func (r *ReceiverKafka) Connect(nthreads int, peer core.Receiver) error {
+	for i := 0; i < nthreads; i++ {
+		r.wgpeer.Add(1)
+		go func() {
+			for msg := range r.queue {
+				if err := peer.Receive(msg); err != nil {
+					msg.Complete(core.MsgStatusFailed)
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
+				}
+			}
+			r.wgpeer.Done()
+		}()
+	}
+
+	return nil
+}
+
+func (r *ReceiverKafka) Receive(*core.Message) error {
+	return fmt.Errorf("kafka receiver %q can not receive internal messages", r.name)
+}
+
+func (r *ReceiverKafka) isdone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the duration and tells if the receiver is still running.
+func (r *ReceiverKafka) sleep(d time.Duration) bool {
+	select {
+	case <-r.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// run is the consumer main loop: it joins the group, polls the assigned
+// partitions and rejoins once the group rebalances.
+func (r *ReceiverKafka) run() {
+	defer r.wgloop.Done()
+	for !r.isdone() {
+		if err := r.join(); err != nil {
+			r.ctx.Logger().Error("kafka receiver %q failed to join group %q: %s", r.name, r.group, err)
+			r.sleep(r.backoff)
+			continue
+		}
+		r.ctx.Logger().Info("kafka receiver %q joined group %q, generation %d, %d partitions assigned", r.name, r.group, r.generation, len(r.positions))
+		rejoin := make(chan struct{})
+		stophb := make(chan struct{})
+		var wghb sync.WaitGroup
+		wghb.Add(1)
+		go r.heartbeatLoop(r.coord, r.generation, r.memberid, rejoin, stophb, &wghb)
+	poll:
+		for {
+			select {
+			case <-r.done:
+				break poll
+			case <-rejoin:
+				break poll
+			default:
+			}
+			if err := r.poll(); err != nil {
+				if code, ok := err.(kafkaError); ok && isKafkaRebalanceErr(int16(code)) {
+					break poll
+				}
+				r.ctx.Logger().Error("kafka receiver %q poll failed: %s", r.name, err)
+				r.sleep(r.backoff)
+			}
+		}
+		close(stophb)
+		wghb.Wait()
+	}
+	r.leave()
+	r.cluster.close()
+}
+
+func isKafkaRebalanceErr(code int16) bool {
+	return code == KafkaErrRebalanceInProgress ||
+		code == KafkaErrIllegalGeneration ||
+		code == KafkaErrUnknownMemberID ||
+		code == KafkaErrNotCoordinator
+}
+
+func (r *ReceiverKafka) heartbeatLoop(coord *kafkaConn, generation int32, memberid string, rejoin, stop chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(r.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		e := &kafkaEncoder{}
+		e.string(r.group)
+		e.int32(generation)
+		e.string(memberid)
+		resp, err := coord.roundTrip(kafkaAPIHeartbeat, 1, e.buf, false)
+		if err == nil {
+			d := &kafkaDecoder{buf: resp}
+			d.int32() // throttle time
+			if code := d.int16(); code != KafkaErrNone {
+				err = kafkaError(code)
+			} else {
+				err = d.err
+			}
+		}
+		if err != nil {
+			r.ctx.Logger().Info("kafka receiver %q heartbeat failed, rejoining group %q: %s", r.name, r.group, err)
+			close(rejoin)
+			return
+		}
+	}
+}
+
+// join finds the group coordinator, joins the group, syncs the assignment
+// and resolves the starting offsets.
+func (r *ReceiverKafka) join() error {
+	if r.coord != nil {
+		r.coord.Close()
+		r.coord = nil
+	}
+	if _, err := r.cluster.bootstrap(nil); err != nil {
+		return err
+	}
+	coord, err := r.findCoordinator()
+	if err != nil {
+		return err
+	}
+	r.coord = coord
+
+	// Join group
+	e := &kafkaEncoder{}
+	e.string(r.group)
+	e.int32(int32(r.session / time.Millisecond))
+	e.int32(int32(r.rebalance / time.Millisecond))
+	e.string(r.memberid)
+	e.string(kafkaProtocolConsumer)
+	e.int32(1)
+	e.string(kafkaAssignorRange)
+	e.bytes(encodeKafkaSubscription(r.topics))
+	resp, err := r.coord.roundTrip(kafkaAPIJoinGroup, 2, e.buf, false)
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{buf: resp}
+	d.int32() // throttle time
+	if code := d.int16(); code != KafkaErrNone {
+		if code == KafkaErrUnknownMemberID {
+			r.memberid = ""
+		}
+		return kafkaError(code)
+	}
+	r.generation = d.int32()
+	d.string() // protocol name
+	leader := d.string()
+	r.memberid = d.string()
+	subscriptions := make(map[string][]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		member := d.string()
+		topics, err := decodeKafkaSubscription(d.bytes())
+		if err != nil {
+			return err
+		}
+		subscriptions[member] = topics
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	// The leader computes the assignment for the entire group
+	var assignments map[string]map[string][]int32
+	if leader == r.memberid {
+		var topics []string
+		for _, subscribed := range subscriptions {
+			topics = append(topics, subscribed...)
+		}
+		md, err := r.cluster.metadata(topics)
+		if err != nil {
+			return err
+		}
+		partitions := make(map[string]int)
+		for topic, parts := range md.Topics {
+			partitions[topic] = len(parts)
+		}
+		assignments = kafkaRangeAssign(subscriptions, partitions)
+	}
+	e = &kafkaEncoder{}
+	e.string(r.group)
+	e.int32(r.generation)
+	e.string(r.memberid)
+	e.int32(int32(len(assignments)))
+	for member, assignment := range assignments {
+		e.string(member)
+		e.bytes(encodeKafkaAssignment(assignment))
+	}
+	if resp, err = r.coord.roundTrip(kafkaAPISyncGroup, 1, e.buf, false); err != nil {
+		return err
+	}
+	d = &kafkaDecoder{buf: resp}
+	d.int32() // throttle time
+	if code := d.int16(); code != KafkaErrNone {
+		return kafkaError(code)
+	}
+	assignment, err := decodeKafkaAssignment(d.bytes())
+	if err != nil {
+		return err
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	r.positions = make(map[kafkaTopicPartition]int64)
+	for topic, partitions := range assignment {
+		for _, partition := range partitions {
+			r.positions[kafkaTopicPartition{topic, partition}] = -1
+		}
+	}
+	if err := r.refreshLeaders(); err != nil {
+		return err
+	}
+	return r.fetchOffsets()
+}
+
+func (r *ReceiverKafka) findCoordinator() (*kafkaConn, error) {
+	conn, err := r.cluster.anyConn()
+	if err != nil {
+		return nil, err
+	}
+	e := &kafkaEncoder{}
+	e.string(r.group)
+	e.int8(0) // group key type
+	resp, err := conn.roundTrip(kafkaAPIFindCoordinator, 1, e.buf, false)
+	if err != nil {
+		r.cluster.dropConn(conn)
+		return nil, err
+	}
+	d := &kafkaDecoder{buf: resp}
+	d.int32() // throttle time
+	code := d.int16()
+	d.string() // error message
+	d.int32()  // node id
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if code != KafkaErrNone {
+		return nil, kafkaError(code)
+	}
+	// JoinGroup blocks until the group rebalances
+	return dialKafka(net.JoinHostPort(host, strconv.Itoa(int(port))), r.cluster.clientid, r.rebalance+r.session)
+}
+
+func (r *ReceiverKafka) refreshLeaders() error {
+	md, err := r.cluster.metadata(r.topics)
+	if err != nil {
+		return err
+	}
+	r.leaders = make(map[kafkaTopicPartition]int32)
+	for topic, partitions := range md.Topics {
+		for _, p := range partitions {
+			r.leaders[kafkaTopicPartition{topic, p.ID}] = p.Leader
+		}
+	}
+	return nil
+}
+
+// byTopic groups the partitions by topic, both sorted.
+func byTopic(tps []kafkaTopicPartition) ([]string, map[string][]int32) {
+	grouped := make(map[string][]int32)
+	var topics []string
+	for _, tp := range tps {
+		if _, ok := grouped[tp.topic]; !ok {
+			topics = append(topics, tp.topic)
+		}
+		grouped[tp.topic] = append(grouped[tp.topic], tp.partition)
+	}
+	sort.Strings(topics)
+	for _, partitions := range grouped {
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	return topics, grouped
+}
+
+func (r *ReceiverKafka) assigned() []kafkaTopicPartition {
+	tps := make([]kafkaTopicPartition, 0, len(r.positions))
+	for tp := range r.positions {
+		tps = append(tps, tp)
+	}
+	return tps
+}
+
+// fetchOffsets sets the positions to the committed offsets, the partitions
+// with no commits are reset according to offset_reset.
+func (r *ReceiverKafka) fetchOffsets() error {
+	topics, grouped := byTopic(r.assigned())
+	e := &kafkaEncoder{}
+	e.string(r.group)
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+		e.int32(int32(len(grouped[topic])))
+		for _, partition := range grouped[topic] {
+			e.int32(partition)
+		}
+	}
+	resp, err := r.coord.roundTrip(kafkaAPIOffsetFetch, 2, e.buf, false)
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{buf: resp}
+	var reset []kafkaTopicPartition
+	for i, nt := 0, d.arrayLen(); i < nt; i++ {
+		topic := d.string()
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			tp := kafkaTopicPartition{topic, d.int32()}
+			offset := d.int64()
+			d.string() // metadata
+			if code := d.int16(); code != KafkaErrNone {
+				return kafkaError(code)
+			}
+			if _, ok := r.positions[tp]; !ok {
+				continue
+			}
+			r.positions[tp] = offset
+			if offset < 0 {
+				reset = append(reset, tp)
+			}
+		}
+	}
+	if code := d.int16(); code != KafkaErrNone {
+		return kafkaError(code)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return r.resetOffsets(reset)
+}
+
+// resetOffsets moves the partitions to the earliest or the latest offset
+// depending on offset_reset.
+func (r *ReceiverKafka) resetOffsets(tps []kafkaTopicPartition) error {
+	ts := int64(kafkaOffsetLatest)
+	if r.reset == KafkaOffsetResetEarliest {
+		ts = kafkaOffsetEarliest
+	}
+	leaders := make(map[int32][]kafkaTopicPartition)
+	for _, tp := range tps {
+		leaders[r.leaders[tp]] = append(leaders[r.leaders[tp]], tp)
+	}
+	for leader, tps := range leaders {
+		topics, grouped := byTopic(tps)
+		e := &kafkaEncoder{}
+		e.int32(-1) // replica id
+		e.int32(int32(len(topics)))
+		for _, topic := range topics {
+			e.string(topic)
+			e.int32(int32(len(grouped[topic])))
+			for _, partition := range grouped[topic] {
+				e.int32(partition)
+				e.int64(ts)
+			}
+		}
+		conn, err := r.cluster.conn(leader)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.roundTrip(kafkaAPIListOffsets, 1, e.buf, false)
+		if err != nil {
+			r.cluster.dropConn(conn)
+			return err
+		}
+		d := &kafkaDecoder{buf: resp}
+		for i, nt := 0, d.arrayLen(); i < nt; i++ {
+			topic := d.string()
+			for j, np := 0, d.arrayLen(); j < np; j++ {
+				tp := kafkaTopicPartition{topic, d.int32()}
+				code := d.int16()
+				d.int64() // timestamp
+				offset := d.int64()
+				if code != KafkaErrNone {
+					return kafkaError(code)
+				}
+				r.positions[tp] = offset
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+	}
+	return nil
+}
+
+type kafkaFetched struct {
+	tp      kafkaTopicPartition
+	records []kafkaRecord
+}
+
+// poll fetches the assigned partitions from their leaders, delivers the
+// records and commits the offsets of the delivered ones.
+func (r *ReceiverKafka) poll() error {
+	if len(r.positions) == 0 {
+		// Nothing assigned, waiting for a rebalance
+		r.sleep(r.maxwait)
+		return nil
+	}
+	var fetched []kafkaFetched
+	var reset []kafkaTopicPartition
+	refresh := false
+	leaders := make(map[int32][]kafkaTopicPartition)
+	for tp := range r.positions {
+		leader, ok := r.leaders[tp]
+		if !ok || leader < 0 {
+			refresh = true
+			continue
+		}
+		leaders[leader] = append(leaders[leader], tp)
+	}
+	for leader, tps := range leaders {
+		topics, grouped := byTopic(tps)
+		e := &kafkaEncoder{}
+		e.int32(-1) // replica id
+		e.int32(int32(r.maxwait / time.Millisecond))
+		e.int32(1) // min bytes
+		e.int32(r.maxbytes)
+		e.int8(0) // isolation level: read uncommitted
+		e.int32(int32(len(topics)))
+		for _, topic := range topics {
+			e.string(topic)
+			e.int32(int32(len(grouped[topic])))
+			for _, partition := range grouped[topic] {
+				e.int32(partition)
+				e.int64(r.positions[kafkaTopicPartition{topic, partition}])
+				e.int32(r.maxbytes)
+			}
+		}
+		conn, err := r.cluster.conn(leader)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.roundTrip(kafkaAPIFetch, 4, e.buf, false)
+		if err != nil {
+			r.cluster.dropConn(conn)
+			return err
+		}
+		d := &kafkaDecoder{buf: resp}
+		d.int32() // throttle time
+		for i, nt := 0, d.arrayLen(); i < nt; i++ {
+			topic := d.string()
+			for j, np := 0, d.arrayLen(); j < np; j++ {
+				tp := kafkaTopicPartition{topic, d.int32()}
+				code := d.int16()
+				d.int64() // high watermark
+				d.int64() // last stable offset
+				for k, na := 0, d.arrayLen(); k < na; k++ {
+					d.int64() // producer id
+					d.int64() // first offset
+				}
+				data := d.bytes()
+				switch {
+				case code == KafkaErrOffsetOutOfRange:
+					reset = append(reset, tp)
+					continue
+				case isKafkaMetadataErr(code):
+					refresh = true
+					continue
+				case code != KafkaErrNone:
+					return kafkaError(code)
+				}
+				records, err := decodeKafkaRecordBatches(data)
+				if err != nil {
+					return err
+				}
+				// Batches might start before the requested offset
+				pos, ok := r.positions[tp]
+				if !ok {
+					continue
+				}
+				for len(records) > 0 && records[0].Offset < pos {
+					records = records[1:]
+				}
+				if len(records) > 0 {
+					fetched = append(fetched, kafkaFetched{tp, records})
+				}
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+	}
+	if refresh {
+		if err := r.refreshLeaders(); err != nil {
+			return err
+		}
+	}
+	if len(reset) > 0 {
+		r.ctx.Logger().Warn("kafka receiver %q: %d partitions are out of range, resetting to %s", r.name, len(reset), r.reset)
+		if err := r.resetOffsets(reset); err != nil {
+			return err
+		}
+	}
+	if len(fetched) == 0 {
+		return nil
+	}
+	return r.deliver(fetched)
+}
+
+// deliver sends the records downstream, awaits them and commits the
+// offsets. A partition is committed up to the first record which did not
+// complete with MsgStatusDone, the position is rewound to it.
+func (r *ReceiverKafka) deliver(fetched []kafkaFetched) error {
+	msgs := make([][]*core.Message, len(fetched))
+	for i, f := range fetched {
+		msgs[i] = make([]*core.Message, 0, len(f.records))
+		for _, rec := range f.records {
+			msg := core.NewMessage(rec.Value)
+			msg.SetMeta(MetaKafkaTopic, f.tp.topic)
+			msg.SetMeta(MetaKafkaPartition, int(f.tp.partition))
+			msg.SetMeta(MetaKafkaOffset, rec.Offset)
+			if rec.Key != nil {
+				msg.SetMeta(MetaKafkaKey, string(rec.Key))
+			}
+			for _, h := range rec.Headers {
+				msg.SetMeta(MetaKafkaHeaderPrefix+h.Key, string(h.Value))
+			}
+			select {
+			case r.queue <- msg:
+			case <-r.done:
+				return nil
+			}
+			msgs[i] = append(msgs[i], msg)
+		}
+	}
+
+	commits := make(map[kafkaTopicPartition]int64)
+	failed := false
+	for i, f := range fetched {
+		pos := r.positions[f.tp]
+		for j, msg := range msgs[i] {
+			var sts core.MsgStatus
+			select {
+			case sts = <-msg.AwaitChan():
+			case <-r.done:
+				// Not committing anything, the records would be
+				// redelivered
+				return nil
+			}
+			if sts != core.MsgStatusDone {
+				// The remaining records of the partition would be
+				// fetched again, their completions don't matter
+				r.ctx.Logger().With(msg.LogFields()).Error("kafka receiver %q: record %s/%d@%d completed with status %d, rewinding", r.name, f.tp.topic, f.tp.partition, f.records[j].Offset, sts)
+				failed = true
+				break
+			}
+			pos = f.records[j].Offset + 1
+		}
+		if pos != r.positions[f.tp] {
+			r.positions[f.tp] = pos
+			commits[f.tp] = pos
+		}
+	}
+	if err := r.commit(commits); err != nil {
+		return err
+	}
+	if failed {
+		r.sleep(r.backoff)
+	}
+	return nil
+}
+
+func (r *ReceiverKafka) commit(offsets map[kafkaTopicPartition]int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	tps := make([]kafkaTopicPartition, 0, len(offsets))
+	for tp := range offsets {
+		tps = append(tps, tp)
+	}
+	topics, grouped := byTopic(tps)
+	e := &kafkaEncoder{}
+	e.string(r.group)
+	e.int32(r.generation)
+	e.string(r.memberid)
+	e.int64(-1) // retention time: broker default
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+		e.int32(int32(len(grouped[topic])))
+		for _, partition := range grouped[topic] {
+			e.int32(partition)
+			e.int64(offsets[kafkaTopicPartition{topic, partition}])
+			e.nullableString(nil) // metadata
+		}
+	}
+	resp, err := r.coord.roundTrip(kafkaAPIOffsetCommit, 2, e.buf, false)
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{buf: resp}
+	for i, nt := 0, d.arrayLen(); i < nt; i++ {
+		d.string() // topic
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			d.int32() // partition
+			if code := d.int16(); code != KafkaErrNone {
+				return kafkaError(code)
+			}
+		}
+	}
+	return d.err
+}
+
+func (r *ReceiverKafka) leave() {
+	if r.coord == nil {
+		return
+	}
+	defer r.coord.Close()
+	if len(r.memberid) == 0 {
+		return
+	}
+	e := &kafkaEncoder{}
+	e.string(r.group)
+	e.string(r.memberid)
+	if _, err := r.coord.roundTrip(kafkaAPILeaveGroup, 1, e.buf, false); err != nil {
+		r.ctx.Logger().Warn("kafka receiver %q failed to leave group %q: %s", r.name, r.group, err)
+	}
+}
+
+// encodeKafkaSubscription builds the consumer protocol (v0) member
+// metadata.
+func encodeKafkaSubscription(topics []string) []byte {
+	e := &kafkaEncoder{}
+	e.int16(0) // version
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+	e.bytes(nil) // user data
+	return e.buf
+}
+
+func decodeKafkaSubscription(data []byte) ([]string, error) {
+	d := &kafkaDecoder{buf: data}
+	d.int16() // version
+	topics := make([]string, d.arrayLen())
+	for i := range topics {
+		topics[i] = d.string()
+	}
+	return topics, d.err
+}
+
+func encodeKafkaAssignment(assignment map[string][]int32) []byte {
+	topics := make([]string, 0, len(assignment))
+	for topic := range assignment {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	e := &kafkaEncoder{}
+	e.int16(0) // version
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+		e.int32(int32(len(assignment[topic])))
+		for _, partition := range assignment[topic] {
+			e.int32(partition)
+		}
+	}
+	e.bytes(nil) // user data
+	return e.buf
+}
+
+func decodeKafkaAssignment(data []byte) (map[string][]int32, error) {
+	res := make(map[string][]int32)
+	if len(data) == 0 {
+		// Members with no partitions might get an empty assignment
+		return res, nil
+	}
+	d := &kafkaDecoder{buf: data}
+	d.int16() // version
+	for i, nt := 0, d.arrayLen(); i < nt; i++ {
+		topic := d.string()
+		partitions := make([]int32, d.arrayLen())
+		for j := range partitions {
+			partitions[j] = d.int32()
+		}
+		res[topic] = partitions
+	}
+	return res, d.err
+}
+
+// kafkaRangeAssign assigns every topic partitions to the subscribed members
+// in contiguous ranges ordered by the member id. The first members get an
+// extra partition if the partitions can not be split evenly.
+func kafkaRangeAssign(subscriptions map[string][]string, partitions map[string]int) map[string]map[string][]int32 {
+	members := make(map[string][]string)
+	res := make(map[string]map[string][]int32, len(subscriptions))
+	for member, topics := range subscriptions {
+		res[member] = make(map[string][]int32)
+		for _, topic := range topics {
+			members[topic] = append(members[topic], member)
+		}
+	}
+	for topic, subscribed := range members {
+		sort.Strings(subscribed)
+		n := partitions[topic]
+		per, extra := n/len(subscribed), n%len(subscribed)
+		start := 0
+		for i, member := range subscribed {
+			cnt := per
+			if i < extra {
+				cnt++
+			}
+			for p := start; p < start+cnt; p++ {
+				res[member][topic] = append(res[member][topic], int32(p))
+			}
+			start += cnt
+		}
+	}
+	return res
+}
@@ -0,0 +1,184 @@
+package actor
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestReceiverKafka(t *testing.T) {
+	stub := newKafkaStub(t, 2)
+	defer stub.close()
+	stub.append("events", 0, kafkaRecord{Value: []byte("a")}, kafkaRecord{Value: []byte("b")}, kafkaRecord{Value: []byte("c")})
+	stub.append("events", 1,
+		kafkaRecord{Value: []byte("d")},
+		kafkaRecord{Key: []byte("user-42"), Value: []byte("e"), Headers: []kafkaHeader{{"trace", []byte("abc")}}},
+	)
+	// The record d has been consumed by a previous member
+	stub.committed[kafkaTopicPartition{"events", 1}] = 1
+
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverKafka("receiver", ctx, core.Params{
+		"bind":               stub.addr(),
+		"group":              "flowd",
+		"topics":             []interface{}{"events"},
+		"offset_reset":       "earliest",
+		"heartbeat_interval": 50,
+		"max_wait":           20,
+		"retry_backoff":      20,
+	})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	var lock sync.Mutex
+	failed := false
+	received := make(map[string]int)
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		lock.Lock()
+		body := string(msg.Body())
+		received[body]++
+		status := core.MsgStatusDone
+		// The first attempt to deliver b fails
+		if body == "b" && !failed {
+			failed = true
+			status = core.MsgStatusFailed
+		}
+		lock.Unlock()
+		mailbox <- msg
+		msg.Complete(status)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+
+	stub.expectCommitted(t, "events", 0, 3)
+	stub.expectCommitted(t, "events", 1, 2)
+
+	lock.Lock()
+	// b is redelivered along with c
+	if want := map[string]int{"a": 1, "b": 2, "c": 2, "e": 1}; !reflect.DeepEqual(received, want) {
+		t.Fatalf("unexpected deliveries: got: %v, want: %v", received, want)
+	}
+	lock.Unlock()
+	var e *core.Message
+	for e == nil {
+		if msg := <-mailbox; string(msg.Body()) == "e" {
+			e = msg
+		}
+	}
+	wantmeta := map[string]interface{}{
+		MetaKafkaTopic:                  "events",
+		MetaKafkaPartition:              1,
+		MetaKafkaOffset:                 int64(1),
+		MetaKafkaKey:                    "user-42",
+		MetaKafkaHeaderPrefix + "trace": "abc",
+	}
+	for key, want := range wantmeta {
+		if got, ok := e.Meta(key); !ok || got != want {
+			t.Fatalf("unexpected meta %q: got: %v, want: %v", key, got, want)
+		}
+	}
+
+	// A rebalance makes the member rejoin the group and resume from the
+	// committed offsets
+	stub.lock.Lock()
+	stub.hberr = KafkaErrRebalanceInProgress
+	stub.lock.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stub.lock.Lock()
+		njoin := stub.njoin
+		stub.lock.Unlock()
+		if njoin >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the group rejoin")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stub.append("events", 1, kafkaRecord{Value: []byte("f")})
+	stub.expectCommitted(t, "events", 1, 3)
+	lock.Lock()
+	if want := map[string]int{"a": 1, "b": 2, "c": 2, "e": 1, "f": 1}; !reflect.DeepEqual(received, want) {
+		t.Fatalf("unexpected deliveries after rejoin: got: %v, want: %v", received, want)
+	}
+	lock.Unlock()
+
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+	stub.lock.Lock()
+	defer stub.lock.Unlock()
+	if stub.nleave != 1 {
+		t.Fatalf("unexpected number of group leaves: got: %d, want: 1", stub.nleave)
+	}
+}
+
+func TestKafkaRangeAssign(t *testing.T) {
+	subscriptions := map[string][]string{
+		"member-b": {"events", "logs"},
+		"member-a": {"events"},
+		"member-c": {"events", "logs"},
+	}
+	partitions := map[string]int{"events": 5, "logs": 1}
+	want := map[string]map[string][]int32{
+		"member-a": {"events": {0, 1}},
+		"member-b": {"events": {2, 3}, "logs": {0}},
+		"member-c": {"events": {4}},
+	}
+	got := kafkaRangeAssign(subscriptions, partitions)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected assignment: got: %v, want: %v", got, want)
+	}
+	for member, assignment := range got {
+		decoded, err := decodeKafkaAssignment(encodeKafkaAssignment(assignment))
+		if err != nil {
+			t.Fatalf("failed to decode %s assignment: %s", member, err)
+		}
+		if !reflect.DeepEqual(decoded, assignment) {
+			t.Fatalf("unexpected decoded %s assignment: got: %v, want: %v", member, decoded, assignment)
+		}
+	}
+}
+
+func TestNewReceiverKafkaMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"group": "g", "topics": "t"}, fmt.Errorf("kafka receiver \"receiver\" is missing `bind` config")},
+		{core.Params{"bind": "127.0.0.1:9092", "topics": "t"}, fmt.Errorf("kafka receiver \"receiver\" is missing `group` config")},
+		{core.Params{"bind": "127.0.0.1:9092", "group": "g"}, fmt.Errorf("kafka receiver \"receiver\" is missing `topics` config")},
+		{core.Params{"bind": "127.0.0.1:9092", "group": "g", "topics": []interface{}{}}, fmt.Errorf("kafka receiver \"receiver\" got malformed `topics` config")},
+		{core.Params{"bind": "127.0.0.1:9092", "group": "g", "topics": "t", "offset_reset": "none"}, fmt.Errorf("kafka receiver \"receiver\" got an unknown `offset_reset`: \"none\"")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverKafka("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package actor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaMQTTTopic    = "mqtt.topic"
+	MetaMQTTQoS      = "mqtt.qos"
+	MetaMQTTClientID = "mqtt.client_id"
+
+	DefaultMQTTMaxPacketSize = 1024 * 1024
+
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+
+	mqttVersion311 = 4
+	mqttVersion5   = 5
+
+	// CONNACK and DISCONNECT reason codes, the 5 suffix stands for MQTT 5
+	mqttConnAccepted           = 0x00
+	mqttConnBadVersion311      = 0x01
+	mqttConnBadCredentials311  = 0x04
+	mqttConnBadCredentials5    = 0x86
+	mqttDisconnQoSNotSupported = 0x9B
+
+	mqttPropAssignedClientID = 0x12
+	mqttPropMaxPacketSize    = 0x27
+)
+
+type mqttPacket struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// mqttSession keeps the connection state negotiated with CONNECT.
+type mqttSession struct {
+	version  byte
+	clientid string
+}
+
+// NewReceiverMQTT builds a TCP receiver accepting MQTT 3.1.1 and 5
+// publishers. It handles CONNECT, PUBLISH (QoS 0 and 1), PINGREQ and
+// DISCONNECT, there are no subscriptions. Every published message becomes
+// a message with the mqtt.topic, mqtt.qos and mqtt.client_id meta
+// attributes.
+// QoS 1 messages are acknowledged with PUBACK once they complete with
+// MsgStatusDone. No PUBACK is sent otherwise: the client redelivers the
+// message once it reconnects. QoS 0 messages are not awaited.
+// If `username` is configured, clients have to authenticate with the
+// `username` and `password` pair.
+func NewReceiverMQTT(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	rcv, err := NewReceiverTCP(name, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	var username, password string
+	auth := false
+	if v, ok := params["username"]; ok {
+		username = v.(string)
+		auth = true
+		if v, ok := params["password"]; ok {
+			password = v.(string)
+		}
+	} else if _, ok := params["password"]; ok {
+		return nil, fmt.Errorf("mqtt receiver %q has `password` config with no `username`", name)
+	}
+	maxsize := DefaultMQTTMaxPacketSize
+	if v, ok := params["max_packet_size"]; ok {
+		maxsize = v.(int)
+		if maxsize <= 0 {
+			return nil, fmt.Errorf("mqtt receiver %q `max_packet_size` should be a positive integer, got: %d", name, maxsize)
+		}
+	}
+	r := rcv.(*ReceiverTCP)
+	var seq int64
+	r.connhandler = func(conn net.Conn) {
+		r.handleConnMQTT(conn, func(user, pass string, hascreds bool) bool {
+			return !auth || (hascreds && user == username && pass == password)
+		}, maxsize, &seq)
+	}
+	return r, nil
+}
+
+// readMQTTPacket reads a control packet: the fixed header followed by the
+// remaining length encoded as a variable byte integer.
+func readMQTTPacket(reader *bufio.Reader, maxsize int) (*mqttPacket, error) {
+	head, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	size, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, fmt.Errorf("mqtt: malformed remaining length")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	if size > maxsize {
+		return nil, fmt.Errorf("mqtt: packet size %d exceeds the limit of %d", size, maxsize)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &mqttPacket{kind: head >> 4, flags: head & 0x0f, body: body}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func appendMQTTVarint(buf []byte, v int) []byte {
+	for {
+		b := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if v == 0 {
+			return buf
+		}
+	}
+}
+
+func encodeMQTTPacket(kind, flags byte, body []byte) []byte {
+	buf := appendMQTTVarint([]byte{kind<<4 | flags}, len(body))
+	return append(buf, body...)
+}
+
+// mqttDecoder reads the packet fields, the first error is sticky.
+type mqttDecoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *mqttDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf)-d.off {
+		d.err = fmt.Errorf("mqtt: malformed packet")
+		return nil
+	}
+	v := d.buf[d.off : d.off+n]
+	d.off += n
+	return v
+}
+
+func (d *mqttDecoder) byte() byte {
+	if v := d.next(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *mqttDecoder) uint16() uint16 {
+	if v := d.next(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *mqttDecoder) binary() []byte {
+	return d.next(int(d.uint16()))
+}
+
+func (d *mqttDecoder) string() string {
+	return string(d.binary())
+}
+
+func (d *mqttDecoder) varint() int {
+	v, mult := 0, 1
+	for i := 0; i < 4; i++ {
+		b := d.byte()
+		v += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			return v
+		}
+		mult *= 128
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("mqtt: malformed variable byte integer")
+	}
+	return 0
+}
+
+// skipProperties skips MQTT 5 properties, none of them is used.
+func (d *mqttDecoder) skipProperties() {
+	d.next(d.varint())
+}
+
+func (d *mqttDecoder) rest() []byte {
+	return d.next(len(d.buf) - d.off)
+}
+
+func mqttString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+func (r *ReceiverTCP) handleConnMQTT(conn net.Conn, authfunc func(string, string, bool) bool, maxsize int, seq *int64) {
+	r.ctx.Logger().Debug("new mqtt connection from %s", conn.RemoteAddr())
+
+	r.wgconn.Add(1)
+	defer r.wgconn.Done()
+
+	connover := make(chan struct{})
+	defer close(connover)
+	go func() {
+		select {
+		case <-r.done:
+			conn.Close()
+		case <-connover:
+		}
+	}()
+	defer func() {
+		conn.Close()
+		r.ctx.Logger().Debug("closing mqtt connection from %s", conn.RemoteAddr())
+	}()
+
+	reader := bufio.NewReaderSize(conn, r.bufsize)
+	var sess *mqttSession
+	var keepalive time.Duration
+	for {
+		if keepalive > 0 {
+			// The client is gone after one and a half keep alive
+			// periods with no packets
+			conn.SetReadDeadline(time.Now().Add(keepalive * 3 / 2))
+		}
+		pkt, err := readMQTTPacket(reader, maxsize)
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-r.done:
+				default:
+					r.ctx.Logger().Debug("mqtt connection from %s failed: %s", conn.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+		if sess == nil && pkt.kind != mqttConnect {
+			r.ctx.Logger().Debug("mqtt connection from %s sent packet type %d before CONNECT", conn.RemoteAddr(), pkt.kind)
+			return
+		}
+		var reply []byte
+		switch pkt.kind {
+		case mqttConnect:
+			if sess != nil {
+				r.ctx.Logger().Debug("mqtt client %q sent a second CONNECT", sess.clientid)
+				return
+			}
+			var ok bool
+			sess, keepalive, reply, ok = r.connectMQTT(pkt, authfunc, maxsize, seq)
+			if !ok {
+				r.writeMQTT(conn, reply)
+				return
+			}
+		case mqttPublish:
+			var ok bool
+			if reply, ok = r.publishMQTT(pkt, sess); !ok {
+				r.writeMQTT(conn, reply)
+				return
+			}
+		case mqttPingReq:
+			reply = encodeMQTTPacket(mqttPingResp, 0, nil)
+		case mqttDisconnect:
+			return
+		default:
+			r.ctx.Logger().Debug("mqtt client %q sent unsupported packet type %d", sess.clientid, pkt.kind)
+			return
+		}
+		if reply == nil {
+			continue
+		}
+		if err := r.writeMQTT(conn, reply); err != nil {
+			r.ctx.Logger().Error(err.Error())
+			return
+		}
+	}
+}
+
+func (r *ReceiverTCP) writeMQTT(conn net.Conn, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+	_, err := conn.Write(data)
+	return err
+}
+
+// connectMQTT handles the CONNECT packet. It returns the CONNACK packet and
+// false if the connection has to be closed.
+func (r *ReceiverTCP) connectMQTT(pkt *mqttPacket, authfunc func(string, string, bool) bool, maxsize int, seq *int64) (*mqttSession, time.Duration, []byte, bool) {
+	d := &mqttDecoder{buf: pkt.body}
+	proto := d.string()
+	version := d.byte()
+	flags := d.byte()
+	keepalive := time.Duration(d.uint16()) * time.Second
+	if d.err != nil || (proto != "MQTT" && proto != "MQIsdp") {
+		return nil, 0, nil, false
+	}
+	if version != mqttVersion311 && version != mqttVersion5 {
+		// The reply is 3.1.1-formatted as the client version is unknown
+		return nil, 0, encodeMQTTPacket(mqttConnAck, 0, []byte{0, mqttConnBadVersion311}), false
+	}
+	if version == mqttVersion5 {
+		d.skipProperties()
+	}
+	sess := &mqttSession{version: version, clientid: d.string()}
+	if flags&0x04 != 0 {
+		// Will message
+		if version == mqttVersion5 {
+			d.skipProperties()
+		}
+		d.string()
+		d.binary()
+	}
+	var user, pass string
+	hascreds := false
+	if flags&0x80 != 0 {
+		user = d.string()
+		hascreds = true
+	}
+	if flags&0x40 != 0 {
+		pass = string(d.binary())
+	}
+	if d.err != nil {
+		return nil, 0, nil, false
+	}
+	assigned := len(sess.clientid) == 0
+	if assigned {
+		sess.clientid = fmt.Sprintf("flowd-%d", atomic.AddInt64(seq, 1))
+	}
+
+	code := byte(mqttConnAccepted)
+	if !authfunc(user, pass, hascreds) {
+		code = mqttConnBadCredentials311
+		if version == mqttVersion5 {
+			code = mqttConnBadCredentials5
+		}
+	}
+	body := []byte{0, code} // no session present
+	if version == mqttVersion5 {
+		var props []byte
+		if code == mqttConnAccepted {
+			props = append(props, mqttPropMaxPacketSize, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(props[1:], uint32(maxsize))
+			if assigned {
+				props = mqttString(append(props, mqttPropAssignedClientID), sess.clientid)
+			}
+		}
+		body = append(appendMQTTVarint(body, len(props)), props...)
+	}
+	reply := encodeMQTTPacket(mqttConnAck, 0, body)
+	if code != mqttConnAccepted {
+		r.ctx.Logger().Info("mqtt client %q failed to authenticate as %q", sess.clientid, user)
+		return nil, 0, reply, false
+	}
+	return sess, keepalive, reply, true
+}
+
+// publishMQTT sends the published message downstream. It returns the
+// PUBACK packet (nil if there is nothing to reply) and false if the
+// connection has to be closed.
+func (r *ReceiverTCP) publishMQTT(pkt *mqttPacket, sess *mqttSession) ([]byte, bool) {
+	qos := (pkt.flags >> 1) & 0x03
+	if qos > 1 {
+		r.ctx.Logger().Debug("mqtt client %q sent an unsupported QoS %d message", sess.clientid, qos)
+		if sess.version == mqttVersion5 {
+			return encodeMQTTPacket(mqttDisconnect, 0, []byte{mqttDisconnQoSNotSupported, 0}), false
+		}
+		return nil, false
+	}
+	d := &mqttDecoder{buf: pkt.body}
+	topic := d.string()
+	var pktid []byte
+	if qos > 0 {
+		pktid = d.next(2)
+	}
+	if sess.version == mqttVersion5 {
+		d.skipProperties()
+	}
+	payload := d.rest()
+	if d.err != nil {
+		r.ctx.Logger().Debug("mqtt client %q sent a malformed PUBLISH: %s", sess.clientid, d.err)
+		return nil, false
+	}
+
+	msg := core.NewMessage(payload)
+	msg.SetMeta(MetaMQTTTopic, topic)
+	msg.SetMeta(MetaMQTTQoS, int(qos))
+	msg.SetMeta(MetaMQTTClientID, sess.clientid)
+	select {
+	case r.queue <- msg:
+	case <-r.done:
+		return nil, false
+	}
+	if qos == 0 {
+		return nil, true
+	}
+	var status core.MsgStatus
+	select {
+	case status = <-msg.AwaitChan():
+	case <-time.After(MsgSendTimeout):
+		status = core.MsgStatusTimedOut
+	}
+	if status != core.MsgStatusDone {
+		r.ctx.Logger().With(msg.LogFields()).Debug("mqtt message %d from %q completed with status %d, not acknowledging", binary.BigEndian.Uint16(pktid), sess.clientid, status)
+		return nil, true
+	}
+	return encodeMQTTPacket(mqttPubAck, 0, pktid), true
+}
@@ -0,0 +1,292 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func mqttConnectPacket(version byte, clientid string, creds ...string) []byte {
+	body := mqttString(nil, "MQTT")
+	flags := byte(0x02) // clean session
+	if len(creds) > 0 {
+		flags |= 0x80
+	}
+	if len(creds) > 1 {
+		flags |= 0x40
+	}
+	body = append(body, version, flags, 0, 60)
+	if version == mqttVersion5 {
+		body = append(body, 0)
+	}
+	body = mqttString(body, clientid)
+	for _, cred := range creds {
+		body = mqttString(body, cred)
+	}
+	return encodeMQTTPacket(mqttConnect, 0, body)
+}
+
+func mqttPublishPacket(version byte, topic string, qos byte, pktid uint16, payload string) []byte {
+	body := mqttString(nil, topic)
+	if qos > 0 {
+		body = append(body, byte(pktid>>8), byte(pktid))
+	}
+	if version == mqttVersion5 {
+		// Payload format indicator: UTF-8
+		body = append(body, 2, 0x01, 0x01)
+	}
+	body = append(body, payload...)
+	return encodeMQTTPacket(mqttPublish, qos<<1, body)
+}
+
+func newTestReceiverMQTT(t *testing.T, params core.Params) (core.Actor, chan *core.Message) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	params["bind"] = "127.0.0.1:0"
+	rcv, err := NewReceiverMQTT("receiver", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		status := core.MsgStatusDone
+		if strings.HasPrefix(string(msg.Body()), "fail") {
+			status = core.MsgStatusFailed
+		}
+		msg.Complete(status)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	return rcv, mailbox
+}
+
+func dialMQTT(t *testing.T, rcv core.Actor) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", rcv.(*ReceiverTCP).listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func expectMQTTPacket(t *testing.T, reader *bufio.Reader, want []byte) {
+	pkt, err := readMQTTPacket(reader, DefaultMQTTMaxPacketSize)
+	if err != nil {
+		t.Fatalf("failed to read packet: %s", err)
+	}
+	if got := encodeMQTTPacket(pkt.kind, pkt.flags, pkt.body); !bytes.Equal(got, want) {
+		t.Fatalf("unexpected packet: got: %x, want: %x", got, want)
+	}
+}
+
+func expectMQTTClosed(t *testing.T, reader *bufio.Reader) {
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got: %v", err)
+	}
+}
+
+func TestReceiverMQTT(t *testing.T) {
+	rcv, mailbox := newTestReceiverMQTT(t, core.Params{})
+	defer rcv.Stop()
+
+	tests := []struct {
+		version     byte
+		clientid    string
+		wantconnack []byte
+		wantclient  string
+	}{
+		{mqttVersion311, "sensor-1", []byte{0x20, 2, 0, 0}, "sensor-1"},
+		{mqttVersion311, "", []byte{0x20, 2, 0, 0}, "flowd-1"},
+		{mqttVersion5, "sensor-2", []byte{0x20, 8, 0, 0, 5, 0x27, 0, 0x10, 0, 0}, "sensor-2"},
+		{mqttVersion5, "", []byte{0x20, 18, 0, 0, 15, 0x27, 0, 0x10, 0, 0, 0x12, 0, 7, 'f', 'l', 'o', 'w', 'd', '-', '2'}, "flowd-2"},
+	}
+	for _, testCase := range tests {
+		t.Run(fmt.Sprintf("v%d/%q", testCase.version, testCase.clientid), func(t *testing.T) {
+			conn, reader := dialMQTT(t, rcv)
+			defer conn.Close()
+
+			conn.Write(mqttConnectPacket(testCase.version, testCase.clientid))
+			expectMQTTPacket(t, reader, testCase.wantconnack)
+
+			conn.Write(mqttPublishPacket(testCase.version, "sensors/temp", 0, 0, "fire-and-forget"))
+			conn.Write(mqttPublishPacket(testCase.version, "sensors/temp", 1, 7, "21.5"))
+			expectMQTTPacket(t, reader, []byte{0x40, 2, 0, 7})
+			// A failed message is not acknowledged
+			conn.Write(mqttPublishPacket(testCase.version, "sensors/hum", 1, 8, "fail-me"))
+			conn.Write(encodeMQTTPacket(mqttPingReq, 0, nil))
+			expectMQTTPacket(t, reader, []byte{0xd0, 0})
+
+			for _, want := range []map[string]interface{}{
+				{"body": "fire-and-forget", MetaMQTTTopic: "sensors/temp", MetaMQTTQoS: 0},
+				{"body": "21.5", MetaMQTTTopic: "sensors/temp", MetaMQTTQoS: 1},
+				{"body": "fail-me", MetaMQTTTopic: "sensors/hum", MetaMQTTQoS: 1},
+			} {
+				msg := <-mailbox
+				got := map[string]interface{}{"body": string(msg.Body())}
+				for _, key := range []string{MetaMQTTTopic, MetaMQTTQoS} {
+					got[key], _ = msg.Meta(key)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("unexpected message: got: %v, want: %v", got, want)
+				}
+				if clientid, _ := msg.Meta(MetaMQTTClientID); clientid != testCase.wantclient {
+					t.Fatalf("unexpected client id: got: %v, want: %s", clientid, testCase.wantclient)
+				}
+			}
+
+			conn.Write(encodeMQTTPacket(mqttDisconnect, 0, nil))
+			expectMQTTClosed(t, reader)
+		})
+	}
+}
+
+func TestReceiverMQTTAuth(t *testing.T) {
+	rcv, _ := newTestReceiverMQTT(t, core.Params{"username": "device", "password": "secret"})
+	defer rcv.Stop()
+
+	tests := []struct {
+		name        string
+		connect     []byte
+		wantconnack []byte
+		wantclosed  bool
+	}{
+		{"no credentials", mqttConnectPacket(mqttVersion311, "c"), []byte{0x20, 2, 0, 4}, true},
+		{"wrong password", mqttConnectPacket(mqttVersion311, "c", "device", "guess"), []byte{0x20, 2, 0, 4}, true},
+		{"no password", mqttConnectPacket(mqttVersion5, "c", "device"), []byte{0x20, 3, 0, 0x86, 0}, true},
+		{"valid credentials", mqttConnectPacket(mqttVersion311, "c", "device", "secret"), []byte{0x20, 2, 0, 0}, false},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			conn, reader := dialMQTT(t, rcv)
+			defer conn.Close()
+			conn.Write(testCase.connect)
+			expectMQTTPacket(t, reader, testCase.wantconnack)
+			if testCase.wantclosed {
+				expectMQTTClosed(t, reader)
+			}
+		})
+	}
+}
+
+func TestReceiverMQTTProtocolErrors(t *testing.T) {
+	rcv, _ := newTestReceiverMQTT(t, core.Params{"max_packet_size": 64})
+	defer rcv.Stop()
+
+	tests := []struct {
+		name      string
+		packets   [][]byte
+		connected bool
+		wantrepl  []byte
+	}{
+		{
+			name:    "publish before connect",
+			packets: [][]byte{mqttPublishPacket(mqttVersion311, "t", 0, 0, "body")},
+		},
+		{
+			name:     "unsupported protocol version",
+			packets:  [][]byte{mqttConnectPacket(3, "c")},
+			wantrepl: []byte{0x20, 2, 0, 1},
+		},
+		{
+			name:      "qos 2",
+			packets:   [][]byte{mqttConnectPacket(mqttVersion5, "c"), mqttPublishPacket(mqttVersion5, "t", 2, 1, "body")},
+			connected: true,
+			wantrepl:  []byte{0xe0, 2, 0x9b, 0},
+		},
+		{
+			name:      "packet too large",
+			packets:   [][]byte{mqttConnectPacket(mqttVersion311, "c"), mqttPublishPacket(mqttVersion311, "t", 0, 0, strings.Repeat("x", 64))},
+			connected: true,
+		},
+		{
+			name:      "second connect",
+			packets:   [][]byte{mqttConnectPacket(mqttVersion311, "c"), mqttConnectPacket(mqttVersion311, "c")},
+			connected: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			conn, reader := dialMQTT(t, rcv)
+			defer conn.Close()
+			for _, pkt := range testCase.packets {
+				conn.Write(pkt)
+			}
+			if testCase.connected {
+				// Skip the CONNACK
+				if _, err := readMQTTPacket(reader, DefaultMQTTMaxPacketSize); err != nil {
+					t.Fatalf("failed to read CONNACK: %s", err)
+				}
+			}
+			if testCase.wantrepl != nil {
+				expectMQTTPacket(t, reader, testCase.wantrepl)
+			}
+			expectMQTTClosed(t, reader)
+		})
+	}
+}
+
+func TestReadMQTTPacket(t *testing.T) {
+	tests := []struct {
+		input   []byte
+		want    *mqttPacket
+		wanterr error
+	}{
+		{[]byte{0xc0, 0}, &mqttPacket{kind: mqttPingReq, body: []byte{}}, nil},
+		{[]byte{0x32, 2, 'a', 'b'}, &mqttPacket{kind: mqttPublish, flags: 2, body: []byte("ab")}, nil},
+		{[]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x7f}, nil, fmt.Errorf("mqtt: malformed remaining length")},
+		{[]byte{0x30, 0x80, 0x80, 0x01}, nil, fmt.Errorf("mqtt: packet size 16384 exceeds the limit of 1024")},
+		{[]byte{0x30, 3, 'a'}, nil, io.ErrUnexpectedEOF},
+		{[]byte{0x30}, nil, io.ErrUnexpectedEOF},
+	}
+	for _, testCase := range tests {
+		got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(testCase.input)), 1024)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %x: got: %v, want: %v", testCase.input, err, testCase.wanterr)
+		}
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Fatalf("unexpected packet for %x: got: %+v, want: %+v", testCase.input, got, testCase.want)
+		}
+	}
+}
+
+func TestNewReceiverMQTTMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"bind": "127.0.0.1:0", "password": "secret"}, fmt.Errorf("mqtt receiver \"receiver\" has `password` config with no `username`")},
+		{core.Params{"bind": "127.0.0.1:0", "max_packet_size": 0}, fmt.Errorf("mqtt receiver \"receiver\" `max_packet_size` should be a positive integer, got: 0")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverMQTT("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
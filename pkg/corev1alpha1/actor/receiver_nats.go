@@ -0,0 +1,266 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	DefaultNATSReconnectWait = time.Second
+	natsMaxPayload           = 64 * 1024 * 1024
+)
+
+// ReceiverNATS subscribes to the `subjects` (wildcards are allowed) and
+// turns the delivered messages into flow messages with the nats.subject
+// meta attribute. If `queue` is set, the subscriptions join the queue group
+// so the messages are spread among the group members.
+// NATS delivery is at-most-once, so messages are not awaited unless the
+// publisher expects a reply: the message status (see MsgStatusToTcpResp) is
+// published to the reply subject which is also stored as nats.reply.
+// The receiver reconnects after `reconnect_wait` milliseconds once the
+// connection is lost.
+type ReceiverNATS struct {
+	name     string
+	ctx      *core.Context
+	addr     string
+	opts     natsOptions
+	subjects []string
+	group    string
+	backoff  time.Duration
+	lock     sync.Mutex
+	queue    chan *core.Message
+	done     chan struct{}
+	wgloop   sync.WaitGroup
+	wgpeer   sync.WaitGroup
+	wgreply  sync.WaitGroup
+}
+
+var _ core.Actor = (*ReceiverNATS)(nil)
+
+func NewReceiverNATS(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	bind, ok := params["bind"]
+	if !ok || len(bind.(string)) == 0 {
+		return nil, fmt.Errorf("nats receiver %q is missing `bind` config", name)
+	}
+	v, ok := params["subjects"]
+	if !ok {
+		return nil, fmt.Errorf("nats receiver %q is missing `subjects` config", name)
+	}
+	subjects, err := toStrList(v)
+	if err != nil || len(subjects) == 0 {
+		return nil, fmt.Errorf("nats receiver %q got malformed `subjects` config", name)
+	}
+	for _, subject := range subjects {
+		if !validNATSSubject(subject) {
+			return nil, fmt.Errorf("nats receiver %q got an invalid subject: %q", name, subject)
+		}
+	}
+	r := &ReceiverNATS{
+		name:     name,
+		ctx:      ctx,
+		addr:     bind.(string),
+		opts:     natsOptionsFromParams(params),
+		subjects: subjects,
+		backoff:  DefaultNATSReconnectWait,
+		queue:    make(chan *core.Message),
+		done:     make(chan struct{}),
+	}
+	if v, ok := params["queue"]; ok {
+		r.group = v.(string)
+		if strings.ContainsAny(r.group, " \t\r\n") {
+			return nil, fmt.Errorf("nats receiver %q got an invalid queue group: %q", name, r.group)
+		}
+	}
+	if v, ok := params["reconnect_wait"]; ok {
+		r.backoff = time.Duration(v.(int)) * time.Millisecond
+	}
+
+	return r, nil
+}
+
+func (r *ReceiverNATS) Name() string {
+	return r.name
+}
+
+func (r *ReceiverNATS) Start() error {
+	r.wgloop.Add(1)
+	go r.run()
+
+	return nil
+}
+
+func (r *ReceiverNATS) Stop() error {
+	close(r.done)
+	r.wgloop.Wait()
+	r.wgreply.Wait()
+	close(r.queue)
+	r.wgpeer.Wait()
+
+	return nil
+}
+
+func (r *ReceiverNATS) Connect(nthreads int, peer core.Receiver) error {
+	for i := 0; i < nthreads; i++ {
+		r.wgpeer.Add(1)
+		go func() {
+			for msg := range r.queue {
+				if err := peer.Receive(msg); err != nil {
+					msg.Complete(core.MsgStatusFailed)
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
+				}
+			}
+			r.wgpeer.Done()
+		}()
+	}
+
+	return nil
+}
+
+func (r *ReceiverNATS) Receive(*core.Message) error {
+	return fmt.Errorf("nats receiver %q can not receive internal messages", r.name)
+}
+
+// run keeps the subscriptions alive: it reconnects and resubscribes once
+// the connection is lost.
+func (r *ReceiverNATS) run() {
+	defer r.wgloop.Done()
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		if err := r.subscribe(); err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			r.ctx.Logger().Error("nats receiver %q connection to %s failed: %s", r.name, r.addr, err)
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(r.backoff):
+		}
+	}
+}
+
+// subscribe connects to the server, subscribes and serves the connection
+// until it fails or the receiver stops.
+func (r *ReceiverNATS) subscribe() error {
+	conn, reader, err := dialNATS(r.addr, r.opts, TCPConnTimeout)
+	if err != nil {
+		return err
+	}
+	connover := make(chan struct{})
+	defer close(connover)
+	go func() {
+		select {
+		case <-r.done:
+		case <-connover:
+		}
+		conn.Close()
+	}()
+
+	var buf []byte
+	for i, subject := range r.subjects {
+		buf = append(buf, "SUB "+subject+" "...)
+		if len(r.group) > 0 {
+			buf = append(buf, r.group+" "...)
+		}
+		buf = strconv.AppendInt(buf, int64(i+1), 10)
+		buf = append(buf, '\r', '\n')
+	}
+	if err := r.write(conn, buf); err != nil {
+		return err
+	}
+	r.ctx.Logger().Info("nats receiver %q subscribed to %s", r.name, strings.Join(r.subjects, ", "))
+
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			if err := r.handleMsg(conn, reader, line); err != nil {
+				return err
+			}
+		case line == "PING":
+			if err := r.write(conn, []byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			// Fatal errors are followed by the server closing the
+			// connection
+			r.ctx.Logger().Error("nats receiver %q got an error: %s", r.name, natsErrText(line))
+		}
+	}
+}
+
+func (r *ReceiverNATS) write(conn net.Conn, data []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+	_, err := conn.Write(data)
+	return err
+}
+
+// handleMsg reads the `MSG <subject> <sid> [reply-to] <#bytes>` payload and
+// sends it downstream.
+func (r *ReceiverNATS) handleMsg(conn net.Conn, reader *bufio.Reader, line string) error {
+	args := strings.Fields(line[4:])
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("nats: malformed MSG: %q", line)
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 || size > natsMaxPayload {
+		return fmt.Errorf("nats: malformed MSG size: %q", line)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return err
+	}
+	msg := core.NewMessage(payload[:size])
+	msg.SetMeta(MetaNATSSubject, args[0])
+	var reply string
+	if len(args) == 4 {
+		reply = args[2]
+		msg.SetMeta(MetaNATSReply, reply)
+	}
+	select {
+	case r.queue <- msg:
+	case <-r.done:
+		return nil
+	}
+	if len(reply) == 0 {
+		return nil
+	}
+	r.wgreply.Add(1)
+	go func() {
+		defer r.wgreply.Done()
+		var status core.MsgStatus
+		select {
+		case status = <-msg.AwaitChan():
+		case <-time.After(MsgSendTimeout):
+			status = core.MsgStatusTimedOut
+		case <-r.done:
+			return
+		}
+		resp := strings.TrimSpace(string(MsgStatusToTcpResp[status]))
+		pub := fmt.Sprintf("PUB %s %d\r\n%s\r\n", reply, len(resp), resp)
+		if err := r.write(conn, []byte(pub)); err != nil {
+			r.ctx.Logger().Debug("nats receiver %q failed to reply to %s: %s", r.name, reply, err)
+		}
+	}()
+	return nil
+}
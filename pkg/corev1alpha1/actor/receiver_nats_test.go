@@ -0,0 +1,172 @@
+package actor
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func newTestReceiverNATS(t *testing.T, params core.Params, mailbox chan *core.Message) core.Actor {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverNATS("receiver", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		status := core.MsgStatusDone
+		if string(msg.Body()) == "throttle-me" {
+			status = core.MsgStatusThrottled
+		}
+		msg.Complete(status)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	return rcv
+}
+
+func expectNATSMessage(t *testing.T, mailbox chan *core.Message) *core.Message {
+	select {
+	case msg := <-mailbox:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a message")
+	}
+	return nil
+}
+
+func TestReceiverNATS(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	stub.token = "s3cr3t"
+	mailbox := make(chan *core.Message, 16)
+	rcv := newTestReceiverNATS(t, core.Params{
+		"bind":           stub.addr(),
+		"subjects":       []interface{}{"events.>", "logs"},
+		"token":          "s3cr3t",
+		"reconnect_wait": 20,
+	}, mailbox)
+	defer rcv.Stop()
+	stub.waitSubs(t, 2)
+
+	stub.publish("events.web", "", "hello\r\nworld")
+	stub.publish("metrics", "", "not subscribed")
+	stub.publish("logs", "", "line")
+	for _, want := range []map[string]interface{}{
+		{"body": "hello\r\nworld", MetaNATSSubject: "events.web"},
+		{"body": "line", MetaNATSSubject: "logs"},
+	} {
+		msg := expectNATSMessage(t, mailbox)
+		subject, _ := msg.Meta(MetaNATSSubject)
+		if got := map[string]interface{}{"body": string(msg.Body()), MetaNATSSubject: subject}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected message: got: %v, want: %v", got, want)
+		}
+	}
+
+	// Requests are replied with the message status
+	stub.publish("events.web", "_INBOX.1", "ping")
+	stub.publish("events.web", "_INBOX.2", "throttle-me")
+	for _, want := range []string{"_INBOX.1", "_INBOX.2"} {
+		msg := expectNATSMessage(t, mailbox)
+		if reply, _ := msg.Meta(MetaNATSReply); reply != want {
+			t.Fatalf("unexpected reply subject: got: %v, want: %s", reply, want)
+		}
+	}
+	// The replies are sent concurrently
+	replies := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		pub := stub.expect(t)
+		replies[pub.subject] = pub.payload
+	}
+	if want := map[string]string{"_INBOX.1": "OK", "_INBOX.2": "THROTTLED"}; !reflect.DeepEqual(replies, want) {
+		t.Fatalf("unexpected replies: got: %v, want: %v", replies, want)
+	}
+
+	// The receiver resubscribes once the connection is lost
+	stub.dropConns()
+	stub.waitSubs(t, 4)
+	stub.publish("logs", "", "after reconnect")
+	if msg := expectNATSMessage(t, mailbox); string(msg.Body()) != "after reconnect" {
+		t.Fatalf("unexpected message after reconnect: %q", msg.Body())
+	}
+}
+
+func TestReceiverNATSQueueGroup(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	mailbox := make(chan *core.Message, 16)
+	for i := 0; i < 2; i++ {
+		rcv := newTestReceiverNATS(t, core.Params{
+			"bind":     stub.addr(),
+			"subjects": "jobs",
+			"queue":    "workers",
+		}, mailbox)
+		defer rcv.Stop()
+	}
+	stub.waitSubs(t, 2)
+	stub.lock.Lock()
+	for _, sub := range stub.subs {
+		if sub.group != "workers" {
+			t.Fatalf("unexpected queue group: %q", sub.group)
+		}
+	}
+	stub.lock.Unlock()
+
+	for i := 0; i < 4; i++ {
+		stub.publish("jobs", "", fmt.Sprintf("job-%d", i))
+	}
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, string(expectNATSMessage(t, mailbox).Body()))
+	}
+	sort.Strings(got)
+	if want := []string{"job-0", "job-1", "job-2", "job-3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected messages: got: %v, want: %v", got, want)
+	}
+	select {
+	case msg := <-mailbox:
+		t.Fatalf("unexpected duplicate delivery: %q", msg.Body())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNewReceiverNATSMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"subjects": "events"}, fmt.Errorf("nats receiver \"receiver\" is missing `bind` config")},
+		{core.Params{"bind": "127.0.0.1:4222"}, fmt.Errorf("nats receiver \"receiver\" is missing `subjects` config")},
+		{core.Params{"bind": "127.0.0.1:4222", "subjects": []interface{}{}}, fmt.Errorf("nats receiver \"receiver\" got malformed `subjects` config")},
+		{core.Params{"bind": "127.0.0.1:4222", "subjects": "events..web"}, fmt.Errorf("nats receiver \"receiver\" got an invalid subject: \"events..web\"")},
+		{core.Params{"bind": "127.0.0.1:4222", "subjects": "events", "queue": "my workers"}, fmt.Errorf("nats receiver \"receiver\" got an invalid queue group: \"my workers\"")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverNATS("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaRedisCommand = "redis.command"
+	MetaRedisKey     = "redis.key"
+	MetaRedisChannel = "redis.channel"
+	// MetaRedisFieldPrefix prefixes XADD entry fields other than the body.
+	MetaRedisFieldPrefix = "redis.field."
+
+	DefaultRESPBodyField = "body"
+	// DefaultRESPMaxBulkLen limits a single bulk string: the buffer is
+	// allocated upfront from the client-provided length.
+	DefaultRESPMaxBulkLen = 1024 * 1024
+
+	respMaxArrayLen = 1024 * 1024
+	// respArgsPrealloc limits the command argument slice preallocation, the
+	// slice grows as the arguments are actually read.
+	respArgsPrealloc = 64
+)
+
+var MsgStatusToRESPErr = map[core.MsgStatus]string{
+	core.MsgStatusPartialSend: "-ERR message partially sent\r\n",
+	core.MsgStatusInvalid:     "-ERR invalid message\r\n",
+	core.MsgStatusFailed:      "-ERR failed to send message\r\n",
+	core.MsgStatusTimedOut:    "-ERR timed out\r\n",
+	core.MsgStatusUnroutable:  "-ERR unroutable message\r\n",
+	core.MsgStatusThrottled:   "-ERR throttled\r\n",
+}
+
+// NewReceiverRESP builds a TCP receiver speaking the Redis protocol. It
+// accepts PING, ECHO, SELECT, QUIT, LPUSH, RPUSH, PUBLISH and XADD. Every
+// pushed value (or published message, or stream entry) becomes a message,
+// the key or the channel is stored in the message meta.
+// The reply reflects the message status: the command succeeds once all the
+// messages complete with MsgStatusDone, otherwise an error is returned
+// (see MsgStatusToRESPErr).
+// An XADD entry body is taken from the `body_field` field, the remaining
+// fields are stored in the meta as redis.field.<name>.
+// Bulk strings longer than `max_bulk_len` bytes (DefaultRESPMaxBulkLen by
+// default) are rejected as a protocol error.
+func NewReceiverRESP(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	rcv, err := NewReceiverTCP(name, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	bodyfield := DefaultRESPBodyField
+	if v, ok := params["body_field"]; ok {
+		bodyfield = v.(string)
+	}
+	maxbulk := DefaultRESPMaxBulkLen
+	if v, ok := params["max_bulk_len"]; ok {
+		if maxbulk = v.(int); maxbulk <= 0 {
+			return nil, fmt.Errorf("resp receiver %q `max_bulk_len` should be a positive integer, got: %d", name, maxbulk)
+		}
+	}
+	r := rcv.(*ReceiverTCP)
+	var seq int64
+	r.connhandler = func(conn net.Conn) {
+		r.handleConnRESP(conn, bodyfield, maxbulk, &seq)
+	}
+	return r, nil
+}
+
+// readRESPCommand reads either a RESP array of bulk strings or an inline
+// command. Bulk strings longer than maxbulk bytes are rejected.
+func readRESPCommand(reader *bufio.Reader, maxbulk int) ([][]byte, error) {
+	line, err := readRESPLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return [][]byte{}, nil
+	}
+	if line[0] != '*' {
+		fields := bytes.Fields(line)
+		res := make([][]byte, len(fields))
+		for i, f := range fields {
+			res[i] = append([]byte{}, f...)
+		}
+		return res, nil
+	}
+	cnt, err := strconv.Atoi(string(line[1:]))
+	if err != nil || cnt < 0 || cnt > respMaxArrayLen {
+		return nil, fmt.Errorf("Protocol error: invalid multibulk length")
+	}
+	prealloc := cnt
+	if prealloc > respArgsPrealloc {
+		prealloc = respArgsPrealloc
+	}
+	args := make([][]byte, 0, prealloc)
+	for i := 0; i < cnt; i++ {
+		line, err := readRESPLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("Protocol error: expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxbulk {
+			return nil, fmt.Errorf("Protocol error: invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("Protocol error: bulk string is not terminated")
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+func readRESPLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("Protocol error: too big inline request")
+		}
+		return nil, err
+	}
+	return dropCR(line[:len(line)-1]), nil
+}
+
+func respBulk(data []byte) []byte {
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(data), data))
+}
+
+func respErr(format string, args ...interface{}) []byte {
+	return []byte("-ERR " + fmt.Sprintf(format, args...) + "\r\n")
+}
+
+func (r *ReceiverTCP) handleConnRESP(conn net.Conn, bodyfield string, maxbulk int, seq *int64) {
+	r.ctx.Logger().Debug("new resp connection from %s", conn.RemoteAddr())
+
+	r.wgconn.Add(1)
+	defer r.wgconn.Done()
+
+	connover := make(chan struct{})
+	defer close(connover)
+	go func() {
+		select {
+		case <-r.done:
+			conn.Close()
+		case <-connover:
+		}
+	}()
+
+	reader := bufio.NewReaderSize(conn, r.bufsize)
+	for {
+		args, err := readRESPCommand(reader, maxbulk)
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-r.done:
+				default:
+					r.ctx.Logger().Debug("resp connection from %s failed: %s", conn.RemoteAddr(), err)
+					if strings.HasPrefix(err.Error(), "Protocol error") {
+						conn.Write(respErr("%s", err))
+					}
+				}
+			}
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply, quit := r.execRESP(args, bodyfield, seq)
+		conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+		if _, err := conn.Write(reply); err != nil {
+			r.ctx.Logger().Error(err.Error())
+			break
+		}
+		if quit {
+			break
+		}
+	}
+	conn.Close()
+
+	r.ctx.Logger().Debug("closing resp connection from %s", conn.RemoteAddr())
+}
+
+func (r *ReceiverTCP) execRESP(args [][]byte, bodyfield string, seq *int64) ([]byte, bool) {
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "ping":
+		if len(args) > 1 {
+			return respBulk(args[1]), false
+		}
+		return []byte("+PONG\r\n"), false
+	case "echo":
+		if len(args) != 2 {
+			return respErr("wrong number of arguments for '%s' command", cmd), false
+		}
+		return respBulk(args[1]), false
+	case "select":
+		return []byte("+OK\r\n"), false
+	case "quit":
+		return []byte("+OK\r\n"), true
+	case "lpush", "rpush":
+		if len(args) < 3 {
+			return respErr("wrong number of arguments for '%s' command", cmd), false
+		}
+		msgs := make([]*core.Message, 0, len(args)-2)
+		for _, v := range args[2:] {
+			msg := core.NewMessage(v)
+			msg.SetMeta(MetaRedisCommand, cmd)
+			msg.SetMeta(MetaRedisKey, string(args[1]))
+			msgs = append(msgs, msg)
+		}
+		if errreply := r.sendRESP(msgs); errreply != nil {
+			return errreply, false
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", len(msgs))), false
+	case "publish":
+		if len(args) != 3 {
+			return respErr("wrong number of arguments for '%s' command", cmd), false
+		}
+		msg := core.NewMessage(args[2])
+		msg.SetMeta(MetaRedisCommand, cmd)
+		msg.SetMeta(MetaRedisChannel, string(args[1]))
+		if errreply := r.sendRESP([]*core.Message{msg}); errreply != nil {
+			return errreply, false
+		}
+		return []byte(":1\r\n"), false
+	case "xadd":
+		return r.execXADD(args, bodyfield, seq), false
+	}
+	return respErr("unknown command '%s'", args[0]), false
+}
+
+// execXADD handles `XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold
+// [LIMIT count]] *|id field value [field value ...]`. The trimming options
+// are accepted and ignored.
+func (r *ReceiverTCP) execXADD(args [][]byte, bodyfield string, seq *int64) []byte {
+	if len(args) < 5 {
+		return respErr("wrong number of arguments for 'xadd' command")
+	}
+	key := string(args[1])
+	i := 2
+	for i < len(args) {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "nomkstream" {
+			i++
+			continue
+		}
+		if opt == "maxlen" || opt == "minid" {
+			i++
+			if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+				i++
+			}
+			i++
+			if i < len(args) && strings.ToLower(string(args[i])) == "limit" {
+				i += 2
+			}
+			continue
+		}
+		break
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return respErr("wrong number of arguments for 'xadd' command")
+	}
+	id := string(args[i])
+	if id == "*" {
+		id = fmt.Sprintf("%d-%d", time.Now().UnixNano()/int64(time.Millisecond), atomic.AddInt64(seq, 1))
+	}
+	var body []byte
+	fields := make(map[string]string)
+	for j := i + 1; j < len(args); j += 2 {
+		if string(args[j]) == bodyfield {
+			body = args[j+1]
+			continue
+		}
+		fields[string(args[j])] = string(args[j+1])
+	}
+	if body == nil {
+		return respErr("missing '%s' field", bodyfield)
+	}
+	msg := core.NewMessage(body)
+	msg.SetMeta(MetaRedisCommand, "xadd")
+	msg.SetMeta(MetaRedisKey, key)
+	for k, v := range fields {
+		msg.SetMeta(MetaRedisFieldPrefix+k, v)
+	}
+	if errreply := r.sendRESP([]*core.Message{msg}); errreply != nil {
+		return errreply
+	}
+	return respBulk([]byte(id))
+}
+
+// sendRESP sends the messages downstream and awaits them. It returns nil
+// if all the messages are done, the error reply otherwise.
+func (r *ReceiverTCP) sendRESP(msgs []*core.Message) []byte {
+	for _, msg := range msgs {
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return respStatusErr(core.MsgStatusFailed)
+		}
+	}
+	timeout := time.After(MsgSendTimeout)
+	for _, msg := range msgs {
+		var status core.MsgStatus
+		select {
+		case status = <-msg.AwaitChan():
+		case <-timeout:
+			status = core.MsgStatusTimedOut
+		}
+		if status != core.MsgStatusDone {
+			return respStatusErr(status)
+		}
+	}
+	return nil
+}
+
+func respStatusErr(status core.MsgStatus) []byte {
+	if reply, ok := MsgStatusToRESPErr[status]; ok {
+		return []byte(reply)
+	}
+	return respErr("unexpected message status %d", status)
+}
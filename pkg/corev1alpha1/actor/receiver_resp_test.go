@@ -0,0 +1,273 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func respCommand(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    [][]byte
+		wanterr error
+	}{
+		{respCommand("LPUSH", "k", ""), [][]byte{[]byte("LPUSH"), []byte("k"), []byte("")}, nil},
+		{"  ping   hi \n", [][]byte{[]byte("ping"), []byte("hi")}, nil},
+		{"\r\n", [][]byte{}, nil},
+		{"*x\r\n", nil, fmt.Errorf("Protocol error: invalid multibulk length")},
+		{"*1\r\n:1\r\n", nil, fmt.Errorf("Protocol error: expected '$', got ':1'")},
+		{"*1\r\n$-1\r\n", nil, fmt.Errorf("Protocol error: invalid bulk length")},
+		{"*1\r\n$1\r\nab\r\n", nil, fmt.Errorf("Protocol error: bulk string is not terminated")},
+		{"*1\r\n$3\r\nab", nil, io.ErrUnexpectedEOF},
+		{"*-1\r\n", nil, fmt.Errorf("Protocol error: invalid multibulk length")},
+		{"*1048577\r\n", nil, fmt.Errorf("Protocol error: invalid multibulk length")},
+		{"*1\r\n$17\r\n", nil, fmt.Errorf("Protocol error: invalid bulk length")},
+		{"*1\r\n$536870912\r\n", nil, fmt.Errorf("Protocol error: invalid bulk length")},
+	}
+	for _, testCase := range tests {
+		got, err := readRESPCommand(bufio.NewReader(strings.NewReader(testCase.input)), 16)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %q: got: %v, want: %v", testCase.input, err, testCase.wanterr)
+		}
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Fatalf("unexpected command for %q: got: %q, want: %q", testCase.input, got, testCase.want)
+		}
+	}
+}
+
+func TestReceiverRESP(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverRESP("receiver", ctx, core.Params{"bind": "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		status := core.MsgStatusDone
+		if string(msg.Body()) == "throttle-me" {
+			status = core.MsgStatusThrottled
+		}
+		msg.Complete(status)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	defer rcv.Stop()
+
+	conn, err := net.Dial("tcp", rcv.(*ReceiverTCP).listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	tests := []struct {
+		name      string
+		request   string
+		wantreply string
+		wantmsgs  []map[string]interface{}
+	}{
+		{
+			name:      "inline ping",
+			request:   "PING\r\n",
+			wantreply: "+PONG\r\n",
+		},
+		{
+			name:      "ping with a message",
+			request:   respCommand("PING", "hi"),
+			wantreply: "$2\r\nhi\r\n",
+		},
+		{
+			name:      "lpush",
+			request:   respCommand("LPUSH", "jobs", "a", "b\r\nc"),
+			wantreply: ":2\r\n",
+			wantmsgs: []map[string]interface{}{
+				{"body": "a", MetaRedisCommand: "lpush", MetaRedisKey: "jobs"},
+				{"body": "b\r\nc", MetaRedisCommand: "lpush", MetaRedisKey: "jobs"},
+			},
+		},
+		{
+			name:      "publish",
+			request:   respCommand("publish", "events", "hello"),
+			wantreply: ":1\r\n",
+			wantmsgs: []map[string]interface{}{
+				{"body": "hello", MetaRedisCommand: "publish", MetaRedisChannel: "events"},
+			},
+		},
+		{
+			name:      "xadd",
+			request:   respCommand("XADD", "stream", "MAXLEN", "~", "1000", "1-1", "body", "payload", "source", "app"),
+			wantreply: "$3\r\n1-1\r\n",
+			wantmsgs: []map[string]interface{}{
+				{"body": "payload", MetaRedisKey: "stream", MetaRedisFieldPrefix + "source": "app"},
+			},
+		},
+		{
+			name:      "xadd without body",
+			request:   respCommand("XADD", "stream", "*", "source", "app"),
+			wantreply: "-ERR missing 'body' field\r\n",
+		},
+		{
+			name:      "status mapping",
+			request:   respCommand("RPUSH", "jobs", "throttle-me"),
+			wantreply: MsgStatusToRESPErr[core.MsgStatusThrottled],
+			wantmsgs: []map[string]interface{}{
+				{"body": "throttle-me", MetaRedisCommand: "rpush"},
+			},
+		},
+		{
+			name:      "unknown command",
+			request:   respCommand("GET", "jobs"),
+			wantreply: "-ERR unknown command 'GET'\r\n",
+		},
+		{
+			name:      "wrong arity",
+			request:   respCommand("LPUSH", "jobs"),
+			wantreply: "-ERR wrong number of arguments for 'lpush' command\r\n",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := conn.Write([]byte(testCase.request)); err != nil {
+				t.Fatalf("failed to write request: %s", err)
+			}
+			expectSyslogMessages(t, mailbox, testCase.wantmsgs)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			reply := make([]byte, len(testCase.wantreply))
+			if _, err := io.ReadFull(reader, reply); err != nil {
+				t.Fatalf("failed to read reply: %s", err)
+			}
+			if string(reply) != testCase.wantreply {
+				t.Fatalf("unexpected reply: got: %q, want: %q", reply, testCase.wantreply)
+			}
+		})
+	}
+}
+
+func TestReceiverRESPMalformed(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverRESP("receiver", ctx, core.Params{"bind": "127.0.0.1:0", "max_bulk_len": 8})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	defer rcv.Stop()
+	addr := rcv.(*ReceiverTCP).listener.Addr().String()
+
+	tests := []struct {
+		name      string
+		request   string
+		wantreply string
+	}{
+		{
+			name:      "negative multibulk length",
+			request:   "*-1\r\n",
+			wantreply: "-ERR Protocol error: invalid multibulk length\r\n",
+		},
+		{
+			name:      "bulk length above max_bulk_len",
+			request:   "*1\r\n$9\r\n",
+			wantreply: "-ERR Protocol error: invalid bulk length\r\n",
+		},
+		{
+			name:      "huge bulk length",
+			request:   "*1\r\n$536870912\r\n",
+			wantreply: "-ERR Protocol error: invalid bulk length\r\n",
+		},
+		{
+			// The receiver survives the malformed requests above
+			name:      "ping",
+			request:   "PING\r\n",
+			wantreply: "+PONG\r\n",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("failed to dial: %s", err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte(testCase.request)); err != nil {
+				t.Fatalf("failed to write request: %s", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			reply := make([]byte, len(testCase.wantreply))
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				t.Fatalf("failed to read reply: %s", err)
+			}
+			if string(reply) != testCase.wantreply {
+				t.Fatalf("unexpected reply: got: %q, want: %q", reply, testCase.wantreply)
+			}
+		})
+	}
+}
+
+func TestNewReceiverRESPMaxBulkLen(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	_, err = NewReceiverRESP("receiver", ctx, core.Params{"bind": "127.0.0.1:0", "max_bulk_len": 0})
+	wanterr := fmt.Errorf("resp receiver %q `max_bulk_len` should be a positive integer, got: %d", "receiver", 0)
+	if !eqErr(err, wanterr) {
+		t.Fatalf("unexpected error: got: %v, want: %v", err, wanterr)
+	}
+}
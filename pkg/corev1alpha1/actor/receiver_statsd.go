@@ -0,0 +1,58 @@
+package actor
+
+import (
+	"bytes"
+	"net"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// NewReceiverStatsd builds a UDP receiver accepting statsd packets. A packet
+// might contain multiple newline-separated metrics: every metric becomes a
+// separate message, the parsed metric is stored in the statsd.metric meta
+// attribute. The messages are meant to be rolled up by
+// core.statsd_aggregator.
+func NewReceiverStatsd(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	rcv, err := NewReceiverUDP(name, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	r := rcv.(*ReceiverUDP)
+	r.connhandler = r.handleConnStatsd
+	return r, nil
+}
+
+// handleConnStatsd reads statsd packets off the connection until a read
+// fails.
+func (r *ReceiverUDP) handleConnStatsd(conn net.Conn) {
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				r.ctx.Logger().Error("statsd receiver %q failed to read a packet: %s", r.name, err)
+			}
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			line = dropCR(line)
+			if len(line) == 0 {
+				continue
+			}
+			metric, err := ParseStatsd(line)
+			if err != nil {
+				r.ctx.Logger().Debug("statsd receiver %q got a malformed metric: %s", r.name, err)
+				continue
+			}
+			msg := core.NewMessage(line)
+			msg.SetMeta(MetaStatsdMetric, metric)
+			select {
+			case r.queue <- msg:
+			case <-r.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package actor
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestReceiverStatsd(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverStatsd("receiver", ctx, core.Params{"bind": "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		msg.Complete(core.MsgStatusDone)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	defer rcv.Stop()
+
+	conn, err := net.Dial("udp", rcv.(*ReceiverUDP).conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hits:1|c\nbroken\r\nrt:5|ms|#env:prod\n")); err != nil {
+		t.Fatalf("failed to write packet: %s", err)
+	}
+
+	want := []*StatsdMetric{
+		{Name: "hits", Type: StatsdCounter, Value: 1, Rate: 1},
+		{Name: "rt", Type: StatsdTimer, Value: 5, Rate: 1, Tags: []string{"env:prod"}},
+	}
+	for _, w := range want {
+		select {
+		case msg := <-mailbox:
+			got, _ := msg.Meta(MetaStatsdMetric)
+			if !reflect.DeepEqual(got, w) {
+				t.Fatalf("unexpected metric: got: %+v, want: %+v", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for metric %+v", w)
+		}
+	}
+}
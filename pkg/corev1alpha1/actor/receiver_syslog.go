@@ -0,0 +1,152 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	SyslogTransportUDP = "udp"
+	SyslogTransportTCP = "tcp"
+	SyslogTransportTLS = "tls"
+
+	MaxDatagramSize = 64 * 1024
+)
+
+// NewReceiverSyslog builds a receiver accepting RFC 3164 and RFC 5424 syslog
+// messages over UDP, TCP or TLS (see `transport`): a UDP receiver or a TCP
+// receiver respectively. The header fields are stored in the message meta
+// (see SyslogMessage.SetMeta), the MSG part becomes the message body.
+// TCP and TLS streams support both octet-counting and LF-delimited framing.
+func NewReceiverSyslog(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	if _, ok := params["bind"]; !ok {
+		return nil, fmt.Errorf("syslog receiver %q is missing `bind` config", name)
+	}
+
+	transport := SyslogTransportUDP
+	if v, ok := params["transport"]; ok {
+		transport = v.(string)
+	}
+
+	switch transport {
+	case SyslogTransportUDP:
+		rcv, err := NewReceiverUDP(name, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		r := rcv.(*ReceiverUDP)
+		r.connhandler = r.handleConnSyslog
+		return r, nil
+	case SyslogTransportTCP, SyslogTransportTLS:
+		rcv, err := NewReceiverTCP(name, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		r := rcv.(*ReceiverTCP)
+		if transport == SyslogTransportTLS {
+			if r.tlsconf, err = buildServerTLSConfig(params); err != nil {
+				return nil, fmt.Errorf("syslog receiver %q failed to configure tls: %s", name, err)
+			}
+		}
+		r.connhandler = r.handleConnSyslog
+		return r, nil
+	}
+
+	return nil, fmt.Errorf("syslog receiver %q got an unknown `transport`: %q", name, transport)
+}
+
+// handleConnSyslog reads syslog datagrams off the connection until a read
+// fails.
+func (r *ReceiverUDP) handleConnSyslog(conn net.Conn) {
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				r.ctx.Logger().Error("syslog receiver %q failed to read a packet: %s", r.name, err)
+			}
+			return
+		}
+		msg := newSyslogMessage(dropCR(trimLF(buf[:n])), time.Now(), r.ctx.Logger(), r.name)
+		if msg == nil {
+			continue
+		}
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func trimLF(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\n' {
+		return data[:len(data)-1]
+	}
+	return data
+}
+
+// handleConnSyslog reads a stream of syslog frames off the connection.
+func (r *ReceiverTCP) handleConnSyslog(conn net.Conn) {
+	r.ctx.Logger().Debug("new syslog connection from %s", conn.RemoteAddr())
+
+	r.wgconn.Add(1)
+	defer r.wgconn.Done()
+
+	connover := make(chan struct{})
+	defer close(connover)
+	go func() {
+		select {
+		case <-r.done:
+		case <-connover:
+		}
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 1024), r.bufsize)
+	scanner.Split(ScanSyslog)
+	for scanner.Scan() {
+		msg := newSyslogMessage(scanner.Bytes(), time.Now(), r.ctx.Logger(), r.name)
+		if msg == nil {
+			continue
+		}
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-r.done:
+		default:
+			r.ctx.Logger().Error("syslog receiver %q failed to read from %s: %s", r.name, conn.RemoteAddr(), err)
+		}
+	}
+
+	r.ctx.Logger().Debug("closing syslog connection from %s", conn.RemoteAddr())
+}
+
+// newSyslogMessage parses a single frame into a message, nil for an empty
+// frame. A frame that does not start with a PRI part is forwarded as is with
+// the default priority.
+func newSyslogMessage(frame []byte, now time.Time, logger *core.Logger, name string) *core.Message {
+	if len(frame) == 0 {
+		return nil
+	}
+	sm, err := ParseSyslog(frame, now)
+	if err != nil {
+		logger.Debug("syslog receiver %q got a malformed message: %s", name, err)
+		sm = &SyslogMessage{Priority: SyslogDefaultPriority, Message: frame}
+	}
+	msg := core.NewMessage(sm.Message)
+	sm.SetMeta(msg)
+	return msg
+}
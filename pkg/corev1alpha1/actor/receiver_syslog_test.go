@@ -0,0 +1,197 @@
+package actor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func newTestSyslogReceiver(t *testing.T, params core.Params) (core.Actor, chan *core.Message) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverSyslog("receiver", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		msg.Complete(core.MsgStatusDone)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	return rcv, mailbox
+}
+
+func syslogLocalAddr(rcv core.Actor) string {
+	if r, ok := rcv.(*ReceiverUDP); ok {
+		return r.conn.LocalAddr().String()
+	}
+	return rcv.(*ReceiverTCP).listener.Addr().String()
+}
+
+func expectSyslogMessages(t *testing.T, mailbox chan *core.Message, want []map[string]interface{}) {
+	for _, w := range want {
+		select {
+		case msg := <-mailbox:
+			if string(msg.Body()) != w["body"] {
+				t.Fatalf("unexpected message body: got: %q, want: %q", msg.Body(), w["body"])
+			}
+			for k, v := range w {
+				if k == "body" {
+					continue
+				}
+				if got, _ := msg.Meta(k); got != v {
+					t.Fatalf("unexpected meta %q: got: %v, want: %v", k, got, v)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for message %v", w)
+		}
+	}
+}
+
+func TestReceiverSyslogUDP(t *testing.T) {
+	rcv, mailbox := newTestSyslogReceiver(t, core.Params{"bind": "127.0.0.1:0"})
+	defer rcv.Stop()
+
+	conn, err := net.Dial("udp", syslogLocalAddr(rcv))
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	for _, packet := range []string{
+		"<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n",
+		"<165>1 2003-10-11T22:14:15.003Z host evntslog - ID47 [origin ip=\"10.0.0.1\"] event",
+		"no pri at all",
+	} {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			t.Fatalf("failed to write packet: %s", err)
+		}
+		// Keep the order: the receiver runs multiple readers
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	expectSyslogMessages(t, mailbox, []map[string]interface{}{
+		{
+			"body":             "'su root' failed",
+			MetaSyslogFacility: "auth",
+			MetaSyslogSeverity: "crit",
+			MetaSyslogHostname: "mymachine",
+			MetaSyslogAppName:  "su",
+		},
+		{
+			"body":                                  "event",
+			MetaSyslogPriority:                      165,
+			MetaSyslogFacility:                      "local4",
+			MetaSyslogSeverity:                      "notice",
+			MetaSyslogMsgID:                         "ID47",
+			MetaSyslogStructuredData + ".origin.ip": "10.0.0.1",
+		},
+		{
+			"body":             "no pri at all",
+			MetaSyslogFacility: "user",
+			MetaSyslogSeverity: "notice",
+		},
+	})
+}
+
+func TestReceiverSyslogTCP(t *testing.T) {
+	rcv, mailbox := newTestSyslogReceiver(t, core.Params{
+		"bind":      "127.0.0.1:0",
+		"transport": "tcp",
+	})
+	defer rcv.Stop()
+
+	conn, err := net.Dial("tcp", syslogLocalAddr(rcv))
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	frame := "<13>1 - host app - - - multi\nline"
+	if _, err := fmt.Fprintf(conn, "%d %s<14>Jan  2 11:00:00 host app: plain\n", len(frame), frame); err != nil {
+		t.Fatalf("failed to write frames: %s", err)
+	}
+
+	expectSyslogMessages(t, mailbox, []map[string]interface{}{
+		{"body": "multi\nline", MetaSyslogSeverity: "notice", MetaSyslogHostname: "host"},
+		{"body": "plain", MetaSyslogSeverity: "info", MetaSyslogAppName: "app"},
+	})
+}
+
+func TestReceiverSyslogTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-syslog")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile := newTestCert(t, dir)
+
+	rcv, mailbox := newTestSyslogReceiver(t, core.Params{
+		"bind":      "127.0.0.1:0",
+		"transport": "tls",
+		"tls_cert":  certfile,
+		"tls_key":   keyfile,
+	})
+	defer rcv.Stop()
+
+	pem, err := ioutil.ReadFile(certfile)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	conn, err := tls.Dial("tcp", syslogLocalAddr(rcv), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("<86>1 - host sshd 42 - - accepted\n")); err != nil {
+		t.Fatalf("failed to write frame: %s", err)
+	}
+
+	expectSyslogMessages(t, mailbox, []map[string]interface{}{
+		{"body": "accepted", MetaSyslogFacility: "authpriv", MetaSyslogProcID: "42"},
+	})
+}
+
+func TestNewReceiverSyslogMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{}, fmt.Errorf("syslog receiver \"receiver\" is missing `bind` config")},
+		{core.Params{"bind": ":514", "transport": "sctp"}, fmt.Errorf("syslog receiver \"receiver\" got an unknown `transport`: \"sctp\"")},
+		{core.Params{"bind": ":6514", "transport": "tls"}, fmt.Errorf("syslog receiver \"receiver\" failed to configure tls: missing `tls_cert` config")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverSyslog("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+		}
+	}
+}
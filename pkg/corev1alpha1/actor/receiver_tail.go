@@ -0,0 +1,611 @@
+package actor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	TailPollInterval       = 250 * time.Millisecond
+	TailCheckpointInterval = time.Second
+	TailMaxLineSize        = 64 * 1024
+	TailFingerprintSize    = 512
+	TailMaxInflight        = 1024
+
+	MetaFilePath   = "file.path"
+	MetaFileOffset = "file.offset"
+)
+
+// TailCheckpoint is a persisted read position of a single file. The file is
+// identified by the checksum of it's first FingerprintLen bytes: if the
+// checksum does not match on restart, the file is considered to be rotated
+// and is read from the beginning.
+type TailCheckpoint struct {
+	Offset         int64  `json:"offset"`
+	Fingerprint    uint32 `json:"fingerprint"`
+	FingerprintLen int64  `json:"fingerprint_len"`
+}
+
+type tailAck struct {
+	msg *core.Message
+	end int64
+	gen int
+}
+
+// tailFile represents a single opened file. A new instance is created every
+// time the file under the tailed path is rotated or truncated.
+type tailFile struct {
+	file      *os.File
+	info      os.FileInfo
+	offset    int64
+	committed int64
+	pending   []byte
+	// gen is bumped by the reader on every rewind, the acks of the
+	// messages read before the rewind are ignored.
+	gen    int
+	rewind chan int64
+	acks   chan tailAck
+	wg     sync.WaitGroup
+}
+
+// commit advances the committed offset in the message order. Once a message
+// completes with a retryable status (see tailRetryable), the reader is asked
+// to rewind to the committed offset: the failed message and the ones
+// following it are read again. Messages completing with a permanent failure
+// status are logged and committed past: re-reading them would never succeed.
+func (tf *tailFile) commit(logger *core.Logger, path string) {
+	defer tf.wg.Done()
+	gen, failed := 0, false
+	for ack := range tf.acks {
+		sts := ack.msg.Await()
+		if ack.gen != gen {
+			if ack.gen < gen {
+				continue
+			}
+			gen, failed = ack.gen, false
+		}
+		if failed {
+			continue
+		}
+		if sts != core.MsgStatusDone {
+			committed := atomic.LoadInt64(&tf.committed)
+			if !tailRetryable(sts) {
+				logger.With(ack.msg.LogFields()).Error("tail receiver: message at %s:%d completed with status %d, skipping", path, committed, sts)
+			} else {
+				logger.With(ack.msg.LogFields()).Error("tail receiver: message at %s:%d completed with status %d, rewinding", path, committed, sts)
+				failed = true
+				tf.rewind <- committed
+				continue
+			}
+		}
+		atomic.StoreInt64(&tf.committed, ack.end)
+	}
+}
+
+// tailRetryable tells whether a message completed with the status might
+// succeed if it's sent again.
+func tailRetryable(sts core.MsgStatus) bool {
+	switch sts {
+	case core.MsgStatusFailed, core.MsgStatusTimedOut, core.MsgStatusThrottled:
+		return true
+	}
+	return false
+}
+
+func (tf *tailFile) close() {
+	close(tf.acks)
+	tf.wg.Wait()
+	tf.file.Close()
+}
+
+func (tf *tailFile) checkpoint() (*TailCheckpoint, error) {
+	buf := make([]byte, TailFingerprintSize)
+	n, err := tf.file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &TailCheckpoint{
+		Offset:         atomic.LoadInt64(&tf.committed),
+		Fingerprint:    crc32.ChecksumIEEE(buf[:n]),
+		FingerprintLen: int64(n),
+	}, nil
+}
+
+func (tf *tailFile) matches(ck *TailCheckpoint) bool {
+	buf := make([]byte, ck.FingerprintLen)
+	n, err := tf.file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return int64(n) == ck.FingerprintLen && crc32.ChecksumIEEE(buf) == ck.Fingerprint
+}
+
+type tailer struct {
+	path string
+	cur  *tailFile
+	lock sync.Mutex
+}
+
+func (t *tailer) current() *tailFile {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.cur
+}
+
+func (t *tailer) setCurrent(tf *tailFile) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.cur = tf
+}
+
+// ReceiverTail follows files matching a list of glob patterns the way
+// `tail -F` does: it handles file rotation and truncation. Every line (or a
+// chunk of data terminated by a custom delimiter) becomes a message with
+// file.path and file.offset meta attributes.
+// If `checkpoint` is set, the read positions are persisted in the
+// corresponding file and are advanced only once the messages complete with
+// MsgStatusDone or a permanent failure status (invalid, unroutable).
+type ReceiverTail struct {
+	name      string
+	ctx       *core.Context
+	globs     []string
+	delim     []byte
+	fromstart bool
+	pollintvl time.Duration
+	ckpath    string
+	ckintvl   time.Duration
+	maxline   int
+	cks       map[string]*TailCheckpoint
+	tailers   map[string]*tailer
+	lock      sync.Mutex
+	queue     chan *core.Message
+	done      chan struct{}
+	wgtail    sync.WaitGroup
+	wgpeer    sync.WaitGroup
+}
+
+var _ core.Actor = (*ReceiverTail)(nil)
+
+func NewReceiverTail(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	globs := make([]string, 0, 1)
+	if bind, ok := params["bind"]; ok && len(bind.(string)) > 0 {
+		globs = append(globs, bind.(string))
+	}
+	if paths, ok := params["paths"]; ok {
+		ps, err := toStrList(paths)
+		if err != nil {
+			return nil, fmt.Errorf("tail receiver %q has malformed `paths` config: %s", name, err)
+		}
+		globs = append(globs, ps...)
+	}
+	if len(globs) == 0 {
+		return nil, fmt.Errorf("tail receiver %q is missing `bind` config", name)
+	}
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("tail receiver %q got a malformed glob %q: %s", name, glob, err)
+		}
+	}
+
+	r := &ReceiverTail{
+		name:      name,
+		ctx:       ctx,
+		globs:     globs,
+		delim:     []byte{'\n'},
+		pollintvl: TailPollInterval,
+		ckintvl:   TailCheckpointInterval,
+		maxline:   TailMaxLineSize,
+		cks:       make(map[string]*TailCheckpoint),
+		tailers:   make(map[string]*tailer),
+		queue:     make(chan *core.Message),
+		done:      make(chan struct{}),
+	}
+
+	if v, ok := params["framing"]; ok {
+		switch v.(string) {
+		case "lf":
+			r.delim = []byte{'\n'}
+		case "crlf":
+			r.delim = []byte{'\r', '\n'}
+		case "nul":
+			r.delim = []byte{0}
+		default:
+			return nil, fmt.Errorf("tail receiver %q got an unknown `framing`: %q", name, v)
+		}
+	}
+	if v, ok := params["read_from"]; ok {
+		switch v.(string) {
+		case "beginning":
+			r.fromstart = true
+		case "end":
+			r.fromstart = false
+		default:
+			return nil, fmt.Errorf("tail receiver %q got an unknown `read_from`: %q", name, v)
+		}
+	}
+	if v, ok := params["poll_interval"]; ok {
+		r.pollintvl = time.Duration(v.(int)) * time.Millisecond
+	}
+	if v, ok := params["buf_size"]; ok {
+		r.maxline = v.(int)
+	}
+	if v, ok := params["checkpoint"]; ok {
+		r.ckpath = v.(string)
+	}
+	if v, ok := params["checkpoint_interval"]; ok {
+		r.ckintvl = time.Duration(v.(int)) * time.Millisecond
+	}
+
+	return r, nil
+}
+
+func (r *ReceiverTail) Name() string {
+	return r.name
+}
+
+func (r *ReceiverTail) Start() error {
+	if len(r.ckpath) > 0 {
+		if err := r.loadCheckpoints(); err != nil {
+			return err
+		}
+	}
+
+	// Files existing at the start time are read according to `read_from`,
+	// the ones appearing later are always read from the beginning.
+	r.discover(r.fromstart)
+
+	r.wgtail.Add(1)
+	go func() {
+		defer r.wgtail.Done()
+		poll := time.NewTicker(r.pollintvl)
+		defer poll.Stop()
+		var ck <-chan time.Time
+		if len(r.ckpath) > 0 {
+			ticker := time.NewTicker(r.ckintvl)
+			defer ticker.Stop()
+			ck = ticker.C
+		}
+		for {
+			select {
+			case <-poll.C:
+				r.discover(true)
+			case <-ck:
+				if err := r.saveCheckpoints(); err != nil {
+					r.ctx.Logger().Error("tail receiver %q failed to save checkpoints: %s", r.name, err)
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *ReceiverTail) Stop() error {
+	close(r.done)
+	r.wgtail.Wait()
+
+	r.lock.Lock()
+	tfs := make([]*tailFile, 0, len(r.tailers))
+	for _, t := range r.tailers {
+		tfs = append(tfs, t.current())
+	}
+	r.lock.Unlock()
+
+	// Let the in-flight messages complete so the checkpoints are accurate.
+	committed := make(chan struct{})
+	go func() {
+		for _, tf := range tfs {
+			close(tf.acks)
+			tf.wg.Wait()
+		}
+		close(committed)
+	}()
+	select {
+	case <-committed:
+	case <-time.After(ShutdownTimeout):
+		r.ctx.Logger().Warn("tail receiver %q timed out waiting for in-flight messages", r.name)
+	}
+
+	var err error
+	if len(r.ckpath) > 0 {
+		err = r.saveCheckpoints()
+	}
+	for _, tf := range tfs {
+		tf.file.Close()
+	}
+	close(r.queue)
+	r.wgpeer.Wait()
+
+	return err
+}
+
+func (r *ReceiverTail) Connect(nthreads int, peer core.Receiver) error {
+	for i := 0; i < nthreads; i++ {
+		r.wgpeer.Add(1)
+		go func() {
+			for msg := range r.queue {
+				if err := peer.Receive(msg); err != nil {
+					msg.Complete(core.MsgStatusFailed)
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
+				}
+			}
+			r.wgpeer.Done()
+		}()
+	}
+	return nil
+}
+
+func (r *ReceiverTail) Receive(*core.Message) error {
+	return fmt.Errorf("tail receiver %q can not receive internal messages", r.name)
+}
+
+func (r *ReceiverTail) discover(fromstart bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, glob := range r.globs {
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			r.ctx.Logger().Error("tail receiver %q failed to list %q: %s", r.name, glob, err)
+			continue
+		}
+		for _, path := range paths {
+			if _, ok := r.tailers[path]; ok {
+				continue
+			}
+			t := &tailer{path: path}
+			tf, err := r.open(path, fromstart, r.cks[path])
+			if err != nil {
+				r.ctx.Logger().Error("tail receiver %q failed to open %q: %s", r.name, path, err)
+				continue
+			}
+			t.setCurrent(tf)
+			r.tailers[path] = t
+			r.ctx.Logger().Info("tail receiver %q is following %s", r.name, path)
+			r.wgtail.Add(1)
+			go r.follow(t)
+		}
+	}
+}
+
+// open opens the file and positions the read offset: a matching checkpoint
+// takes precedence over the fromstart flag.
+func (r *ReceiverTail) open(path string, fromstart bool, ck *TailCheckpoint) (*tailFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	tf := &tailFile{
+		file:   file,
+		info:   info,
+		rewind: make(chan int64, 1),
+		acks:   make(chan tailAck, TailMaxInflight),
+	}
+	if ck != nil && tf.matches(ck) && ck.Offset <= info.Size() {
+		tf.offset = ck.Offset
+	} else if !fromstart {
+		tf.offset = info.Size()
+	}
+	tf.committed = tf.offset
+	if _, err := file.Seek(tf.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	tf.wg.Add(1)
+	go tf.commit(r.ctx.Logger(), path)
+
+	return tf, nil
+}
+
+func (r *ReceiverTail) follow(t *tailer) {
+	defer r.wgtail.Done()
+	buf := make([]byte, DefaultBufSize)
+	for {
+		tf := t.current()
+		select {
+		case offset := <-tf.rewind:
+			if !r.rewind(t.path, tf, offset) {
+				return
+			}
+		default:
+		}
+		n, err := tf.file.Read(buf)
+		if n > 0 {
+			tf.pending = append(tf.pending, buf[:n]...)
+			tf.offset += int64(n)
+			if !r.emit(t.path, tf, false) {
+				return
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			r.ctx.Logger().Error("tail receiver %q failed to read %s: %s", r.name, t.path, err)
+		}
+		// EOF: check whether the file was rotated, truncated or deleted.
+		info, err := os.Stat(t.path)
+		if os.IsNotExist(err) {
+			r.ctx.Logger().Info("tail receiver %q detected removal of %s", r.name, t.path)
+			r.emit(t.path, tf, true)
+			r.remove(t)
+			return
+		}
+		if err == nil {
+			if !os.SameFile(info, tf.info) {
+				r.ctx.Logger().Info("tail receiver %q detected rotation of %s", r.name, t.path)
+				if !r.emit(t.path, tf, true) {
+					return
+				}
+				if !r.reopen(t, tf) {
+					return
+				}
+				continue
+			} else if info.Size() < tf.offset {
+				r.ctx.Logger().Info("tail receiver %q detected truncation of %s", r.name, t.path)
+				if !r.reopen(t, tf) {
+					return
+				}
+				continue
+			}
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(r.pollintvl):
+		}
+	}
+}
+
+// rewind re-positions the reader at the offset after a message failure. The
+// reading is resumed after a poll interval.
+func (r *ReceiverTail) rewind(path string, tf *tailFile, offset int64) bool {
+	if _, err := tf.file.Seek(offset, io.SeekStart); err != nil {
+		r.ctx.Logger().Error("tail receiver %q failed to rewind %s: %s", r.name, path, err)
+	} else {
+		tf.offset = offset
+		tf.pending = nil
+		tf.gen++
+	}
+	select {
+	case <-r.done:
+		return false
+	case <-time.After(r.pollintvl):
+		return true
+	}
+}
+
+// remove stops following a deleted file. The file is picked up again by
+// discover if it re-appears.
+func (r *ReceiverTail) remove(t *tailer) {
+	r.lock.Lock()
+	delete(r.tailers, t.path)
+	delete(r.cks, t.path)
+	r.lock.Unlock()
+	// The file is closed once all it's messages complete.
+	go t.current().close()
+}
+
+func (r *ReceiverTail) reopen(t *tailer, tf *tailFile) bool {
+	// Rotation or truncation invalidates the old checkpoint.
+	r.lock.Lock()
+	delete(r.cks, t.path)
+	r.lock.Unlock()
+	for {
+		ntf, err := r.open(t.path, true, nil)
+		if err == nil {
+			t.setCurrent(ntf)
+			// The old file is closed once all it's messages complete.
+			go tf.close()
+			return true
+		}
+		select {
+		case <-r.done:
+			return false
+		case <-time.After(r.pollintvl):
+		}
+	}
+}
+
+// emit splits the pending data into messages. If flush is true, the
+// trailing non-terminated chunk is emitted as well.
+func (r *ReceiverTail) emit(path string, tf *tailFile, flush bool) bool {
+	start := tf.offset - int64(len(tf.pending))
+	for len(tf.pending) > 0 {
+		var chunk []byte
+		var adv int
+		if ix := bytes.Index(tf.pending, r.delim); ix >= 0 {
+			chunk, adv = tf.pending[:ix], ix+len(r.delim)
+		} else if flush || len(tf.pending) >= r.maxline {
+			chunk, adv = tf.pending, len(tf.pending)
+		} else {
+			break
+		}
+		if len(r.delim) == 1 && r.delim[0] == '\n' {
+			chunk = dropCR(chunk)
+		}
+		msg := core.NewMessage(chunk)
+		msg.SetMeta(MetaFilePath, path)
+		msg.SetMeta(MetaFileOffset, start)
+		start += int64(adv)
+		tf.pending = tf.pending[adv:]
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return false
+		}
+		ack := tailAck{msg: msg, end: start, gen: tf.gen}
+		select {
+		case tf.acks <- ack:
+		case <-r.done:
+			// The message is already queued: account for it in the
+			// checkpoint unless the acks are backed up.
+			select {
+			case tf.acks <- ack:
+			default:
+			}
+			return false
+		}
+	}
+	// Compact the pending buffer to avoid retaining the read chunks.
+	tf.pending = append([]byte(nil), tf.pending...)
+	return true
+}
+
+func (r *ReceiverTail) loadCheckpoints() error {
+	data, err := ioutil.ReadFile(r.ckpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("tail receiver %q failed to read checkpoint file: %s", r.name, err)
+	}
+	cks := make(map[string]*TailCheckpoint)
+	if err := json.Unmarshal(data, &cks); err != nil {
+		return fmt.Errorf("tail receiver %q failed to parse checkpoint file: %s", r.name, err)
+	}
+	r.cks = cks
+	return nil
+}
+
+// saveCheckpoints atomically replaces the checkpoint file.
+func (r *ReceiverTail) saveCheckpoints() error {
+	r.lock.Lock()
+	cks := make(map[string]*TailCheckpoint, len(r.tailers))
+	for path, ck := range r.cks {
+		cks[path] = ck
+	}
+	for path, t := range r.tailers {
+		ck, err := t.current().checkpoint()
+		if err != nil {
+			continue
+		}
+		cks[path] = ck
+	}
+	r.lock.Unlock()
+
+	data, err := json.Marshal(cks)
+	if err != nil {
+		return err
+	}
+	tmp := r.ckpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.ckpath)
+}
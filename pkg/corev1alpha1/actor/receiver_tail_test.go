@@ -0,0 +1,300 @@
+package actor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func appendFile(t *testing.T, path string, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open file %s: %s", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("failed to write file %s: %s", path, err)
+	}
+}
+
+type tailRecord struct {
+	body   string
+	path   string
+	offset int64
+}
+
+func newTestTailReceiver(t *testing.T, params core.Params, status func(*core.Message) core.MsgStatus) (core.Actor, chan tailRecord) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverTail("receiver", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan tailRecord, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		path, _ := msg.Meta(MetaFilePath)
+		offset, _ := msg.Meta(MetaFileOffset)
+		mailbox <- tailRecord{string(msg.Body()), path.(string), offset.(int64)}
+		msg.Complete(status(msg))
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	return rcv, mailbox
+}
+
+func expectTailRecords(t *testing.T, mailbox chan tailRecord, want []tailRecord) {
+	for _, w := range want {
+		select {
+		case got := <-mailbox:
+			if got != w {
+				t.Fatalf("unexpected message: got: %+v, want: %+v", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for message %+v", w)
+		}
+	}
+}
+
+func TestReceiverTailFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-tail")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "foo\nbar\n")
+
+	rcv, mailbox := newTestTailReceiver(t, core.Params{
+		"bind":          filepath.Join(dir, "*.log"),
+		"read_from":     "beginning",
+		"poll_interval": 10,
+	}, func(*core.Message) core.MsgStatus { return core.MsgStatusDone })
+
+	expectTailRecords(t, mailbox, []tailRecord{{"foo", path, 0}, {"bar", path, 4}})
+
+	// A partial line is held until the delimiter arrives
+	appendFile(t, path, "baz")
+	appendFile(t, path, "\r\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"baz", path, 8}})
+
+	// Rotation: the remaining data of the old file is flushed
+	appendFile(t, path, "moo")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("failed to rotate file: %s", err)
+	}
+	appendFile(t, path, "new\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"moo", path, 13}, {"new", path, 0}})
+
+	// Truncation
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("failed to truncate file: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "x\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"x", path, 0}})
+
+	// A newly discovered file is read from the beginning
+	other := filepath.Join(dir, "other.log")
+	appendFile(t, other, "hello\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"hello", other, 0}})
+
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+}
+
+func TestReceiverTailCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-tail")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	ckpath := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, "1\n2\n3\n4\n")
+
+	params := core.Params{
+		"bind":          path,
+		"checkpoint":    ckpath,
+		"poll_interval": 10,
+	}
+
+	rcv, mailbox := newTestTailReceiver(t, params, func(*core.Message) core.MsgStatus {
+		return core.MsgStatusDone
+	})
+	// No checkpoint yet, read_from defaults to the end of the file
+	appendFile(t, path, "5\n6\n7\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"5", path, 8}, {"6", path, 10}, {"7", path, 12}})
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(ckpath)
+	if err != nil {
+		t.Fatalf("failed to read checkpoint file: %s", err)
+	}
+	cks := make(map[string]*TailCheckpoint)
+	if err := json.Unmarshal(data, &cks); err != nil {
+		t.Fatalf("failed to parse checkpoint file: %s", err)
+	}
+	if ck, ok := cks[path]; !ok || ck.Offset != 14 {
+		t.Fatalf("unexpected checkpoint: got: %+v, want offset: 14", ck)
+	}
+
+	// Restart: the reading is resumed from the checkpoint. The second
+	// message fails once: the reading is rewound right before it.
+	appendFile(t, path, "8\n")
+	failed := false
+	rcv, mailbox = newTestTailReceiver(t, params, func(msg *core.Message) core.MsgStatus {
+		if string(msg.Body()) == "9" && !failed {
+			failed = true
+			return core.MsgStatusFailed
+		}
+		return core.MsgStatusDone
+	})
+	appendFile(t, path, "9\n10\n")
+	expectTailRecords(t, mailbox, []tailRecord{
+		{"8", path, 14},
+		{"9", path, 16},
+		{"10", path, 18},
+		{"9", path, 16},
+		{"10", path, 18},
+	})
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+	data, _ = ioutil.ReadFile(ckpath)
+	json.Unmarshal(data, &cks)
+	if ck := cks[path]; ck.Offset != 21 {
+		t.Fatalf("unexpected checkpoint offset: got: %d, want: 21", ck.Offset)
+	}
+}
+
+func TestReceiverTailPermanentFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-tail")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	ckpath := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, "")
+	rcv, mailbox := newTestTailReceiver(t, core.Params{
+		"bind":          path,
+		"checkpoint":    ckpath,
+		"poll_interval": 10,
+	}, func(*core.Message) core.MsgStatus {
+		return core.MsgStatusInvalid
+	})
+	appendFile(t, path, "1\n2\n3\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"1", path, 0}, {"2", path, 2}, {"3", path, 4}})
+
+	// Invalid messages are not read again
+	select {
+	case got := <-mailbox:
+		t.Fatalf("unexpected message: %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(ckpath)
+	if err != nil {
+		t.Fatalf("failed to read checkpoint file: %s", err)
+	}
+	cks := make(map[string]*TailCheckpoint)
+	if err := json.Unmarshal(data, &cks); err != nil {
+		t.Fatalf("failed to parse checkpoint file: %s", err)
+	}
+	if ck, ok := cks[path]; !ok || ck.Offset != 6 {
+		t.Fatalf("unexpected checkpoint: got: %+v, want offset: 6", ck)
+	}
+}
+
+func TestReceiverTailRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-receiver-tail")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "")
+	rcv, mailbox := newTestTailReceiver(t, core.Params{
+		"bind":          filepath.Join(dir, "*.log"),
+		"poll_interval": 10,
+	}, func(*core.Message) core.MsgStatus {
+		return core.MsgStatusDone
+	})
+	appendFile(t, path, "first\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"first", path, 0}})
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed to remove file: %s", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r := rcv.(*ReceiverTail)
+		r.lock.Lock()
+		_, ok := r.tailers[path]
+		r.lock.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the tailer to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A re-created file is read from the beginning
+	appendFile(t, path, "second\n")
+	expectTailRecords(t, mailbox, []tailRecord{{"second", path, 0}})
+
+	if err := rcv.Stop(); err != nil {
+		t.Fatalf("failed to stop receiver: %s", err)
+	}
+}
+
+func TestNewReceiverTailMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{}, fmt.Errorf("tail receiver \"receiver\" is missing `bind` config")},
+		{core.Params{"bind": "/tmp/[", "framing": "lf"}, fmt.Errorf("tail receiver \"receiver\" got a malformed glob \"/tmp/[\": syntax error in pattern")},
+		{core.Params{"bind": "/tmp/*.log", "framing": "cr"}, fmt.Errorf("tail receiver \"receiver\" got an unknown `framing`: \"cr\"")},
+		{core.Params{"bind": "/tmp/*.log", "read_from": "middle"}, fmt.Errorf("tail receiver \"receiver\" got an unknown `read_from`: \"middle\"")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewReceiverTail("receiver", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+		}
+	}
+}
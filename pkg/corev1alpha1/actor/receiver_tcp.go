@@ -3,6 +3,7 @@ package actor
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"sync"
@@ -52,6 +53,10 @@ type ReceiverTCP struct {
 	done     chan struct{}
 	wgconn   sync.WaitGroup
 	wgpeer   sync.WaitGroup
+	// connhandler serves an accepted connection, handleConn by default.
+	connhandler func(net.Conn)
+	// tlsconf enables TLS on the listener if set.
+	tlsconf *tls.Config
 }
 
 var _ core.Actor = (*ReceiverTCP)(nil)
@@ -81,7 +86,7 @@ func NewReceiverTCP(name string, ctx *core.Context, params core.Params) (core.Ac
 		bufsize = DefaultBufSize
 	}
 
-	return &ReceiverTCP{
+	r := &ReceiverTCP{
 		ctx:     ctx,
 		name:    name,
 		addr:    addr,
@@ -89,7 +94,10 @@ func NewReceiverTCP(name string, ctx *core.Context, params core.Params) (core.Ac
 		bufsize: bufsize.(int),
 		queue:   make(chan *core.Message),
 		done:    make(chan struct{}),
-	}, nil
+	}
+	r.connhandler = r.handleConn
+
+	return r, nil
 }
 
 func (r *ReceiverTCP) Name() string {
@@ -101,6 +109,9 @@ func (r *ReceiverTCP) Start() error {
 	if err != nil {
 		return err
 	}
+	if r.tlsconf != nil {
+		l = tls.NewListener(l, r.tlsconf)
+	}
 	r.listener = l
 	nthreads, ok := r.ctx.Config().Get(types.NewKey(cfg.SystemMaxprocs))
 	if !ok {
@@ -121,15 +132,15 @@ func (r *ReceiverTCP) Start() error {
 			for {
 				conn, err := l.Accept()
 				if err != nil {
+					select {
+					case <-r.done:
+						return
+					default:
+					}
 					r.ctx.Logger().Error(err.Error())
 					continue
 				}
-				go r.handleConn(conn)
-				select {
-				case <-r.done:
-					break
-				default:
-				}
+				go r.connhandler(conn)
 			}
 		}()
 	}
@@ -153,7 +164,7 @@ func (r *ReceiverTCP) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range r.queue {
 				if err = peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					r.ctx.Logger().Error(err.Error())
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			r.wgpeer.Done()
@@ -191,7 +202,10 @@ func ScanBin(data []byte, atEOF bool) (advance int, token []byte, err error) {
 }
 
 func (r *ReceiverTCP) handleConn(conn net.Conn) {
-	r.ctx.Logger().Debug("new tcp connection from %s", conn.RemoteAddr())
+	logger := r.ctx.Logger().With(core.LogFields{
+		"remote": conn.RemoteAddr().String(),
+	})
+	logger.Debug("new tcp connection")
 
 	r.wgconn.Add(1)
 
@@ -231,15 +245,15 @@ func (r *ReceiverTCP) handleConn(conn net.Conn) {
 		reply := MsgStatusToTcpResp[status]
 		conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
 		if _, err := conn.Write(reply); err != nil {
-			r.ctx.Logger().Error(err.Error())
+			logger.With(msg.LogFields()).Error("failed to send the reply: %s", err)
 		}
 	}
 	close(scanover)
 	if err := scanner.Err(); err != nil {
-		r.ctx.Logger().Error(err.Error())
+		logger.Error("failed to read the connection: %s", err)
 	}
 
 	r.wgconn.Done()
 
-	r.ctx.Logger().Debug("closing tcp connnection from %s", conn.RemoteAddr())
+	logger.Debug("closing tcp connection")
 }
@@ -19,6 +19,10 @@ type ReceiverUDP struct {
 	done   chan struct{}
 	wgconn sync.WaitGroup
 	wgpeer sync.WaitGroup
+	// connhandler reads the packets off the listening connection,
+	// handleConn by default. Every reader thread calls it in a loop until
+	// the receiver is stopped.
+	connhandler func(net.Conn)
 }
 
 var _ core.Actor = (*ReceiverUDP)(nil)
@@ -33,13 +37,16 @@ func NewReceiverUDP(name string, ctx *core.Context, params core.Params) (core.Ac
 		return nil, err
 	}
 
-	return &ReceiverUDP{
+	r := &ReceiverUDP{
 		name:  name,
 		ctx:   ctx,
 		addr:  addr,
 		queue: make(chan *core.Message),
 		done:  make(chan struct{}),
-	}, nil
+	}
+	r.connhandler = r.handleConn
+
+	return r, nil
 }
 
 func (r *ReceiverUDP) Name() string {
@@ -52,11 +59,19 @@ func (r *ReceiverUDP) handleConn(conn net.Conn) {
 
 	for scanner.Scan() {
 		msg := core.NewMessage(scanner.Bytes())
-		r.queue <- msg
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		r.ctx.Logger().Error(err.Error())
+		select {
+		case <-r.done:
+		default:
+			r.ctx.Logger().Error(err.Error())
+		}
 	}
 }
 
@@ -68,12 +83,6 @@ func (r *ReceiverUDP) Start() error {
 	}
 	r.conn = conn
 
-	isdone := false
-	go func() {
-		<-r.done
-		isdone = true
-	}()
-
 	nthreads, ok := r.ctx.Config().Get(types.NewKey("system.maxprocs"))
 	if !ok {
 		nthreads = 1
@@ -82,12 +91,15 @@ func (r *ReceiverUDP) Start() error {
 	for i := 0; i < nthreads.(int); i++ {
 		r.wgconn.Add(1)
 		go func() {
-			for !isdone {
-				r.handleConn(conn)
+			defer r.wgconn.Done()
+			for {
+				select {
+				case <-r.done:
+					return
+				default:
+				}
+				r.connhandler(conn)
 			}
-
-			r.conn.Close()
-			r.wgconn.Done()
 		}()
 	}
 
@@ -96,6 +108,9 @@ func (r *ReceiverUDP) Start() error {
 
 func (r *ReceiverUDP) Stop() error {
 	close(r.done)
+	if r.conn != nil {
+		r.conn.Close()
+	}
 	r.wgconn.Wait()
 	close(r.queue)
 	r.wgpeer.Wait()
@@ -109,7 +124,8 @@ func (r *ReceiverUDP) Connect(nthreads int, peer core.Receiver) error {
 		go func() {
 			for msg := range r.queue {
 				if err := peer.Receive(msg); err != nil {
-					r.ctx.Logger().Error(err.Error())
+					msg.Complete(core.MsgStatusFailed)
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			r.wgpeer.Done()
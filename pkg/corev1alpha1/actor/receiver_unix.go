@@ -101,7 +101,7 @@ func (u *ReceiverUnix) Connect(nthreads int, peer core.Receiver) error {
 		go func() {
 			for msg := range u.queue {
 				if err := peer.Receive(msg); err != nil {
-					u.ctx.Logger().Error(err.Error())
+					u.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 		}()
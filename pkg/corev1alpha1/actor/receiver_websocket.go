@@ -0,0 +1,123 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaWSPath = "ws.path"
+)
+
+// NewReceiverWebSocket builds a TCP receiver accepting WebSocket
+// connections on `host:port[/path]`. Every text or binary message becomes
+// a message with the request path stored as ws.path. Unless the receiver
+// is `silent`, every message is replied with a text frame carrying the
+// message status, just like the TCP receiver does (see MsgStatusToTcpResp,
+// the trailing CRLF is dropped). Messages larger than `max_message_size`
+// close the connection.
+func NewReceiverWebSocket(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	bind, ok := params["bind"]
+	if !ok {
+		return nil, fmt.Errorf("websocket receiver %q is missing `bind` config", name)
+	}
+	addr, path := splitWSBind(bind.(string))
+	tcpparams := make(core.Params, len(params))
+	for k, v := range params {
+		tcpparams[k] = v
+	}
+	tcpparams["bind"] = addr
+	rcv, err := NewReceiverTCP(name, ctx, tcpparams)
+	if err != nil {
+		return nil, err
+	}
+	maxsize := DefaultWSMaxMessageSize
+	if v, ok := params["max_message_size"]; ok {
+		if maxsize = v.(int); maxsize <= 0 {
+			return nil, fmt.Errorf("websocket receiver %q `max_message_size` should be a positive integer, got: %d", name, maxsize)
+		}
+	}
+	r := rcv.(*ReceiverTCP)
+	r.connhandler = func(conn net.Conn) {
+		r.handleConnWebSocket(conn, path, maxsize)
+	}
+	return r, nil
+}
+
+func (r *ReceiverTCP) handleConnWebSocket(conn net.Conn, path string, maxsize int) {
+	r.ctx.Logger().Debug("new websocket connection from %s", conn.RemoteAddr())
+
+	r.wgconn.Add(1)
+	defer r.wgconn.Done()
+
+	connover := make(chan struct{})
+	defer close(connover)
+	go func() {
+		select {
+		case <-r.done:
+			conn.Close()
+		case <-connover:
+		}
+	}()
+	defer func() {
+		conn.Close()
+		r.ctx.Logger().Debug("closing websocket connection from %s", conn.RemoteAddr())
+	}()
+
+	reader := bufio.NewReaderSize(conn, r.bufsize)
+	req, err := wsHandshake(conn, reader, path)
+	if err != nil {
+		r.ctx.Logger().Debug(err.Error())
+		return
+	}
+	var lock sync.Mutex
+	write := func(data []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+		_, err := conn.Write(data)
+		return err
+	}
+	for {
+		_, payload, err := readWSMessage(reader, maxsize, write)
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-r.done:
+				default:
+					r.ctx.Logger().Debug("websocket connection from %s failed: %s", conn.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+		msg := core.NewMessage(payload)
+		msg.SetMeta(MetaWSPath, req.URL.Path)
+		select {
+		case r.queue <- msg:
+		case <-r.done:
+			return
+		}
+		if r.silent {
+			continue
+		}
+
+		var status core.MsgStatus
+		select {
+		case status = <-msg.AwaitChan():
+		case <-time.After(MsgSendTimeout):
+			status = core.MsgStatusTimedOut
+		}
+		reply := strings.TrimSpace(string(MsgStatusToTcpResp[status]))
+		if err := write(encodeWSFrame(wsOpText, []byte(reply))); err != nil {
+			r.ctx.Logger().Error(err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestReceiverWebSocket(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	rcv, err := NewReceiverWebSocket("receiver", ctx, core.Params{"bind": "127.0.0.1:0/events"})
+	if err != nil {
+		t.Fatalf("failed to create receiver: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan *core.Message, 16)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- msg
+		status := core.MsgStatusDone
+		if string(msg.Body()) == "throttle-me" {
+			status = core.MsgStatusThrottled
+		}
+		msg.Complete(status)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := rcv.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect receiver: %s", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("failed to start receiver: %s", err)
+	}
+	defer rcv.Stop()
+	addr := rcv.(*ReceiverTCP).listener.Addr().String()
+
+	conn, reader := dialWS(t, addr, "/events?client=web")
+	defer conn.Close()
+	tests := []struct {
+		frames    [][]byte
+		wantbody  string
+		wantreply string
+	}{
+		{[][]byte{wsClientFrame(true, wsOpText, []byte("hello"))}, "hello", "OK"},
+		{[][]byte{wsClientFrame(true, wsOpBinary, []byte{0xff, 0x00})}, "\xff\x00", "OK"},
+		{
+			[][]byte{wsClientFrame(false, wsOpText, []byte("throttle")), wsClientFrame(true, wsOpContinuation, []byte("-me"))},
+			"throttle-me",
+			"THROTTLED",
+		},
+	}
+	for _, testCase := range tests {
+		for _, frame := range testCase.frames {
+			conn.Write(frame)
+		}
+		msg := <-mailbox
+		if string(msg.Body()) != testCase.wantbody {
+			t.Fatalf("unexpected message body: got: %q, want: %q", msg.Body(), testCase.wantbody)
+		}
+		if path, _ := msg.Meta(MetaWSPath); path != "/events" {
+			t.Fatalf("unexpected path meta: %v", path)
+		}
+		if opcode, reply := readWSServerFrame(t, reader); opcode != wsOpText || string(reply) != testCase.wantreply {
+			t.Fatalf("unexpected reply: got: %d %q, want: %q", opcode, reply, testCase.wantreply)
+		}
+	}
+
+	conn.Write(wsClientFrame(true, wsOpPing, []byte("hb")))
+	if opcode, payload := readWSServerFrame(t, reader); opcode != wsOpPong || string(payload) != "hb" {
+		t.Fatalf("unexpected ping reply: %d %q", opcode, payload)
+	}
+	conn.Write(wsClientFrame(true, wsOpClose, []byte{0x03, 0xe8}))
+	if opcode, _ := readWSServerFrame(t, reader); opcode != wsOpClose {
+		t.Fatalf("unexpected close reply opcode: %d", opcode)
+	}
+
+	// Plain HTTP requests and unknown paths are rejected
+	for target, wantcode := range map[string]int{"/events": http.StatusUpgradeRequired, "/other": http.StatusNotFound} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		upgrade := ""
+		if target == "/other" {
+			upgrade = "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: a2V5\r\nSec-WebSocket-Version: 13\r\n"
+		}
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: flowd\r\n%s\r\n", target, upgrade)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %s", err)
+		}
+		if resp.StatusCode != wantcode {
+			t.Fatalf("unexpected status code for %s: got: %d, want: %d", target, resp.StatusCode, wantcode)
+		}
+		conn.Close()
+	}
+}
+
+func TestNewReceiverWebSocketMalformed(t *testing.T) {
+	_, err := NewReceiverWebSocket("receiver", nil, core.Params{"bind": "127.0.0.1:0", "max_message_size": -1})
+	if want := fmt.Errorf("websocket receiver \"receiver\" `max_message_size` should be a positive integer, got: -1"); !eqErr(err, want) {
+		t.Fatalf("unexpected error: got: %v, want: %s", err, want)
+	}
+}
@@ -194,7 +194,7 @@ func (r *Replicator) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range q {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					r.ctx.Logger().Error(err.Error())
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			r.wg.Done()
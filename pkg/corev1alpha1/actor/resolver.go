@@ -0,0 +1,97 @@
+package actor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolver is a minimalistic DNS resolver interface used by sink heads
+// with dynamic endpoint sets.
+type Resolver interface {
+	LookupHost(host string) ([]string, error)
+	LookupSRV(name string) ([]*net.SRV, error)
+}
+
+type netResolver struct{}
+
+var _ Resolver = (*netResolver)(nil)
+
+func (*netResolver) LookupHost(host string) ([]string, error) {
+	return net.LookupHost(host)
+}
+
+func (*netResolver) LookupSRV(name string) ([]*net.SRV, error) {
+	_, srvs, err := net.LookupSRV("", "", name)
+	return srvs, err
+}
+
+var DefaultResolver Resolver = &netResolver{}
+
+// ResolveBind expands a bind address into a sorted list of addresses with
+// IP literal hosts. The following formats are supported:
+// * srv://_service._proto.name: every SRV target is resolved and combined
+//   with the record port, the transport is derived from the proto label.
+// * tcp://host:port and udp://host:port: the host is resolved into a list
+//   of IP addresses.
+// Any other address is returned as is.
+func ResolveBind(resolver Resolver, bind string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(bind, "srv://"):
+		return resolveSRV(resolver, bind[6:])
+	case strings.HasPrefix(bind, "tcp://"), strings.HasPrefix(bind, "udp://"):
+		host, port, err := net.SplitHostPort(bind[6:])
+		if err != nil {
+			return nil, err
+		}
+		return resolveHost(resolver, bind[:6], host, port)
+	}
+	return []string{bind}, nil
+}
+
+func resolveSRV(resolver Resolver, name string) ([]string, error) {
+	scheme := "tcp://"
+	if labels := strings.Split(name, "."); len(labels) > 1 && labels[1] == "_udp" {
+		scheme = "udp://"
+	}
+	srvs, err := resolver.LookupSRV(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("no SRV records found for %q", name)
+	}
+	// Priorities and weights are not taken into account: all targets are
+	// considered equal and balanced by the sink head.
+	res := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		addrs, err := resolveHost(resolver, scheme, strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, addrs...)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func resolveHost(resolver Resolver, scheme, host, port string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil || len(host) == 0 {
+		return []string{scheme + net.JoinHostPort(host, port)}, nil
+	}
+	ips, err := resolver.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %q", host)
+	}
+	res := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		res = append(res, scheme+net.JoinHostPort(ip, port))
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -6,9 +6,14 @@ import (
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
 )
 
+const DefaultRoutingKey = "sendto"
+
+// Router sends a message to the peer named by the value of the message
+// meta attribute `routing_key` (sendto by default).
 type Router struct {
 	name  string
 	ctx   *core.Context
+	rtkey string
 	rtmap map[string]chan *core.Message
 	lock  sync.Mutex
 	wg    sync.WaitGroup
@@ -17,9 +22,14 @@ type Router struct {
 var _ core.Actor = (*Router)(nil)
 
 func NewRouter(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	rtkey := DefaultRoutingKey
+	if v, ok := params["routing_key"]; ok {
+		rtkey = v.(string)
+	}
 	return &Router{
 		name:  name,
 		ctx:   ctx,
+		rtkey: rtkey,
 		rtmap: make(map[string]chan *core.Message),
 		lock:  sync.Mutex{},
 	}, nil
@@ -55,7 +65,7 @@ func (r *Router) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range queue {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					r.ctx.Logger().Error(err.Error())
+					r.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			r.wg.Done()
@@ -65,7 +75,7 @@ func (r *Router) Connect(nthreads int, peer core.Receiver) error {
 }
 
 func (r *Router) Receive(msg *core.Message) error {
-	if rtkey, ok := msg.Meta("sendto"); ok {
+	if rtkey, ok := msg.Meta(r.rtkey); ok {
 		if queue, ok := r.rtmap[rtkey.(string)]; ok {
 			queue <- msg
 			return nil
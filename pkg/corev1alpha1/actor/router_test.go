@@ -0,0 +1,59 @@
+package actor
+
+import (
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestRouterRoutingKey(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	router, err := NewRouter("router", ctx, core.Params{"routing_key": MetaSyslogFacility})
+	if err != nil {
+		t.Fatalf("failed to create router: %s", err)
+	}
+	peer, err := flowtest.NewTestActor("auth", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		msg.Complete(core.MsgStatusDone)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := router.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect router: %s", err)
+	}
+	defer router.Stop()
+
+	tests := []struct {
+		meta    map[string]string
+		wantsts core.MsgStatus
+	}{
+		{map[string]string{MetaSyslogFacility: "auth"}, core.MsgStatusDone},
+		{map[string]string{MetaSyslogFacility: "kern"}, core.MsgStatusUnroutable},
+		{map[string]string{DefaultRoutingKey: "auth"}, core.MsgStatusUnroutable},
+	}
+	for _, testCase := range tests {
+		msg := core.NewMessage([]byte("body"))
+		for k, v := range testCase.meta {
+			msg.SetMeta(k, v)
+		}
+		if err := router.Receive(msg); err != nil {
+			t.Fatalf("failed to route message: %s", err)
+		}
+		select {
+		case sts := <-msg.AwaitChan():
+			if sts != testCase.wantsts {
+				t.Fatalf("unexpected status for %v: got: %s, want: %s", testCase.meta, sts2name(sts), sts2name(testCase.wantsts))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %v", testCase.meta)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package actor
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/awesome-flow/flow/pkg/cfg"
@@ -13,19 +14,33 @@ const (
 	minbackoff = 50 * time.Millisecond
 	maxbackoff = 5 * time.Second
 	maxretries = 0
+
+	DefaultSinkPauseStatus = core.MsgStatusThrottled
 )
 
+// sinkPauseStatuses are the statuses a paused sink might complete the
+// messages with.
+var sinkPauseStatuses = map[string]core.MsgStatus{
+	"failed":     core.MsgStatusFailed,
+	"timedout":   core.MsgStatusTimedOut,
+	"unroutable": core.MsgStatusUnroutable,
+	"throttled":  core.MsgStatusThrottled,
+}
+
 type SinkCfg struct {
-	minbackoff time.Duration
-	maxbackoff time.Duration
-	maxretries int
+	minbackoff  time.Duration
+	maxbackoff  time.Duration
+	maxretries  int
+	pausequeue  int
+	pausestatus core.MsgStatus
 }
 
 func NewSinkCfg(params core.Params) (*SinkCfg, error) {
 	cfg := &SinkCfg{
-		minbackoff: minbackoff,
-		maxbackoff: maxbackoff,
-		maxretries: maxretries,
+		minbackoff:  minbackoff,
+		maxbackoff:  maxbackoff,
+		maxretries:  maxretries,
+		pausestatus: DefaultSinkPauseStatus,
 	}
 	if v, ok := params["max_retries"]; ok {
 		cfg.maxretries = v.(int)
@@ -36,10 +51,38 @@ func NewSinkCfg(params core.Params) (*SinkCfg, error) {
 	if v, ok := params["max_backoff"]; ok {
 		cfg.maxbackoff = time.Duration(v.(int)) * time.Millisecond
 	}
+	if v, ok := params["pause_queue"]; ok {
+		cfg.pausequeue = v.(int)
+		if cfg.pausequeue < 0 {
+			return nil, fmt.Errorf("`pause_queue` should be a non-negative integer, got: %d", cfg.pausequeue)
+		}
+	}
+	if v, ok := params["pause_status"]; ok {
+		status, ok := sinkPauseStatuses[fmt.Sprintf("%v", v)]
+		if !ok {
+			return nil, fmt.Errorf("unknown `pause_status`: %v", v)
+		}
+		cfg.pausestatus = status
+	}
 
 	return cfg, nil
 }
 
+// MsgStatusError is an error a sink head might return on write to complete
+// the message with a status other than MsgStatusFailed.
+type MsgStatusError struct {
+	Status core.MsgStatus
+	Err    error
+}
+
+func (e *MsgStatusError) Error() string {
+	return e.Err.Error()
+}
+
+// Sink writes the messages to the head. A paused sink holds up to
+// `pause_queue` inbound messages and completes the rest with `pause_status`
+// (throttled by default), the held messages are written once the sink is
+// resumed.
 type Sink struct {
 	name      string
 	ctx       *core.Context
@@ -48,9 +91,16 @@ type Sink struct {
 	queue     chan *core.Message
 	reconnect chan chan struct{}
 	done      chan struct{}
+	lock      sync.Mutex
+	idle      *sync.Cond
+	paused    bool
+	held      []*core.Message
+	inflight  int
 }
 
 var _ core.Actor = (*Sink)(nil)
+var _ core.Pauser = (*Sink)(nil)
+var _ core.QueueReporter = (*Sink)(nil)
 
 func NewSink(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
 	cfg, err := NewSinkCfg(params)
@@ -62,7 +112,7 @@ func NewSink(name string, ctx *core.Context, params core.Params) (core.Actor, er
 		return nil, fmt.Errorf("failed to initialize sink %q: %s", name, err)
 	}
 
-	return &Sink{
+	s := &Sink{
 		name:      name,
 		ctx:       ctx,
 		cfg:       cfg,
@@ -70,7 +120,10 @@ func NewSink(name string, ctx *core.Context, params core.Params) (core.Actor, er
 		queue:     make(chan *core.Message),
 		reconnect: make(chan chan struct{}),
 		done:      make(chan struct{}),
-	}, nil
+	}
+	s.idle = sync.NewCond(&s.lock)
+
+	return s, nil
 }
 
 func (s *Sink) Name() string {
@@ -133,18 +186,31 @@ func (s *Sink) Start() error {
 		}
 	}()
 
+	write := func(msg *core.Message) (int, error, bool) {
+		return s.head.Write(msg.Body())
+	}
+	if mh, ok := s.head.(MsgSinkHead); ok {
+		write = mh.WriteMsg
+	}
+
 	for i := 0; i < nthreads.(int); i++ {
 		go func() {
 			for msg := range s.queue {
-				if _, err, rec := s.head.Write(msg.Body()); err != nil {
-					s.ctx.Logger().Error("sink %q failed to send message: %s", s.name, err)
-					msg.Complete(core.MsgStatusFailed)
+				if _, err, rec := write(msg); err != nil {
+					s.ctx.Logger().With(msg.LogFields()).Error("sink %q failed to send message: %s", s.name, err)
+					status := core.MsgStatusFailed
+					if serr, ok := err.(*MsgStatusError); ok {
+						status = serr.Status
+					}
+					msg.Complete(status)
+					s.release()
 					if rec {
 						reqreconn()
 					}
 					continue
 				}
 				msg.Complete(core.MsgStatusDone)
+				s.release()
 			}
 		}()
 	}
@@ -159,6 +225,13 @@ func (s *Sink) Start() error {
 }
 
 func (s *Sink) Stop() error {
+	s.lock.Lock()
+	held := s.held
+	s.held = nil
+	s.lock.Unlock()
+	for _, msg := range held {
+		msg.Complete(s.cfg.pausestatus)
+	}
 	if err := s.head.Stop(); err != nil {
 		return err
 	}
@@ -174,6 +247,89 @@ func (s *Sink) Connect(int, core.Receiver) error {
 }
 
 func (s *Sink) Receive(msg *core.Message) error {
+	s.lock.Lock()
+	if s.paused {
+		if len(s.held) < s.cfg.pausequeue {
+			s.held = append(s.held, msg)
+			s.lock.Unlock()
+			return nil
+		}
+		s.lock.Unlock()
+		msg.Complete(s.cfg.pausestatus)
+		return nil
+	}
+	s.inflight++
+	s.lock.Unlock()
 	s.queue <- msg
 	return nil
 }
+
+// release marks a message written by the head as completed.
+func (s *Sink) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inflight--
+	if s.inflight == 0 {
+		s.idle.Broadcast()
+	}
+}
+
+func (s *Sink) Pause() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = true
+	return nil
+}
+
+// Resume writes the messages held while the sink was paused.
+func (s *Sink) Resume() error {
+	s.lock.Lock()
+	if !s.paused {
+		s.lock.Unlock()
+		return nil
+	}
+	s.paused = false
+	held := s.held
+	s.held = nil
+	s.inflight += len(held)
+	s.lock.Unlock()
+	for _, msg := range held {
+		s.queue <- msg
+	}
+	return nil
+}
+
+// Drain pauses the sink and waits for the in-flight writes to complete: once
+// it returns with no error, the head is not used until the sink is resumed.
+func (s *Sink) Drain(timeout time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = true
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		s.lock.Lock()
+		expired = true
+		s.idle.Broadcast()
+		s.lock.Unlock()
+	})
+	defer timer.Stop()
+	for s.inflight > 0 {
+		if expired {
+			return core.DrainTimedOutErr
+		}
+		s.idle.Wait()
+	}
+	return nil
+}
+
+// QueueLen returns the number of messages held while the sink is paused.
+func (s *Sink) QueueLen() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.held)
+}
+
+// QueueCap returns the number of messages a paused sink might hold.
+func (s *Sink) QueueCap() int {
+	return s.cfg.pausequeue
+}
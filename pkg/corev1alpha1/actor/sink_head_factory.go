@@ -14,12 +14,62 @@ type SinkHead interface {
 	Connect() error
 }
 
+// MsgSinkHead is an optional interface a SinkHead might implement if it needs
+// access to the entire message (e.g. meta attributes) on write. Sink prefers
+// WriteMsg over Write if the head implements it.
+type MsgSinkHead interface {
+	WriteMsg(*core.Message) (int, error, bool)
+}
+
+// SinkHeadBuilder builds a singular sink head from a bind address.
+type SinkHeadBuilder func(bind string) (SinkHead, error)
+
 func SinkHeadFactory(params core.Params) (SinkHead, error) {
+	if b, ok := params["bind"].(string); ok && strings.HasPrefix(b, "graphite://") {
+		// Graphite relays are balanced by the metric path
+		return NewSinkHeadGraphite(b[11:], params)
+	}
+	if _, ok := params["endpoints"]; ok {
+		return NewSinkHeadPool(params, DefaultSinkHeadBuilder)
+	}
 	b, ok := params["bind"]
 	if !ok {
 		return nil, fmt.Errorf("missing `bind` config")
 	}
 	bind := b.(string)
+	if strings.HasPrefix(bind, "file://") {
+		return NewSinkHeadFileWithParams(bind[7:], params)
+	}
+	if strings.HasPrefix(bind, "syslog://") {
+		return NewSinkHeadSyslog(bind[9:], params)
+	}
+	if strings.HasPrefix(bind, "redis://") {
+		return NewSinkHeadRedis(bind[8:], params)
+	}
+	if strings.HasPrefix(bind, "kafka://") {
+		return NewSinkHeadKafka(bind[8:], params)
+	}
+	if strings.HasPrefix(bind, "nats://") {
+		return NewSinkHeadNATS(bind[7:], params)
+	}
+	if strings.HasPrefix(bind, "ws://") {
+		return NewSinkHeadWebSocket(bind[5:], params)
+	}
+	_, resolve := params["resolve"]
+	_, interval := params["resolve_interval"]
+	if strings.HasPrefix(bind, "srv://") || resolve || interval {
+		// A single bind address might resolve into multiple endpoints.
+		poolparams := make(core.Params, len(params))
+		for k, v := range params {
+			poolparams[k] = v
+		}
+		poolparams["endpoints"] = []string{bind}
+		return NewSinkHeadPool(poolparams, DefaultSinkHeadBuilder)
+	}
+	return DefaultSinkHeadBuilder(bind)
+}
+
+var DefaultSinkHeadBuilder = func(bind string) (SinkHead, error) {
 	if strings.HasPrefix(bind, "tcp://") {
 		tcpaddr, err := net.ResolveTCPAddr("tcp", bind[6:])
 		if err != nil {
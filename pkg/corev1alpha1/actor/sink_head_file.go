@@ -1,9 +1,32 @@
 package actor
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/zstd"
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	FsyncNever    = "never"
+	FsyncInterval = "interval"
+	FsyncEvery    = "every"
+
+	DefaultFsyncInterval = time.Second
+	// FileRotateCheckInterval is the longest period between the time-based
+	// rotation checks of an idle file.
+	FileRotateCheckInterval = time.Second
 )
 
 type FileOpener func(string) (io.WriteCloser, error)
@@ -18,9 +41,41 @@ var DefaultFileOpener = func(path string) (io.WriteCloser, error) {
 	return os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 }
 
+var FileCompressors = map[string]struct {
+	ext     string
+	factory func(io.Writer) io.WriteCloser
+}{
+	"gzip": {".gz", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	"zstd": {".zst", func(w io.Writer) io.WriteCloser { return zstd.NewWriter(w) }},
+}
+
+// SinkHeadFile writes messages to a file. The file path might be an
+// strftime-style template (see strftime for the supported verbs): the file is
+// rotated every time the rendered path changes. Apart from that, the file is
+// rotated once it exceeds `rotate_size` bytes or every `rotate_interval`
+// milliseconds. The time-based rotation does not wait for a write: an idle
+// non-empty file is rotated within FileRotateCheckInterval. Rotated files
+// are optionally compressed and the number of them is capped by `retain`.
+// The file is re-opened on SIGHUP, which makes the head compatible with
+// external tools like logrotate.
 type SinkHeadFile struct {
-	path string
-	out  io.WriteCloser
+	path     string
+	curpath  string
+	out      io.WriteCloser
+	size     int64
+	opened   time.Time
+	lock     sync.Mutex
+	rotsize  int64
+	rotintvl time.Duration
+	compress string
+	retain   int
+	fsync    string
+	fsintvl  time.Duration
+	timefun  func() time.Time
+	hup      chan os.Signal
+	done     chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
 
 	Opener FileOpener
 }
@@ -29,38 +84,302 @@ var _ (SinkHead) = (*SinkHeadFile)(nil)
 
 func NewSinkHeadFile(path string) (*SinkHeadFile, error) {
 	return &SinkHeadFile{
-		path:   path,
-		Opener: DefaultFileOpener,
+		path:    path,
+		fsync:   FsyncNever,
+		fsintvl: DefaultFsyncInterval,
+		timefun: time.Now,
+		done:    make(chan struct{}),
+		Opener:  DefaultFileOpener,
 	}, nil
 }
 
+func NewSinkHeadFileWithParams(path string, params core.Params) (*SinkHeadFile, error) {
+	h, err := NewSinkHeadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := params["rotate_size"]; ok {
+		h.rotsize = int64(v.(int))
+	}
+	if v, ok := params["rotate_interval"]; ok {
+		h.rotintvl = time.Duration(v.(int)) * time.Millisecond
+	}
+	if v, ok := params["compress"]; ok {
+		if _, ok := FileCompressors[v.(string)]; !ok {
+			return nil, fmt.Errorf("file sink head: unknown compression algorithm %q", v)
+		}
+		h.compress = v.(string)
+	}
+	if v, ok := params["retain"]; ok {
+		h.retain = v.(int)
+	}
+	if v, ok := params["fsync"]; ok {
+		switch v.(string) {
+		case FsyncNever, FsyncInterval, FsyncEvery:
+			h.fsync = v.(string)
+		default:
+			return nil, fmt.Errorf("file sink head: unknown fsync policy %q", v)
+		}
+	}
+	if v, ok := params["fsync_interval"]; ok {
+		h.fsintvl = time.Duration(v.(int)) * time.Millisecond
+	}
+
+	return h, nil
+}
+
+func (h *SinkHeadFile) isStd() bool {
+	return h.path == "STDOUT" || h.path == "STDERR"
+}
+
 func (h *SinkHeadFile) Connect() error {
-	out, err := h.Opener(h.path)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.open()
+}
+
+func (h *SinkHeadFile) open() error {
+	path := h.path
+	if !h.isStd() {
+		path = strftime(h.path, h.timefun())
+	}
+	out, err := h.Opener(path)
 	if err != nil {
 		return err
 	}
+	// The previous file is still open on a reconnect. It's replaced anyway,
+	// a close failure must not prevent the head from recovering.
+	h.close()
 	h.out = out
+	h.curpath = path
+	h.opened = h.timefun()
+	h.size = 0
+	if st, ok := out.(interface{ Stat() (os.FileInfo, error) }); ok && !h.isStd() {
+		if fi, err := st.Stat(); err == nil {
+			h.size = fi.Size()
+		}
+	}
 
 	return nil
 }
 
+func (h *SinkHeadFile) close() error {
+	if h.out == nil || h.out == os.Stdout || h.out == os.Stderr {
+		return nil
+	}
+	err := h.out.Close()
+	h.out = nil
+	return err
+}
+
+// Reopen closes the current file and opens it again. This might be useful
+// if the file was moved by an external tool.
+func (h *SinkHeadFile) Reopen() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err := h.close(); err != nil {
+		return err
+	}
+	return h.open()
+}
+
 func (h *SinkHeadFile) Stop() error {
+	// No archive routines are spawned past this point: wg.Wait below would
+	// race with wg.Add in rotate otherwise.
+	h.lock.Lock()
+	h.stopped = true
+	h.lock.Unlock()
+
+	if h.hup != nil {
+		signal.Stop(h.hup)
+	}
+	close(h.done)
+	h.wg.Wait()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
 	if h.out != nil {
-		if h.out != os.Stdin && h.out != os.Stdout {
-			return h.out.Close()
+		h.sync()
+	}
+	return h.close()
+}
+
+func (h *SinkHeadFile) Start() error {
+	if h.isStd() {
+		return nil
+	}
+	h.hup = make(chan os.Signal, 1)
+	signal.Notify(h.hup, syscall.SIGHUP)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		var tick <-chan time.Time
+		if h.fsync == FsyncInterval {
+			ticker := time.NewTicker(h.fsintvl)
+			defer ticker.Stop()
+			tick = ticker.C
 		}
+		var rottick <-chan time.Time
+		if h.rotintvl > 0 || strings.Contains(h.path, "%") {
+			intvl := FileRotateCheckInterval
+			if h.rotintvl > 0 && h.rotintvl < intvl {
+				intvl = h.rotintvl
+			}
+			ticker := time.NewTicker(intvl)
+			defer ticker.Stop()
+			rottick = ticker.C
+		}
+		for {
+			select {
+			case <-h.hup:
+				h.Reopen()
+			case <-tick:
+				h.lock.Lock()
+				h.sync()
+				h.lock.Unlock()
+			case <-rottick:
+				h.lock.Lock()
+				// An empty file is left as is, Write rotates it if needed
+				if now := h.timefun(); h.out != nil && h.size > 0 && h.shouldRotate(now) {
+					h.rotate(now)
+				}
+				h.lock.Unlock()
+			case <-h.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (h *SinkHeadFile) sync() error {
+	if s, ok := h.out.(interface{ Sync() error }); ok && !h.isStd() {
+		return s.Sync()
 	}
 	return nil
 }
 
-func (h *SinkHeadFile) Start() error {
+func (h *SinkHeadFile) shouldRotate(now time.Time) bool {
+	if h.isStd() {
+		return false
+	}
+	if h.rotsize > 0 && h.size >= h.rotsize {
+		return true
+	}
+	if h.rotintvl > 0 && now.Sub(h.opened) >= h.rotintvl {
+		return true
+	}
+	return strftime(h.path, now) != h.curpath
+}
+
+// rotate closes the current file and opens a new one. If the path has not
+// changed, the current file is renamed using the rotation timestamp suffix.
+// The rotated file is not archived once the head is stopped.
+func (h *SinkHeadFile) rotate(now time.Time) error {
+	if err := h.close(); err != nil {
+		return err
+	}
+	rotated := h.curpath
+	if strftime(h.path, now) == h.curpath {
+		rotated = h.curpath + "." + now.Format("20060102T150405.000000000")
+		if err := os.Rename(h.curpath, rotated); err != nil {
+			return err
+		}
+	}
+	if !h.stopped {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			h.archive(rotated)
+		}()
+	}
+
+	return h.open()
+}
+
+// archive compresses the rotated file (if configured) and removes the
+// oldest rotated files exceeding the retention count.
+func (h *SinkHeadFile) archive(rotated string) error {
+	if len(h.compress) > 0 {
+		if err := compressFile(rotated, h.compress); err != nil {
+			return err
+		}
+	}
+	if h.retain <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(strftimeGlob(h.path) + "*")
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	curpath := h.curpath
+	h.lock.Unlock()
+	infos := make([]os.FileInfo, 0, len(files))
+	paths := make(map[os.FileInfo]string, len(files))
+	for _, file := range files {
+		if file == curpath {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, fi)
+		paths[fi] = file
+	}
+	if len(infos) <= h.retain {
+		return nil
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, fi := range infos[h.retain:] {
+		os.Remove(paths[fi])
+	}
 	return nil
 }
 
+func compressFile(path string, alg string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + FileCompressors[alg].ext)
+	if err != nil {
+		return err
+	}
+	w := FileCompressors[alg].factory(out)
+	if _, err := io.Copy(w, in); err != nil {
+		w.Close()
+		out.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
 func (h *SinkHeadFile) Write(data []byte) (int, error, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
 	if h.out == nil {
 		return 0, fmt.Errorf("sink head out file is nil"), true
 	}
+	if now := h.timefun(); h.shouldRotate(now) {
+		if err := h.rotate(now); err != nil {
+			return 0, err, true
+		}
+	}
 	payload := make([]byte, len(data)+2)
 	copy(payload, data)
 	copy(payload[len(data):], []byte("\r\n"))
@@ -68,6 +387,72 @@ func (h *SinkHeadFile) Write(data []byte) (int, error, bool) {
 	if err != nil {
 		return 0, err, true
 	}
+	h.size += int64(n)
+	if h.fsync == FsyncEvery {
+		if err := h.sync(); err != nil {
+			return n, err, true
+		}
+	}
 
 	return n, nil, false
 }
+
+// strftime renders a path template. Supported verbs: %Y (year), %m (month),
+// %d (day), %H (hour), %M (minute), %S (second), %s (unix timestamp),
+// %j (day of the year) and %% (a literal percent sign).
+func strftime(tmpl string, t time.Time) string {
+	if !strings.Contains(tmpl, "%") {
+		return tmpl
+	}
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '%' || i == len(tmpl)-1 {
+			b.WriteByte(tmpl[i])
+			continue
+		}
+		i++
+		switch tmpl[i] {
+		case 'Y':
+			b.WriteString(strconv.Itoa(t.Year()))
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(tmpl[i])
+		}
+	}
+	return b.String()
+}
+
+// strftimeGlob converts a path template into a glob pattern matching all
+// the files the template might render into.
+func strftimeGlob(tmpl string) string {
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] == '%' && i < len(tmpl)-1 {
+			i++
+			if tmpl[i] == '%' {
+				b.WriteByte('%')
+			} else {
+				b.WriteByte('*')
+			}
+			continue
+		}
+		b.WriteByte(tmpl[i])
+	}
+	return b.String()
+}
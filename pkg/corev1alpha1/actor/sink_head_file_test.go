@@ -2,10 +2,18 @@ package actor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"syscall"
 	"testing"
+	"time"
 
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
 	testutil "github.com/awesome-flow/flow/pkg/util/test"
 )
 
@@ -71,3 +79,296 @@ func TestSinkHeadFileWrite(t *testing.T) {
 		t.Fatalf("unexpected buf contents: got: %q, want: %q", gotdata, wantdata)
 	}
 }
+
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2019, time.March, 7, 9, 5, 3, 0, time.UTC)
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"/var/log/flow.log", "/var/log/flow.log"},
+		{"/var/log/flow-%Y%m%d.log", "/var/log/flow-20190307.log"},
+		{"/var/log/flow-%Y-%m-%dT%H:%M:%S.log", "/var/log/flow-2019-03-07T09:05:03.log"},
+		{"/var/log/flow-%j-%s.log", "/var/log/flow-066-1551949503.log"},
+		{"/var/log/flow-100%%-%q.log", "/var/log/flow-100%-%q.log"},
+		{"/var/log/flow%", "/var/log/flow%"},
+	}
+	for _, testCase := range tests {
+		if got := strftime(testCase.tmpl, ts); got != testCase.want {
+			t.Fatalf("unexpected strftime result for %q: got: %q, want: %q", testCase.tmpl, got, testCase.want)
+		}
+	}
+	if got, want := strftimeGlob("/var/log/flow-%Y%m%d-100%%.log"), "/var/log/flow-***-100%.log"; got != want {
+		t.Fatalf("unexpected glob: got: %q, want: %q", got, want)
+	}
+}
+
+func TestNewSinkHeadFileWithParamsMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  core.Params
+		wanterr error
+	}{
+		{"compress", core.Params{"compress": "lzma"}, fmt.Errorf("file sink head: unknown compression algorithm \"lzma\"")},
+		{"fsync", core.Params{"fsync": "always"}, fmt.Errorf("file sink head: unknown fsync policy \"always\"")},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := NewSinkHeadFileWithParams("/dev/null", testCase.params)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+			}
+		})
+	}
+}
+
+func newTestFileHead(t *testing.T, path string, params core.Params) *SinkHeadFile {
+	head, err := NewSinkHeadFileWithParams(path, params)
+	if err != nil {
+		t.Fatalf("failed to create file sink head: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start file sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect file sink head: %s", err)
+	}
+	return head
+}
+
+func TestSinkHeadFileRotateSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-file")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flow.log")
+	head := newTestFileHead(t, path, core.Params{
+		"rotate_size": 100,
+		"compress":    "gzip",
+		"retain":      2,
+	})
+	for i := 0; i < 5; i++ {
+		data := testutil.RandBytes(98)
+		if _, err, _ := head.Write(data); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+		// rotated files are sorted by their modification time
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop file sink head: %s", err)
+	}
+
+	rotated, err := filepath.Glob(path + ".*.gz")
+	if err != nil {
+		t.Fatalf("failed to list rotated files: %s", err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("unexpected number of rotated files: got: %d, want: 2", len(rotated))
+	}
+	for _, file := range rotated {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("failed to open rotated file: %s", err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("failed to read rotated file %s: %s", file, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to read rotated file %s: %s", file, err)
+		}
+		if len(data) != 100 {
+			t.Fatalf("unexpected rotated file size: got: %d, want: 100", len(data))
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat the active file: %s", err)
+	}
+	if fi.Size() != 100 {
+		t.Fatalf("unexpected active file size: got: %d, want: 100", fi.Size())
+	}
+}
+
+func TestSinkHeadFileRotateTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-file")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2019, time.March, 7, 9, 5, 3, 0, time.UTC)
+	head, err := NewSinkHeadFileWithParams(filepath.Join(dir, "flow-%Y%m%d%H.log"), core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create file sink head: %s", err)
+	}
+	head.timefun = func() time.Time { return now }
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect file sink head: %s", err)
+	}
+	head.Write([]byte("foo"))
+	now = now.Add(time.Hour)
+	head.Write([]byte("bar"))
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop file sink head: %s", err)
+	}
+
+	for path, want := range map[string]string{
+		"flow-2019030709.log": "foo\r\n",
+		"flow-2019030710.log": "bar\r\n",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("failed to read file %s: %s", path, err)
+		}
+		if string(got) != want {
+			t.Fatalf("unexpected contents of %s: got: %q, want: %q", path, got, want)
+		}
+	}
+}
+
+func TestSinkHeadFileReopenOnHUP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-file")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flow.log")
+	head := newTestFileHead(t, path, core.Params{"fsync": FsyncEvery})
+	head.Write([]byte("foo"))
+	// logrotate-like behavior: move the file and notify the process
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("failed to move file: %s", err)
+	}
+	head.hup <- syscall.SIGHUP
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file %s was not reopened", path)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	head.Write([]byte("bar"))
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop file sink head: %s", err)
+	}
+	if got, _ := ioutil.ReadFile(path + ".1"); string(got) != "foo\r\n" {
+		t.Fatalf("unexpected moved file contents: got: %q, want: %q", got, "foo\r\n")
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != "bar\r\n" {
+		t.Fatalf("unexpected reopened file contents: got: %q, want: %q", got, "bar\r\n")
+	}
+}
+
+type closeTrackingWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (wc *closeTrackingWriteCloser) Close() error {
+	wc.closed = true
+	return nil
+}
+
+func TestSinkHeadFileReconnectClosesFile(t *testing.T) {
+	outs := make([]*closeTrackingWriteCloser, 0, 2)
+	head, err := NewSinkHeadFile("/dev/null")
+	if err != nil {
+		t.Fatalf("failed to create file sink head: %s", err)
+	}
+	head.Opener = func(string) (io.WriteCloser, error) {
+		out := &closeTrackingWriteCloser{}
+		outs = append(outs, out)
+		return out, nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := head.Connect(); err != nil {
+			t.Fatalf("failed to connect file sink head: %s", err)
+		}
+	}
+	if !outs[0].closed {
+		t.Fatalf("the previous file was not closed on reconnect")
+	}
+	if outs[1].closed {
+		t.Fatalf("the current file was closed on reconnect")
+	}
+}
+
+func TestSinkHeadFileRotateIntervalIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-file")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flow.log")
+	head := newTestFileHead(t, path, core.Params{"rotate_interval": 20})
+	if _, err, _ := head.Write([]byte("foo")); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	// No more writes: the file is rotated by the timer
+	deadline := time.Now().Add(time.Second)
+	var rotated []string
+	for len(rotated) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle file %s was not rotated", path)
+		}
+		time.Sleep(5 * time.Millisecond)
+		rotated, _ = filepath.Glob(path + ".*")
+	}
+	// The new file stays empty and is not rotated any further
+	time.Sleep(60 * time.Millisecond)
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop file sink head: %s", err)
+	}
+	if rotated, _ = filepath.Glob(path + ".*"); len(rotated) != 1 {
+		t.Fatalf("unexpected rotated files: got: %v, want exactly 1", rotated)
+	}
+	if got, _ := ioutil.ReadFile(rotated[0]); string(got) != "foo\r\n" {
+		t.Fatalf("unexpected rotated file contents: got: %q, want: %q", got, "foo\r\n")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Fatalf("unexpected active file: %v, %v", fi, err)
+	}
+}
+
+func TestSinkHeadFileRotateStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-file")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flow.log")
+	head := newTestFileHead(t, path, core.Params{
+		"rotate_size": 10,
+		"compress":    "gzip",
+	})
+	// Every write rotates the file, the writer races Stop
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			if _, err, _ := head.Write(testutil.RandBytes(10)); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop file sink head: %s", err)
+	}
+	<-stopped
+	if !head.stopped {
+		t.Fatalf("expected the head to be marked as stopped")
+	}
+}
@@ -0,0 +1,448 @@
+package actor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	GraphiteProtocolPlaintext = "plaintext"
+	GraphiteProtocolPickle    = "pickle"
+
+	DefaultGraphiteBatchSize     = 500
+	DefaultGraphiteFlushInterval = time.Second
+
+	MetaGraphitePath      = "graphite.path"
+	MetaGraphiteValue     = "graphite.value"
+	MetaGraphiteTimestamp = "graphite.timestamp"
+)
+
+// GraphiteMetric is a single Graphite datapoint.
+type GraphiteMetric struct {
+	Path      string
+	Value     float64
+	Timestamp int64
+}
+
+// ParseGraphite parses Graphite plaintext lines: `path value [timestamp]`.
+// The timestamp defaults to now.
+func ParseGraphite(data []byte, now time.Time) ([]GraphiteMetric, error) {
+	res := make([]GraphiteMetric, 0, 1)
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("graphite: malformed line %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("graphite: malformed value in %q", line)
+		}
+		ts := now.Unix()
+		if len(fields) == 3 {
+			// Some clients send fractional timestamps
+			fts, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("graphite: malformed timestamp in %q", line)
+			}
+			ts = int64(fts)
+		}
+		res = append(res, GraphiteMetric{Path: fields[0], Value: value, Timestamp: ts})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("graphite: no metrics found")
+	}
+	return res, nil
+}
+
+// metaGraphite builds a metric from the graphite.* meta attributes.
+func metaGraphite(msg *core.Message, now time.Time) (*GraphiteMetric, bool, error) {
+	path, ok := msg.Meta(MetaGraphitePath)
+	if !ok {
+		return nil, false, nil
+	}
+	v, ok := msg.Meta(MetaGraphiteValue)
+	if !ok {
+		return nil, true, fmt.Errorf("graphite: missing %s meta", MetaGraphiteValue)
+	}
+	m := &GraphiteMetric{Path: fmt.Sprintf("%v", path), Timestamp: now.Unix()}
+	switch vv := v.(type) {
+	case float64:
+		m.Value = vv
+	case int:
+		m.Value = float64(vv)
+	case int64:
+		m.Value = float64(vv)
+	default:
+		f, err := strconv.ParseFloat(fmt.Sprintf("%v", vv), 64)
+		if err != nil {
+			return nil, true, fmt.Errorf("graphite: malformed %s meta: %v", MetaGraphiteValue, v)
+		}
+		m.Value = f
+	}
+	if ts, ok := msg.Meta(MetaGraphiteTimestamp); ok {
+		switch tt := ts.(type) {
+		case time.Time:
+			m.Timestamp = tt.Unix()
+		case int64:
+			m.Timestamp = tt
+		case int:
+			m.Timestamp = int64(tt)
+		}
+	}
+	return m, true, nil
+}
+
+// sanitizeGraphitePath replaces the characters Graphite can not store in a
+// path with underscores. Tags (path;tag=value) are preserved.
+func sanitizeGraphitePath(path string) string {
+	b := []byte(path)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-', c == ':', c == ';', c == '=':
+		default:
+			b[i] = '_'
+		}
+	}
+	return strings.Trim(string(b), ".")
+}
+
+func formatGraphitePlaintext(metrics []GraphiteMetric) []byte {
+	var b bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "%s %s %d\n", m.Path, strconv.FormatFloat(m.Value, 'f', -1, 64), m.Timestamp)
+	}
+	return b.Bytes()
+}
+
+// formatGraphitePickle renders a pickle protocol frame: a 4-byte big-endian
+// payload length followed by a protocol 2 pickle of
+// [(path, (timestamp, value)), ...].
+func formatGraphitePickle(metrics []GraphiteMetric) []byte {
+	var p bytes.Buffer
+	p.Write([]byte{0x80, 0x02}) // PROTO 2
+	p.WriteByte(']')            // EMPTY_LIST
+	p.WriteByte('(')            // MARK
+	buf := make([]byte, 8)
+	for _, m := range metrics {
+		p.WriteByte('X') // BINUNICODE
+		binary.LittleEndian.PutUint32(buf, uint32(len(m.Path)))
+		p.Write(buf[:4])
+		p.WriteString(m.Path)
+		p.WriteByte('J') // BININT
+		binary.LittleEndian.PutUint32(buf, uint32(int32(m.Timestamp)))
+		p.Write(buf[:4])
+		p.WriteByte('G') // BINFLOAT
+		binary.BigEndian.PutUint64(buf, math.Float64bits(m.Value))
+		p.Write(buf)
+		p.WriteByte(0x86) // TUPLE2: (timestamp, value)
+		p.WriteByte(0x86) // TUPLE2: (path, (timestamp, value))
+	}
+	p.WriteByte('e') // APPENDS
+	p.WriteByte('.') // STOP
+
+	frame := make([]byte, 4, 4+p.Len())
+	binary.BigEndian.PutUint32(frame, uint32(p.Len()))
+	return append(frame, p.Bytes()...)
+}
+
+// graphiteReq is a set of metrics awaiting a write. A pickle request might
+// share the frame with the concurrently written ones.
+type graphiteReq struct {
+	metrics []GraphiteMetric
+	resp    chan error
+}
+
+// SinkHeadGraphite sends metrics to one or more carbon relays. A message is
+// either a set of plaintext lines (`path value [timestamp]`) in the body, or
+// a single metric defined by the graphite.path, graphite.value and
+// graphite.timestamp meta attributes.
+// The relays (see `endpoints`) are served by a SinkHeadPool: every metric
+// path is pinned to a relay using a consistent hash, falling back to the
+// next healthy relay if the write fails. The pool settings (`pool_size`,
+// `max_fails`, `eject_time`) apply.
+// The pickle protocol batches concurrent writes: up to `batch_size` metrics,
+// waiting for at most `flush_interval` milliseconds, are sent in a single
+// frame per relay. A write returns once it's frame is sent.
+type SinkHeadGraphite struct {
+	pool     *SinkHeadPool
+	protocol string
+	prefix   string
+	sanitize bool
+	batch    int
+	interval time.Duration
+	timefun  func() time.Time
+	reqs     chan *graphiteReq
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ SinkHead = (*SinkHeadGraphite)(nil)
+var _ MsgSinkHead = (*SinkHeadGraphite)(nil)
+
+// GraphiteRelayBuilder builds a raw (no delimiter) TCP sink head.
+var GraphiteRelayBuilder = func(addr string) (SinkHead, error) {
+	tcpaddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	head, err := NewSinkHeadTCP(tcpaddr)
+	if err != nil {
+		return nil, err
+	}
+	head.Delimiter = nil
+	return head, nil
+}
+
+func NewSinkHeadGraphite(addr string, params core.Params) (*SinkHeadGraphite, error) {
+	return NewSinkHeadGraphiteWithBuilder(addr, params, GraphiteRelayBuilder)
+}
+
+func NewSinkHeadGraphiteWithBuilder(addr string, params core.Params, builder SinkHeadBuilder) (*SinkHeadGraphite, error) {
+	addrs := []string{addr}
+	if v, ok := params["endpoints"]; ok {
+		eps, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("graphite sink head: malformed `endpoints` config: %s", err)
+		}
+		addrs = eps
+	}
+	relays := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimPrefix(addr, "graphite://")
+		if len(addr) > 0 {
+			relays = append(relays, addr)
+		}
+	}
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("graphite sink head: no relay addresses configured")
+	}
+	poolparams := core.Params{
+		"endpoints": relays,
+		"balance":   PoolBalanceConsistentHash,
+		"hash_key":  MetaGraphitePath,
+	}
+	for _, k := range []string{"pool_size", "max_fails", "eject_time"} {
+		if v, ok := params[k]; ok {
+			poolparams[k] = v
+		}
+	}
+	pool, err := NewSinkHeadPool(poolparams, builder)
+	if err != nil {
+		return nil, fmt.Errorf("graphite sink head: %s", err)
+	}
+	h := &SinkHeadGraphite{
+		pool:     pool,
+		protocol: GraphiteProtocolPlaintext,
+		sanitize: true,
+		batch:    DefaultGraphiteBatchSize,
+		interval: DefaultGraphiteFlushInterval,
+		timefun:  time.Now,
+		reqs:     make(chan *graphiteReq),
+		done:     make(chan struct{}),
+	}
+	if v, ok := params["protocol"]; ok {
+		h.protocol = v.(string)
+	}
+	if h.protocol != GraphiteProtocolPlaintext && h.protocol != GraphiteProtocolPickle {
+		return nil, fmt.Errorf("graphite sink head: unknown protocol %q", h.protocol)
+	}
+	if v, ok := params["prefix"]; ok {
+		h.prefix = v.(string)
+		if len(h.prefix) > 0 && !strings.HasSuffix(h.prefix, ".") {
+			h.prefix += "."
+		}
+	}
+	if v, ok := params["sanitize"]; ok {
+		h.sanitize = v.(bool)
+	}
+	if v, ok := params["batch_size"]; ok {
+		if h.batch = v.(int); h.batch <= 0 {
+			return nil, fmt.Errorf("graphite sink head: `batch_size` should be a positive integer, got: %d", h.batch)
+		}
+	}
+	if v, ok := params["flush_interval"]; ok {
+		h.interval = time.Duration(v.(int)) * time.Millisecond
+	}
+
+	return h, nil
+}
+
+// Connect succeeds if at least one relay is reachable: the remaining ones
+// are reconnected lazily on write.
+func (h *SinkHeadGraphite) Connect() error {
+	return h.pool.Connect()
+}
+
+func (h *SinkHeadGraphite) Start() error {
+	if err := h.pool.Start(); err != nil {
+		return err
+	}
+	if h.protocol != GraphiteProtocolPickle {
+		return nil
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			var req *graphiteReq
+			select {
+			case req = <-h.reqs:
+			case <-h.done:
+				return
+			}
+			h.exec(h.collect(req))
+		}
+	}()
+	return nil
+}
+
+// collect accumulates a pickle batch starting with the request. With no
+// flush_interval only the readily available requests are batched.
+func (h *SinkHeadGraphite) collect(req *graphiteReq) []*graphiteReq {
+	batch := []*graphiteReq{req}
+	size := len(req.metrics)
+	if h.interval <= 0 {
+		for size < h.batch {
+			select {
+			case req := <-h.reqs:
+				batch = append(batch, req)
+				size += len(req.metrics)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+	timer := time.NewTimer(h.interval)
+	defer timer.Stop()
+	for size < h.batch {
+		select {
+		case req := <-h.reqs:
+			batch = append(batch, req)
+			size += len(req.metrics)
+		case <-timer.C:
+			return batch
+		case <-h.done:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (h *SinkHeadGraphite) Stop() error {
+	close(h.done)
+	h.wg.Wait()
+	return h.pool.Stop()
+}
+
+// exec groups the metrics of the requests by relay and sends them: pickle
+// frames hold at most batch_size metrics. Every request is completed with
+// the error of the last failed write it took part in.
+func (h *SinkHeadGraphite) exec(batch []*graphiteReq) {
+	eps := h.pool.Endpoints()
+	if len(eps) == 0 {
+		for _, req := range batch {
+			req.resp <- fmt.Errorf("graphite sink head: no relays available")
+		}
+		return
+	}
+	type group struct {
+		metrics []GraphiteMetric
+		reqs    []*graphiteReq
+	}
+	groups := make(map[int]*group)
+	for _, req := range batch {
+		for _, m := range req.metrics {
+			ix := poolHashIndex(m.Path, len(eps))
+			g, ok := groups[ix]
+			if !ok {
+				g = &group{}
+				groups[ix] = g
+			}
+			g.metrics = append(g.metrics, m)
+			if l := len(g.reqs); l == 0 || g.reqs[l-1] != req {
+				g.reqs = append(g.reqs, req)
+			}
+		}
+	}
+	errs := make(map[*graphiteReq]error, len(batch))
+	for ix, g := range groups {
+		var err error
+		if h.protocol == GraphiteProtocolPickle {
+			for ms := g.metrics; len(ms) > 0 && err == nil; {
+				size := len(ms)
+				if size > h.batch {
+					size = h.batch
+				}
+				_, err, _ = h.pool.writeFrom(formatGraphitePickle(ms[:size]), eps, ix)
+				ms = ms[size:]
+			}
+		} else {
+			_, err, _ = h.pool.writeFrom(formatGraphitePlaintext(g.metrics), eps, ix)
+		}
+		if err != nil {
+			err = fmt.Errorf("graphite sink head: failed to send %d metrics to %s: %s", len(g.metrics), eps[ix].Bind(), err)
+			for _, req := range g.reqs {
+				errs[req] = err
+			}
+		}
+	}
+	for _, req := range batch {
+		req.resp <- errs[req]
+	}
+}
+
+func (h *SinkHeadGraphite) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
+
+// WriteMsg sends the message metrics and returns once they are written: a
+// pickle write awaits the batch it joins. It never requests a sink
+// reconnect: the relays are reconnected independently on the next write.
+func (h *SinkHeadGraphite) WriteMsg(msg *core.Message) (int, error, bool) {
+	now := h.timefun()
+	var metrics []GraphiteMetric
+	m, ok, err := metaGraphite(msg, now)
+	if err != nil {
+		return 0, err, false
+	}
+	if ok {
+		metrics = []GraphiteMetric{*m}
+	} else if metrics, err = ParseGraphite(msg.Body(), now); err != nil {
+		return 0, err, false
+	}
+	for i := range metrics {
+		metrics[i].Path = h.prefix + metrics[i].Path
+		if h.sanitize {
+			metrics[i].Path = sanitizeGraphitePath(metrics[i].Path)
+		}
+	}
+
+	req := &graphiteReq{metrics: metrics, resp: make(chan error, 1)}
+	if h.protocol == GraphiteProtocolPickle {
+		select {
+		case h.reqs <- req:
+		case <-h.done:
+			return 0, fmt.Errorf("graphite sink head is stopped"), false
+		}
+	} else {
+		h.exec([]*graphiteReq{req})
+	}
+	if err := <-req.resp; err != nil {
+		return 0, err, false
+	}
+	return len(msg.Body()), nil, false
+}
@@ -0,0 +1,263 @@
+package actor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// newGraphiteStub starts a TCP server accumulating everything it receives
+// over a single connection.
+func newGraphiteStub(t *testing.T) (string, chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	recv := make(chan []byte, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		recv <- data
+	}()
+	return l.Addr().String(), recv
+}
+
+func awaitGraphiteStub(t *testing.T, recv chan []byte) []byte {
+	select {
+	case data := <-recv:
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the stub data")
+	}
+	return nil
+}
+
+func TestParseGraphite(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		input   string
+		want    []GraphiteMetric
+		wanterr bool
+	}{
+		{"a.b 1 100", []GraphiteMetric{{"a.b", 1, 100}}, false},
+		{"a.b 1.5\nc -2 100.7\n", []GraphiteMetric{{"a.b", 1.5, 1500000000}, {"c", -2, 100}}, false},
+		{"a.b", nil, true},
+		{"a b 1 100", nil, true},
+		{"a.b one", nil, true},
+		{"a.b 1 yesterday", nil, true},
+		{"\n", nil, true},
+	}
+	for _, testCase := range tests {
+		got, err := ParseGraphite([]byte(testCase.input), now)
+		if (err != nil) != testCase.wanterr {
+			t.Fatalf("unexpected error for %q: %v", testCase.input, err)
+		}
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Fatalf("unexpected metrics for %q: got: %+v, want: %+v", testCase.input, got, testCase.want)
+		}
+	}
+}
+
+func TestSinkHeadGraphitePlaintext(t *testing.T) {
+	addr, recv := newGraphiteStub(t)
+	head, err := NewSinkHeadGraphite(addr, core.Params{"prefix": "flow"})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	head.timefun = func() time.Time { return time.Unix(1500000000, 0) }
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	if _, err, _ := head.Write([]byte("cpu.user 42 100\nhost/name(1) 1")); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+	msg := core.NewMessage(nil)
+	msg.SetMeta(MetaGraphitePath, "mem.free;dc=eu")
+	msg.SetMeta(MetaGraphiteValue, 1024)
+	msg.SetMeta(MetaGraphiteTimestamp, time.Unix(200, 0))
+	if _, err, _ := head.WriteMsg(msg); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+	if _, err, _ := head.Write([]byte("malformed")); err == nil {
+		t.Fatalf("expected an error for a malformed line")
+	}
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop: %s", err)
+	}
+
+	want := "flow.cpu.user 42 100\nflow.host_name_1_ 1 1500000000\nflow.mem.free;dc=eu 1024 200\n"
+	if got := string(awaitGraphiteStub(t, recv)); got != want {
+		t.Fatalf("unexpected data: got: %q, want: %q", got, want)
+	}
+}
+
+func TestSinkHeadGraphitePickle(t *testing.T) {
+	addr, recv := newGraphiteStub(t)
+	head, err := NewSinkHeadGraphite(addr, core.Params{
+		"protocol":       "pickle",
+		"batch_size":     2,
+		"flush_interval": 60000,
+		"sanitize":       false,
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	// The batch is full right away, the metrics are split into 2 frames
+	if _, err, _ := head.Write([]byte("a.b 1.5 1500000000\nc -2 1\nd 0 1")); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+	if err := head.Stop(); err != nil {
+		t.Fatalf("failed to stop: %s", err)
+	}
+
+	// [('a.b', (1500000000, 1.5)), ('c', (1, -2.0))], verified with
+	// python's pickle.loads
+	want := "0000003480025d285803000000612e624a002f6859473ff800000000000086865801000000634a0100000047c0000000000000008686652e" +
+		hex.EncodeToString(formatGraphitePickle([]GraphiteMetric{{"d", 0, 1}}))
+	if got := hex.EncodeToString(awaitGraphiteStub(t, recv)); got != want {
+		t.Fatalf("unexpected data:\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestSinkHeadGraphitePickleCompletion(t *testing.T) {
+	var relay *testSinkHead
+	head, err := NewSinkHeadGraphiteWithBuilder("relay:2004", core.Params{
+		"protocol":       "pickle",
+		"batch_size":     3,
+		"flush_interval": 60000,
+	}, func(bind string) (SinkHead, error) {
+		relay = newTestSinkHead(bind)
+		return relay, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	defer head.Stop()
+
+	write := func(n int) chan error {
+		res := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				_, err, _ := head.Write([]byte(fmt.Sprintf("metric.%d 1 1", i)))
+				res <- err
+			}(i)
+		}
+		return res
+	}
+
+	// The writes are held until the batch is full and sent in one frame
+	res := write(2)
+	select {
+	case err := <-res:
+		t.Fatalf("write returned before the batch was sent: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	res3 := write(1)
+	for i := 0; i < 2; i++ {
+		if err := <-res; err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+	if err := <-res3; err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if n := relay.Writes(); n != 1 {
+		t.Fatalf("unexpected number of frames: got: %d, want: 1", n)
+	}
+
+	// A failed frame fails every write it holds
+	relay.lock.Lock()
+	relay.failwr = true
+	relay.lock.Unlock()
+	res = write(3)
+	for i := 0; i < 3; i++ {
+		if err := <-res; err == nil {
+			t.Fatalf("expected a write error for a failed frame")
+		}
+	}
+}
+
+func TestSinkHeadGraphiteConsistentHash(t *testing.T) {
+	heads := make(map[string]*testSinkHead)
+	builder := func(bind string) (SinkHead, error) {
+		heads[bind] = newTestSinkHead(bind)
+		return heads[bind], nil
+	}
+	head, err := NewSinkHeadGraphiteWithBuilder("", core.Params{
+		"endpoints": []interface{}{"graphite://relay1:2003", "relay2:2003", "relay3:2003"},
+	}, builder)
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("metric.%d", i%20)
+		if _, err, _ := head.Write([]byte(path + " 1 1")); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+	}
+	pinned := make(map[string]string)
+	total := 0
+	for bind, h := range heads {
+		if h.Writes() == 0 {
+			t.Fatalf("relay %s got no writes", bind)
+		}
+		total += h.Writes()
+		for _, data := range h.writes {
+			path := strings.Fields(string(data))[0]
+			if p, ok := pinned[path]; ok && p != bind {
+				t.Fatalf("path %q moved from %s to %s", path, p, bind)
+			}
+			pinned[path] = bind
+		}
+	}
+	if total != 100 {
+		t.Fatalf("unexpected total writes: got: %d, want: 100", total)
+	}
+}
+
+func TestNewSinkHeadGraphiteMalformed(t *testing.T) {
+	tests := []struct {
+		addr    string
+		params  core.Params
+		wanterr error
+	}{
+		{"", core.Params{}, fmt.Errorf("graphite sink head: no relay addresses configured")},
+		{"127.0.0.1:2003", core.Params{"protocol": "json"}, fmt.Errorf("graphite sink head: unknown protocol \"json\"")},
+		{"127.0.0.1:2003", core.Params{"batch_size": 0}, fmt.Errorf("graphite sink head: `batch_size` should be a positive integer, got: 0")},
+		{"127.0.0.1:2003", core.Params{"endpoints": 1}, fmt.Errorf("graphite sink head: malformed `endpoints` config: unexpected value type: int")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewSinkHeadGraphite(testCase.addr, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+		}
+	}
+}
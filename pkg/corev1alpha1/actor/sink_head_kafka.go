@@ -0,0 +1,553 @@
+package actor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/util/hash"
+)
+
+const (
+	KafkaPartitionerMurmur2 = "murmur2"
+	KafkaPartitionerJump    = "jump"
+
+	KafkaAcksNone   int16 = 0
+	KafkaAcksLeader int16 = 1
+	KafkaAcksAll    int16 = -1
+
+	DefaultKafkaBatchSize = 100
+	DefaultKafkaTimeout   = 10 * time.Second
+)
+
+type kafkaProduceReq struct {
+	topic  string
+	key    []byte
+	record kafkaRecord
+	resp   chan error
+}
+
+// SinkHeadKafka produces messages to Kafka over the native wire protocol.
+// The topic is rendered from the `topic` template over the message meta
+// (kafka.topic by default), the record key from the `key` template (the
+// kafka.key meta by default) and the kafka.header.* meta attributes become
+// record headers.
+// Keyed records are partitioned with `partitioner`: murmur2 (the Java
+// client default) or jump (JumpHash of the fnv64a key hash), keyless ones
+// are spread round-robin.
+// Concurrent writes are batched: up to `batch_size` records, waiting for at
+// most `flush_interval` milliseconds, are sent in a single produce request
+// per partition leader, optionally compressed with `compression`. The
+// partition error codes are mapped to message statuses (see
+// KafkaErrToMsgStatus), with `acks` set to 0 messages are done once written.
+type SinkHeadKafka struct {
+	cluster     *kafkaCluster
+	topic       *metaTemplate
+	key         *metaTemplate
+	partitioner string
+	acks        int16
+	timeout     time.Duration
+	codec       int8
+	level       int
+	batch       int
+	interval    time.Duration
+	timefun     func() time.Time
+	topics      map[string][]kafkaPartition
+	rr          map[string]uint32
+	lock        sync.Mutex
+	reqs        chan *kafkaProduceReq
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	ConnectTimeout time.Duration
+}
+
+var _ (SinkHead) = (*SinkHeadKafka)(nil)
+var _ (MsgSinkHead) = (*SinkHeadKafka)(nil)
+
+func NewSinkHeadKafka(addr string, params core.Params) (*SinkHeadKafka, error) {
+	var addrs []string
+	if len(addr) > 0 {
+		addrs = append(addrs, addr)
+	}
+	if v, ok := params["brokers"]; ok {
+		brokers, err := toStrList(v)
+		if err != nil {
+			return nil, fmt.Errorf("kafka sink head: malformed `brokers` config: %s", err)
+		}
+		for _, broker := range brokers {
+			addrs = append(addrs, strings.TrimPrefix(broker, "kafka://"))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("kafka sink head: no broker addresses configured")
+	}
+	clientid := DefaultKafkaClientID
+	if v, ok := params["client_id"]; ok {
+		clientid = v.(string)
+	}
+
+	h := &SinkHeadKafka{
+		cluster:        newKafkaCluster(addrs, clientid, TCPConnTimeout),
+		partitioner:    KafkaPartitionerMurmur2,
+		acks:           KafkaAcksLeader,
+		timeout:        DefaultKafkaTimeout,
+		level:          -1,
+		batch:          DefaultKafkaBatchSize,
+		timefun:        time.Now,
+		topics:         make(map[string][]kafkaPartition),
+		rr:             make(map[string]uint32),
+		reqs:           make(chan *kafkaProduceReq),
+		done:           make(chan struct{}),
+		ConnectTimeout: TCPConnTimeout,
+	}
+	topic := "{" + MetaKafkaTopic + "}"
+	if v, ok := params["topic"]; ok {
+		topic = v.(string)
+	}
+	var err error
+	if h.topic, err = newMetaTemplate(topic); err != nil {
+		return nil, fmt.Errorf("kafka sink head: malformed `topic` %q: %s", topic, err)
+	}
+	if v, ok := params["key"]; ok {
+		if h.key, err = newMetaTemplate(v.(string)); err != nil {
+			return nil, fmt.Errorf("kafka sink head: malformed `key` %q: %s", v, err)
+		}
+	}
+
+	if v, ok := params["partitioner"]; ok {
+		h.partitioner = v.(string)
+	}
+	if h.partitioner != KafkaPartitionerMurmur2 && h.partitioner != KafkaPartitionerJump {
+		return nil, fmt.Errorf("kafka sink head: unknown partitioner %q", h.partitioner)
+	}
+	if v, ok := params["acks"]; ok {
+		switch acks := fmt.Sprintf("%v", v); acks {
+		case "0":
+			h.acks = KafkaAcksNone
+		case "1":
+			h.acks = KafkaAcksLeader
+		case "-1", "all":
+			h.acks = KafkaAcksAll
+		default:
+			return nil, fmt.Errorf("kafka sink head: unknown `acks` value %q, want: 0, 1 or all", acks)
+		}
+	}
+	if v, ok := params["timeout"]; ok {
+		h.timeout = time.Duration(v.(int)) * time.Millisecond
+	}
+	if v, ok := params["compression"]; ok {
+		if h.codec, ok = KafkaCompressionCodecs[v.(string)]; !ok {
+			return nil, fmt.Errorf("kafka sink head: unsupported compression %q", v)
+		}
+	}
+	if v, ok := params["compression_level"]; ok {
+		h.level = v.(int)
+	}
+	if v, ok := params["batch_size"]; ok {
+		if h.batch = v.(int); h.batch <= 0 {
+			return nil, fmt.Errorf("kafka sink head: `batch_size` should be a positive integer, got: %d", h.batch)
+		}
+	}
+	if v, ok := params["flush_interval"]; ok {
+		h.interval = time.Duration(v.(int)) * time.Millisecond
+	}
+
+	return h, nil
+}
+
+// Connect drops the broker connections and refreshes the cluster metadata
+// from the first reachable bootstrap broker.
+func (h *SinkHeadKafka) Connect() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	topics := make([]string, 0, len(h.topics))
+	for topic := range h.topics {
+		topics = append(topics, topic)
+	}
+	h.cluster.timeout = h.ConnectTimeout
+	md, err := h.cluster.bootstrap(topics)
+	if err != nil {
+		return fmt.Errorf("kafka sink head: failed to fetch metadata: %s", err)
+	}
+	h.topics = md.Topics
+
+	return nil
+}
+
+func (h *SinkHeadKafka) Start() error {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			var req *kafkaProduceReq
+			select {
+			case req = <-h.reqs:
+			case <-h.done:
+				return
+			}
+			h.exec(h.collect(req))
+		}
+	}()
+	return nil
+}
+
+// collect accumulates a batch starting with the request. With no
+// flush_interval only the readily available requests are batched.
+func (h *SinkHeadKafka) collect(req *kafkaProduceReq) []*kafkaProduceReq {
+	batch := []*kafkaProduceReq{req}
+	if h.interval <= 0 {
+		for len(batch) < h.batch {
+			select {
+			case req := <-h.reqs:
+				batch = append(batch, req)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+	timer := time.NewTimer(h.interval)
+	defer timer.Stop()
+	for len(batch) < h.batch {
+		select {
+		case req := <-h.reqs:
+			batch = append(batch, req)
+		case <-timer.C:
+			return batch
+		case <-h.done:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (h *SinkHeadKafka) Stop() error {
+	close(h.done)
+	h.wg.Wait()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.cluster.close()
+
+	return nil
+}
+
+func kafkaStatusErr(code int16, format string, args ...interface{}) error {
+	status, ok := KafkaErrToMsgStatus[code]
+	if !ok {
+		status = core.MsgStatusFailed
+	}
+	return &MsgStatusError{
+		Status: status,
+		Err:    fmt.Errorf("kafka sink head: "+format+": error code %d", append(args, code)...),
+	}
+}
+
+// isKafkaMetadataErr tells if the error code calls for a metadata refresh.
+func isKafkaMetadataErr(code int16) bool {
+	return code == KafkaErrUnknownTopicOrPartition ||
+		code == KafkaErrLeaderNotAvailable ||
+		code == KafkaErrNotLeaderForPartition
+}
+
+// partitions returns the topic partitions fetching the metadata if needed.
+// Should be called under the lock.
+func (h *SinkHeadKafka) partitions(topic string) ([]kafkaPartition, error) {
+	if partitions, ok := h.topics[topic]; ok {
+		return partitions, nil
+	}
+	md, err := h.cluster.metadata([]string{topic})
+	if err != nil {
+		return nil, err
+	}
+	if code, ok := md.TopicErrs[topic]; ok {
+		return nil, kafkaStatusErr(code, "failed to fetch topic %q metadata", topic)
+	}
+	partitions, ok := md.Topics[topic]
+	if !ok || len(partitions) == 0 {
+		return nil, kafkaStatusErr(KafkaErrUnknownTopicOrPartition, "no partitions for topic %q", topic)
+	}
+	h.topics[topic] = partitions
+	return partitions, nil
+}
+
+// murmur2 is the Kafka Java client flavour of MurmurHash2 used by the
+// default partitioner.
+func murmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	const r = 24
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+func (h *SinkHeadKafka) partition(topic string, key []byte, n int) int32 {
+	if key == nil {
+		h.rr[topic]++
+		return int32(h.rr[topic] % uint32(n))
+	}
+	if h.partitioner == KafkaPartitionerJump {
+		hsh := fnv.New64a()
+		hsh.Write(key)
+		return hash.JumpHash(hsh.Sum64(), n)
+	}
+	return (murmur2(key) & 0x7fffffff) % int32(n)
+}
+
+type kafkaTopicPartition struct {
+	topic     string
+	partition int32
+}
+
+// exec assigns the requests to partitions and sends a produce request per
+// partition leader.
+func (h *SinkHeadKafka) exec(batch []*kafkaProduceReq) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	leaders := make(map[int32]map[kafkaTopicPartition][]*kafkaProduceReq)
+	for _, req := range batch {
+		partitions, err := h.partitions(req.topic)
+		if err != nil {
+			req.resp <- err
+			continue
+		}
+		p := partitions[h.partition(req.topic, req.key, len(partitions))]
+		if p.Leader < 0 {
+			// Leader election is in progress
+			delete(h.topics, req.topic)
+			req.resp <- kafkaStatusErr(KafkaErrLeaderNotAvailable, "partition %s/%d has no leader", req.topic, p.ID)
+			continue
+		}
+		if _, ok := leaders[p.Leader]; !ok {
+			leaders[p.Leader] = make(map[kafkaTopicPartition][]*kafkaProduceReq)
+		}
+		tp := kafkaTopicPartition{req.topic, p.ID}
+		leaders[p.Leader][tp] = append(leaders[p.Leader][tp], req)
+	}
+	for leader, tps := range leaders {
+		h.produce(leader, tps)
+	}
+}
+
+// produce sends a single produce request to the broker and completes the
+// requests according to the partition responses.
+func (h *SinkHeadKafka) produce(leader int32, tps map[kafkaTopicPartition][]*kafkaProduceReq) {
+	complete := func(err error) {
+		for _, reqs := range tps {
+			for _, req := range reqs {
+				req.resp <- err
+			}
+		}
+	}
+	keys := make([]kafkaTopicPartition, 0, len(tps))
+	for tp := range tps {
+		keys = append(keys, tp)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+	// Zstd compression requires produce v7
+	version := int16(3)
+	if h.codec == KafkaCompressionZstd {
+		version = 7
+	}
+	e := &kafkaEncoder{}
+	e.nullableString(nil) // transactional id
+	e.int16(h.acks)
+	e.int32(int32(h.timeout / time.Millisecond))
+	var topics []string
+	for _, tp := range keys {
+		if len(topics) == 0 || topics[len(topics)-1] != tp.topic {
+			topics = append(topics, tp.topic)
+		}
+	}
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+		var parts []kafkaTopicPartition
+		for _, tp := range keys {
+			if tp.topic == topic {
+				parts = append(parts, tp)
+			}
+		}
+		e.int32(int32(len(parts)))
+		for _, tp := range parts {
+			records := make([]kafkaRecord, 0, len(tps[tp]))
+			for _, req := range tps[tp] {
+				records = append(records, req.record)
+			}
+			data, err := encodeKafkaRecordBatch(records, h.codec, h.level)
+			if err != nil {
+				complete(&MsgStatusError{Status: core.MsgStatusFailed, Err: fmt.Errorf("kafka sink head: failed to encode records: %s", err)})
+				return
+			}
+			e.int32(tp.partition)
+			e.bytes(data)
+		}
+	}
+
+	conn, err := h.cluster.conn(leader)
+	if err != nil {
+		complete(err)
+		return
+	}
+	resp, err := conn.roundTrip(kafkaAPIProduce, version, e.buf, h.acks == KafkaAcksNone)
+	if err != nil {
+		h.cluster.dropConn(conn)
+		complete(err)
+		return
+	}
+	if h.acks == KafkaAcksNone {
+		complete(nil)
+		return
+	}
+
+	d := &kafkaDecoder{buf: resp}
+	codes := make(map[kafkaTopicPartition]int16)
+	for i, nt := 0, d.arrayLen(); i < nt; i++ {
+		topic := d.string()
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			partition := d.int32()
+			codes[kafkaTopicPartition{topic, partition}] = d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if version >= 5 {
+				d.int64() // log start offset
+			}
+		}
+	}
+	if d.err != nil {
+		h.cluster.dropConn(conn)
+		complete(d.err)
+		return
+	}
+	for tp, reqs := range tps {
+		code, ok := codes[tp]
+		var err error
+		if !ok {
+			err = fmt.Errorf("kafka sink head: no response for partition %s/%d", tp.topic, tp.partition)
+		} else if code != KafkaErrNone {
+			if isKafkaMetadataErr(code) {
+				delete(h.topics, tp.topic)
+			}
+			err = kafkaStatusErr(code, "failed to produce to %s/%d", tp.topic, tp.partition)
+		}
+		for _, req := range reqs {
+			req.resp <- err
+		}
+	}
+}
+
+// buildRequest renders the topic, the key and the headers from the meta.
+func (h *SinkHeadKafka) buildRequest(msg *core.Message) (*kafkaProduceReq, error) {
+	topic, err := h.topic.render(msg)
+	if err != nil || len(topic) == 0 {
+		if err == nil {
+			err = fmt.Errorf("empty topic")
+		}
+		return nil, &MsgStatusError{Status: core.MsgStatusUnroutable, Err: fmt.Errorf("kafka sink head: failed to render the topic: %s", err)}
+	}
+	req := &kafkaProduceReq{
+		topic: topic,
+		record: kafkaRecord{
+			Timestamp: h.timefun(),
+			Value:     msg.Body(),
+		},
+		resp: make(chan error, 1),
+	}
+	if h.key != nil {
+		key, err := h.key.render(msg)
+		if err != nil {
+			return nil, &MsgStatusError{Status: core.MsgStatusUnroutable, Err: fmt.Errorf("kafka sink head: failed to render the key: %s", err)}
+		}
+		req.key = []byte(key)
+	} else if v, ok := msg.Meta(MetaKafkaKey); ok {
+		switch key := v.(type) {
+		case []byte:
+			req.key = key
+		case string:
+			req.key = []byte(key)
+		default:
+			req.key = []byte(fmt.Sprintf("%v", key))
+		}
+	}
+	req.record.Key = req.key
+	var headers []string
+	for _, k := range msg.MetaKeys() {
+		if key, ok := k.(string); ok && strings.HasPrefix(key, MetaKafkaHeaderPrefix) && len(key) > len(MetaKafkaHeaderPrefix) {
+			headers = append(headers, key)
+		}
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		v, _ := msg.Meta(header)
+		var value []byte
+		switch v := v.(type) {
+		case []byte:
+			value = v
+		case string:
+			value = []byte(v)
+		default:
+			value = []byte(fmt.Sprintf("%v", v))
+		}
+		req.record.Headers = append(req.record.Headers, kafkaHeader{Key: header[len(MetaKafkaHeaderPrefix):], Value: value})
+	}
+	return req, nil
+}
+
+// WriteMsg queues the record into the batch and awaits the produce
+// response. Partition errors are returned as MsgStatusError, connection
+// failures request a reconnect.
+func (h *SinkHeadKafka) WriteMsg(msg *core.Message) (int, error, bool) {
+	req, err := h.buildRequest(msg)
+	if err != nil {
+		return 0, err, false
+	}
+	select {
+	case h.reqs <- req:
+	case <-h.done:
+		return 0, fmt.Errorf("kafka sink head is stopped"), false
+	}
+	if err := <-req.resp; err != nil {
+		_, isstatus := err.(*MsgStatusError)
+		return 0, err, !isstatus
+	}
+	return len(msg.Body()), nil, false
+}
+
+func (h *SinkHeadKafka) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
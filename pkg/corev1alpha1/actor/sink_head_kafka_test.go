@@ -0,0 +1,254 @@
+package actor
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func newTestSinkHeadKafka(t *testing.T, addr string, params core.Params) *SinkHeadKafka {
+	head, err := NewSinkHeadKafka(addr, params)
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	head.timefun = func() time.Time { return time.Unix(1500000000, 0) }
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	return head
+}
+
+func TestMurmur2(t *testing.T) {
+	// The reference values come from the Kafka Java client test suite
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for input, want := range tests {
+		if got := murmur2([]byte(input)); got != want {
+			t.Fatalf("unexpected murmur2 hash for %q: got: %d, want: %d", input, got, want)
+		}
+	}
+}
+
+func TestSinkHeadKafkaProduce(t *testing.T) {
+	stub := newKafkaStub(t, 4)
+	defer stub.close()
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{"topic": "events.{source}", "compression": "gzip"})
+	defer head.Stop()
+
+	msg := core.NewMessage([]byte("body"))
+	msg.SetMeta("source", "web")
+	msg.SetMeta(MetaKafkaKey, "user-42")
+	msg.SetMeta(MetaKafkaHeaderPrefix+"trace", "abc")
+	msg.SetMeta(MetaKafkaHeaderPrefix+"attempt", 2)
+	if n, err, rec := head.WriteMsg(msg); n != 4 || err != nil || rec {
+		t.Fatalf("unexpected write result: %d, %v, %t", n, err, rec)
+	}
+	batch := stub.expect(t)
+	want := kafkaStubBatch{
+		topic:     "events.web",
+		partition: (murmur2([]byte("user-42")) & 0x7fffffff) % 4,
+		acks:      KafkaAcksLeader,
+		records: []kafkaRecord{{
+			Timestamp: time.Unix(1500000000, 0),
+			Key:       []byte("user-42"),
+			Value:     []byte("body"),
+			Headers:   []kafkaHeader{{"attempt", []byte("2")}, {"trace", []byte("abc")}},
+		}},
+	}
+	if !reflect.DeepEqual(batch, want) {
+		t.Fatalf("unexpected produce request: got: %+v, want: %+v", batch, want)
+	}
+
+	// Keyless records are spread round-robin
+	seen := make(map[int32]bool)
+	for i := 0; i < 4; i++ {
+		msg := core.NewMessage([]byte("body"))
+		msg.SetMeta("source", "web")
+		if _, err, _ := head.WriteMsg(msg); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+		seen[stub.expect(t).partition] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("unexpected round-robin partitions: %v", seen)
+	}
+
+	// A message with no topic meta is unroutable
+	_, err, rec := head.WriteMsg(core.NewMessage([]byte("body")))
+	if serr, ok := err.(*MsgStatusError); !ok || serr.Status != core.MsgStatusUnroutable || rec {
+		t.Fatalf("unexpected write result for a message with no topic: %v, %t", err, rec)
+	}
+}
+
+func TestSinkHeadKafkaJumpPartitioner(t *testing.T) {
+	stub := newKafkaStub(t, 8)
+	defer stub.close()
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{"topic": "events", "key": "{user}", "partitioner": "jump"})
+	defer head.Stop()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		msg := core.NewMessage([]byte("body"))
+		msg.SetMeta("user", user)
+		if _, err, _ := head.WriteMsg(msg); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+		head.lock.Lock()
+		want := head.partition("events", []byte(user), 8)
+		head.lock.Unlock()
+		if got := stub.expect(t).partition; got != want {
+			t.Fatalf("unexpected partition for %q: got: %d, want: %d", user, got, want)
+		}
+	}
+}
+
+func TestSinkHeadKafkaStatus(t *testing.T) {
+	stub := newKafkaStub(t, 1)
+	defer stub.close()
+	stub.topicerrs["forbidden"] = KafkaErrTopicAuthorizationFailed
+	codes := map[string]int16{
+		"ok":      KafkaErrNone,
+		"large":   KafkaErrMessageTooLarge,
+		"partial": KafkaErrNotEnoughReplicasAfterAppend,
+		"timeout": KafkaErrRequestTimedOut,
+		"unknown": 1000,
+	}
+	stub.codefunc = func(batch kafkaStubBatch) int16 { return codes[string(batch.records[0].Value)] }
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{"topic": "{topic}", "acks": "all"})
+	defer head.Stop()
+
+	tests := []struct {
+		topic      string
+		body       string
+		wantstatus core.MsgStatus
+	}{
+		{"events", "ok", core.MsgStatusDone},
+		{"events", "large", core.MsgStatusInvalid},
+		{"events", "partial", core.MsgStatusPartialSend},
+		{"events", "timeout", core.MsgStatusTimedOut},
+		{"events", "unknown", core.MsgStatusFailed},
+		{"forbidden", "ok", core.MsgStatusUnroutable},
+	}
+	for _, testCase := range tests {
+		msg := core.NewMessage([]byte(testCase.body))
+		msg.SetMeta("topic", testCase.topic)
+		_, err, rec := head.WriteMsg(msg)
+		if rec {
+			t.Fatalf("unexpected reconnect request for %q", testCase.body)
+		}
+		status := core.MsgStatusDone
+		if err != nil {
+			serr, ok := err.(*MsgStatusError)
+			if !ok {
+				t.Fatalf("unexpected error type for %q: %T", testCase.body, err)
+			}
+			status = serr.Status
+		}
+		if status != testCase.wantstatus {
+			t.Fatalf("unexpected status for %s/%q: got: %d, want: %d", testCase.topic, testCase.body, status, testCase.wantstatus)
+		}
+	}
+}
+
+func TestSinkHeadKafkaNoAcks(t *testing.T) {
+	stub := newKafkaStub(t, 1)
+	defer stub.close()
+	// The error code is never delivered to the producer
+	stub.codefunc = func(kafkaStubBatch) int16 { return KafkaErrMessageTooLarge }
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{"topic": "events", "acks": 0, "compression": "zstd"})
+	defer head.Stop()
+
+	for i := 0; i < 2; i++ {
+		if _, err, _ := head.Write([]byte("body")); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+		if batch := stub.expect(t); batch.acks != KafkaAcksNone || string(batch.records[0].Value) != "body" {
+			t.Fatalf("unexpected produce request: %+v", batch)
+		}
+	}
+}
+
+func TestSinkHeadKafkaBatching(t *testing.T) {
+	stub := newKafkaStub(t, 2)
+	defer stub.close()
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{
+		"topic":          "events",
+		"key":            "static",
+		"batch_size":     5,
+		"flush_interval": 200,
+		"compression":    "snappy",
+	})
+	defer head.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err, _ := head.Write([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				t.Errorf("unexpected write error: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&stub.nproduce); n != 1 {
+		t.Fatalf("unexpected number of produce requests: got: %d, want: 1", n)
+	}
+	if batch := stub.expect(t); len(batch.records) != 5 {
+		t.Fatalf("unexpected batch size: got: %d, want: 5", len(batch.records))
+	}
+}
+
+func TestSinkHeadKafkaConnLost(t *testing.T) {
+	stub := newKafkaStub(t, 1)
+	defer stub.close()
+	head := newTestSinkHeadKafka(t, stub.addr(), core.Params{"topic": "events"})
+	defer head.Stop()
+
+	if _, err, _ := head.Write([]byte("body")); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	stub.dropConns()
+	if _, err, rec := head.Write([]byte("body")); err == nil || !rec {
+		t.Fatalf("expected a reconnect request, got: %v, %t", err, rec)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to reconnect: %s", err)
+	}
+	if _, err, _ := head.Write([]byte("body")); err != nil {
+		t.Fatalf("unexpected write error after reconnect: %s", err)
+	}
+}
+
+func TestNewSinkHeadKafkaMalformed(t *testing.T) {
+	tests := []struct {
+		addr    string
+		params  core.Params
+		wanterr error
+	}{
+		{"", core.Params{}, fmt.Errorf("kafka sink head: no broker addresses configured")},
+		{"127.0.0.1:9092", core.Params{"acks": 2}, fmt.Errorf("kafka sink head: unknown `acks` value \"2\", want: 0, 1 or all")},
+		{"127.0.0.1:9092", core.Params{"compression": "lz4"}, fmt.Errorf("kafka sink head: unsupported compression \"lz4\"")},
+		{"127.0.0.1:9092", core.Params{"partitioner": "random"}, fmt.Errorf("kafka sink head: unknown partitioner \"random\"")},
+		{"127.0.0.1:9092", core.Params{"topic": "{topic"}, fmt.Errorf("kafka sink head: malformed `topic` \"{topic\": unclosed `{` in template")},
+		{"127.0.0.1:9092", core.Params{"batch_size": 0}, fmt.Errorf("kafka sink head: `batch_size` should be a positive integer, got: 0")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewSinkHeadKafka(testCase.addr, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
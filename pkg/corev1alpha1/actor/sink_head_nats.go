@@ -0,0 +1,245 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	DefaultNATSPipeline = 64
+	NATSReplyTimeout    = 5 * time.Second
+)
+
+// NATSErrToMsgStatus maps nats server error prefixes to message statuses.
+// Unlisted errors fail the message.
+var NATSErrToMsgStatus = map[string]core.MsgStatus{
+	"Permissions Violation":     core.MsgStatusUnroutable,
+	"Maximum Payload Violation": core.MsgStatusInvalid,
+}
+
+type natsRequest struct {
+	pub  []byte
+	resp chan error
+}
+
+// SinkHeadNATS publishes message bodies to the subject rendered from the
+// `subject` template over the message meta, e.g. `events.{source}`; it
+// defaults to the meta set by the nats receiver. The connection runs in
+// verbose mode so every PUB is acknowledged by the server, error replies
+// are mapped to message statuses (see NATSErrToMsgStatus).
+// Concurrent writes are pipelined: up to `pipeline` PUBs are sent in a
+// single round trip.
+type SinkHeadNATS struct {
+	addr     string
+	opts     natsOptions
+	subject  *metaTemplate
+	pipeline int
+	conn     net.Conn
+	reader   *bufio.Reader
+	lock     sync.Mutex
+	reqs     chan *natsRequest
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	ConnectTimeout time.Duration
+	ReplyTimeout   time.Duration
+}
+
+var _ (SinkHead) = (*SinkHeadNATS)(nil)
+var _ (MsgSinkHead) = (*SinkHeadNATS)(nil)
+
+func NewSinkHeadNATS(addr string, params core.Params) (*SinkHeadNATS, error) {
+	h := &SinkHeadNATS{
+		addr:           addr,
+		opts:           natsOptionsFromParams(params),
+		pipeline:       DefaultNATSPipeline,
+		reqs:           make(chan *natsRequest),
+		done:           make(chan struct{}),
+		ConnectTimeout: TCPConnTimeout,
+		ReplyTimeout:   NATSReplyTimeout,
+	}
+	h.opts.Verbose = true
+	subject := "{" + MetaNATSSubject + "}"
+	if v, ok := params["subject"]; ok {
+		subject = v.(string)
+	}
+	var err error
+	if h.subject, err = newMetaTemplate(subject); err != nil {
+		return nil, fmt.Errorf("nats sink head: malformed `subject` %q: %s", subject, err)
+	}
+	if v, ok := params["pipeline"]; ok {
+		if h.pipeline = v.(int); h.pipeline <= 0 {
+			return nil, fmt.Errorf("nats sink head: `pipeline` should be a positive integer, got: %d", h.pipeline)
+		}
+	}
+
+	return h, nil
+}
+
+func (h *SinkHeadNATS) Connect() error {
+	conn, reader, err := dialNATS(h.addr, h.opts, h.ConnectTimeout)
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.conn, h.reader = conn, reader
+
+	return nil
+}
+
+func (h *SinkHeadNATS) Start() error {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			var req *natsRequest
+			select {
+			case req = <-h.reqs:
+			case <-h.done:
+				return
+			}
+			batch := []*natsRequest{req}
+		collect:
+			for len(batch) < h.pipeline {
+				select {
+				case req := <-h.reqs:
+					batch = append(batch, req)
+				default:
+					break collect
+				}
+			}
+			h.exec(batch)
+		}
+	}()
+	return nil
+}
+
+func (h *SinkHeadNATS) Stop() error {
+	close(h.done)
+	h.wg.Wait()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn != nil {
+		return h.conn.Close()
+	}
+	return nil
+}
+
+// exec sends the batch in a single write and reads the acknowledgements in
+// order. Server PINGs might interleave with them.
+func (h *SinkHeadNATS) exec(batch []*natsRequest) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fail := func(reqs []*natsRequest, err error) {
+		for _, req := range reqs {
+			req.resp <- err
+		}
+	}
+	if h.conn == nil {
+		fail(batch, fmt.Errorf("nats sink head conn is nil"))
+		return
+	}
+	var buf []byte
+	for _, req := range batch {
+		buf = append(buf, req.pub...)
+	}
+	h.conn.SetDeadline(time.Now().Add(h.ReplyTimeout))
+	if _, err := h.conn.Write(buf); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		fail(batch, err)
+		return
+	}
+	for i := 0; i < len(batch); {
+		line, err := readNATSLine(h.reader)
+		if err == nil && line == "PING" {
+			_, err = h.conn.Write([]byte("PONG\r\n"))
+		}
+		if err != nil {
+			h.conn.Close()
+			h.conn = nil
+			fail(batch[i:], err)
+			return
+		}
+		switch {
+		case line == "+OK":
+			batch[i].resp <- nil
+			i++
+		case strings.HasPrefix(line, "-ERR"):
+			batch[i].resp <- natsReplyErr(line)
+			i++
+		}
+	}
+}
+
+// natsReplyErr converts an error reply into a MsgStatusError.
+func natsReplyErr(line string) error {
+	status := core.MsgStatusFailed
+	text := natsErrText(line)
+	for prefix, st := range NATSErrToMsgStatus {
+		if strings.HasPrefix(text, prefix) {
+			status = st
+			break
+		}
+	}
+	return &MsgStatusError{Status: status, Err: natsErr(line)}
+}
+
+func (h *SinkHeadNATS) buildPub(msg *core.Message) ([]byte, error) {
+	subject, err := h.subject.render(msg)
+	if err == nil && !validNATSSubject(subject) {
+		err = fmt.Errorf("invalid subject %q", subject)
+	}
+	if err != nil {
+		return nil, &MsgStatusError{Status: core.MsgStatusUnroutable, Err: fmt.Errorf("nats sink head: failed to render the subject: %s", err)}
+	}
+	body := msg.Body()
+	buf := make([]byte, 0, len(subject)+len(body)+16)
+	buf = append(buf, "PUB "...)
+	buf = append(buf, subject...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(len(body)), 10)
+	buf = append(buf, '\r', '\n')
+	buf = append(buf, body...)
+	buf = append(buf, '\r', '\n')
+	return buf, nil
+}
+
+// WriteMsg queues the PUB into the pipeline and awaits the server
+// acknowledgement. An error reply is returned as a MsgStatusError,
+// connection failures request a reconnect.
+func (h *SinkHeadNATS) WriteMsg(msg *core.Message) (int, error, bool) {
+	pub, err := h.buildPub(msg)
+	if err != nil {
+		return 0, err, false
+	}
+	req := &natsRequest{pub: pub, resp: make(chan error, 1)}
+	select {
+	case h.reqs <- req:
+	case <-h.done:
+		return 0, fmt.Errorf("nats sink head is stopped"), false
+	}
+	if err := <-req.resp; err != nil {
+		_, isreply := err.(*MsgStatusError)
+		return 0, err, !isreply
+	}
+	return len(msg.Body()), nil, false
+}
+
+func (h *SinkHeadNATS) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
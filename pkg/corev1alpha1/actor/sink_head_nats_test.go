@@ -0,0 +1,212 @@
+package actor
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func newTestSinkHeadNATS(t *testing.T, addr string, params core.Params) *SinkHeadNATS {
+	head, err := NewSinkHeadNATS(addr, params)
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	return head
+}
+
+func TestSinkHeadNATSPublish(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	stub.token = "s3cr3t"
+	// Server PINGs interleave with the acknowledgements
+	stub.pinger = true
+	head := newTestSinkHeadNATS(t, stub.addr(), core.Params{"subject": "events.{source}", "token": "s3cr3t", "client_name": "relay"})
+	defer head.Stop()
+
+	msg := core.NewMessage([]byte("hello\r\nworld"))
+	msg.SetMeta("source", "web")
+	if n, err, rec := head.WriteMsg(msg); n != 12 || err != nil || rec {
+		t.Fatalf("unexpected write result: %d, %v, %t", n, err, rec)
+	}
+	if pub, want := stub.expect(t), (natsStubPub{"events.web", "hello\r\nworld"}); pub != want {
+		t.Fatalf("unexpected pub: got: %+v, want: %+v", pub, want)
+	}
+	stub.lock.Lock()
+	opts := stub.options[0]
+	stub.lock.Unlock()
+	if !opts.Verbose || opts.Name != "relay" || opts.Token != "s3cr3t" {
+		t.Fatalf("unexpected connect options: %+v", opts)
+	}
+
+	// The default subject comes from the receiver meta
+	head = newTestSinkHeadNATS(t, stub.addr(), core.Params{"token": "s3cr3t"})
+	defer head.Stop()
+	msg = core.NewMessage([]byte("body"))
+	msg.SetMeta(MetaNATSSubject, "logs.app")
+	if _, err, _ := head.WriteMsg(msg); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if pub, want := stub.expect(t), (natsStubPub{"logs.app", "body"}); pub != want {
+		t.Fatalf("unexpected pub: got: %+v, want: %+v", pub, want)
+	}
+}
+
+func TestSinkHeadNATSStatus(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	stub.errfunc = func(subject, payload string) string {
+		switch payload {
+		case "forbidden":
+			return fmt.Sprintf("Permissions Violation for Publish to %q", subject)
+		case "large":
+			return "Maximum Payload Violation"
+		case "broken":
+			return "Something Went Wrong"
+		}
+		return ""
+	}
+	head := newTestSinkHeadNATS(t, stub.addr(), core.Params{"subject": "{subject}"})
+	defer head.Stop()
+
+	tests := []struct {
+		subject    string
+		body       string
+		wantstatus core.MsgStatus
+	}{
+		{"events", "ok", core.MsgStatusDone},
+		{"events", "forbidden", core.MsgStatusUnroutable},
+		{"events", "large", core.MsgStatusInvalid},
+		{"events", "broken", core.MsgStatusFailed},
+		{"events..web", "ok", core.MsgStatusUnroutable},
+		{"events web", "ok", core.MsgStatusUnroutable},
+	}
+	for _, testCase := range tests {
+		msg := core.NewMessage([]byte(testCase.body))
+		msg.SetMeta("subject", testCase.subject)
+		_, err, rec := head.WriteMsg(msg)
+		if rec {
+			t.Fatalf("unexpected reconnect request for %q", testCase.body)
+		}
+		status := core.MsgStatusDone
+		if err != nil {
+			serr, ok := err.(*MsgStatusError)
+			if !ok {
+				t.Fatalf("unexpected error type for %q: %T", testCase.body, err)
+			}
+			status = serr.Status
+		}
+		if status != testCase.wantstatus {
+			t.Fatalf("unexpected status for %s/%q: got: %d, want: %d", testCase.subject, testCase.body, status, testCase.wantstatus)
+		}
+	}
+}
+
+func TestSinkHeadNATSConcurrentWrites(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	stub.errfunc = func(subject, payload string) string {
+		if strings.HasSuffix(payload, "3") {
+			return "Permissions Violation"
+		}
+		return ""
+	}
+	head := newTestSinkHeadNATS(t, stub.addr(), core.Params{"subject": "events", "pipeline": 4})
+	defer head.Stop()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i], _ = head.Write([]byte(fmt.Sprintf("msg-%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	var got []string
+	for i := 0; i < 18; i++ {
+		got = append(got, stub.expect(t).payload)
+	}
+	sort.Strings(got)
+	var want []string
+	for i, err := range errs {
+		body := fmt.Sprintf("msg-%d", i)
+		if strings.HasSuffix(body, "3") {
+			if err == nil {
+				t.Fatalf("expected %q to fail", body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", body, err)
+		}
+		want = append(want, body)
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected pubs: got: %v, want: %v", got, want)
+	}
+}
+
+func TestSinkHeadNATSConnect(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	stub.token = "s3cr3t"
+	head, err := NewSinkHeadNATS(stub.addr(), core.Params{"token": "guess"})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); !eqErr(err, fmt.Errorf("nats: server error: Authorization Violation")) {
+		t.Fatalf("unexpected connect error: %v", err)
+	}
+}
+
+func TestSinkHeadNATSConnLost(t *testing.T) {
+	stub := newNATSStub(t)
+	defer stub.close()
+	head := newTestSinkHeadNATS(t, stub.addr(), core.Params{"subject": "events"})
+	defer head.Stop()
+
+	if _, err, _ := head.Write([]byte("body")); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	stub.dropConns()
+	if _, err, rec := head.Write([]byte("body")); err == nil || !rec {
+		t.Fatalf("expected a reconnect request, got: %v, %t", err, rec)
+	}
+	if _, err, rec := head.Write([]byte("body")); err == nil || !rec {
+		t.Fatalf("expected a reconnect request with no connection, got: %v, %t", err, rec)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to reconnect: %s", err)
+	}
+	if _, err, _ := head.Write([]byte("body")); err != nil {
+		t.Fatalf("unexpected write error after reconnect: %s", err)
+	}
+}
+
+func TestNewSinkHeadNATSMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"subject": "events.{source"}, fmt.Errorf("nats sink head: malformed `subject` \"events.{source\": unclosed `{` in template")},
+		{core.Params{"pipeline": 0}, fmt.Errorf("nats sink head: `pipeline` should be a positive integer, got: 0")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewSinkHeadNATS("127.0.0.1:4222", testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
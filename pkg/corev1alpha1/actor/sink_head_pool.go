@@ -0,0 +1,508 @@
+package actor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/util/hash"
+)
+
+const (
+	PoolBalanceRoundRobin       = "round_robin"
+	PoolBalanceLeastOutstanding = "least_outstanding"
+	PoolBalanceConsistentHash   = "consistent_hash"
+
+	DefaultPoolSize      = 1
+	DefaultPoolMaxFails  = 3
+	DefaultPoolEjectTime = 10 * time.Second
+)
+
+// poolConn is a single connection slot of an endpoint. A slot serves one
+// write at a time. A stopped slot is never re-connected: it belongs to an
+// endpoint removed from the pool or to a stopped pool.
+type poolConn struct {
+	head      SinkHead
+	connected bool
+	stopped   bool
+	lock      sync.Mutex
+}
+
+func (c *poolConn) connect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return fmt.Errorf("pool connection is stopped")
+	}
+	if c.connected {
+		return nil
+	}
+	if err := c.head.Connect(); err != nil {
+		return err
+	}
+	c.connected = true
+	return nil
+}
+
+func (c *poolConn) write(data []byte) (int, error, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return 0, fmt.Errorf("pool connection is stopped"), false
+	}
+	if !c.connected {
+		if err := c.head.Connect(); err != nil {
+			return 0, err, true
+		}
+		c.connected = true
+	}
+	n, err, rec := c.head.Write(data)
+	if rec {
+		c.connected = false
+	}
+	return n, err, rec
+}
+
+func (c *poolConn) stop() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return nil
+	}
+	c.connected, c.stopped = false, true
+	return c.head.Stop()
+}
+
+// PoolEndpoint is a group of connection slots sharing the same bind address.
+// It keeps track of the number of in-flight writes and consecutive failures.
+type PoolEndpoint struct {
+	bind        string
+	conns       []*poolConn
+	next        uint32
+	outstanding int64
+	fails       int64
+	ejected     int64
+}
+
+func newPoolEndpoint(bind string, size int, builder SinkHeadBuilder) (*PoolEndpoint, error) {
+	ep := &PoolEndpoint{
+		bind:  bind,
+		conns: make([]*poolConn, 0, size),
+	}
+	for i := 0; i < size; i++ {
+		head, err := builder(bind)
+		if err != nil {
+			return nil, err
+		}
+		ep.conns = append(ep.conns, &poolConn{head: head})
+	}
+	return ep, nil
+}
+
+// Bind returns the endpoint address.
+func (ep *PoolEndpoint) Bind() string {
+	return ep.bind
+}
+
+// Outstanding returns the number of writes in progress.
+func (ep *PoolEndpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&ep.outstanding)
+}
+
+// Fails returns the number of consecutive failed writes.
+func (ep *PoolEndpoint) Fails() int64 {
+	return atomic.LoadInt64(&ep.fails)
+}
+
+// Healthy returns false if the endpoint is ejected at the moment now.
+func (ep *PoolEndpoint) Healthy(now int64) bool {
+	return atomic.LoadInt64(&ep.ejected) <= now
+}
+
+func (ep *PoolEndpoint) write(data []byte) (int, error, bool) {
+	atomic.AddInt64(&ep.outstanding, 1)
+	defer atomic.AddInt64(&ep.outstanding, -1)
+	ix := atomic.AddUint32(&ep.next, 1) % uint32(len(ep.conns))
+	return ep.conns[ix].write(data)
+}
+
+type poolPickFunc func(*SinkHeadPool, []*PoolEndpoint, *core.Message) int
+
+// SinkHeadPool is a sink head balancing writes across multiple endpoints,
+// each one served by a fixed-size pool of connections. Endpoints failing
+// max_fails writes in a row are ejected for eject_time milliseconds.
+//
+// The consistent_hash balancing requires `hash_key`: the messages missing the
+// meta attribute are balanced round-robin and counted (see MissingHashKey).
+//
+// If the endpoint list contains srv:// addresses or `resolve` is enabled,
+// the pool is dynamic: endpoints are re-resolved on every reconnect and,
+// if `resolve_interval` is set, periodically.
+type SinkHeadPool struct {
+	binds     []string
+	endpoints []*PoolEndpoint
+	builder   SinkHeadBuilder
+	size      int
+	next      uint32
+	nokey     uint64
+	pick      poolPickFunc
+	hashkey   string
+	maxfails  int64
+	ejecttime int64
+	timefun   func() int64
+	dynamic   bool
+	interval  time.Duration
+	started   bool
+	lock      sync.RWMutex
+	done      chan struct{}
+	stopOnce  sync.Once
+
+	Resolver Resolver
+}
+
+var _ SinkHead = (*SinkHeadPool)(nil)
+var _ MsgSinkHead = (*SinkHeadPool)(nil)
+
+func NewSinkHeadPool(params core.Params, builder SinkHeadBuilder) (*SinkHeadPool, error) {
+	eps, ok := params["endpoints"]
+	if !ok {
+		return nil, fmt.Errorf("missing `endpoints` config")
+	}
+	binds, err := toStrList(eps)
+	if err != nil {
+		return nil, fmt.Errorf("malformed `endpoints` config: %s", err)
+	}
+	if len(binds) == 0 {
+		return nil, fmt.Errorf("`endpoints` config is empty")
+	}
+
+	size := DefaultPoolSize
+	if v, ok := params["pool_size"]; ok {
+		size = v.(int)
+		if size <= 0 {
+			return nil, fmt.Errorf("`pool_size` should be a positive integer, got: %d", size)
+		}
+	}
+
+	h := &SinkHeadPool{
+		binds:     binds,
+		builder:   builder,
+		size:      size,
+		pick:      pickRoundRobin,
+		maxfails:  DefaultPoolMaxFails,
+		ejecttime: int64(DefaultPoolEjectTime),
+		timefun:   nanotime,
+		done:      make(chan struct{}),
+		Resolver:  DefaultResolver,
+	}
+
+	if v, ok := params["balance"]; ok {
+		switch v.(string) {
+		case PoolBalanceRoundRobin:
+			h.pick = pickRoundRobin
+		case PoolBalanceLeastOutstanding:
+			h.pick = pickLeastOutstanding
+		case PoolBalanceConsistentHash:
+			h.pick = pickConsistentHash
+		default:
+			return nil, fmt.Errorf("unknown `balance` mode: %q", v)
+		}
+	}
+	if v, ok := params["hash_key"]; ok {
+		h.hashkey = v.(string)
+	}
+	if params["balance"] == PoolBalanceConsistentHash && len(h.hashkey) == 0 {
+		return nil, fmt.Errorf("`balance: %s` requires `hash_key` config", PoolBalanceConsistentHash)
+	}
+	if v, ok := params["max_fails"]; ok {
+		h.maxfails = int64(v.(int))
+	}
+	if v, ok := params["eject_time"]; ok {
+		h.ejecttime = int64(time.Duration(v.(int)) * time.Millisecond)
+	}
+	if v, ok := params["resolve"]; ok {
+		h.dynamic = v.(bool)
+	}
+	if v, ok := params["resolve_interval"]; ok {
+		h.interval = time.Duration(v.(int)) * time.Millisecond
+		h.dynamic = h.dynamic || h.interval > 0
+	}
+	for _, bind := range binds {
+		if strings.HasPrefix(bind, "srv://") {
+			h.dynamic = true
+		}
+	}
+
+	// Dynamic endpoints are resolved on the first connect.
+	if !h.dynamic {
+		if err := h.SetEndpoints(binds); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func toStrList(v interface{}) ([]string, error) {
+	switch vv := v.(type) {
+	case []string:
+		return vv, nil
+	case string:
+		return []string{vv}, nil
+	case []interface{}:
+		res := make([]string, 0, len(vv))
+		for _, s := range vv {
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string value: %+v", s)
+			}
+			res = append(res, str)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected value type: %T", v)
+}
+
+// MissingHashKey returns the number of writes balanced round-robin for
+// missing the hash_key meta attribute.
+func (h *SinkHeadPool) MissingHashKey() uint64 {
+	return atomic.LoadUint64(&h.nokey)
+}
+
+// Endpoints returns the list of pool endpoints.
+func (h *SinkHeadPool) Endpoints() []*PoolEndpoint {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.endpoints
+}
+
+// SetEndpoints replaces the endpoint set. Endpoints with a known bind
+// address are preserved along with their connections and health state,
+// the removed ones are stopped. The heads of the new endpoints are started
+// if the pool is already running.
+func (h *SinkHeadPool) SetEndpoints(binds []string) error {
+	h.lock.Lock()
+	started := h.started
+	added := make([]*PoolEndpoint, 0, len(binds))
+	known := make(map[string]*PoolEndpoint, len(h.endpoints))
+	for _, ep := range h.endpoints {
+		known[ep.bind] = ep
+	}
+	endpoints := make([]*PoolEndpoint, 0, len(binds))
+	for _, bind := range binds {
+		if ep, ok := known[bind]; ok {
+			endpoints = append(endpoints, ep)
+			delete(known, bind)
+			continue
+		}
+		ep, err := newPoolEndpoint(bind, h.size, h.builder)
+		if err != nil {
+			h.lock.Unlock()
+			return fmt.Errorf("failed to build endpoint %q: %s", bind, err)
+		}
+		endpoints = append(endpoints, ep)
+		added = append(added, ep)
+	}
+	h.endpoints = endpoints
+	h.lock.Unlock()
+
+	for _, ep := range known {
+		for _, c := range ep.conns {
+			c.stop()
+		}
+	}
+	if started {
+		if err := startPoolEndpoints(added); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startPoolEndpoints(eps []*PoolEndpoint) error {
+	for _, ep := range eps {
+		for _, c := range ep.conns {
+			if err := c.head.Start(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Refresh re-resolves the configured endpoints and updates the endpoint set.
+// The current set is preserved if the resolution fails.
+func (h *SinkHeadPool) Refresh() error {
+	binds := make([]string, 0, len(h.binds))
+	seen := make(map[string]bool)
+	for _, bind := range h.binds {
+		resolved, err := ResolveBind(h.Resolver, bind)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %q: %s", bind, err)
+		}
+		for _, r := range resolved {
+			if !seen[r] {
+				seen[r] = true
+				binds = append(binds, r)
+			}
+		}
+	}
+	return h.SetEndpoints(binds)
+}
+
+// Connect establishes connections for all slots. It only fails if none of
+// the slots managed to connect: the rest would be re-connected on write.
+// Dynamic pools re-resolve the endpoint set first.
+func (h *SinkHeadPool) Connect() error {
+	if h.dynamic {
+		if err := h.Refresh(); err != nil && len(h.Endpoints()) == 0 {
+			return err
+		}
+	}
+	var lasterr error
+	connected := 0
+	for _, ep := range h.Endpoints() {
+		for _, c := range ep.conns {
+			if err := c.connect(); err != nil {
+				lasterr = err
+				continue
+			}
+			connected++
+		}
+	}
+	if connected == 0 && lasterr != nil {
+		return lasterr
+	}
+	return nil
+}
+
+func (h *SinkHeadPool) Start() error {
+	// The endpoints added past this point are started by SetEndpoints.
+	h.lock.Lock()
+	h.started = true
+	eps := h.endpoints
+	h.lock.Unlock()
+	if err := startPoolEndpoints(eps); err != nil {
+		return err
+	}
+	if h.dynamic && h.interval > 0 {
+		go func() {
+			ticker := time.NewTicker(h.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					// A failed resolution keeps the current endpoint set,
+					// it would be retried on the next tick or reconnect.
+					h.Refresh()
+				case <-h.done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (h *SinkHeadPool) Stop() error {
+	h.stopOnce.Do(func() { close(h.done) })
+	for _, ep := range h.Endpoints() {
+		for _, c := range ep.conns {
+			if err := c.stop(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *SinkHeadPool) Write(data []byte) (int, error, bool) {
+	return h.write(data, nil)
+}
+
+func (h *SinkHeadPool) WriteMsg(msg *core.Message) (int, error, bool) {
+	return h.write(msg.Body(), msg)
+}
+
+// write picks an endpoint according to the balancing policy and writes the
+// data starting from it.
+func (h *SinkHeadPool) write(data []byte, msg *core.Message) (int, error, bool) {
+	eps := h.Endpoints()
+	if len(eps) == 0 {
+		return 0, fmt.Errorf("pool has no endpoints"), true
+	}
+	return h.writeFrom(data, eps, h.pick(h, eps, msg))
+}
+
+// writeFrom writes the data to the endpoint start, falling back to the next
+// healthy endpoints if the write fails.
+func (h *SinkHeadPool) writeFrom(data []byte, eps []*PoolEndpoint, start int) (int, error, bool) {
+	l := len(eps)
+	now := h.timefun()
+	var lasterr error
+	rec := false
+	for i := 0; i < l; i++ {
+		ep := eps[(start+i)%l]
+		if !ep.Healthy(now) {
+			continue
+		}
+		n, err, r := ep.write(data)
+		if err == nil {
+			atomic.StoreInt64(&ep.fails, 0)
+			return n, nil, false
+		}
+		lasterr = err
+		rec = rec || r
+		if atomic.AddInt64(&ep.fails, 1) >= h.maxfails {
+			atomic.StoreInt64(&ep.ejected, now+h.ejecttime)
+			atomic.StoreInt64(&ep.fails, 0)
+		}
+	}
+	if lasterr == nil {
+		return 0, fmt.Errorf("all pool endpoints are ejected"), false
+	}
+	return 0, lasterr, rec
+}
+
+func pickRoundRobin(h *SinkHeadPool, eps []*PoolEndpoint, _ *core.Message) int {
+	return int(atomic.AddUint32(&h.next, 1) % uint32(len(eps)))
+}
+
+func pickLeastOutstanding(h *SinkHeadPool, eps []*PoolEndpoint, _ *core.Message) int {
+	now := h.timefun()
+	minix, minval := 0, int64(-1)
+	for ix, ep := range eps {
+		if !ep.Healthy(now) {
+			continue
+		}
+		if o := ep.Outstanding(); minval < 0 || o < minval {
+			minix, minval = ix, o
+		}
+	}
+	return minix
+}
+
+func pickConsistentHash(h *SinkHeadPool, eps []*PoolEndpoint, msg *core.Message) int {
+	if msg == nil {
+		atomic.AddUint64(&h.nokey, 1)
+		return pickRoundRobin(h, eps, msg)
+	}
+	v, ok := msg.Meta(h.hashkey)
+	if !ok {
+		atomic.AddUint64(&h.nokey, 1)
+		return pickRoundRobin(h, eps, msg)
+	}
+	return poolHashIndex(fmt.Sprintf("%v", v), len(eps))
+}
+
+// poolHashIndex pins the key to one of n endpoints.
+func poolHashIndex(key string, n int) int {
+	hsh := fnv.New64a()
+	hsh.Write([]byte(key))
+	return int(hash.JumpHash(hsh.Sum64(), n))
+}
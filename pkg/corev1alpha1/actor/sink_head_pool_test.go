@@ -0,0 +1,449 @@
+package actor
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	testutil "github.com/awesome-flow/flow/pkg/util/test"
+)
+
+func newTestPool(t *testing.T, params core.Params) (*SinkHeadPool, map[string][]*testSinkHead) {
+	heads := make(map[string][]*testSinkHead)
+	builder := func(bind string) (SinkHead, error) {
+		head := newTestSinkHead(bind)
+		heads[bind] = append(heads[bind], head)
+		return head, nil
+	}
+	pool, err := NewSinkHeadPool(params, builder)
+	if err != nil {
+		t.Fatalf("failed to initialize pool sink head: %s", err)
+	}
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("failed to connect pool sink head: %s", err)
+	}
+	return pool, heads
+}
+
+func TestNewSinkHeadPoolMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  core.Params
+		wanterr error
+	}{
+		{
+			"no endpoints",
+			core.Params{},
+			fmt.Errorf("missing `endpoints` config"),
+		},
+		{
+			"empty endpoints",
+			core.Params{"endpoints": []interface{}{}},
+			fmt.Errorf("`endpoints` config is empty"),
+		},
+		{
+			"zero pool size",
+			core.Params{"endpoints": []interface{}{"tcp://127.0.0.1:7222"}, "pool_size": 0},
+			fmt.Errorf("`pool_size` should be a positive integer, got: 0"),
+		},
+		{
+			"unknown balance",
+			core.Params{"endpoints": []interface{}{"tcp://127.0.0.1:7222"}, "balance": "random"},
+			fmt.Errorf("unknown `balance` mode: \"random\""),
+		},
+		{
+			"consistent hash without hash key",
+			core.Params{"endpoints": []interface{}{"tcp://127.0.0.1:7222"}, "balance": PoolBalanceConsistentHash},
+			fmt.Errorf("`balance: consistent_hash` requires `hash_key` config"),
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := NewSinkHeadPool(testCase.params, func(bind string) (SinkHead, error) {
+				return newTestSinkHead(bind), nil
+			})
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+			}
+		})
+	}
+}
+
+func TestSinkHeadPoolRoundRobin(t *testing.T) {
+	binds := []interface{}{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222", "tcp://10.0.0.3:7222"}
+	pool, heads := newTestPool(t, core.Params{
+		"endpoints": binds,
+		"pool_size": 2,
+	})
+
+	for i := 0; i < 60; i++ {
+		if _, err, _ := pool.Write(testutil.RandBytes(64)); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+
+	for _, bind := range binds {
+		if len(heads[bind.(string)]) != 2 {
+			t.Fatalf("unexpected number of connections for %s: got: %d, want: 2", bind, len(heads[bind.(string)]))
+		}
+		for _, head := range heads[bind.(string)] {
+			if head.Writes() != 10 {
+				t.Fatalf("unexpected number of writes for %s: got: %d, want: 10", bind, head.Writes())
+			}
+		}
+	}
+}
+
+func TestSinkHeadPoolConsistentHash(t *testing.T) {
+	binds := []interface{}{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222", "tcp://10.0.0.3:7222", "tcp://10.0.0.4:7222"}
+	pool, heads := newTestPool(t, core.Params{
+		"endpoints": binds,
+		"balance":   PoolBalanceConsistentHash,
+		"hash_key":  "user",
+	})
+
+	for i := 0; i < 20; i++ {
+		msg := core.NewMessage(testutil.RandBytes(64))
+		msg.SetMeta("user", "foo")
+		if _, err, _ := pool.WriteMsg(msg); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+
+	hit := 0
+	for _, bind := range binds {
+		if n := heads[bind.(string)][0].Writes(); n > 0 {
+			if n != 20 {
+				t.Fatalf("unexpected number of writes for %s: got: %d, want: 20", bind, n)
+			}
+			hit++
+		}
+	}
+	if hit != 1 {
+		t.Fatalf("unexpected number of endpoints hit: got: %d, want: 1", hit)
+	}
+	if n := pool.MissingHashKey(); n != 0 {
+		t.Fatalf("unexpected number of messages missing the hash key: got: %d, want: 0", n)
+	}
+}
+
+func TestSinkHeadPoolConsistentHashMissingKey(t *testing.T) {
+	binds := []interface{}{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222"}
+	pool, heads := newTestPool(t, core.Params{
+		"endpoints": binds,
+		"balance":   PoolBalanceConsistentHash,
+		"hash_key":  "user",
+	})
+
+	for i := 0; i < 10; i++ {
+		msg := core.NewMessage(testutil.RandBytes(64))
+		if i%2 == 0 {
+			msg.SetMeta("user", "foo")
+		}
+		if _, err, _ := pool.WriteMsg(msg); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+	if _, err, _ := pool.Write(testutil.RandBytes(64)); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+
+	if n := pool.MissingHashKey(); n != 6 {
+		t.Fatalf("unexpected number of messages missing the hash key: got: %d, want: 6", n)
+	}
+	total := 0
+	for _, bind := range binds {
+		total += heads[bind.(string)][0].Writes()
+	}
+	if total != 11 {
+		t.Fatalf("unexpected total writes: got: %d, want: 11", total)
+	}
+}
+
+func TestSinkHeadPoolLeastOutstanding(t *testing.T) {
+	binds := []interface{}{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222", "tcp://10.0.0.3:7222"}
+	pool, _ := newTestPool(t, core.Params{
+		"endpoints": binds,
+		"balance":   PoolBalanceLeastOutstanding,
+	})
+	eps := pool.Endpoints()
+	eps[0].outstanding = 4
+	eps[1].outstanding = 1
+	eps[2].outstanding = 2
+	if ix := pickLeastOutstanding(pool, eps, nil); ix != 1 {
+		t.Fatalf("unexpected endpoint picked: got: %d, want: 1", ix)
+	}
+	// An ejected endpoint should never be picked
+	eps[1].ejected = pool.timefun() + int64(time.Hour)
+	if ix := pickLeastOutstanding(pool, eps, nil); ix != 2 {
+		t.Fatalf("unexpected endpoint picked: got: %d, want: 2", ix)
+	}
+}
+
+func TestSinkHeadPoolEjection(t *testing.T) {
+	binds := []interface{}{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222"}
+	pool, heads := newTestPool(t, core.Params{
+		"endpoints":  binds,
+		"max_fails":  2,
+		"eject_time": 1000,
+	})
+	var now int64 = 1
+	pool.timefun = func() int64 { return now }
+
+	broken := heads["tcp://10.0.0.1:7222"][0]
+	broken.failwr = true
+	healthy := heads["tcp://10.0.0.2:7222"][0]
+
+	for i := 0; i < 10; i++ {
+		if _, err, _ := pool.Write(testutil.RandBytes(64)); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+	if healthy.Writes() != 10 {
+		t.Fatalf("unexpected number of writes to the healthy endpoint: got: %d, want: 10", healthy.Writes())
+	}
+	ep := pool.Endpoints()[0]
+	if ep.Healthy(now) {
+		t.Fatalf("expected endpoint %s to be ejected", ep.Bind())
+	}
+
+	now += int64(2 * time.Second)
+	if !ep.Healthy(now) {
+		t.Fatalf("expected endpoint %s to be restored after the eject time", ep.Bind())
+	}
+	broken.failwr = false
+	for i := 0; i < 10; i++ {
+		if _, err, _ := pool.Write(testutil.RandBytes(64)); err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+	}
+	if broken.Writes() == 0 {
+		t.Fatalf("expected restored endpoint %s to receive writes", ep.Bind())
+	}
+}
+
+func TestSinkHeadPoolAllEjected(t *testing.T) {
+	pool, heads := newTestPool(t, core.Params{
+		"endpoints": []interface{}{"tcp://10.0.0.1:7222"},
+		"max_fails": 1,
+	})
+	heads["tcp://10.0.0.1:7222"][0].failwr = true
+	if _, err, rec := pool.Write(testutil.RandBytes(64)); err == nil || !rec {
+		t.Fatalf("expected a write error with reconnect flag, got: %s, %t", err, rec)
+	}
+	wanterr := fmt.Errorf("all pool endpoints are ejected")
+	if _, err, rec := pool.Write(testutil.RandBytes(64)); !eqErr(err, wanterr) || rec {
+		t.Fatalf("unexpected write result: got: %s, %t, want: %s, false", err, rec, wanterr)
+	}
+}
+
+func TestSinkHeadPoolConnectPartial(t *testing.T) {
+	heads := make(map[string]*testSinkHead)
+	builder := func(bind string) (SinkHead, error) {
+		head := newTestSinkHead(bind)
+		head.failcon = bind == "tcp://10.0.0.1:7222"
+		heads[bind] = head
+		return head, nil
+	}
+	pool, err := NewSinkHeadPool(core.Params{
+		"endpoints": []string{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222"},
+	}, builder)
+	if err != nil {
+		t.Fatalf("failed to initialize pool sink head: %s", err)
+	}
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("unexpected connect error: %s", err)
+	}
+	heads["tcp://10.0.0.2:7222"].failcon = true
+	heads["tcp://10.0.0.2:7222"].failwr = true
+	pool.Write(testutil.RandBytes(64))
+	if err := pool.Connect(); err == nil {
+		t.Fatalf("expected connect to fail if no endpoint is reachable")
+	}
+}
+
+type testResolver struct {
+	lock  sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+var _ Resolver = (*testResolver)(nil)
+
+func (r *testResolver) LookupHost(host string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if ips, ok := r.hosts[host]; ok {
+		return ips, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", host)
+}
+
+func (r *testResolver) LookupSRV(name string) ([]*net.SRV, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if srvs, ok := r.srvs[name]; ok {
+		return srvs, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", name)
+}
+
+func (r *testResolver) setHost(host string, ips []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hosts[host] = ips
+}
+
+func poolBinds(pool *SinkHeadPool) []string {
+	res := make([]string, 0)
+	for _, ep := range pool.Endpoints() {
+		res = append(res, ep.Bind())
+	}
+	return res
+}
+
+func TestResolveBind(t *testing.T) {
+	resolver := &testResolver{
+		hosts: map[string][]string{
+			"collector.local":   {"10.0.0.2", "10.0.0.1"},
+			"a.collector.local": {"10.0.1.1"},
+			"b.collector.local": {"10.0.1.2"},
+		},
+		srvs: map[string][]*net.SRV{
+			"_collector._tcp.local": {
+				{Target: "b.collector.local.", Port: 7223},
+				{Target: "a.collector.local.", Port: 7222},
+			},
+			"_collector._udp.local": {
+				{Target: "a.collector.local.", Port: 8125},
+			},
+		},
+	}
+	tests := []struct {
+		bind    string
+		want    []string
+		wanterr error
+	}{
+		{"tcp://127.0.0.1:7222", []string{"tcp://127.0.0.1:7222"}, nil},
+		{"tcp://collector.local:7222", []string{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222"}, nil},
+		{"udp://collector.local:8125", []string{"udp://10.0.0.1:8125", "udp://10.0.0.2:8125"}, nil},
+		{"srv://_collector._tcp.local", []string{"tcp://10.0.1.1:7222", "tcp://10.0.1.2:7223"}, nil},
+		{"srv://_collector._udp.local", []string{"udp://10.0.1.1:8125"}, nil},
+		{"unix:///tmp/flow.sock", []string{"unix:///tmp/flow.sock"}, nil},
+		{"tcp://unknown.local:7222", nil, fmt.Errorf("no such host: unknown.local")},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.bind, func(t *testing.T) {
+			got, err := ResolveBind(resolver, testCase.bind)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected resolve result: got: %+v, want: %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSinkHeadPoolRefresh(t *testing.T) {
+	resolver := &testResolver{
+		hosts: map[string][]string{
+			"collector.local": {"10.0.0.1", "10.0.0.2"},
+		},
+	}
+	heads := make(map[string]*testSinkHead)
+	var lock sync.Mutex
+	builder := func(bind string) (SinkHead, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		head := newTestSinkHead(bind)
+		heads[bind] = head
+		return head, nil
+	}
+	pool, err := NewSinkHeadPool(core.Params{
+		"endpoints":        []interface{}{"tcp://collector.local:7222"},
+		"resolve_interval": 10,
+	}, builder)
+	if err != nil {
+		t.Fatalf("failed to initialize pool sink head: %s", err)
+	}
+	pool.Resolver = resolver
+	if got := poolBinds(pool); len(got) != 0 {
+		t.Fatalf("expected no endpoints before connect, got: %+v", got)
+	}
+	if err := pool.Start(); err != nil {
+		t.Fatalf("failed to start pool sink head: %s", err)
+	}
+	defer pool.Stop()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("failed to connect pool sink head: %s", err)
+	}
+	want := []string{"tcp://10.0.0.1:7222", "tcp://10.0.0.2:7222"}
+	if got := poolBinds(pool); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected endpoints: got: %+v, want: %+v", got, want)
+	}
+	removed, preserved := pool.Endpoints()[0], pool.Endpoints()[1]
+
+	resolver.setHost("collector.local", []string{"10.0.0.2", "10.0.0.3"})
+	want = []string{"tcp://10.0.0.2:7222", "tcp://10.0.0.3:7222"}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(poolBinds(pool), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints were not refreshed: got: %+v, want: %+v", poolBinds(pool), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if pool.Endpoints()[0] != preserved {
+		t.Fatalf("expected a known endpoint to be preserved across refreshes")
+	}
+	lock.Lock()
+	added, gone := heads["tcp://10.0.0.3:7222"], heads["tcp://10.0.0.1:7222"]
+	lock.Unlock()
+	if n := added.Starts(); n != 1 {
+		t.Fatalf("expected the added endpoint head to be started once, got: %d", n)
+	}
+
+	// A write racing the removal does not re-connect the removed endpoint
+	conns := gone.Conns()
+	if _, err, _ := removed.write([]byte("data")); err == nil {
+		t.Fatalf("expected a write to the removed endpoint to fail")
+	}
+	if n := gone.Conns(); n != conns {
+		t.Fatalf("the removed endpoint was re-connected: got: %d connections, want: %d", n, conns)
+	}
+
+	// Failing resolution keeps the endpoint set untouched
+	resolver.setHost("collector.local", nil)
+	if err := pool.Refresh(); err == nil {
+		t.Fatalf("expected refresh to fail")
+	}
+	if got := poolBinds(pool); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected endpoints: got: %+v, want: %+v", got, want)
+	}
+
+	// Stop is safe to call more than once
+	if err := pool.Stop(); err != nil {
+		t.Fatalf("failed to stop pool sink head: %s", err)
+	}
+}
+
+func TestSinkHeadFactoryDynamic(t *testing.T) {
+	head, err := SinkHeadFactory(core.Params{
+		"bind": "srv://_collector._tcp.local",
+	})
+	if err != nil {
+		t.Fatalf("failed to build sink head: %s", err)
+	}
+	pool, ok := head.(*SinkHeadPool)
+	if !ok {
+		t.Fatalf("unexpected sink head type: %T", head)
+	}
+	if !pool.dynamic {
+		t.Fatalf("expected srv:// sink head to be dynamic")
+	}
+}
@@ -0,0 +1,370 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	RedisCommandLPush   = "lpush"
+	RedisCommandRPush   = "rpush"
+	RedisCommandPublish = "publish"
+	RedisCommandXAdd    = "xadd"
+
+	DefaultRedisPipeline = 64
+	RedisReplyTimeout    = 5 * time.Second
+)
+
+// RedisErrToMsgStatus maps redis error reply prefixes to message statuses.
+// Unlisted errors fail the message.
+var RedisErrToMsgStatus = map[string]core.MsgStatus{
+	"OOM":        core.MsgStatusFailed,
+	"WRONGTYPE":  core.MsgStatusInvalid,
+	"LOADING":    core.MsgStatusThrottled,
+	"BUSY":       core.MsgStatusThrottled,
+	"TRYAGAIN":   core.MsgStatusThrottled,
+	"MASTERDOWN": core.MsgStatusThrottled,
+}
+
+type redisRequest struct {
+	cmd  []byte
+	resp chan error
+}
+
+// SinkHeadRedis writes message bodies to redis with LPUSH, RPUSH, PUBLISH or
+// XADD, see `command`. The target key (or the channel) is rendered from the
+// `key` template over the message meta, e.g. `events:{redis.key}`; it
+// defaults to the meta set by the RESP receiver. XADD entries carry the body
+// in the `body_field` field along with the redis.field.* meta attributes,
+// `maxlen` enables approximate stream trimming.
+// Concurrent writes are pipelined: up to `pipeline` commands are sent in a
+// single round trip. Error replies are mapped to message statuses (see
+// RedisErrToMsgStatus).
+type SinkHeadRedis struct {
+	addr      string
+	command   string
+	key       *metaTemplate
+	bodyfield string
+	maxlen    int
+	password  string
+	db        int
+	pipeline  int
+	conn      net.Conn
+	reader    *bufio.Reader
+	lock      sync.Mutex
+	reqs      chan *redisRequest
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	ConnectTimeout time.Duration
+	ReplyTimeout   time.Duration
+}
+
+var _ (SinkHead) = (*SinkHeadRedis)(nil)
+var _ (MsgSinkHead) = (*SinkHeadRedis)(nil)
+
+func NewSinkHeadRedis(addr string, params core.Params) (*SinkHeadRedis, error) {
+	h := &SinkHeadRedis{
+		addr:           addr,
+		command:        RedisCommandRPush,
+		bodyfield:      DefaultRESPBodyField,
+		pipeline:       DefaultRedisPipeline,
+		reqs:           make(chan *redisRequest),
+		done:           make(chan struct{}),
+		ConnectTimeout: TCPConnTimeout,
+		ReplyTimeout:   RedisReplyTimeout,
+	}
+	if v, ok := params["command"]; ok {
+		h.command = strings.ToLower(v.(string))
+	}
+	key := "{" + MetaRedisKey + "}"
+	switch h.command {
+	case RedisCommandLPush, RedisCommandRPush, RedisCommandXAdd:
+	case RedisCommandPublish:
+		key = "{" + MetaRedisChannel + "}"
+	default:
+		return nil, fmt.Errorf("redis sink head: unknown command %q", h.command)
+	}
+	if v, ok := params["key"]; ok {
+		key = v.(string)
+	}
+	var err error
+	if h.key, err = newMetaTemplate(key); err != nil {
+		return nil, fmt.Errorf("redis sink head: malformed `key` %q: %s", key, err)
+	}
+	if v, ok := params["body_field"]; ok {
+		h.bodyfield = v.(string)
+	}
+	if v, ok := params["maxlen"]; ok {
+		if h.maxlen = v.(int); h.maxlen < 0 {
+			return nil, fmt.Errorf("redis sink head: `maxlen` should be a non-negative integer, got: %d", h.maxlen)
+		}
+	}
+	if v, ok := params["password"]; ok {
+		h.password = v.(string)
+	}
+	if v, ok := params["db"]; ok {
+		h.db = v.(int)
+	}
+	if v, ok := params["pipeline"]; ok {
+		if h.pipeline = v.(int); h.pipeline <= 0 {
+			return nil, fmt.Errorf("redis sink head: `pipeline` should be a positive integer, got: %d", h.pipeline)
+		}
+	}
+
+	return h, nil
+}
+
+// Connect dials redis and authenticates and selects the database if
+// configured to.
+func (h *SinkHeadRedis) Connect() error {
+	conn, err := net.DialTimeout("tcp", h.addr, h.ConnectTimeout)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	var setup [][][]byte
+	if len(h.password) > 0 {
+		setup = append(setup, [][]byte{[]byte("AUTH"), []byte(h.password)})
+	}
+	if h.db != 0 {
+		setup = append(setup, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(h.db))})
+	}
+	for _, args := range setup {
+		conn.SetDeadline(time.Now().Add(h.ReplyTimeout))
+		if _, err := conn.Write(appendRESPCommand(nil, args)); err != nil {
+			conn.Close()
+			return err
+		}
+		line, err := readRESPReply(reader)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if line[0] == '-' {
+			conn.Close()
+			return fmt.Errorf("redis sink head: %s failed: %s", args[0], line[1:])
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.conn, h.reader = conn, reader
+
+	return nil
+}
+
+func (h *SinkHeadRedis) Start() error {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			var req *redisRequest
+			select {
+			case req = <-h.reqs:
+			case <-h.done:
+				return
+			}
+			batch := []*redisRequest{req}
+		collect:
+			for len(batch) < h.pipeline {
+				select {
+				case req := <-h.reqs:
+					batch = append(batch, req)
+				default:
+					break collect
+				}
+			}
+			h.exec(batch)
+		}
+	}()
+	return nil
+}
+
+func (h *SinkHeadRedis) Stop() error {
+	close(h.done)
+	h.wg.Wait()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn != nil {
+		return h.conn.Close()
+	}
+	return nil
+}
+
+// exec sends the batch in a single write and reads the replies in order.
+func (h *SinkHeadRedis) exec(batch []*redisRequest) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fail := func(reqs []*redisRequest, err error) {
+		for _, req := range reqs {
+			req.resp <- err
+		}
+	}
+	if h.conn == nil {
+		fail(batch, fmt.Errorf("redis sink head conn is nil"))
+		return
+	}
+	var buf []byte
+	for _, req := range batch {
+		buf = append(buf, req.cmd...)
+	}
+	h.conn.SetDeadline(time.Now().Add(h.ReplyTimeout))
+	if _, err := h.conn.Write(buf); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		fail(batch, err)
+		return
+	}
+	for i, req := range batch {
+		line, err := readRESPReply(h.reader)
+		if err != nil {
+			h.conn.Close()
+			h.conn = nil
+			fail(batch[i:], err)
+			return
+		}
+		req.resp <- redisReplyErr(line)
+	}
+}
+
+// redisReplyErr converts an error reply into a MsgStatusError, any other
+// reply means success.
+func redisReplyErr(line []byte) error {
+	if line[0] != '-' {
+		return nil
+	}
+	reply := string(line[1:])
+	prefix := reply
+	if ix := strings.IndexByte(reply, ' '); ix > 0 {
+		prefix = reply[:ix]
+	}
+	status, ok := RedisErrToMsgStatus[prefix]
+	if !ok {
+		status = core.MsgStatusFailed
+	}
+	return &MsgStatusError{Status: status, Err: fmt.Errorf("redis replied with an error: %s", reply)}
+}
+
+// readRESPReply reads a single reply and returns its first line. Nested bulk
+// strings and array elements are consumed and dropped.
+func readRESPReply(reader *bufio.Reader) ([]byte, error) {
+	line, err := readRESPLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis sink head: empty reply")
+	}
+	// The line points to the reader buffer which is overwritten by the
+	// nested reads.
+	line = append([]byte{}, line...)
+	switch line[0] {
+	case '+', '-', ':':
+	case '$':
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis sink head: malformed bulk reply: %q", line)
+		}
+		if size >= 0 {
+			if _, err := io.CopyN(ioutil.Discard, reader, int64(size)+2); err != nil {
+				return nil, err
+			}
+		}
+	case '*':
+		cnt, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis sink head: malformed array reply: %q", line)
+		}
+		for i := 0; i < cnt; i++ {
+			if _, err := readRESPReply(reader); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("redis sink head: unexpected reply: %q", line)
+	}
+	return line, nil
+}
+
+func appendRESPCommand(buf []byte, args [][]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// buildCommand renders the command for the message.
+func (h *SinkHeadRedis) buildCommand(msg *core.Message) ([]byte, error) {
+	key, err := h.key.render(msg)
+	if err != nil {
+		return nil, &MsgStatusError{Status: core.MsgStatusUnroutable, Err: fmt.Errorf("redis sink head: failed to render the key: %s", err)}
+	}
+	args := [][]byte{[]byte(strings.ToUpper(h.command)), []byte(key)}
+	if h.command != RedisCommandXAdd {
+		return appendRESPCommand(nil, append(args, msg.Body())), nil
+	}
+	if h.maxlen > 0 {
+		args = append(args, []byte("MAXLEN"), []byte("~"), []byte(strconv.Itoa(h.maxlen)))
+	}
+	args = append(args, []byte("*"), []byte(h.bodyfield), msg.Body())
+	var fields []string
+	for _, k := range msg.MetaKeys() {
+		if key, ok := k.(string); ok && strings.HasPrefix(key, MetaRedisFieldPrefix) && len(key) > len(MetaRedisFieldPrefix) {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		v, _ := msg.Meta(field)
+		args = append(args, []byte(field[len(MetaRedisFieldPrefix):]), []byte(fmt.Sprintf("%v", v)))
+	}
+	return appendRESPCommand(nil, args), nil
+}
+
+// WriteMsg queues the command into the pipeline and awaits the reply. An
+// error reply is returned as a MsgStatusError, connection failures request
+// a reconnect.
+func (h *SinkHeadRedis) WriteMsg(msg *core.Message) (int, error, bool) {
+	cmd, err := h.buildCommand(msg)
+	if err != nil {
+		return 0, err, false
+	}
+	req := &redisRequest{cmd: cmd, resp: make(chan error, 1)}
+	select {
+	case h.reqs <- req:
+	case <-h.done:
+		return 0, fmt.Errorf("redis sink head is stopped"), false
+	}
+	if err := <-req.resp; err != nil {
+		_, isreply := err.(*MsgStatusError)
+		return 0, err, !isreply
+	}
+	return len(msg.Body()), nil, false
+}
+
+func (h *SinkHeadRedis) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
@@ -0,0 +1,340 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// newRedisStub starts an in-process RESP server replying to every command
+// with the handler result. The received commands are sent to the returned
+// channel.
+func newRedisStub(t *testing.T, handler func(args []string) string) (string, chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	cmds := make(chan []string, 64)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			args, err := readRESPCommand(reader, DefaultRESPMaxBulkLen)
+			if err != nil {
+				return
+			}
+			strargs := make([]string, len(args))
+			for i, arg := range args {
+				strargs[i] = string(arg)
+			}
+			cmds <- strargs
+			reply := handler(strargs)
+			if len(reply) == 0 {
+				return
+			}
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), cmds
+}
+
+func newTestSinkHeadRedis(t *testing.T, addr string, params core.Params) *SinkHeadRedis {
+	head, err := NewSinkHeadRedis(addr, params)
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	head.ReplyTimeout = time.Second
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	return head
+}
+
+func TestSinkHeadRedisCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		params core.Params
+		meta   map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "rpush with the default key",
+			params: core.Params{},
+			meta:   map[string]interface{}{MetaRedisKey: "jobs"},
+			want:   []string{"RPUSH", "jobs", "body"},
+		},
+		{
+			name:   "lpush with a key template",
+			params: core.Params{"command": "LPUSH", "key": "events:{source}:{shard}"},
+			meta:   map[string]interface{}{"source": "web", "shard": 2},
+			want:   []string{"LPUSH", "events:web:2", "body"},
+		},
+		{
+			name:   "publish with the default channel",
+			params: core.Params{"command": "publish"},
+			meta:   map[string]interface{}{MetaRedisChannel: "news"},
+			want:   []string{"PUBLISH", "news", "body"},
+		},
+		{
+			name:   "xadd",
+			params: core.Params{"command": "xadd", "key": "stream", "maxlen": 1000, "body_field": "payload"},
+			meta: map[string]interface{}{
+				MetaRedisFieldPrefix + "source":  "web",
+				MetaRedisFieldPrefix + "attempt": 1,
+				MetaRedisKey:                     "ignored",
+			},
+			want: []string{"XADD", "stream", "MAXLEN", "~", "1000", "*", "payload", "body", "attempt", "1", "source", "web"},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			addr, cmds := newRedisStub(t, func([]string) string { return ":1\r\n" })
+			head := newTestSinkHeadRedis(t, addr, testCase.params)
+			defer head.Stop()
+			msg := core.NewMessage([]byte("body"))
+			for k, v := range testCase.meta {
+				msg.SetMeta(k, v)
+			}
+			if n, err, rec := head.WriteMsg(msg); err != nil || rec || n != 4 {
+				t.Fatalf("unexpected write result: %d, %v, %t", n, err, rec)
+			}
+			if got := <-cmds; !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected command: got: %q, want: %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSinkHeadRedisReplyStatus(t *testing.T) {
+	replies := map[string]string{
+		"ok":        "+OK\r\n",
+		"int":       ":42\r\n",
+		"bulk":      "$3\r\n1-1\r\n",
+		"nil":       "$-1\r\n",
+		"oom":       "-OOM command not allowed when used memory > 'maxmemory'.\r\n",
+		"wrongtype": "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		"loading":   "-LOADING Redis is loading the dataset in memory\r\n",
+		"err":       "-ERR unknown command\r\n",
+	}
+	addr, _ := newRedisStub(t, func(args []string) string { return replies[args[1]] })
+	head := newTestSinkHeadRedis(t, addr, core.Params{})
+	defer head.Stop()
+
+	tests := []struct {
+		key        string
+		wantstatus core.MsgStatus
+	}{
+		{"ok", core.MsgStatusDone},
+		{"int", core.MsgStatusDone},
+		{"bulk", core.MsgStatusDone},
+		{"nil", core.MsgStatusDone},
+		{"oom", core.MsgStatusFailed},
+		{"wrongtype", core.MsgStatusInvalid},
+		{"loading", core.MsgStatusThrottled},
+		{"err", core.MsgStatusFailed},
+	}
+	for _, testCase := range tests {
+		msg := core.NewMessage([]byte("body"))
+		msg.SetMeta(MetaRedisKey, testCase.key)
+		_, err, rec := head.WriteMsg(msg)
+		if rec {
+			t.Fatalf("unexpected reconnect request for %q", testCase.key)
+		}
+		status := core.MsgStatusDone
+		if err != nil {
+			serr, ok := err.(*MsgStatusError)
+			if !ok {
+				t.Fatalf("unexpected error type for %q: %T", testCase.key, err)
+			}
+			status = serr.Status
+		}
+		if status != testCase.wantstatus {
+			t.Fatalf("unexpected status for %q: got: %d, want: %d", testCase.key, status, testCase.wantstatus)
+		}
+	}
+
+	// A missing key meta makes the message unroutable, nothing is sent
+	_, err, rec := head.WriteMsg(core.NewMessage([]byte("body")))
+	if serr, ok := err.(*MsgStatusError); !ok || serr.Status != core.MsgStatusUnroutable || rec {
+		t.Fatalf("unexpected write result for a message with no key: %v, %t", err, rec)
+	}
+}
+
+func TestSinkHeadRedisPipeline(t *testing.T) {
+	const nreqs = 5
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// All the commands are expected to arrive before any reply is sent
+		reader := bufio.NewReader(conn)
+		for i := 0; i < nreqs; i++ {
+			if _, err := readRESPCommand(reader, DefaultRESPMaxBulkLen); err != nil {
+				return
+			}
+		}
+		conn.Write([]byte(strings.Repeat(":1\r\n", nreqs-1) + "-OOM out of memory\r\n"))
+	}()
+
+	head, err := NewSinkHeadRedis(l.Addr().String(), core.Params{"key": "jobs"})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	head.ReplyTimeout = time.Second
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	batch := make([]*redisRequest, 0, nreqs)
+	for i := 0; i < nreqs; i++ {
+		cmd, err := head.buildCommand(core.NewMessage([]byte(fmt.Sprintf("msg-%d", i))))
+		if err != nil {
+			t.Fatalf("failed to build command: %s", err)
+		}
+		batch = append(batch, &redisRequest{cmd: cmd, resp: make(chan error, 1)})
+	}
+	head.exec(batch)
+	for i, req := range batch {
+		err := <-req.resp
+		if i < nreqs-1 && err != nil {
+			t.Fatalf("unexpected error for request %d: %s", i, err)
+		}
+		if i == nreqs-1 {
+			if serr, ok := err.(*MsgStatusError); !ok || serr.Status != core.MsgStatusFailed {
+				t.Fatalf("unexpected error for the last request: %v", err)
+			}
+		}
+	}
+}
+
+func TestSinkHeadRedisConcurrentWrites(t *testing.T) {
+	addr, cmds := newRedisStub(t, func([]string) string { return ":1\r\n" })
+	head := newTestSinkHeadRedis(t, addr, core.Params{"key": "jobs", "pipeline": 4})
+	defer head.Stop()
+
+	const nwriters = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, nwriters)
+	for i := 0; i < nwriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err, _ := head.Write([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if len(cmds) != nwriters {
+		t.Fatalf("unexpected number of commands: got: %d, want: %d", len(cmds), nwriters)
+	}
+}
+
+func TestSinkHeadRedisConnect(t *testing.T) {
+	addr, cmds := newRedisStub(t, func(args []string) string {
+		if args[0] == "AUTH" && args[1] != "secret" {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		return "+OK\r\n"
+	})
+	head := newTestSinkHeadRedis(t, addr, core.Params{"password": "secret", "db": 3})
+	defer head.Stop()
+	for _, want := range [][]string{{"AUTH", "secret"}, {"SELECT", "3"}} {
+		if got := <-cmds; !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected command: got: %q, want: %q", got, want)
+		}
+	}
+
+	addr, _ = newRedisStub(t, func(args []string) string { return "-WRONGPASS invalid username-password pair\r\n" })
+	head, err := NewSinkHeadRedis(addr, core.Params{"password": "wrong"})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	wanterr := fmt.Errorf("redis sink head: AUTH failed: WRONGPASS invalid username-password pair")
+	if err := head.Connect(); !eqErr(err, wanterr) {
+		t.Fatalf("unexpected connect error: got: %v, want: %s", err, wanterr)
+	}
+}
+
+func TestSinkHeadRedisConnLost(t *testing.T) {
+	// The stub drops the connection on the first command
+	addr, _ := newRedisStub(t, func([]string) string { return "" })
+	head := newTestSinkHeadRedis(t, addr, core.Params{"key": "jobs"})
+	defer head.Stop()
+	if _, err, rec := head.Write([]byte("body")); err == nil || !rec {
+		t.Fatalf("expected a reconnect request, got: %v, %t", err, rec)
+	}
+	if _, err, rec := head.Write([]byte("body")); err == nil || !rec {
+		t.Fatalf("expected a reconnect request, got: %v, %t", err, rec)
+	}
+}
+
+func TestReadRESPReply(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wanterr error
+	}{
+		{"+OK\r\n", "+OK", nil},
+		{"$5\r\nhello\r\n:1\r\n", "$5", nil},
+		{"*2\r\n$3\r\nabc\r\n*1\r\n:1\r\n:2\r\n", "*2", nil},
+		{"*-1\r\n", "*-1", nil},
+		{"?\r\n", "", fmt.Errorf("redis sink head: unexpected reply: \"?\"")},
+		{"$x\r\n", "", fmt.Errorf("redis sink head: malformed bulk reply: \"$x\"")},
+	}
+	for _, testCase := range tests {
+		reader := bufio.NewReader(strings.NewReader(testCase.input))
+		got, err := readRESPReply(reader)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %q: got: %v, want: %v", testCase.input, err, testCase.wanterr)
+		}
+		if string(got) != testCase.want {
+			t.Fatalf("unexpected reply for %q: got: %q, want: %q", testCase.input, got, testCase.want)
+		}
+	}
+}
+
+func TestNewSinkHeadRedisMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"command": "set"}, fmt.Errorf("redis sink head: unknown command \"set\"")},
+		{core.Params{"key": "{jobs"}, fmt.Errorf("redis sink head: malformed `key` \"{jobs\": unclosed `{` in template")},
+		{core.Params{"pipeline": 0}, fmt.Errorf("redis sink head: `pipeline` should be a positive integer, got: 0")},
+		{core.Params{"maxlen": -1}, fmt.Errorf("redis sink head: `maxlen` should be a non-negative integer, got: -1")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewSinkHeadRedis("127.0.0.1:6379", testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %v, want: %s", err, testCase.wanterr)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package actor
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	SyslogFramingOctetCounting = "octet_counting"
+	SyslogFramingLF            = "lf"
+
+	SyslogDefaultFacility = "user"
+	SyslogDefaultSeverity = "notice"
+	SyslogDefaultAppName  = "flowd"
+)
+
+// SinkHeadSyslog builds syslog frames from the message body and the syslog.*
+// meta attributes (see SyslogMessage.SetMeta) and sends them over UDP, TCP
+// or TLS. The attributes missing in the message meta fall back to the
+// `facility`, `severity`, `hostname` and `app_name` params.
+type SinkHeadSyslog struct {
+	addr      string
+	transport string
+	format    string
+	framing   string
+	tlsconf   *tls.Config
+	facility  int
+	severity  int
+	hostname  string
+	appname   string
+	timefun   func() time.Time
+	conn      net.Conn
+	lock      sync.Mutex
+
+	ConnectTimeout time.Duration
+}
+
+var _ (SinkHead) = (*SinkHeadSyslog)(nil)
+var _ (MsgSinkHead) = (*SinkHeadSyslog)(nil)
+
+func NewSinkHeadSyslog(addr string, params core.Params) (*SinkHeadSyslog, error) {
+	h := &SinkHeadSyslog{
+		addr:           addr,
+		transport:      SyslogTransportUDP,
+		format:         SyslogFormatRFC5424,
+		framing:        SyslogFramingOctetCounting,
+		appname:        SyslogDefaultAppName,
+		timefun:        time.Now,
+		ConnectTimeout: TCPConnTimeout,
+	}
+	if v, ok := params["transport"]; ok {
+		h.transport = v.(string)
+	}
+	switch h.transport {
+	case SyslogTransportUDP, SyslogTransportTCP:
+	case SyslogTransportTLS:
+		tlsconf, err := buildClientTLSConfig(params)
+		if err != nil {
+			return nil, fmt.Errorf("syslog sink head: failed to configure tls: %s", err)
+		}
+		if len(tlsconf.ServerName) == 0 {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				tlsconf.ServerName = host
+			}
+		}
+		h.tlsconf = tlsconf
+	default:
+		return nil, fmt.Errorf("syslog sink head: unknown transport %q", h.transport)
+	}
+	if v, ok := params["format"]; ok {
+		h.format = v.(string)
+	}
+	if h.format != SyslogFormatRFC5424 && h.format != SyslogFormatRFC3164 {
+		return nil, fmt.Errorf("syslog sink head: unknown format %q", h.format)
+	}
+	if v, ok := params["framing"]; ok {
+		h.framing = v.(string)
+	}
+	if h.framing != SyslogFramingOctetCounting && h.framing != SyslogFramingLF {
+		return nil, fmt.Errorf("syslog sink head: unknown framing %q", h.framing)
+	}
+
+	facility := SyslogDefaultFacility
+	if v, ok := params["facility"]; ok {
+		facility = v.(string)
+	}
+	var ok bool
+	if h.facility, ok = syslogCode(SyslogFacilities, facility); !ok {
+		return nil, fmt.Errorf("syslog sink head: unknown facility %q", facility)
+	}
+	severity := SyslogDefaultSeverity
+	if v, ok := params["severity"]; ok {
+		severity = v.(string)
+	}
+	if h.severity, ok = syslogCode(SyslogSeverities, severity); !ok {
+		return nil, fmt.Errorf("syslog sink head: unknown severity %q", severity)
+	}
+
+	if v, ok := params["hostname"]; ok {
+		h.hostname = v.(string)
+	} else if hostname, err := os.Hostname(); err == nil {
+		h.hostname = hostname
+	}
+	if v, ok := params["app_name"]; ok {
+		h.appname = v.(string)
+	}
+
+	return h, nil
+}
+
+// syslogCode looks up a facility or a severity code by name. Numeric codes
+// are accepted as well.
+func syslogCode(names []string, v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, v >= 0 && v < len(names)
+	case string:
+		for code, name := range names {
+			if name == v {
+				return code, true
+			}
+		}
+		if code, err := strconv.Atoi(v); err == nil {
+			return code, code >= 0 && code < len(names)
+		}
+	}
+	return 0, false
+}
+
+func (h *SinkHeadSyslog) Connect() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var conn net.Conn
+	var err error
+	switch h.transport {
+	case SyslogTransportUDP:
+		conn, err = net.DialTimeout("udp", h.addr, h.ConnectTimeout)
+	case SyslogTransportTCP:
+		conn, err = net.DialTimeout("tcp", h.addr, h.ConnectTimeout)
+	case SyslogTransportTLS:
+		dialer := &net.Dialer{Timeout: h.ConnectTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", h.addr, h.tlsconf)
+	}
+	if err != nil {
+		return err
+	}
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.conn = conn
+
+	return nil
+}
+
+func (h *SinkHeadSyslog) Start() error {
+	return nil
+}
+
+func (h *SinkHeadSyslog) Stop() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn != nil {
+		return h.conn.Close()
+	}
+	return nil
+}
+
+// buildMessage merges the message meta with the configured defaults.
+func (h *SinkHeadSyslog) buildMessage(msg *core.Message) *SyslogMessage {
+	facility, severity := h.facility, h.severity
+	if v, ok := msg.Meta(MetaSyslogPriority); ok {
+		if pri, ok := v.(int); ok && pri >= 0 && pri <= syslogMaxPri {
+			facility, severity = pri/8, pri%8
+		}
+	}
+	if v, ok := msg.Meta(MetaSyslogFacility); ok {
+		if code, ok := syslogCode(SyslogFacilities, v); ok {
+			facility = code
+		}
+	}
+	if v, ok := msg.Meta(MetaSyslogSeverity); ok {
+		if code, ok := syslogCode(SyslogSeverities, v); ok {
+			severity = code
+		}
+	}
+
+	sm := &SyslogMessage{
+		Priority:  facility*8 + severity,
+		Timestamp: h.timefun(),
+		Hostname:  h.hostname,
+		AppName:   h.appname,
+		Message:   msg.Body(),
+	}
+	if v, ok := msg.Meta(MetaSyslogTimestamp); ok {
+		if ts, ok := v.(time.Time); ok {
+			sm.Timestamp = ts
+		}
+	}
+	for k, field := range map[string]*string{
+		MetaSyslogHostname: &sm.Hostname,
+		MetaSyslogAppName:  &sm.AppName,
+		MetaSyslogProcID:   &sm.ProcID,
+		MetaSyslogMsgID:    &sm.MsgID,
+	} {
+		if v, ok := msg.Meta(k); ok {
+			if s, ok := v.(string); ok && len(s) > 0 {
+				*field = s
+			}
+		}
+	}
+	prefix := MetaSyslogStructuredData + "."
+	for _, k := range msg.MetaKeys() {
+		key, ok := k.(string)
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// The SD-ID might contain dots (e.g. an IP-based enterprise ID),
+		// the param name can not.
+		ix := strings.LastIndexByte(key, '.')
+		if ix <= len(prefix) {
+			continue
+		}
+		id, name := key[len(prefix):ix], key[ix+1:]
+		v, _ := msg.Meta(k)
+		if sm.StructuredData == nil {
+			sm.StructuredData = make(map[string]map[string]string)
+		}
+		if _, ok := sm.StructuredData[id]; !ok {
+			sm.StructuredData[id] = make(map[string]string)
+		}
+		sm.StructuredData[id][name] = fmt.Sprintf("%v", v)
+	}
+
+	return sm
+}
+
+func (h *SinkHeadSyslog) frame(sm *SyslogMessage) []byte {
+	var data []byte
+	if h.format == SyslogFormatRFC3164 {
+		data = sm.Format3164()
+	} else {
+		data = sm.Format5424()
+	}
+	if h.transport == SyslogTransportUDP {
+		return data
+	}
+	if h.framing == SyslogFramingLF {
+		return append(data, '\n')
+	}
+	return append([]byte(strconv.Itoa(len(data))+" "), data...)
+}
+
+func (h *SinkHeadSyslog) WriteMsg(msg *core.Message) (int, error, bool) {
+	return h.write(h.frame(h.buildMessage(msg)))
+}
+
+// Write sends the data as a message body with the default header fields.
+func (h *SinkHeadSyslog) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
+
+func (h *SinkHeadSyslog) write(data []byte) (int, error, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.conn == nil {
+		return 0, fmt.Errorf("syslog sink head conn is nil"), true
+	}
+	n, err := h.conn.Write(data)
+	if err != nil {
+		h.conn.Close()
+		h.conn = nil
+		return n, err, true
+	}
+	return n, nil, false
+}
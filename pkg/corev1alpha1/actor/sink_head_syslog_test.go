@@ -0,0 +1,197 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func TestSinkHeadSyslogFrame(t *testing.T) {
+	ts := time.Date(2019, time.March, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name   string
+		params core.Params
+		meta   map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "defaults",
+			params: core.Params{"hostname": "flowhost"},
+			want:   "<13>1 2019-03-04T05:06:07Z flowhost flowd - - - body",
+		},
+		{
+			name: "meta overrides defaults",
+			params: core.Params{
+				"hostname": "flowhost",
+				"facility": "local3",
+				"severity": "err",
+			},
+			meta: map[string]interface{}{
+				MetaSyslogFacility:                           "auth",
+				MetaSyslogAppName:                            "sshd",
+				MetaSyslogProcID:                             "42",
+				MetaSyslogStructuredData + ".origin.ip":      "10.0.0.1",
+				MetaSyslogStructuredData + ".x@1.2.3.4.path": `C:\tmp [1]`,
+			},
+			want: `<35>1 2019-03-04T05:06:07Z flowhost sshd 42 - [origin ip="10.0.0.1"][x@1.2.3.4 path="C:\\tmp [1\]"] body`,
+		},
+		{
+			name:   "priority meta",
+			params: core.Params{"hostname": "flowhost"},
+			meta:   map[string]interface{}{MetaSyslogPriority: 165},
+			want:   "<165>1 2019-03-04T05:06:07Z flowhost flowd - - - body",
+		},
+		{
+			name:   "rfc3164 over tcp with lf framing",
+			params: core.Params{"hostname": "flowhost", "format": "rfc3164", "transport": "tcp", "framing": "lf"},
+			meta:   map[string]interface{}{MetaSyslogProcID: "42", MetaSyslogSeverity: "debug"},
+			want:   "<15>Mar  4 05:06:07 flowhost flowd[42]: body\n",
+		},
+		{
+			name:   "octet counting",
+			params: core.Params{"hostname": "h", "transport": "tcp", "app_name": "app"},
+			want:   "43 <13>1 2019-03-04T05:06:07Z h app - - - body",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			head, err := NewSinkHeadSyslog("127.0.0.1:514", testCase.params)
+			if err != nil {
+				t.Fatalf("failed to create sink head: %s", err)
+			}
+			head.timefun = func() time.Time { return ts }
+			msg := core.NewMessage([]byte("body"))
+			for k, v := range testCase.meta {
+				msg.SetMeta(k, v)
+			}
+			if got := string(head.frame(head.buildMessage(msg))); got != testCase.want {
+				t.Fatalf("unexpected frame: got: %q, want: %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSinkHeadSyslogRoundTrip(t *testing.T) {
+	want := &SyslogMessage{
+		Priority:       86,
+		Version:        1,
+		Timestamp:      time.Date(2019, time.March, 4, 5, 6, 7, 8000, time.UTC),
+		Hostname:       "host",
+		AppName:        "app",
+		ProcID:         "1",
+		MsgID:          "ID1",
+		StructuredData: map[string]map[string]string{"a": {"b": `"quoted" \ ]`}},
+		Message:        []byte("body"),
+	}
+	got, err := ParseSyslog(want.Format5424(), time.Now())
+	if err != nil {
+		t.Fatalf("failed to parse formatted message: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected message: got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestSinkHeadSyslogTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-sink-head-syslog")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile := newTestCert(t, dir)
+
+	rcv, mailbox := newTestSyslogReceiver(t, core.Params{
+		"bind":      "127.0.0.1:0",
+		"transport": "tls",
+		"tls_cert":  certfile,
+		"tls_key":   keyfile,
+		"tls_ca":    certfile,
+	})
+	defer rcv.Stop()
+
+	head, err := NewSinkHeadSyslog(syslogLocalAddr(rcv), core.Params{
+		"transport": "tls",
+		"tls_ca":    certfile,
+		"tls_cert":  certfile,
+		"tls_key":   keyfile,
+		"hostname":  "flowhost",
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer head.Stop()
+
+	for _, body := range []string{"first\nline", "second"} {
+		msg := core.NewMessage([]byte(body))
+		msg.SetMeta(MetaSyslogFacility, "local7")
+		if _, err, _ := head.WriteMsg(msg); err != nil {
+			t.Fatalf("failed to write message: %s", err)
+		}
+	}
+
+	expectSyslogMessages(t, mailbox, []map[string]interface{}{
+		{"body": "first\nline", MetaSyslogFacility: "local7", MetaSyslogHostname: "flowhost"},
+		{"body": "second", MetaSyslogFacility: "local7", MetaSyslogAppName: "flowd"},
+	})
+}
+
+func TestSinkHeadSyslogUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	head, err := NewSinkHeadSyslog(conn.LocalAddr().String(), core.Params{"hostname": "h"})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer head.Stop()
+	head.timefun = func() time.Time { return time.Unix(0, 0).UTC() }
+	if _, err, _ := head.Write([]byte("body")); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := bufio.NewReader(conn).Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read packet: %s", err)
+	}
+	if want := "<13>1 1970-01-01T00:00:00Z h flowd - - - body"; string(buf[:n]) != want {
+		t.Fatalf("unexpected packet: got: %q, want: %q", buf[:n], want)
+	}
+}
+
+func TestNewSinkHeadSyslogMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"transport": "sctp"}, fmt.Errorf("syslog sink head: unknown transport \"sctp\"")},
+		{core.Params{"format": "json"}, fmt.Errorf("syslog sink head: unknown format \"json\"")},
+		{core.Params{"framing": "nul"}, fmt.Errorf("syslog sink head: unknown framing \"nul\"")},
+		{core.Params{"facility": "local8"}, fmt.Errorf("syslog sink head: unknown facility \"local8\"")},
+		{core.Params{"severity": "fatal"}, fmt.Errorf("syslog sink head: unknown severity \"fatal\"")},
+		{core.Params{"transport": "tls", "tls_cert": "cert.pem"}, fmt.Errorf("syslog sink head: failed to configure tls: `tls_cert` and `tls_key` must be set together")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewSinkHeadSyslog("127.0.0.1:514", testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+		}
+	}
+}
@@ -23,6 +23,8 @@ type SinkHeadTCP struct {
 
 	ConnectTimeout time.Duration
 	WriteTimeout   time.Duration
+	// Delimiter is appended to every write, "\r\n" by default.
+	Delimiter []byte
 }
 
 var _ (SinkHead) = (*SinkHeadTCP)(nil)
@@ -32,6 +34,7 @@ func NewSinkHeadTCP(tcpaddr *net.TCPAddr) (*SinkHeadTCP, error) {
 		addr:           tcpaddr,
 		connbuilder:    DefaultTCPConnBuilder,
 		ConnectTimeout: TCPConnTimeout,
+		Delimiter:      []byte("\r\n"),
 	}, nil
 }
 
@@ -61,9 +64,9 @@ func (h *SinkHeadTCP) Write(data []byte) (int, error, bool) {
 		return 0, fmt.Errorf("tcp sink head conn is nil"), true
 	}
 	l := len(data)
-	buf := make([]byte, l+2)
+	buf := make([]byte, l+len(h.Delimiter))
 	copy(buf, data)
-	copy(buf[l:], []byte("\r\n"))
+	copy(buf[l:], h.Delimiter)
 	rec := false
 	n, err := h.conn.Write(buf)
 	if err != nil {
@@ -0,0 +1,244 @@
+package actor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	DefaultWSSubscriberBuffer = 256
+)
+
+type wsSubscriber struct {
+	conn   net.Conn
+	filter url.Values
+	out    chan []byte
+	lock   sync.Mutex
+	closed bool
+}
+
+// matches tells if every filter key is present in the message meta and its
+// value is one of the filter values.
+func (s *wsSubscriber) matches(msg *core.Message) bool {
+	for key, values := range s.filter {
+		v, ok := msg.Meta(key)
+		if !ok {
+			return false
+		}
+		str := fmt.Sprintf("%v", v)
+		found := false
+		for _, value := range values {
+			if value == str {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *wsSubscriber) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.out)
+	}
+}
+
+// send enqueues the frame and tells if it was not dropped.
+func (s *wsSubscriber) send(frame []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.out <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+// SinkHeadWebSocket is a live-tail sink head: it accepts WebSocket
+// subscribers on `host:port[/path]` and broadcasts every message body to
+// them. Valid UTF-8 bodies are sent as text frames, the rest as binary
+// ones. Subscribers might narrow the stream down with query parameters
+// matching the message meta, e.g. `/tail?source=web&level=error`.
+// Broadcasting is best-effort: messages are not retained, a subscriber
+// falling more than `buffer` messages behind misses the overflowing ones.
+type SinkHeadWebSocket struct {
+	addr     string
+	path     string
+	buffer   int
+	listener net.Listener
+	subs     map[*wsSubscriber]struct{}
+	lock     sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ (SinkHead) = (*SinkHeadWebSocket)(nil)
+var _ (MsgSinkHead) = (*SinkHeadWebSocket)(nil)
+
+func NewSinkHeadWebSocket(bind string, params core.Params) (*SinkHeadWebSocket, error) {
+	addr, path := splitWSBind(bind)
+	h := &SinkHeadWebSocket{
+		addr:   addr,
+		path:   path,
+		buffer: DefaultWSSubscriberBuffer,
+		subs:   make(map[*wsSubscriber]struct{}),
+		done:   make(chan struct{}),
+	}
+	if v, ok := params["buffer"]; ok {
+		if h.buffer = v.(int); h.buffer <= 0 {
+			return nil, fmt.Errorf("websocket sink head: `buffer` should be a positive integer, got: %d", h.buffer)
+		}
+	}
+	return h, nil
+}
+
+// Connect starts listening for subscribers, it's a no-op once the
+// listener is up.
+func (h *SinkHeadWebSocket) Connect() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.listener != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", h.addr)
+	if err != nil {
+		return err
+	}
+	h.listener = l
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			h.wg.Add(1)
+			go h.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func (h *SinkHeadWebSocket) Start() error {
+	return nil
+}
+
+func (h *SinkHeadWebSocket) Stop() error {
+	close(h.done)
+	h.lock.Lock()
+	if h.listener != nil {
+		h.listener.Close()
+	}
+	for sub := range h.subs {
+		sub.conn.Close()
+	}
+	h.lock.Unlock()
+	h.wg.Wait()
+	return nil
+}
+
+func (h *SinkHeadWebSocket) serve(conn net.Conn) {
+	defer h.wg.Done()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	req, err := wsHandshake(conn, reader, h.path)
+	if err != nil {
+		return
+	}
+	sub := &wsSubscriber{
+		conn:   conn,
+		filter: req.URL.Query(),
+		out:    make(chan []byte, h.buffer),
+	}
+	h.lock.Lock()
+	select {
+	case <-h.done:
+		h.lock.Unlock()
+		return
+	default:
+	}
+	h.subs[sub] = struct{}{}
+	h.lock.Unlock()
+	defer func() {
+		h.lock.Lock()
+		delete(h.subs, sub)
+		h.lock.Unlock()
+		sub.close()
+	}()
+
+	writeover := make(chan struct{})
+	go func() {
+		defer close(writeover)
+		for frame := range sub.out {
+			conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+			if _, err := conn.Write(frame); err != nil {
+				conn.Close()
+				// Draining the queue until the subscriber is
+				// unregistered
+				for range sub.out {
+				}
+				return
+			}
+		}
+	}()
+
+	// Subscribers are not expected to send anything but control frames
+	write := func(frame []byte) error {
+		if !sub.send(frame) {
+			return fmt.Errorf("websocket: subscriber queue is full")
+		}
+		return nil
+	}
+	for {
+		if _, _, err := readWSMessage(reader, DefaultWSMaxMessageSize, write); err != nil {
+			if err == io.EOF {
+				// Letting the close frame out
+				sub.close()
+				<-writeover
+			}
+			return
+		}
+	}
+}
+
+// WriteMsg broadcasts the message to the matching subscribers. It never
+// fails: having no subscribers is not an error for a live tail.
+func (h *SinkHeadWebSocket) WriteMsg(msg *core.Message) (int, error, bool) {
+	opcode := byte(wsOpText)
+	if !utf8.Valid(msg.Body()) {
+		opcode = wsOpBinary
+	}
+	frame := encodeWSFrame(opcode, msg.Body())
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for sub := range h.subs {
+		if sub.matches(msg) {
+			sub.send(frame)
+		}
+	}
+	return len(msg.Body()), nil, false
+}
+
+func (h *SinkHeadWebSocket) Write(data []byte) (int, error, bool) {
+	return h.WriteMsg(core.NewMessage(data))
+}
@@ -0,0 +1,134 @@
+package actor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+func TestSinkHeadWebSocket(t *testing.T) {
+	head, err := NewSinkHeadWebSocket("127.0.0.1:0/tail", core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	defer head.Stop()
+	for i := 0; i < 2; i++ {
+		// Reconnects keep the listener
+		if err := head.Connect(); err != nil {
+			t.Fatalf("failed to connect: %s", err)
+		}
+	}
+	addr := head.listener.Addr().String()
+
+	// Writes with no subscribers succeed
+	if _, err, rec := head.Write([]byte("nobody listens")); err != nil || rec {
+		t.Fatalf("unexpected write result: %v, %t", err, rec)
+	}
+
+	all, allreader := dialWS(t, addr, "/tail")
+	defer all.Close()
+	web, webreader := dialWS(t, addr, "/tail?source=web&level=warn&level=error")
+	defer web.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		head.lock.Lock()
+		nsubs := len(head.subs)
+		head.lock.Unlock()
+		if nsubs == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the subscribers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msgs := []struct {
+		body string
+		meta map[string]interface{}
+	}{
+		{"web error", map[string]interface{}{"source": "web", "level": "error"}},
+		{"web info", map[string]interface{}{"source": "web", "level": "info"}},
+		{"\xff binary", map[string]interface{}{"source": "api"}},
+		{"web warn", map[string]interface{}{"source": "web", "level": "warn"}},
+	}
+	for _, m := range msgs {
+		msg := core.NewMessage([]byte(m.body))
+		for k, v := range m.meta {
+			msg.SetMeta(k, v)
+		}
+		if n, err, rec := head.WriteMsg(msg); n != len(m.body) || err != nil || rec {
+			t.Fatalf("unexpected write result: %d, %v, %t", n, err, rec)
+		}
+	}
+	for _, want := range []struct {
+		opcode byte
+		body   string
+	}{{wsOpText, "web error"}, {wsOpText, "web info"}, {wsOpBinary, "\xff binary"}, {wsOpText, "web warn"}} {
+		if opcode, body := readWSServerFrame(t, allreader); opcode != want.opcode || string(body) != want.body {
+			t.Fatalf("unexpected frame: got: %d %q, want: %d %q", opcode, body, want.opcode, want.body)
+		}
+	}
+	for _, want := range []string{"web error", "web warn"} {
+		if _, body := readWSServerFrame(t, webreader); string(body) != want {
+			t.Fatalf("unexpected filtered frame: got: %q, want: %q", body, want)
+		}
+	}
+
+	// A closing subscriber is unregistered
+	web.Write(wsClientFrame(true, wsOpClose, nil))
+	if opcode, _ := readWSServerFrame(t, webreader); opcode != wsOpClose {
+		t.Fatalf("unexpected close reply opcode: %d", opcode)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		head.lock.Lock()
+		nsubs := len(head.subs)
+		head.lock.Unlock()
+		if nsubs == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the subscriber to leave")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSinkHeadWebSocketSlowSubscriber(t *testing.T) {
+	head, err := NewSinkHeadWebSocket("127.0.0.1:0", core.Params{"buffer": 1})
+	if err != nil {
+		t.Fatalf("failed to create sink head: %s", err)
+	}
+	if err := head.Connect(); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer head.Stop()
+	conn, _ := dialWS(t, head.listener.Addr().String(), "/")
+	defer conn.Close()
+	// The subscriber never reads, writes should not block
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			head.Write([]byte(fmt.Sprintf("message %d with some padding to fill the socket buffers", i)))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes are blocked by a slow subscriber")
+	}
+}
+
+func TestNewSinkHeadWebSocketMalformed(t *testing.T) {
+	_, err := NewSinkHeadWebSocket("127.0.0.1:0", core.Params{"buffer": 0})
+	if want := fmt.Errorf("websocket sink head: `buffer` should be a positive integer, got: 0"); !eqErr(err, want) {
+		t.Fatalf("unexpected error: got: %v, want: %s", err, want)
+	}
+}
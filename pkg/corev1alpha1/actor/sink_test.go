@@ -142,3 +142,170 @@ func TestSinkReceive(t *testing.T) {
 		t.Fatalf("unexpected buf contents: got: %q, want: %q", gotdata, wantdata)
 	}
 }
+
+func TestSinkPause(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		cfg.SystemMaxprocs: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to initialise context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	conn := newTestConn(
+		newTestAddr("tcp", "127.0.0.1:12345"),
+		newTestAddr("tcp", "127.0.0.1:23456"),
+	)
+	builder := func(addr *net.TCPAddr, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	sink, err := NewSink("sink", ctx, core.Params{
+		"bind":         "tcp://127.0.0.1:12345",
+		"pause_queue":  2,
+		"pause_status": "unroutable",
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize sink: %s", err)
+	}
+	sink.(*Sink).head.(*SinkHeadTCP).connbuilder = builder
+	if err := sink.Start(); err != nil {
+		t.Fatalf("failed to start sink: %s", err)
+	}
+	defer sink.Stop()
+
+	// Draining an idle sink pauses it right away
+	if err := sink.(*Sink).Drain(time.Second); err != nil {
+		t.Fatalf("failed to drain sink: %s", err)
+	}
+	msgs := []*core.Message{
+		core.NewMessage([]byte("first")),
+		core.NewMessage([]byte("second")),
+		core.NewMessage([]byte("third")),
+	}
+	for _, msg := range msgs {
+		if err := sink.Receive(msg); err != nil {
+			t.Fatalf("failed to send message: %s", err)
+		}
+	}
+	// The queue is full by the third message
+	if s := msgs[2].Await(); s != core.MsgStatusUnroutable {
+		t.Fatalf("unexpected status of the overflowing message: got: %d, want: %d", s, core.MsgStatusUnroutable)
+	}
+	if got := sink.(*Sink).QueueLen(); got != 2 {
+		t.Fatalf("unexpected queue length: got: %d, want: 2", got)
+	}
+	conn.lock.Lock()
+	written := len(conn.buf)
+	conn.lock.Unlock()
+	if written != 0 {
+		t.Fatalf("paused sink has written %d bytes", written)
+	}
+
+	if err := sink.(*Sink).Resume(); err != nil {
+		t.Fatalf("failed to resume sink: %s", err)
+	}
+	for _, msg := range msgs[:2] {
+		if s := msg.Await(); s != core.MsgStatusDone {
+			t.Fatalf("unexpected status of a held message: got: %d, want: %d", s, core.MsgStatusDone)
+		}
+	}
+	if got := sink.(*Sink).QueueLen(); got != 0 {
+		t.Fatalf("unexpected queue length: got: %d, want: 0", got)
+	}
+}
+
+func TestNewSinkCfgPause(t *testing.T) {
+	tests := []struct {
+		params     core.Params
+		wantqueue  int
+		wantstatus core.MsgStatus
+		wanterr    error
+	}{
+		{core.Params{}, 0, core.MsgStatusThrottled, nil},
+		{core.Params{"pause_queue": 16, "pause_status": "failed"}, 16, core.MsgStatusFailed, nil},
+		{core.Params{"pause_queue": -1}, 0, 0, fmt.Errorf("`pause_queue` should be a non-negative integer, got: -1")},
+		{core.Params{"pause_status": "done"}, 0, 0, fmt.Errorf("unknown `pause_status`: done")},
+	}
+	for _, testCase := range tests {
+		cfg, err := NewSinkCfg(testCase.params)
+		if !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error for %v: got: %v, want: %v", testCase.params, err, testCase.wanterr)
+		}
+		if err != nil {
+			continue
+		}
+		if cfg.pausequeue != testCase.wantqueue || cfg.pausestatus != testCase.wantstatus {
+			t.Fatalf("unexpected pause config for %v: got: %d %d, want: %d %d", testCase.params,
+				cfg.pausequeue, cfg.pausestatus, testCase.wantqueue, testCase.wantstatus)
+		}
+	}
+}
+
+type blockingSinkHead struct {
+	*testSinkHead
+	unblock chan struct{}
+}
+
+func (h *blockingSinkHead) Write(data []byte) (int, error, bool) {
+	<-h.unblock
+	return h.testSinkHead.Write(data)
+}
+
+func TestSinkDrainTimeout(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to initialise context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	sink, err := NewSink("sink", ctx, core.Params{
+		"bind":        "tcp://127.0.0.1:12345",
+		"pause_queue": 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize sink: %s", err)
+	}
+	head := &blockingSinkHead{
+		testSinkHead: newTestSinkHead("tcp://127.0.0.1:12345"),
+		unblock:      make(chan struct{}),
+	}
+	sink.(*Sink).head = head
+	if err := sink.Start(); err != nil {
+		t.Fatalf("failed to start sink: %s", err)
+	}
+	defer sink.Stop()
+
+	inflight := core.NewMessage([]byte("inflight"))
+	if err := sink.Receive(inflight); err != nil {
+		t.Fatalf("failed to send message: %s", err)
+	}
+	if err := sink.(*Sink).Drain(20 * time.Millisecond); err != core.DrainTimedOutErr {
+		t.Fatalf("unexpected drain error: got: %v, want: %v", err, core.DrainTimedOutErr)
+	}
+	// The sink stays paused after the timeout
+	held := core.NewMessage([]byte("held"))
+	if err := sink.Receive(held); err != nil {
+		t.Fatalf("failed to send message: %s", err)
+	}
+	if got := sink.(*Sink).QueueLen(); got != 1 {
+		t.Fatalf("unexpected queue length: got: %d, want: 1", got)
+	}
+
+	close(head.unblock)
+	if err := sink.(*Sink).Drain(time.Second); err != nil {
+		t.Fatalf("failed to drain sink: %s", err)
+	}
+	if s := inflight.Await(); s != core.MsgStatusDone {
+		t.Fatalf("unexpected status of the in-flight message: got: %d, want: %d", s, core.MsgStatusDone)
+	}
+	if err := sink.(*Sink).Resume(); err != nil {
+		t.Fatalf("failed to resume sink: %s", err)
+	}
+	if s := held.Await(); s != core.MsgStatusDone {
+		t.Fatalf("unexpected status of the held message: got: %d, want: %d", s, core.MsgStatusDone)
+	}
+}
@@ -0,0 +1,98 @@
+package actor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	StatsdCounter      = "c"
+	StatsdGauge        = "g"
+	StatsdTimer        = "ms"
+	StatsdHistogram    = "h"
+	StatsdDistribution = "d"
+	StatsdSet          = "s"
+
+	MetaStatsdMetric = "statsd.metric"
+)
+
+// StatsdMetric is a single parsed statsd sample:
+// `<name>:<value>|<type>[|@<sample rate>][|#<tag>,<tag>:<value>]`.
+// The latter is the DogStatsD tags extension.
+type StatsdMetric struct {
+	Name  string
+	Type  string
+	Value float64
+	// SetValue holds the raw value of a set member.
+	SetValue string
+	// Delta is true for gauge values with an explicit sign: +N or -N
+	// modify the current gauge value instead of replacing it.
+	Delta bool
+	Rate  float64
+	Tags  []string
+}
+
+// Key identifies the metric series: the name and the sorted tags.
+func (m *StatsdMetric) Key() string {
+	if len(m.Tags) == 0 {
+		return m.Name
+	}
+	return m.Name + "|" + strings.Join(m.Tags, ",")
+}
+
+// ParseStatsd parses a single statsd line.
+func ParseStatsd(line []byte) (*StatsdMetric, error) {
+	colon := bytes.IndexByte(line, ':')
+	if colon <= 0 {
+		return nil, fmt.Errorf("statsd: missing metric name in %q", line)
+	}
+	m := &StatsdMetric{Name: string(line[:colon]), Rate: 1.0}
+	parts := strings.Split(string(line[colon+1:]), "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("statsd: missing metric type in %q", line)
+	}
+	value := parts[0]
+	m.Type = parts[1]
+	switch m.Type {
+	case StatsdSet:
+		if len(value) == 0 {
+			return nil, fmt.Errorf("statsd: empty set value in %q", line)
+		}
+		m.SetValue = value
+	case StatsdCounter, StatsdGauge, StatsdTimer, StatsdHistogram, StatsdDistribution:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("statsd: malformed value in %q", line)
+		}
+		m.Value = v
+		m.Delta = m.Type == StatsdGauge && (value[0] == '+' || value[0] == '-')
+	default:
+		return nil, fmt.Errorf("statsd: unknown metric type %q", m.Type)
+	}
+	for _, part := range parts[2:] {
+		if len(part) == 0 {
+			continue
+		}
+		switch part[0] {
+		case '@':
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("statsd: malformed sample rate in %q", line)
+			}
+			m.Rate = rate
+		case '#':
+			for _, tag := range strings.Split(part[1:], ",") {
+				if len(tag) > 0 {
+					m.Tags = append(m.Tags, tag)
+				}
+			}
+			sort.Strings(m.Tags)
+		}
+		// Other extensions (e.g. DogStatsD container ID) are ignored
+	}
+
+	return m, nil
+}
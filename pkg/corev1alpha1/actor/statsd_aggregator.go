@@ -0,0 +1,424 @@
+package actor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	StatsdFormatGraphite   = "graphite"
+	StatsdFormatPrometheus = "prometheus"
+
+	DefaultStatsdFlushInterval = 10 * time.Second
+)
+
+var DefaultStatsdPercentiles = []float64{90}
+
+type statsdSeries struct {
+	name   string
+	tags   []string
+	value  float64
+	values []float64
+	set    map[string]struct{}
+}
+
+// StatsdAggregator rolls up statsd metrics (see NewReceiverStatsd) and flushes
+// the aggregates every `flush_interval` milliseconds. Every aggregate
+// becomes a separate message in either Graphite plaintext
+// (`path value timestamp`) or Prometheus text (`name{labels} value
+// timestamp`) format, see `format`.
+// The aggregates are:
+//   - counters: <name>.count and <name>.rate (per second), sample rates are
+//     taken into account;
+//   - gauges: <name>, the last value is kept between the flushes;
+//   - timers, histograms and distributions: <name>.count, .sum, .mean,
+//     .lower, .upper and .upper_<N> for every configured `percentiles` N;
+//   - sets: <name>.count, the number of unique values.
+//
+// Messages missing the statsd.metric meta attribute are parsed from the
+// body.
+type StatsdAggregator struct {
+	name        string
+	ctx         *core.Context
+	interval    time.Duration
+	format      string
+	prefix      string
+	percentiles []float64
+	timefun     func() time.Time
+	counters    map[string]*statsdSeries
+	gauges      map[string]*statsdSeries
+	timers      map[string]*statsdSeries
+	sets        map[string]*statsdSeries
+	lock        sync.Mutex
+	queue       chan *core.Message
+	done        chan struct{}
+	wgflush     sync.WaitGroup
+	wgpeer      sync.WaitGroup
+}
+
+var _ core.Actor = (*StatsdAggregator)(nil)
+
+func NewStatsdAggregator(name string, ctx *core.Context, params core.Params) (core.Actor, error) {
+	a := &StatsdAggregator{
+		name:        name,
+		ctx:         ctx,
+		interval:    DefaultStatsdFlushInterval,
+		format:      StatsdFormatGraphite,
+		percentiles: DefaultStatsdPercentiles,
+		timefun:     time.Now,
+		queue:       make(chan *core.Message),
+		done:        make(chan struct{}),
+	}
+	a.reset()
+	if v, ok := params["flush_interval"]; ok {
+		interval := v.(int)
+		if interval <= 0 {
+			return nil, fmt.Errorf("statsd aggregator %q `flush_interval` should be a positive integer, got: %d", name, interval)
+		}
+		a.interval = time.Duration(interval) * time.Millisecond
+	}
+	if v, ok := params["format"]; ok {
+		a.format = v.(string)
+	}
+	if a.format != StatsdFormatGraphite && a.format != StatsdFormatPrometheus {
+		return nil, fmt.Errorf("statsd aggregator %q got an unknown `format`: %q", name, a.format)
+	}
+	if v, ok := params["prefix"]; ok {
+		a.prefix = v.(string)
+	}
+	if v, ok := params["percentiles"]; ok {
+		pcts, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("statsd aggregator %q got malformed `percentiles`: unexpected value type: %T", name, v)
+		}
+		a.percentiles = nil
+		for _, p := range pcts {
+			pct, err := toPercentile(p)
+			if err != nil {
+				return nil, fmt.Errorf("statsd aggregator %q got a malformed percentile: %v", name, err)
+			}
+			a.percentiles = append(a.percentiles, pct)
+		}
+	}
+
+	return a, nil
+}
+
+// toPercentile accepts a percentile either as a YAML number or a string and
+// checks it falls into (0, 100].
+func toPercentile(v interface{}) (float64, error) {
+	var pct float64
+	switch vv := v.(type) {
+	case int:
+		pct = float64(vv)
+	case float64:
+		pct = vv
+	case string:
+		var err error
+		if pct, err = strconv.ParseFloat(vv, 64); err != nil {
+			return 0, fmt.Errorf("%v", vv)
+		}
+	default:
+		return 0, fmt.Errorf("unexpected value type: %T", v)
+	}
+	if pct <= 0 || pct > 100 {
+		return 0, fmt.Errorf("%v", v)
+	}
+	return pct, nil
+}
+
+func (a *StatsdAggregator) reset() {
+	a.counters = make(map[string]*statsdSeries)
+	a.timers = make(map[string]*statsdSeries)
+	a.sets = make(map[string]*statsdSeries)
+	if a.gauges == nil {
+		a.gauges = make(map[string]*statsdSeries)
+	}
+}
+
+func (a *StatsdAggregator) Name() string {
+	return a.name
+}
+
+func (a *StatsdAggregator) Start() error {
+	a.wgflush.Add(1)
+	go func() {
+		defer a.wgflush.Done()
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush(a.done)
+			case <-a.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop flushes the remaining aggregates: the downstream is given
+// MsgSendTimeout to accept them.
+func (a *StatsdAggregator) Stop() error {
+	close(a.done)
+	a.wgflush.Wait()
+	cancel := make(chan struct{})
+	timer := time.AfterFunc(MsgSendTimeout, func() { close(cancel) })
+	a.flush(cancel)
+	timer.Stop()
+	close(a.queue)
+	a.wgpeer.Wait()
+
+	return nil
+}
+
+func (a *StatsdAggregator) Connect(nthreads int, peer core.Receiver) error {
+	for i := 0; i < nthreads; i++ {
+		a.wgpeer.Add(1)
+		go func() {
+			for msg := range a.queue {
+				if err := peer.Receive(msg); err != nil {
+					msg.Complete(core.MsgStatusFailed)
+					a.ctx.Logger().With(msg.LogFields()).Error(err.Error())
+				}
+			}
+			a.wgpeer.Done()
+		}()
+	}
+	return nil
+}
+
+// Receive aggregates the metric and completes the message immediately: the
+// aggregates are sent downstream as new messages on flush.
+func (a *StatsdAggregator) Receive(msg *core.Message) error {
+	var metric *StatsdMetric
+	if v, ok := msg.Meta(MetaStatsdMetric); ok {
+		metric, _ = v.(*StatsdMetric)
+	}
+	if metric == nil {
+		var err error
+		if metric, err = ParseStatsd(msg.Body()); err != nil {
+			a.ctx.Logger().Debug("statsd aggregator %q got a malformed metric: %s", a.name, err)
+			msg.Complete(core.MsgStatusInvalid)
+			return nil
+		}
+	}
+	a.aggregate(metric)
+	msg.Complete(core.MsgStatusDone)
+
+	return nil
+}
+
+func (a *StatsdAggregator) aggregate(m *StatsdMetric) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var series map[string]*statsdSeries
+	switch m.Type {
+	case StatsdCounter:
+		series = a.counters
+	case StatsdGauge:
+		series = a.gauges
+	case StatsdTimer, StatsdHistogram, StatsdDistribution:
+		series = a.timers
+	case StatsdSet:
+		series = a.sets
+	default:
+		return
+	}
+	key := m.Key()
+	s, ok := series[key]
+	if !ok {
+		s = &statsdSeries{name: m.Name, tags: m.Tags}
+		series[key] = s
+	}
+	switch m.Type {
+	case StatsdCounter:
+		s.value += m.Value / m.Rate
+	case StatsdGauge:
+		if m.Delta {
+			s.value += m.Value
+		} else {
+			s.value = m.Value
+		}
+	case StatsdTimer, StatsdHistogram, StatsdDistribution:
+		s.values = append(s.values, m.Value)
+		// A sampled timer value stands for 1/rate values
+		s.value += 1 / m.Rate
+	case StatsdSet:
+		if s.set == nil {
+			s.set = make(map[string]struct{})
+		}
+		s.set[m.SetValue] = struct{}{}
+	}
+}
+
+type statsdAggregate struct {
+	series *statsdSeries
+	suffix string
+	labels []string
+	value  float64
+}
+
+func (a *StatsdAggregator) collect() []statsdAggregate {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	res := make([]statsdAggregate, 0, len(a.counters)*2+len(a.gauges)+len(a.timers)*6+len(a.sets))
+	secs := a.interval.Seconds()
+	for _, s := range a.counters {
+		res = append(res,
+			statsdAggregate{series: s, suffix: "count", value: s.value},
+			statsdAggregate{series: s, suffix: "rate", value: s.value / secs},
+		)
+	}
+	for _, s := range a.gauges {
+		res = append(res, statsdAggregate{series: s, value: s.value})
+	}
+	for _, s := range a.timers {
+		values := s.values
+		sort.Float64s(values)
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		res = append(res,
+			statsdAggregate{series: s, suffix: "count", value: s.value},
+			statsdAggregate{series: s, suffix: "sum", value: sum},
+			statsdAggregate{series: s, suffix: "mean", value: sum / float64(len(values))},
+			statsdAggregate{series: s, suffix: "lower", value: values[0]},
+			statsdAggregate{series: s, suffix: "upper", value: values[len(values)-1]},
+		)
+		for _, pct := range a.percentiles {
+			// Nearest-rank percentile
+			ix := int(math.Ceil(pct/100*float64(len(values)))) - 1
+			if ix < 0 {
+				ix = 0
+			}
+			pctstr := strconv.FormatFloat(pct, 'f', -1, 64)
+			res = append(res, statsdAggregate{
+				series: s,
+				suffix: "upper_" + strings.Replace(pctstr, ".", "_", -1),
+				labels: []string{"quantile:" + strconv.FormatFloat(pct/100, 'f', -1, 64)},
+				value:  values[ix],
+			})
+		}
+	}
+	for _, s := range a.sets {
+		res = append(res, statsdAggregate{series: s, suffix: "count", value: float64(len(s.set))})
+	}
+	a.reset()
+
+	return res
+}
+
+// flush renders the aggregates and sends them downstream. The messages are
+// sorted by the rendered line to keep the output stable. The aggregates not
+// sent by the time cancel fires are dropped.
+func (a *StatsdAggregator) flush(cancel <-chan struct{}) {
+	aggs := a.collect()
+	if len(aggs) == 0 {
+		return
+	}
+	now := a.timefun()
+	lines := make([][]byte, 0, len(aggs))
+	for _, agg := range aggs {
+		if a.format == StatsdFormatPrometheus {
+			lines = append(lines, a.formatPrometheus(agg, now))
+		} else {
+			lines = append(lines, a.formatGraphite(agg, now))
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return bytes.Compare(lines[i], lines[j]) < 0 })
+	for ix, line := range lines {
+		msg := core.NewMessage(line)
+		msg.OnComplete(func(sts core.MsgStatus) {
+			if sts != core.MsgStatusDone {
+				a.ctx.Logger().With(msg.LogFields()).Error("statsd aggregator %q failed to send an aggregate: status %d", a.name, sts)
+			}
+		})
+		select {
+		case a.queue <- msg:
+		case <-cancel:
+			a.ctx.Logger().Error("statsd aggregator %q dropped %d aggregates: the downstream is not accepting them", a.name, len(lines)-ix)
+			return
+		}
+	}
+}
+
+func formatStatsdValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatGraphite renders a Graphite plaintext line. Tags are rendered in the
+// Graphite 1.1 tagged series format: path;tag=value.
+func (a *StatsdAggregator) formatGraphite(agg statsdAggregate, now time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString(a.prefix)
+	b.WriteString(agg.series.name)
+	if len(agg.suffix) > 0 {
+		b.WriteByte('.')
+		b.WriteString(agg.suffix)
+	}
+	for _, tag := range agg.series.tags {
+		b.WriteByte(';')
+		if ix := strings.IndexByte(tag, ':'); ix > 0 {
+			b.WriteString(tag[:ix])
+			b.WriteByte('=')
+			b.WriteString(tag[ix+1:])
+		} else {
+			b.WriteString(tag)
+			b.WriteString("=true")
+		}
+	}
+	fmt.Fprintf(&b, " %s %d", formatStatsdValue(agg.value), now.Unix())
+	return b.Bytes()
+}
+
+var promInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+func promName(name string) string {
+	name = promInvalidChars.ReplaceAllString(name, "_")
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// formatPrometheus renders a Prometheus text exposition line. Percentiles
+// are rendered as summary quantiles, the tags become labels.
+func (a *StatsdAggregator) formatPrometheus(agg statsdAggregate, now time.Time) []byte {
+	var b bytes.Buffer
+	name := a.prefix + agg.series.name
+	if len(agg.suffix) > 0 && len(agg.labels) == 0 {
+		name += "_" + agg.suffix
+	}
+	b.WriteString(promName(name))
+	labels := append(append([]string{}, agg.series.tags...), agg.labels...)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, tag := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			k, v := tag, ""
+			if ix := strings.IndexByte(tag, ':'); ix > 0 {
+				k, v = tag[:ix], tag[ix+1:]
+			}
+			fmt.Fprintf(&b, "%s=%q", promName(k), v)
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(&b, " %s %d", formatStatsdValue(agg.value), now.UnixNano()/int64(time.Millisecond))
+	return b.Bytes()
+}
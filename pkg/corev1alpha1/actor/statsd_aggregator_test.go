@@ -0,0 +1,233 @@
+package actor
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func newTestStatsdAggregator(t *testing.T, params core.Params) (*StatsdAggregator, chan string) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	agg, err := NewStatsdAggregator("aggregator", ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %s", err)
+	}
+	agg.(*StatsdAggregator).timefun = func() time.Time { return time.Unix(1500000000, 0) }
+	peer, err := flowtest.NewTestActor("test-actor", ctx, core.Params{})
+	if err != nil {
+		t.Fatalf("failed to create test actor: %s", err)
+	}
+	mailbox := make(chan string, 64)
+	peer.(*flowtest.TestActor).OnReceive(func(msg *core.Message) {
+		mailbox <- string(msg.Body())
+		msg.Complete(core.MsgStatusDone)
+		peer.(*flowtest.TestActor).Flush()
+	})
+	if err := agg.Connect(1, peer); err != nil {
+		t.Fatalf("failed to connect aggregator: %s", err)
+	}
+	return agg.(*StatsdAggregator), mailbox
+}
+
+func feedStatsd(t *testing.T, agg *StatsdAggregator, lines ...string) {
+	for _, line := range lines {
+		msg := core.NewMessage([]byte(line))
+		if err := agg.Receive(msg); err != nil {
+			t.Fatalf("failed to receive message: %s", err)
+		}
+		if sts := msg.Await(); sts != core.MsgStatusDone {
+			t.Fatalf("unexpected status for %q: %s", line, sts2name(sts))
+		}
+	}
+}
+
+func collectLines(t *testing.T, mailbox chan string, cnt int) []string {
+	res := make([]string, 0, cnt)
+	for i := 0; i < cnt; i++ {
+		select {
+		case line := <-mailbox:
+			res = append(res, line)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for line %d, got so far: %q", i, res)
+		}
+	}
+	return res
+}
+
+func TestStatsdAggregatorGraphite(t *testing.T) {
+	agg, mailbox := newTestStatsdAggregator(t, core.Params{
+		"flush_interval": 2000,
+		"prefix":         "stats.",
+		"percentiles":    []interface{}{"50", "99.9"},
+	})
+
+	feedStatsd(t, agg,
+		"hits:1|c",
+		"hits:1|c|@0.5",
+		"hits:1|c|#env:prod",
+		"temp:10|g",
+		"temp:-3|g",
+		"rt:30|ms",
+		"rt:10|ms",
+		"rt:20|ms|@0.5",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	)
+	agg.flush(nil)
+
+	want := []string{
+		"stats.hits.count 3 1500000000",
+		"stats.hits.count;env=prod 1 1500000000",
+		"stats.hits.rate 1.5 1500000000",
+		"stats.hits.rate;env=prod 0.5 1500000000",
+		"stats.rt.count 4 1500000000",
+		"stats.rt.lower 10 1500000000",
+		"stats.rt.mean 20 1500000000",
+		"stats.rt.sum 60 1500000000",
+		"stats.rt.upper 30 1500000000",
+		"stats.rt.upper_50 20 1500000000",
+		"stats.rt.upper_99_9 30 1500000000",
+		"stats.temp 7 1500000000",
+		"stats.users.count 2 1500000000",
+	}
+	if got := collectLines(t, mailbox, len(want)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected flush:\ngot:  %q\nwant: %q", got, want)
+	}
+
+	// Gauges survive the flush, everything else is reset
+	feedStatsd(t, agg, "temp:+1|g")
+	agg.flush(nil)
+	if got := collectLines(t, mailbox, 1); got[0] != "stats.temp 8 1500000000" {
+		t.Fatalf("unexpected flush: %q", got)
+	}
+}
+
+func TestStatsdAggregatorPrometheus(t *testing.T) {
+	agg, mailbox := newTestStatsdAggregator(t, core.Params{
+		"flush_interval": 1000,
+		"format":         "prometheus",
+	})
+
+	msg := core.NewMessage([]byte("ignored"))
+	msg.SetMeta(MetaStatsdMetric, &StatsdMetric{Name: "api.req-time", Type: StatsdTimer, Value: 5, Rate: 1, Tags: []string{"route:/v1"}})
+	if err := agg.Receive(msg); err != nil {
+		t.Fatalf("failed to receive message: %s", err)
+	}
+	feedStatsd(t, agg, "1xx:2|c")
+	agg.flush(nil)
+
+	want := []string{
+		`_1xx_count 2 1500000000000`,
+		`_1xx_rate 2 1500000000000`,
+		`api_req_time_count{route="/v1"} 1 1500000000000`,
+		`api_req_time_lower{route="/v1"} 5 1500000000000`,
+		`api_req_time_mean{route="/v1"} 5 1500000000000`,
+		`api_req_time_sum{route="/v1"} 5 1500000000000`,
+		`api_req_time_upper{route="/v1"} 5 1500000000000`,
+		`api_req_time{route="/v1",quantile="0.9"} 5 1500000000000`,
+	}
+	if got := collectLines(t, mailbox, len(want)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected flush:\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestStatsdAggregatorNumericPercentiles(t *testing.T) {
+	var params core.Params
+	if err := yaml.Unmarshal([]byte("percentiles: [90, 99.5]"), &params); err != nil {
+		t.Fatalf("failed to unmarshal params: %s", err)
+	}
+	agg, mailbox := newTestStatsdAggregator(t, params)
+	feedStatsd(t, agg, "rt:10|ms", "rt:20|ms")
+	agg.flush(nil)
+
+	want := []string{
+		"rt.count 2 1500000000",
+		"rt.lower 10 1500000000",
+		"rt.mean 15 1500000000",
+		"rt.sum 30 1500000000",
+		"rt.upper 20 1500000000",
+		"rt.upper_90 20 1500000000",
+		"rt.upper_99_5 20 1500000000",
+	}
+	if got := collectLines(t, mailbox, len(want)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected flush:\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestStatsdAggregatorInvalid(t *testing.T) {
+	agg, _ := newTestStatsdAggregator(t, core.Params{})
+	msg := core.NewMessage([]byte("garbage"))
+	if err := agg.Receive(msg); err != nil {
+		t.Fatalf("failed to receive message: %s", err)
+	}
+	if sts := msg.Await(); sts != core.MsgStatusInvalid {
+		t.Fatalf("unexpected status: %s", sts2name(sts))
+	}
+}
+
+func TestNewStatsdAggregatorMalformed(t *testing.T) {
+	tests := []struct {
+		params  core.Params
+		wanterr error
+	}{
+		{core.Params{"format": "influx"}, fmt.Errorf("statsd aggregator \"aggregator\" got an unknown `format`: \"influx\"")},
+		{core.Params{"percentiles": []interface{}{"101"}}, fmt.Errorf("statsd aggregator \"aggregator\" got a malformed percentile: 101")},
+		{core.Params{"percentiles": []interface{}{90, "p99"}}, fmt.Errorf("statsd aggregator \"aggregator\" got a malformed percentile: p99")},
+		{core.Params{"percentiles": []interface{}{0}}, fmt.Errorf("statsd aggregator \"aggregator\" got a malformed percentile: 0")},
+		{core.Params{"percentiles": []interface{}{true}}, fmt.Errorf("statsd aggregator \"aggregator\" got a malformed percentile: unexpected value type: bool")},
+		{core.Params{"percentiles": "99"}, fmt.Errorf("statsd aggregator \"aggregator\" got malformed `percentiles`: unexpected value type: string")},
+		{core.Params{"flush_interval": 0}, fmt.Errorf("statsd aggregator \"aggregator\" `flush_interval` should be a positive integer, got: 0")},
+		{core.Params{"flush_interval": -10}, fmt.Errorf("statsd aggregator \"aggregator\" `flush_interval` should be a positive integer, got: -10")},
+	}
+	for _, testCase := range tests {
+		if _, err := NewStatsdAggregator("aggregator", nil, testCase.params); !eqErr(err, testCase.wanterr) {
+			t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+		}
+	}
+}
+
+func TestStatsdAggregatorStopUnconnected(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	agg, err := NewStatsdAggregator("aggregator", ctx, core.Params{"flush_interval": 10})
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %s", err)
+	}
+	if err := agg.Start(); err != nil {
+		t.Fatalf("failed to start aggregator: %s", err)
+	}
+	// Nothing accepts the aggregates: neither the periodic flush nor the
+	// final one should block the stop
+	feedStatsd(t, agg.(*StatsdAggregator), "hits:1|c")
+	time.Sleep(30 * time.Millisecond)
+	feedStatsd(t, agg.(*StatsdAggregator), "hits:1|c")
+
+	stopped := make(chan error)
+	go func() { stopped <- agg.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("failed to stop aggregator: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the aggregator to stop")
+	}
+}
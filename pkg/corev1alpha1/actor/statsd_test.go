@@ -0,0 +1,40 @@
+package actor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatsd(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *StatsdMetric
+		wanterr bool
+	}{
+		{"hits:1|c", &StatsdMetric{Name: "hits", Type: StatsdCounter, Value: 1, Rate: 1}, false},
+		{"hits:3|c|@0.1", &StatsdMetric{Name: "hits", Type: StatsdCounter, Value: 3, Rate: 0.1}, false},
+		{"temp:-4.5|g", &StatsdMetric{Name: "temp", Type: StatsdGauge, Value: -4.5, Delta: true, Rate: 1}, false},
+		{"temp:4.5|g", &StatsdMetric{Name: "temp", Type: StatsdGauge, Value: 4.5, Rate: 1}, false},
+		{"req.time:320|ms|@0.5|#route:/api,env:prod", &StatsdMetric{Name: "req.time", Type: StatsdTimer, Value: 320, Rate: 0.5, Tags: []string{"env:prod", "route:/api"}}, false},
+		{"size:12|h|#canary", &StatsdMetric{Name: "size", Type: StatsdHistogram, Value: 12, Rate: 1, Tags: []string{"canary"}}, false},
+		{"users:alice|s|c:container", &StatsdMetric{Name: "users", Type: StatsdSet, SetValue: "alice", Rate: 1}, false},
+		{":1|c", nil, true},
+		{"hits:1", nil, true},
+		{"hits:one|c", nil, true},
+		{"hits:1|x", nil, true},
+		{"hits:1|c|@2", nil, true},
+		{"users:|s", nil, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.input, func(t *testing.T) {
+			got, err := ParseStatsd([]byte(testCase.input))
+			if (err != nil) != testCase.wanterr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected metric: got: %+v, want: %+v", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package actor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	MetaSyslogPriority       = "syslog.priority"
+	MetaSyslogFacility       = "syslog.facility"
+	MetaSyslogSeverity       = "syslog.severity"
+	MetaSyslogTimestamp      = "syslog.timestamp"
+	MetaSyslogHostname       = "syslog.hostname"
+	MetaSyslogAppName        = "syslog.app_name"
+	MetaSyslogProcID         = "syslog.procid"
+	MetaSyslogMsgID          = "syslog.msgid"
+	MetaSyslogStructuredData = "syslog.structured_data"
+
+	// SyslogDefaultPriority is user.notice: RFC 3164 suggests this priority
+	// for messages with no (or a malformed) PRI part.
+	SyslogDefaultPriority = 13
+
+	SyslogFormatRFC5424 = "rfc5424"
+	SyslogFormatRFC3164 = "rfc3164"
+
+	syslogNilValue = "-"
+	syslogMaxPri   = 191
+	syslogMaxFrame = 1024 * 1024
+)
+
+var SyslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var SyslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// SyslogMessage is a parsed RFC 3164 (Version 0) or RFC 5424 (Version 1)
+// syslog message.
+type SyslogMessage struct {
+	Priority       int
+	Version        int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        []byte
+}
+
+func (m *SyslogMessage) Facility() int {
+	return m.Priority / 8
+}
+
+func (m *SyslogMessage) Severity() int {
+	return m.Priority % 8
+}
+
+// SetMeta copies the syslog header fields into the message meta. Facility
+// and severity are stored by their names (e.g. "auth", "err"): this makes the
+// message routable by core.router using `routing_key: syslog.facility`.
+// Structured data params are stored as syslog.structured_data.<id>.<name>.
+func (m *SyslogMessage) SetMeta(msg *core.Message) {
+	msg.SetMeta(MetaSyslogPriority, m.Priority)
+	msg.SetMeta(MetaSyslogFacility, SyslogFacilities[m.Facility()])
+	msg.SetMeta(MetaSyslogSeverity, SyslogSeverities[m.Severity()])
+	if !m.Timestamp.IsZero() {
+		msg.SetMeta(MetaSyslogTimestamp, m.Timestamp)
+	}
+	for k, v := range map[string]string{
+		MetaSyslogHostname: m.Hostname,
+		MetaSyslogAppName:  m.AppName,
+		MetaSyslogProcID:   m.ProcID,
+		MetaSyslogMsgID:    m.MsgID,
+	} {
+		if len(v) > 0 {
+			msg.SetMeta(k, v)
+		}
+	}
+	for id, params := range m.StructuredData {
+		for name, value := range params {
+			msg.SetMeta(MetaSyslogStructuredData+"."+id+"."+name, value)
+		}
+	}
+}
+
+// syslogHeaderField replaces the characters not allowed in RFC 5424 header
+// fields and truncates the value to the max field length.
+func syslogHeaderField(v string, maxlen int) string {
+	if len(v) == 0 {
+		return syslogNilValue
+	}
+	b := []byte(v)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxlen {
+		b = b[:maxlen]
+	}
+	return string(b)
+}
+
+var syslogSDEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// Format5424 renders the message as an RFC 5424 frame. Structured data
+// elements and params are sorted by name.
+func (m *SyslogMessage) Format5424() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 ", m.Priority)
+	if m.Timestamp.IsZero() {
+		b.WriteString(syslogNilValue)
+	} else {
+		b.WriteString(m.Timestamp.Format(time.RFC3339Nano))
+	}
+	for _, f := range []struct {
+		v      string
+		maxlen int
+	}{{m.Hostname, 255}, {m.AppName, 48}, {m.ProcID, 128}, {m.MsgID, 32}} {
+		b.WriteByte(' ')
+		b.WriteString(syslogHeaderField(f.v, f.maxlen))
+	}
+	b.WriteByte(' ')
+	if len(m.StructuredData) == 0 {
+		b.WriteString(syslogNilValue)
+	}
+	ids := make([]string, 0, len(m.StructuredData))
+	for id := range m.StructuredData {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		b.WriteByte('[')
+		b.WriteString(syslogHeaderField(id, 32))
+		params := m.StructuredData[id]
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, " %s=\"%s\"", syslogHeaderField(name, 32), syslogSDEscaper.Replace(params[name]))
+		}
+		b.WriteByte(']')
+	}
+	if len(m.Message) > 0 {
+		b.WriteByte(' ')
+		b.Write(m.Message)
+	}
+	return b.Bytes()
+}
+
+// Format3164 renders the message as an RFC 3164 (BSD syslog) frame. The
+// structured data and MSGID are not representable in this format and are
+// omitted.
+func (m *SyslogMessage) Format3164() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>%s", m.Priority, m.Timestamp.Format(time.Stamp))
+	if len(m.Hostname) > 0 {
+		b.WriteByte(' ')
+		b.WriteString(syslogHeaderField(m.Hostname, 255))
+	}
+	if len(m.AppName) > 0 {
+		b.WriteByte(' ')
+		b.WriteString(syslogHeaderField(m.AppName, 32))
+		if len(m.ProcID) > 0 {
+			fmt.Fprintf(&b, "[%s]", syslogHeaderField(m.ProcID, 128))
+		}
+		b.WriteByte(':')
+	}
+	b.WriteByte(' ')
+	b.Write(m.Message)
+	return b.Bytes()
+}
+
+// ParseSyslog parses a single syslog frame. The format is detected
+// automatically: a frame with version 1 and a timestamp following the PRI
+// part is parsed as RFC 5424, anything else is parsed as RFC 3164. now is
+// used to complete RFC 3164 timestamps missing the year.
+func ParseSyslog(data []byte, now time.Time) (*SyslogMessage, error) {
+	pri, rest, err := parseSyslogPri(data)
+	if err != nil {
+		return nil, err
+	}
+	if isSyslog5424(rest) {
+		return parseSyslog5424(pri, 1, rest[2:])
+	}
+	return parseSyslog3164(pri, rest, now), nil
+}
+
+// isSyslog5424 tells whether the frame following the PRI part is an RFC 5424
+// one: version 1 followed by either a nil or a valid timestamp. Otherwise an
+// RFC 3164 message starting with a number (e.g. "<13>12 apples") would be
+// taken for a versioned one.
+func isSyslog5424(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("1 ")) {
+		return false
+	}
+	ts, _, err := nextSyslogField(data[2:])
+	if err != nil {
+		return false
+	}
+	if ts == syslogNilValue {
+		return true
+	}
+	_, err = time.Parse(time.RFC3339Nano, ts)
+	return err == nil
+}
+
+func parseSyslogPri(data []byte) (int, []byte, error) {
+	if len(data) == 0 || data[0] != '<' {
+		return 0, nil, fmt.Errorf("syslog: missing PRI part")
+	}
+	end := bytes.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return 0, nil, fmt.Errorf("syslog: malformed PRI part")
+	}
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri < 0 || pri > syslogMaxPri {
+		return 0, nil, fmt.Errorf("syslog: malformed PRI part %q", data[1:end])
+	}
+	return pri, data[end+1:], nil
+}
+
+// nextSyslogField returns the next space-separated field of the header.
+func nextSyslogField(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	sp := bytes.IndexByte(data, ' ')
+	if sp < 0 {
+		return string(data), nil, nil
+	}
+	return string(data[:sp]), data[sp+1:], nil
+}
+
+func parseSyslog5424(pri, version int, data []byte) (*SyslogMessage, error) {
+	m := &SyslogMessage{Priority: pri, Version: version}
+	var fields [5]string
+	var err error
+	for i := range fields {
+		if fields[i], data, err = nextSyslogField(data); err != nil {
+			return nil, fmt.Errorf("syslog: truncated RFC 5424 header")
+		}
+		if fields[i] == syslogNilValue {
+			fields[i] = ""
+		}
+	}
+	if len(fields[0]) > 0 {
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			return nil, fmt.Errorf("syslog: malformed timestamp %q", fields[0])
+		}
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = fields[1], fields[2], fields[3], fields[4]
+	if m.StructuredData, data, err = parseSyslogSD(data); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[0] == ' ' {
+		data = data[1:]
+	}
+	m.Message = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	return m, nil
+}
+
+func parseSyslogSD(data []byte) (map[string]map[string]string, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("syslog: missing STRUCTURED-DATA")
+	}
+	if data[0] == '-' {
+		return nil, data[1:], nil
+	}
+	sd := make(map[string]map[string]string)
+	for len(data) > 0 && data[0] == '[' {
+		end := bytes.IndexAny(data, " ]")
+		if end < 0 {
+			return nil, nil, fmt.Errorf("syslog: malformed STRUCTURED-DATA")
+		}
+		id := string(data[1:end])
+		params := make(map[string]string)
+		data = data[end:]
+		for len(data) > 0 && data[0] == ' ' {
+			eq := bytes.IndexByte(data, '=')
+			if eq < 0 || eq+1 >= len(data) || data[eq+1] != '"' {
+				return nil, nil, fmt.Errorf("syslog: malformed SD-PARAM in %q", id)
+			}
+			name := string(data[1:eq])
+			data = data[eq+2:]
+			var value bytes.Buffer
+			closed := false
+			for i := 0; i < len(data); i++ {
+				if data[i] == '\\' && i+1 < len(data) && (data[i+1] == '"' || data[i+1] == '\\' || data[i+1] == ']') {
+					i++
+				} else if data[i] == '"' {
+					data = data[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(data[i])
+			}
+			if !closed {
+				return nil, nil, fmt.Errorf("syslog: unterminated SD-PARAM %q in %q", name, id)
+			}
+			params[name] = value.String()
+		}
+		if len(data) == 0 || data[0] != ']' {
+			return nil, nil, fmt.Errorf("syslog: unterminated SD-ELEMENT %q", id)
+		}
+		data = data[1:]
+		sd[id] = params
+	}
+	return sd, data, nil
+}
+
+// parseSyslog3164 never fails: the RFC requires a relay to treat anything
+// following PRI it can not recognize as the message content.
+func parseSyslog3164(pri int, data []byte, now time.Time) *SyslogMessage {
+	m := &SyslogMessage{Priority: pri}
+	// Mmm dd hh:mm:ss, the day is space-padded
+	const tslen = len(time.Stamp)
+	if len(data) > tslen && data[tslen] == ' ' {
+		if ts, err := time.ParseInLocation(time.Stamp, string(data[:tslen]), now.Location()); err == nil {
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// A message from December received in January
+			if ts.After(now.AddDate(0, 1, 0)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			m.Timestamp = ts
+			data = data[tslen+1:]
+			if sp := bytes.IndexByte(data, ' '); sp > 0 {
+				m.Hostname = string(data[:sp])
+				data = data[sp+1:]
+			}
+		}
+	}
+	// TAG is an alphanumeric string terminated by '[', ':' or a space.
+	tagend := bytes.IndexAny(data, "[: ")
+	if tagend > 0 && tagend <= 48 {
+		tag := data[:tagend]
+		rest := data[tagend:]
+		if rest[0] == '[' {
+			if end := bytes.IndexByte(rest, ']'); end > 0 {
+				m.ProcID = string(rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if len(rest) > 0 && rest[0] == ':' {
+			m.AppName = string(tag)
+			data = bytes.TrimPrefix(rest[1:], []byte(" "))
+		}
+	}
+	m.Message = data
+
+	return m
+}
+
+// ScanSyslog is a split function for syslog streams (RFC 6587). It supports
+// both octet-counting (`MSG-LEN SP SYSLOG-MSG`) and non-transparent
+// LF-delimited framing. The framing is detected for every frame.
+func ScanSyslog(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if data[0] >= '1' && data[0] <= '9' {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			if atEOF || len(data) > 8 {
+				return 0, nil, fmt.Errorf("syslog: malformed octet-counting frame")
+			}
+			return 0, nil, nil
+		}
+		size, err := strconv.Atoi(string(data[:sp]))
+		if err != nil || size > syslogMaxFrame {
+			return 0, nil, fmt.Errorf("syslog: malformed octet-counting frame length %q", data[:sp])
+		}
+		if len(data) < sp+1+size {
+			if atEOF {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		return sp + 1 + size, data[sp+1 : sp+1+size], nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, dropCR(data[:i]), nil
+	}
+	if atEOF {
+		return len(data), dropCR(data), nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,187 @@
+package actor
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2019, time.January, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		input   string
+		want    *SyslogMessage
+		wanterr bool
+	}{
+		{
+			name:  "rfc5424 full",
+			input: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="Appli\"cation"][meta seq="1"] An application event`,
+			want: &SyslogMessage{
+				Priority:  165,
+				Version:   1,
+				Timestamp: time.Date(2003, time.October, 11, 22, 14, 15, 3000000, time.UTC),
+				Hostname:  "mymachine.example.com",
+				AppName:   "evntslog",
+				ProcID:    "1234",
+				MsgID:     "ID47",
+				StructuredData: map[string]map[string]string{
+					"exampleSDID@32473": {"iut": "3", "eventSource": `Appli"cation`},
+					"meta":              {"seq": "1"},
+				},
+				Message: []byte("An application event"),
+			},
+		},
+		{
+			name:  "rfc5424 nil values and bom",
+			input: "<34>1 - - su - - - \xEF\xBB\xBF'su root' failed",
+			want: &SyslogMessage{
+				Priority: 34,
+				Version:  1,
+				AppName:  "su",
+				Message:  []byte("'su root' failed"),
+			},
+		},
+		{
+			name:  "rfc5424 no message",
+			input: "<34>1 - host app - - -",
+			want: &SyslogMessage{
+				Priority: 34,
+				Version:  1,
+				Hostname: "host",
+				AppName:  "app",
+				Message:  []byte{},
+			},
+		},
+		{
+			name:  "rfc3164 full",
+			input: "<34>Oct 11 22:14:15 mymachine su[42]: 'su root' failed for lonvick on /dev/pts/8",
+			want: &SyslogMessage{
+				Priority:  34,
+				Timestamp: time.Date(2018, time.October, 11, 22, 14, 15, 0, time.UTC),
+				Hostname:  "mymachine",
+				AppName:   "su",
+				ProcID:    "42",
+				Message:   []byte("'su root' failed for lonvick on /dev/pts/8"),
+			},
+		},
+		{
+			name:  "rfc3164 space padded day",
+			input: "<13>Jan  2 11:00:00 host app: hello",
+			want: &SyslogMessage{
+				Priority:  13,
+				Timestamp: time.Date(2019, time.January, 2, 11, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				AppName:   "app",
+				Message:   []byte("hello"),
+			},
+		},
+		{
+			name:  "rfc3164 no header",
+			input: "<13>just a message",
+			want: &SyslogMessage{
+				Priority: 13,
+				Message:  []byte("just a message"),
+			},
+		},
+		{
+			name:  "rfc3164 message starting with a number",
+			input: "<13>12 apples",
+			want: &SyslogMessage{
+				Priority: 13,
+				Message:  []byte("12 apples"),
+			},
+		},
+		{
+			name:  "rfc3164 message starting with version-like number",
+			input: "<13>1 apple",
+			want: &SyslogMessage{
+				Priority: 13,
+				Message:  []byte("1 apple"),
+			},
+		},
+		{
+			name:    "missing pri",
+			input:   "hello",
+			wanterr: true,
+		},
+		{
+			name:    "pri out of range",
+			input:   "<192>1 - - - - - -",
+			wanterr: true,
+		},
+		{
+			name:    "malformed structured data",
+			input:   `<13>1 - - - - - [id a="b] msg`,
+			wanterr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ParseSyslog([]byte(testCase.input), now)
+			if testCase.wanterr {
+				if err == nil {
+					t.Fatalf("expected an error, got: %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected message: got: %+v, want: %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestScanSyslog(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wanterr bool
+	}{
+		{
+			name:  "non-transparent",
+			input: "<13>a\n<13>b\r\n<13>c",
+			want:  []string{"<13>a", "<13>b", "<13>c"},
+		},
+		{
+			name:  "octet counting",
+			input: "5 <13>a6 <13>b\n",
+			want:  []string{"<13>a", "<13>b\n"},
+		},
+		{
+			name:  "mixed",
+			input: "5 <13>a<13>b\n",
+			want:  []string{"<13>a", "<13>b"},
+		},
+		{
+			name:    "truncated",
+			input:   "10 <13>a",
+			want:    []string{},
+			wanterr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(testCase.input))
+			scanner.Split(ScanSyslog)
+			got := []string{}
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if err := scanner.Err(); (err != nil) != testCase.wanterr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected frames: got: %q, want: %q", got, testCase.want)
+			}
+		})
+	}
+}
@@ -76,7 +76,7 @@ func (t *Throttler) Connect(nthreads int, peer core.Receiver) error {
 			for msg := range t.queue {
 				if err := peer.Receive(msg); err != nil {
 					msg.Complete(core.MsgStatusFailed)
-					t.ctx.Logger().Error(err.Error())
+					t.ctx.Logger().With(msg.LogFields()).Error(err.Error())
 				}
 			}
 			t.wg.Done()
@@ -0,0 +1,81 @@
+package actor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// buildServerTLSConfig loads the server certificate from `tls_cert` and
+// `tls_key`. If `tls_ca` is provided, the clients are required to present a
+// certificate signed by this CA.
+func buildServerTLSConfig(params core.Params) (*tls.Config, error) {
+	certfile, ok := params["tls_cert"]
+	if !ok {
+		return nil, fmt.Errorf("missing `tls_cert` config")
+	}
+	keyfile, ok := params["tls_key"]
+	if !ok {
+		return nil, fmt.Errorf("missing `tls_key` config")
+	}
+	cert, err := tls.LoadX509KeyPair(certfile.(string), keyfile.(string))
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cafile, ok := params["tls_ca"]; ok {
+		pool, err := loadCertPool(cafile.(string))
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// buildClientTLSConfig verifies the server certificate against `tls_ca` if
+// provided (the system pool is used otherwise). A client certificate is
+// presented if `tls_cert` and `tls_key` are set. `tls_server_name` overrides
+// the name the server certificate is verified against.
+func buildClientTLSConfig(params core.Params) (*tls.Config, error) {
+	conf := &tls.Config{}
+	if cafile, ok := params["tls_ca"]; ok {
+		pool, err := loadCertPool(cafile.(string))
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	certfile, hascert := params["tls_cert"]
+	keyfile, haskey := params["tls_key"]
+	if hascert != haskey {
+		return nil, fmt.Errorf("`tls_cert` and `tls_key` must be set together")
+	}
+	if hascert {
+		cert, err := tls.LoadX509KeyPair(certfile.(string), keyfile.(string))
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if v, ok := params["tls_server_name"]; ok {
+		conf.ServerName = v.(string)
+	}
+	return conf, nil
+}
+
+func loadCertPool(cafile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %q", cafile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,233 @@
+package actor
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultWSMaxMessageSize = 1024 * 1024
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsCloseNormal      = 1000
+	wsCloseProtocolErr = 1002
+	wsCloseTooBig      = 1009
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// wsProtocolError closes the connection with the code once returned from
+// readWSMessage.
+type wsProtocolError struct {
+	code uint16
+	text string
+}
+
+func (e *wsProtocolError) Error() string {
+	return fmt.Sprintf("websocket: %s", e.text)
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitWSBind splits `host:port/path` bind address, the path is empty if
+// none is given.
+func splitWSBind(bind string) (string, string) {
+	if ix := strings.IndexByte(bind, '/'); ix >= 0 {
+		return bind[:ix], bind[ix:]
+	}
+	return bind, ""
+}
+
+// wsHandshake reads the opening handshake request and upgrades the
+// connection. If path is not empty, the request path has to match it. A
+// failed handshake is replied with an HTTP error.
+func wsHandshake(conn net.Conn, reader *bufio.Reader, path string) (*http.Request, error) {
+	conn.SetReadDeadline(time.Now().Add(TCPConnTimeout))
+	req, err := http.ReadRequest(reader)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	code, reason := http.StatusSwitchingProtocols, ""
+	switch {
+	case req.Method != http.MethodGet:
+		code, reason = http.StatusMethodNotAllowed, "method not allowed"
+	case len(path) > 0 && req.URL.Path != path:
+		code, reason = http.StatusNotFound, "not found"
+	case !headerHasToken(req.Header, "Upgrade", "websocket") || !headerHasToken(req.Header, "Connection", "upgrade"):
+		code, reason = http.StatusUpgradeRequired, "websocket upgrade required"
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		code, reason = http.StatusUpgradeRequired, "unsupported websocket version"
+	case len(req.Header.Get("Sec-WebSocket-Key")) == 0:
+		code, reason = http.StatusBadRequest, "missing Sec-WebSocket-Key"
+	}
+	var resp string
+	if code == http.StatusSwitchingProtocols {
+		resp = "HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + wsAcceptKey(req.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+	} else {
+		resp = fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: %d\r\nConnection: close\r\n", code, http.StatusText(code), len(reason))
+		if code == http.StatusUpgradeRequired {
+			resp += "Sec-WebSocket-Version: 13\r\n"
+		}
+		resp += "\r\n" + reason
+	}
+	conn.SetWriteDeadline(time.Now().Add(ConnWriteTimeout))
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		return nil, err
+	}
+	if code != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake from %s failed: %s", conn.RemoteAddr(), reason)
+	}
+	return req, nil
+}
+
+// readWSFrame reads a single client (masked) frame and unmasks the payload.
+func readWSFrame(reader *bufio.Reader, maxsize int) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocolErr, "reserved bits are set"}
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocolErr, "client frames must be masked"}
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return false, 0, nil, unexpectedEOF(err)
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return false, 0, nil, unexpectedEOF(err)
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsOpClose && (size > 125 || !fin) {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocolErr, "malformed control frame"}
+	}
+	if size > uint64(maxsize) {
+		return false, 0, nil, &wsProtocolError{wsCloseTooBig, fmt.Sprintf("frame size %d exceeds the limit of %d", size, maxsize)}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(reader, mask[:]); err != nil {
+		return false, 0, nil, unexpectedEOF(err)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return false, 0, nil, unexpectedEOF(err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// encodeWSFrame builds an unmasked (server) final frame.
+func encodeWSFrame(opcode byte, payload []byte) []byte {
+	buf := make([]byte, 0, len(payload)+10)
+	buf = append(buf, 0x80|opcode)
+	switch size := len(payload); {
+	case size < 126:
+		buf = append(buf, byte(size))
+	case size <= 0xffff:
+		buf = append(buf, 126, byte(size>>8), byte(size))
+	default:
+		buf = append(buf, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[2:], uint64(size))
+	}
+	return append(buf, payload...)
+}
+
+func encodeWSClose(code uint16, text string) []byte {
+	payload := []byte{byte(code >> 8), byte(code)}
+	return encodeWSFrame(wsOpClose, append(payload, text...))
+}
+
+// readWSMessage reads frames until a complete data message is assembled.
+// Pings are answered with pongs using the write callback. A close frame is
+// echoed and reported as io.EOF, protocol errors are reported to the peer
+// with a close frame.
+func readWSMessage(reader *bufio.Reader, maxsize int, write func([]byte) error) (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	fragmented := false
+	for {
+		fin, op, payload, err := readWSFrame(reader, maxsize)
+		if err == nil {
+			switch {
+			case op == wsOpPing:
+				err = write(encodeWSFrame(wsOpPong, payload))
+			case op == wsOpPong:
+			case op == wsOpClose:
+				code := uint16(wsCloseNormal)
+				if len(payload) >= 2 {
+					code = binary.BigEndian.Uint16(payload)
+				}
+				write(encodeWSClose(code, ""))
+				return 0, nil, io.EOF
+			case op == wsOpContinuation && !fragmented, (op == wsOpText || op == wsOpBinary) && fragmented:
+				err = &wsProtocolError{wsCloseProtocolErr, "unexpected continuation frame"}
+			case op == wsOpText || op == wsOpBinary || op == wsOpContinuation:
+				if op != wsOpContinuation {
+					opcode = op
+				}
+				if len(message)+len(payload) > maxsize {
+					err = &wsProtocolError{wsCloseTooBig, fmt.Sprintf("message size exceeds the limit of %d", maxsize)}
+					break
+				}
+				message = append(message, payload...)
+				if fin {
+					return opcode, message, nil
+				}
+				fragmented = true
+			default:
+				err = &wsProtocolError{wsCloseProtocolErr, fmt.Sprintf("unknown opcode %d", op)}
+			}
+		}
+		if err != nil {
+			if perr, ok := err.(*wsProtocolError); ok {
+				write(encodeWSClose(perr.code, perr.text))
+			}
+			return 0, nil, err
+		}
+	}
+}
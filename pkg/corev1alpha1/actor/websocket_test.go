@@ -0,0 +1,186 @@
+package actor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// wsClientFrame builds a masked client frame.
+func wsClientFrame(fin bool, opcode byte, payload []byte) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	buf := []byte{head}
+	switch size := len(payload); {
+	case size < 126:
+		buf = append(buf, 0x80|byte(size))
+	case size <= 0xffff:
+		buf = append(buf, 0x80|126, byte(size>>8), byte(size))
+	default:
+		buf = append(buf, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[2:], uint64(size))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	return buf
+}
+
+// readWSServerFrame reads an unmasked server frame.
+func readWSServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		t.Fatalf("failed to read frame: %s", err)
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(reader, ext[:])
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(reader, ext[:])
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("failed to read frame payload: %s", err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func dialWS(t *testing.T, addr, target string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", target, addr)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response: %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, reader
+}
+
+func TestReadWSMessage(t *testing.T) {
+	cat := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	tests := []struct {
+		name       string
+		input      []byte
+		wantopcode byte
+		wantmsg    []byte
+		wanterr    error
+		wantwrites [][]byte
+	}{
+		{
+			name:       "text",
+			input:      wsClientFrame(true, wsOpText, []byte("hello")),
+			wantopcode: wsOpText,
+			wantmsg:    []byte("hello"),
+		},
+		{
+			name:       "16-bit length",
+			input:      wsClientFrame(true, wsOpBinary, bytes.Repeat([]byte{1}, 300)),
+			wantopcode: wsOpBinary,
+			wantmsg:    bytes.Repeat([]byte{1}, 300),
+		},
+		{
+			name: "fragmented with a ping in between",
+			input: cat(
+				wsClientFrame(false, wsOpText, []byte("hel")),
+				wsClientFrame(true, wsOpPing, []byte("p")),
+				wsClientFrame(true, wsOpContinuation, []byte("lo")),
+			),
+			wantopcode: wsOpText,
+			wantmsg:    []byte("hello"),
+			wantwrites: [][]byte{{0x8a, 1, 'p'}},
+		},
+		{
+			name:       "close",
+			input:      wsClientFrame(true, wsOpClose, []byte{0x03, 0xe9}),
+			wanterr:    io.EOF,
+			wantwrites: [][]byte{{0x88, 2, 0x03, 0xe9}},
+		},
+		{
+			name:       "unmasked",
+			input:      []byte{0x81, 1, 'a'},
+			wanterr:    fmt.Errorf("websocket: client frames must be masked"),
+			wantwrites: [][]byte{append([]byte{0x88, 30, 0x03, 0xea}, "client frames must be masked"...)},
+		},
+		{
+			name:       "unexpected continuation",
+			input:      wsClientFrame(true, wsOpContinuation, []byte("a")),
+			wanterr:    fmt.Errorf("websocket: unexpected continuation frame"),
+			wantwrites: [][]byte{append([]byte{0x88, 31, 0x03, 0xea}, "unexpected continuation frame"...)},
+		},
+		{
+			name:       "too big",
+			input:      cat(wsClientFrame(false, wsOpText, bytes.Repeat([]byte{1}, 600)), wsClientFrame(true, wsOpContinuation, bytes.Repeat([]byte{1}, 600))),
+			wanterr:    fmt.Errorf("websocket: message size exceeds the limit of 1024"),
+			wantwrites: [][]byte{append([]byte{0x88, 40, 0x03, 0xf1}, "message size exceeds the limit of 1024"...)},
+		},
+		{
+			name:    "truncated",
+			input:   wsClientFrame(true, wsOpText, []byte("hello"))[:6],
+			wanterr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			var writes [][]byte
+			write := func(data []byte) error {
+				writes = append(writes, data)
+				return nil
+			}
+			opcode, msg, err := readWSMessage(bufio.NewReader(bytes.NewReader(testCase.input)), 1024, write)
+			if !eqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %v, want: %v", err, testCase.wanterr)
+			}
+			if opcode != testCase.wantopcode || !bytes.Equal(msg, testCase.wantmsg) {
+				t.Fatalf("unexpected message: got: %d %q, want: %d %q", opcode, msg, testCase.wantopcode, testCase.wantmsg)
+			}
+			if !reflect.DeepEqual(writes, testCase.wantwrites) {
+				t.Fatalf("unexpected writes: got: %x, want: %x", writes, testCase.wantwrites)
+			}
+		})
+	}
+}
+
+func TestEncodeWSFrame(t *testing.T) {
+	tests := []struct {
+		size     int
+		wanthead []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{65536, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, testCase := range tests {
+		frame := encodeWSFrame(wsOpText, make([]byte, testCase.size))
+		if got := frame[:len(testCase.wanthead)]; !bytes.Equal(got, testCase.wanthead) {
+			t.Fatalf("unexpected frame header for size %d: got: %x, want: %x", testCase.size, got, testCase.wanthead)
+		}
+		if len(frame) != len(testCase.wanthead)+testCase.size {
+			t.Fatalf("unexpected frame length for size %d: %d", testCase.size, len(frame))
+		}
+	}
+}
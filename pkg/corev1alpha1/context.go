@@ -1,8 +1,11 @@
 package corev1alpha1
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/awesome-flow/flow/pkg/cfg"
+	"github.com/awesome-flow/flow/pkg/types"
 	"github.com/awesome-flow/flow/pkg/util"
 )
 
@@ -24,15 +27,29 @@ func NewContext(config *Config) (*Context, error) {
 	}, nil
 }
 
+// initLogger creates a stdout logger: the config providers are not set up
+// yet, the system.log settings are applied by configureLogger on Start.
 func initLogger(config *Config) (*Logger, error) {
-	//TODO: configure logger
 	return NewLogger(os.Stdout), nil
 }
 
+func (ctx *Context) configureLogger() error {
+	val, ok := ctx.config.Get(types.NewKey(cfg.SystemLog))
+	if !ok {
+		return nil
+	}
+	logcfg, ok := val.(types.CfgBlockSystemLog)
+	if !ok {
+		return fmt.Errorf("malformed %s config: %#v", cfg.SystemLog, val)
+	}
+	return ctx.logger.Configure(logcfg)
+}
+
 func (ctx *Context) Start() error {
 	if err := util.ExecEnsure(
-		ctx.logger.Start,
 		ctx.config.Start,
+		ctx.configureLogger,
+		ctx.logger.Start,
 	); err != nil {
 		return err
 	}
@@ -53,6 +70,16 @@ func (ctx *Context) Logger() *Logger {
 	return ctx.logger
 }
 
+// WithLogFields returns a context sharing the config and the log output with
+// the original one, the logger attaches the fields to every entry. The
+// derived context is not meant to be started or stopped on it's own.
+func (ctx *Context) WithLogFields(fields LogFields) *Context {
+	return &Context{
+		logger: ctx.logger.With(fields),
+		config: ctx.config,
+	}
+}
+
 func (ctx *Context) Config() *Config {
 	return ctx.config
 }
@@ -0,0 +1,40 @@
+package corev1alpha1
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/awesome-flow/flow/pkg/cfg"
+)
+
+func TestContextWithLogFields(t *testing.T) {
+	out := new(bytes.Buffer)
+	ctx := &Context{
+		logger: NewLogger(out),
+		config: NewConfig(cfg.NewRepository()),
+	}
+	actorctx := ctx.WithLogFields(LogFields{"actor": "sink"})
+	if actorctx.Config() != ctx.Config() {
+		t.Fatalf("the derived context does not share the config")
+	}
+	if err := ctx.Logger().Start(); err != nil {
+		t.Fatalf("failed to start logger: %s", err)
+	}
+	actorctx.Logger().Info("connected")
+	ctx.Logger().Info("started")
+	if err := ctx.Logger().Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	want := []string{"INFO\tconnected\tactor=sink", "INFO\tstarted"}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected log lines: got: %q, want suffixes: %q", lines, want)
+	}
+	for ix, line := range lines {
+		if !strings.HasSuffix(line, want[ix]) {
+			t.Fatalf("logline %q is expected to contain suffix %q", line, want[ix])
+		}
+	}
+}
@@ -1,5 +1,14 @@
 package corev1alpha1
 
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	DrainTimedOutErr = fmt.Errorf("timed out waiting for the in-flight messages")
+)
+
 type Namer interface {
 	Name() string
 }
@@ -23,3 +32,21 @@ type Actor interface {
 	Receiver
 	Runner
 }
+
+// QueueReporter is implemented by the actors holding messages in an internal
+// queue.
+type QueueReporter interface {
+	QueueLen() int
+	QueueCap() int
+}
+
+// Pauser is implemented by the actors that might stop processing inbound
+// messages without being stopped. Pause and Resume are idempotent. Drain
+// pauses the actor and returns once the messages it has been processing are
+// completed, or DrainTimedOutErr if they are not completed within the
+// timeout. The actor stays paused in both cases.
+type Pauser interface {
+	Pause() error
+	Resume() error
+	Drain(timeout time.Duration) error
+}
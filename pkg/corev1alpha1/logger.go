@@ -1,10 +1,16 @@
 package corev1alpha1
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/awesome-flow/flow/pkg/types"
 )
 
 type LogSev uint8
@@ -18,54 +24,233 @@ const (
 	LogSevFatal
 )
 
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+	LogOutputSyslog = "syslog"
+
+	// DefaultLogBuffer is the number of log entries the logger queues up
+	// before the callers start blocking on the output.
+	DefaultLogBuffer = 1024
+)
+
+// LogFields are the structured attributes attached to the log entries, e.g.
+// the actor name, the message ID or the remote address.
+type LogFields map[string]interface{}
+
 type Log struct {
 	sev     LogSev
+	time    time.Time
 	payload string
+	fields  LogFields
 }
 
 func NewLog(sev LogSev, payload string) *Log {
 	return &Log{
 		sev:     sev,
+		time:    time.Now(),
 		payload: payload,
 	}
 }
 
+// logWriter is the logger output: a stream (stdout, stderr or a file) or
+// syslog.
+type logWriter interface {
+	WriteLog(sev LogSev, line string) error
+	Close() error
+}
+
+// streamLogWriter writes the log lines to a stream. The stream gets closed
+// on Close only if the logger has opened it.
+type streamLogWriter struct {
+	out    io.Writer
+	closer io.Closer
+}
+
+func (w *streamLogWriter) WriteLog(_ LogSev, line string) error {
+	_, err := fmt.Fprintln(w.out, line)
+	return err
+}
+
+func (w *streamLogWriter) Close() error {
+	if w.closer != nil {
+		return w.closer.Close()
+	}
+	return nil
+}
+
+// logSampler limits the number of similar log entries: every second the
+// first `initial` entries with the same severity and the same format string
+// pass, afterwards every `thereafter`-th one does.
+type logSampler struct {
+	initial    int
+	thereafter int
+	lock       sync.Mutex
+	second     int64
+	counts     map[logSampleKey]int
+}
+
+type logSampleKey struct {
+	sev    LogSev
+	format string
+}
+
+func newLogSampler(initial, thereafter int) *logSampler {
+	return &logSampler{
+		initial:    initial,
+		thereafter: thereafter,
+		counts:     make(map[logSampleKey]int),
+	}
+}
+
+func (s *logSampler) allow(sev LogSev, format string, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if second := now.Unix(); second != s.second {
+		s.second = second
+		s.counts = make(map[logSampleKey]int)
+	}
+	key := logSampleKey{sev: sev, format: format}
+	s.counts[key]++
+	cnt := s.counts[key]
+	if cnt <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (cnt-s.initial)%s.thereafter == 0
+}
+
+// logPipe is the part of the logger shared by all the loggers derived
+// with Logger.With: the queue, the settings and the output.
+type logPipe struct {
+	logs    chan *Log
+	writer  logWriter
+	level   LogSev
+	format  string
+	sampler *logSampler
+	done    chan struct{}
+}
+
+// Logger is an asynchronous leveled logger. The entries below the configured
+// level or dropped by the sampler cost a comparison: the payload is never
+// formatted for them.
 type Logger struct {
-	logs chan *Log
-	out  io.Writer
-	done chan struct{}
+	pipe   *logPipe
+	fields LogFields
 }
 
 var _ Runner = (*Logger)(nil)
 
 func NewLogger(out io.Writer) *Logger {
 	return &Logger{
-		logs: make(chan *Log),
-		out:  out,
-		done: make(chan struct{}),
+		pipe: &logPipe{
+			logs:   make(chan *Log, DefaultLogBuffer),
+			writer: &streamLogWriter{out: out},
+			level:  LogSevDebug,
+			format: LogFormatText,
+			done:   make(chan struct{}),
+		},
 	}
 }
 
+// Configure applies the system.log settings to the logger. Empty settings
+// keep the current values. Must be called before Start.
+func (logger *Logger) Configure(config types.CfgBlockSystemLog) error {
+	pipe := logger.pipe
+	if config.Level != "" {
+		level, ok := ParseLogSev(config.Level)
+		if !ok {
+			return fmt.Errorf("unknown log level %q", config.Level)
+		}
+		pipe.level = level
+	}
+	switch config.Format {
+	case "":
+	case LogFormatText, LogFormatJSON:
+		pipe.format = config.Format
+	default:
+		return fmt.Errorf("unknown log format %q, want one of: %s, %s", config.Format, LogFormatText, LogFormatJSON)
+	}
+	sampling := config.Sampling
+	if sampling.Initial < 0 || sampling.Thereafter < 0 {
+		return fmt.Errorf("log sampling settings must be non-negative, got: initial=%d, thereafter=%d", sampling.Initial, sampling.Thereafter)
+	}
+	if sampling.Initial > 0 {
+		pipe.sampler = newLogSampler(sampling.Initial, sampling.Thereafter)
+	}
+	if config.Output != "" {
+		writer, err := newLogWriter(config.Output)
+		if err != nil {
+			return err
+		}
+		if err := pipe.writer.Close(); err != nil {
+			return err
+		}
+		pipe.writer = writer
+	}
+	return nil
+}
+
+func newLogWriter(output string) (logWriter, error) {
+	switch output {
+	case LogOutputStdout:
+		return &streamLogWriter{out: os.Stdout}, nil
+	case LogOutputStderr:
+		return &streamLogWriter{out: os.Stderr}, nil
+	case LogOutputSyslog:
+		return newSyslogLogWriter()
+	}
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %s", err)
+	}
+	return &streamLogWriter{out: file, closer: file}, nil
+}
+
 func (logger *Logger) Start() error {
+	pipe := logger.pipe
 	go func() {
 		var err error
-		for log := range logger.logs {
-			_, err = fmt.Fprintln(logger.out, logger.Format(log))
+		for log := range pipe.logs {
+			err = pipe.writer.WriteLog(log.sev, logger.Format(log))
 			if err != nil {
 				panic(err.Error())
 			}
 		}
-		close(logger.done)
+		close(pipe.done)
 	}()
 
 	return nil
 }
 
 func (logger *Logger) Stop() error {
-	close(logger.logs)
-	<-logger.done
+	close(logger.pipe.logs)
+	<-logger.pipe.done
 
-	return nil
+	return logger.pipe.writer.Close()
+}
+
+// With returns a logger attaching the fields to every entry on top of the
+// fields the original logger attaches. Both loggers share the queue and the
+// output: starting or stopping one of them affects the other.
+func (logger *Logger) With(fields LogFields) *Logger {
+	merged := make(LogFields, len(logger.fields)+len(fields))
+	for k, v := range logger.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{pipe: logger.pipe, fields: merged}
+}
+
+// Enabled indicates whether the entries of the severity make it to the
+// output. Use it to guard the log calls with the arguments that are
+// expensive to compute.
+func (logger *Logger) Enabled(sev LogSev) bool {
+	return sev >= logger.pipe.level
 }
 
 var LogSevLex map[LogSev]string
@@ -81,37 +266,104 @@ func init() {
 	}
 }
 
+// ParseLogSev looks up the severity by it's case-insensitive name.
+func ParseLogSev(name string) (LogSev, bool) {
+	for sev, lex := range LogSevLex {
+		if strings.EqualFold(lex, name) {
+			return sev, true
+		}
+	}
+	return 0, false
+}
+
 func (logger *Logger) Format(log *Log) string {
-	return fmt.Sprintf(
+	if logger.pipe.format == LogFormatJSON {
+		return formatLogJSON(log)
+	}
+	return formatLogText(log)
+}
+
+func formatLogText(log *Log) string {
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
 		"%s\t%s\t%s",
-		time.Now().Format(time.RFC3339),
+		log.time.Format(time.RFC3339),
 		LogSevLex[log.sev],
 		log.payload,
 	)
+	keys := make([]string, 0, len(log.fields))
+	for k := range log.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\t%s=%v", k, log.fields[k])
+	}
+	return b.String()
+}
+
+func formatLogJSON(log *Log) string {
+	entry := make(map[string]interface{}, len(log.fields)+3)
+	for k, v := range log.fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = log.time.Format(time.RFC3339Nano)
+	entry["level"] = LogSevLex[log.sev]
+	entry["msg"] = log.payload
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// A field is not serializable, fall back to it's string form
+		for k, v := range log.fields {
+			entry[k] = fmt.Sprint(v)
+		}
+		data, _ = json.Marshal(entry)
+	}
+	return string(data)
+}
+
+func (logger *Logger) log(sev LogSev, format string, a []interface{}) {
+	pipe := logger.pipe
+	if sev < pipe.level {
+		return
+	}
+	now := time.Now()
+	if pipe.sampler != nil && sev < LogSevFatal && !pipe.sampler.allow(sev, format, now) {
+		return
+	}
+	pipe.logs <- &Log{
+		sev:     sev,
+		time:    now,
+		payload: fmt.Sprintf(format, a...),
+		fields:  logger.fields,
+	}
 }
 
 func (logger *Logger) Debug(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevDebug, fmt.Sprintf(format, a...))
+	logger.log(LogSevDebug, format, a)
 }
 
 func (logger *Logger) Trace(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevTrace, fmt.Sprintf(format, a...))
+	logger.log(LogSevTrace, format, a)
 }
 
 func (logger *Logger) Info(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevInfo, fmt.Sprintf(format, a...))
+	logger.log(LogSevInfo, format, a)
 }
 
 func (logger *Logger) Warn(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevWarn, fmt.Sprintf(format, a...))
+	logger.log(LogSevWarn, format, a)
 }
 
 func (logger *Logger) Error(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevError, fmt.Sprintf(format, a...))
+	logger.log(LogSevError, format, a)
 }
 
 func (logger *Logger) Fatal(format string, a ...interface{}) {
-	logger.logs <- NewLog(LogSevFatal, fmt.Sprintf(format, a...))
+	logger.log(LogSevFatal, format, a)
 	logger.Stop()
 	logger.terminate()
 }
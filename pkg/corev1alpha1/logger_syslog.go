@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package corev1alpha1
+
+import "log/syslog"
+
+// syslogLogWriter sends the log lines to the local syslog daemon, mapping
+// the logger severities onto the syslog ones.
+type syslogLogWriter struct {
+	w *syslog.Writer
+}
+
+func newSyslogLogWriter() (logWriter, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "flowd")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogLogWriter{w: w}, nil
+}
+
+func (w *syslogLogWriter) WriteLog(sev LogSev, line string) error {
+	switch sev {
+	case LogSevDebug, LogSevTrace:
+		return w.w.Debug(line)
+	case LogSevWarn:
+		return w.w.Warning(line)
+	case LogSevError:
+		return w.w.Err(line)
+	case LogSevFatal:
+		return w.w.Crit(line)
+	default:
+		return w.w.Info(line)
+	}
+}
+
+func (w *syslogLogWriter) Close() error {
+	return w.w.Close()
+}
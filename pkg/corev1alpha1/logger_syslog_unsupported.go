@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package corev1alpha1
+
+import "fmt"
+
+func newSyslogLogWriter() (logWriter, error) {
+	return nil, fmt.Errorf("syslog log output is not supported on this platform")
+}
@@ -3,12 +3,18 @@ package corev1alpha1
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/awesome-flow/flow/pkg/types"
 	testutil "github.com/awesome-flow/flow/pkg/util/test"
 )
 
@@ -63,3 +69,203 @@ func TestLogger(t *testing.T) {
 		t.Fatalf("output is incomplete: got: %d lines, want: %d lines", ix, len(res))
 	}
 }
+
+func TestLoggerJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := NewLogger(out)
+	if err := l.Configure(types.CfgBlockSystemLog{Format: LogFormatJSON}); err != nil {
+		t.Fatalf("failed to configure logger: %s", err)
+	}
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start logger")
+	}
+	l.With(LogFields{"actor": "receiver", "err": fmt.Errorf("boom")}).Warn("hello %s", "world")
+	if err := l.Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse log line %q: %s", out.String(), err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, got["time"].(string)); err != nil {
+		t.Fatalf("malformed log time: %s", err)
+	}
+	delete(got, "time")
+	want := map[string]interface{}{
+		"level": "WARN",
+		"msg":   "hello world",
+		"actor": "receiver",
+		"err":   "boom",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected log entry: got: %#v, want: %#v", got, want)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := NewLogger(out)
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start logger")
+	}
+	actor := l.With(LogFields{"actor": "receiver"})
+	actor.With(LogFields{"remote": "127.0.0.1:1234"}).Info("new connection")
+	actor.Info("done")
+	l.Info("plain")
+	if err := l.Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	want := []string{
+		"INFO\tnew connection\tactor=receiver\tremote=127.0.0.1:1234",
+		"INFO\tdone\tactor=receiver",
+		"INFO\tplain",
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected log lines: got: %q, want suffixes: %q", lines, want)
+	}
+	for ix, line := range lines {
+		if !strings.HasSuffix(line, want[ix]) {
+			t.Fatalf("logline %q is expected to contain suffix %q", line, want[ix])
+		}
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := NewLogger(out)
+	if err := l.Configure(types.CfgBlockSystemLog{Level: "warn"}); err != nil {
+		t.Fatalf("failed to configure logger: %s", err)
+	}
+	if l.Enabled(LogSevInfo) || !l.Enabled(LogSevWarn) {
+		t.Fatalf("unexpected Enabled() result for level WARN")
+	}
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start logger")
+	}
+	l.Debug("debug")
+	l.Trace("trace")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	if err := l.Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "WARN\twarn") || !strings.HasSuffix(lines[1], "ERROR\terror") {
+		t.Fatalf("unexpected log lines: %q", lines)
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := NewLogger(out)
+	if err := l.Configure(types.CfgBlockSystemLog{
+		Sampling: types.CfgBlockSystemLogSampling{Initial: 3, Thereafter: 10},
+	}); err != nil {
+		t.Fatalf("failed to configure logger: %s", err)
+	}
+	// Keeps the test away from the sampling window boundary
+	for time.Now().Nanosecond() > 5e8 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start logger")
+	}
+	for i := 0; i < 25; i++ {
+		l.Info("sampled %d", i)
+		l.Warn("other")
+	}
+	if err := l.Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	var sampled, other int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		switch {
+		case strings.Contains(line, "sampled"):
+			sampled++
+		case strings.Contains(line, "other"):
+			other++
+		}
+	}
+	// 3 initial entries, then the 13th and the 23rd ones
+	if sampled != 5 || other != 5 {
+		t.Fatalf("unexpected sampled entry count: got: %d and %d, want: 5 and 5", sampled, other)
+	}
+}
+
+func TestLoggerConfigure(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  types.CfgBlockSystemLog
+		wantErr error
+	}{
+		{
+			"empty config",
+			types.CfgBlockSystemLog{},
+			nil,
+		},
+		{
+			"case-insensitive level",
+			types.CfgBlockSystemLog{Level: "Error", Format: LogFormatText, Output: LogOutputStderr},
+			nil,
+		},
+		{
+			"unknown level",
+			types.CfgBlockSystemLog{Level: "verbose"},
+			fmt.Errorf("unknown log level %q", "verbose"),
+		},
+		{
+			"unknown format",
+			types.CfgBlockSystemLog{Format: "xml"},
+			fmt.Errorf("unknown log format %q, want one of: text, json", "xml"),
+		},
+		{
+			"negative sampling",
+			types.CfgBlockSystemLog{Sampling: types.CfgBlockSystemLogSampling{Initial: -1}},
+			fmt.Errorf("log sampling settings must be non-negative, got: initial=-1, thereafter=0"),
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := NewLogger(new(bytes.Buffer)).Configure(testCase.config)
+			if !reflect.DeepEqual(err, testCase.wantErr) {
+				t.Fatalf("unexpected error: got: %v, want: %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoggerFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-logger")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flowd.log")
+
+	l := NewLogger(new(bytes.Buffer))
+	if err := l.Configure(types.CfgBlockSystemLog{Output: path}); err != nil {
+		t.Fatalf("failed to configure logger: %s", err)
+	}
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start logger")
+	}
+	l.Info("to file")
+	if err := l.Stop(); err != nil {
+		t.Fatalf("failed to stop logger: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the log file: %s", err)
+	}
+	if !strings.HasSuffix(string(data), "INFO\tto file\n") {
+		t.Fatalf("unexpected log file contents: %q", data)
+	}
+}
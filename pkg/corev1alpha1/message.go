@@ -3,6 +3,7 @@ package corev1alpha1
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
 type MsgStatus uint8
@@ -36,18 +37,24 @@ var (
 	MsgCompletedBeforeErr = fmt.Errorf("message has been completed before")
 )
 
+// msgSeq is the last assigned message ID.
+var msgSeq uint64
+
 type Message struct {
-	body   []byte
-	done   chan struct{}
-	meta   map[interface{}]interface{}
-	status MsgStatus
-	mutex  sync.Mutex
+	id         uint64
+	body       []byte
+	done       chan struct{}
+	meta       map[interface{}]interface{}
+	status     MsgStatus
+	oncomplete []func(MsgStatus)
+	mutex      sync.Mutex
 }
 
 func NewMessage(body []byte) *Message {
 	cpbody := make([]byte, len(body))
 	copy(cpbody, body)
 	return &Message{
+		id:     atomic.AddUint64(&msgSeq, 1),
 		body:   cpbody,
 		done:   make(chan struct{}),
 		meta:   make(map[interface{}]interface{}),
@@ -55,13 +62,28 @@ func NewMessage(body []byte) *Message {
 	}
 }
 
+// ID returns the process-wide unique message identifier. It's assigned on
+// the message creation and is meant to correlate the log entries. A copy of
+// the message gets an ID of it's own.
+func (msg *Message) ID() uint64 {
+	return msg.id
+}
+
+// LogFields returns the log fields identifying the message.
+func (msg *Message) LogFields() LogFields {
+	return LogFields{"msg_id": msg.id}
+}
+
 func (msg *Message) Await() MsgStatus {
 	<-msg.done
 	return msg.status
 }
 
+// AwaitChan returns a channel delivering the final status once the message
+// is completed. The caller is free to stop listening on it (e.g. on a
+// timeout): the channel is buffered.
 func (msg *Message) AwaitChan() <-chan MsgStatus {
-	res := make(chan MsgStatus)
+	res := make(chan MsgStatus, 1)
 	go func() {
 		<-msg.done
 		res <- msg.status
@@ -72,15 +94,36 @@ func (msg *Message) AwaitChan() <-chan MsgStatus {
 
 func (msg *Message) Complete(status MsgStatus) error {
 	msg.mutex.Lock()
-	defer msg.mutex.Unlock()
 	if msg.status != MsgStatusNew {
+		msg.mutex.Unlock()
 		return MsgCompletedBeforeErr
 	}
 	msg.status = status
 	close(msg.done)
+	oncomplete := msg.oncomplete
+	msg.oncomplete = nil
+	msg.mutex.Unlock()
+	for _, f := range oncomplete {
+		f(status)
+	}
 	return nil
 }
 
+// OnComplete registers a function to be called with the final status once
+// the message is completed. It's called right away if the message has been
+// completed already. The message copies do not inherit the functions.
+func (msg *Message) OnComplete(f func(MsgStatus)) {
+	msg.mutex.Lock()
+	if msg.status == MsgStatusNew {
+		msg.oncomplete = append(msg.oncomplete, f)
+		msg.mutex.Unlock()
+		return
+	}
+	status := msg.status
+	msg.mutex.Unlock()
+	f(status)
+}
+
 func (msg *Message) Body() []byte {
 	return msg.body
 }
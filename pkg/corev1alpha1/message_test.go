@@ -20,6 +20,20 @@ func TestNewMessage(t *testing.T) {
 	}
 }
 
+func TestMessageID(t *testing.T) {
+	msg1, msg2 := NewMessage(nil), NewMessage(nil)
+	if msg1.ID() == msg2.ID() {
+		t.Fatalf("expected distinct message IDs, got: %d", msg1.ID())
+	}
+	if cpmsg := msg1.Copy(); cpmsg.ID() == msg1.ID() {
+		t.Fatalf("expected the message copy to get a new ID")
+	}
+	want := LogFields{"msg_id": msg1.ID()}
+	if got := msg1.LogFields(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected log fields: got: %+v, want: %+v", got, want)
+	}
+}
+
 func TestNewMessageCopyBody(t *testing.T) {
 	body := testutil.RandBytes(1024)
 	msg := NewMessage(body)
@@ -57,6 +71,26 @@ func TestAwaitChan(t *testing.T) {
 	}
 }
 
+func TestOnComplete(t *testing.T) {
+	msg := NewMessage(testutil.RandBytes(1024))
+	var got []MsgStatus
+	msg.OnComplete(func(status MsgStatus) {
+		got = append(got, status)
+	})
+	if msg.Copy().Complete(MsgStatusFailed); len(got) != 0 {
+		t.Fatalf("the copy completion has been reported: %v", got)
+	}
+	msg.Complete(MsgStatusThrottled)
+	msg.Complete(MsgStatusDone)
+	// Registered after the completion
+	msg.OnComplete(func(status MsgStatus) {
+		got = append(got, status)
+	})
+	if want := []MsgStatus{MsgStatusThrottled, MsgStatusThrottled}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected completion reports: got: %v, want: %v", got, want)
+	}
+}
+
 func TestMetaKeys(t *testing.T) {
 	msg := NewMessage(testutil.RandBytes(1024))
 	meta := make(map[string]interface{})
@@ -11,14 +11,15 @@ import (
 )
 
 var CoreBuilders map[string]core.Builder = map[string]core.Builder{
-	"core.receiver":   actor.ReceiverFactory,
-	"core.buffer":     actor.NewBuffer,
-	"core.compressor": actor.NewCompressor,
-	"core.mux":        actor.NewMux,
-	"core.replicator": actor.NewReplicator,
-	"core.router":     actor.NewRouter,
-	"core.throttler":  actor.NewThrottler,
-	"core.sink":       actor.NewSink,
+	"core.receiver":          actor.ReceiverFactory,
+	"core.buffer":            actor.NewBuffer,
+	"core.compressor":        actor.NewCompressor,
+	"core.mux":               actor.NewMux,
+	"core.replicator":        actor.NewReplicator,
+	"core.router":            actor.NewRouter,
+	"core.throttler":         actor.NewThrottler,
+	"core.sink":              actor.NewSink,
+	"core.statsd_aggregator": actor.NewStatsdAggregator,
 }
 
 type ActorFactory interface {
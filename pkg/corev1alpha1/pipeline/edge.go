@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+// edge delivers the messages from an actor to its peer: the actor is
+// connected to the edge instead of the peer itself, this way the traffic
+// between the two might be observed. The counters go first to keep them
+// 64-bit aligned for the atomic operations.
+type edge struct {
+	sent      uint64
+	done      uint64
+	throttled uint64
+	failed    uint64
+	from      string
+	to        string
+	peer      core.Receiver
+	lock      sync.RWMutex
+	taps      map[*Tap]struct{}
+}
+
+var _ core.Receiver = (*edge)(nil)
+var _ core.Namer = (*edge)(nil)
+
+func newEdge(from, to string, peer core.Receiver) *edge {
+	return &edge{
+		from: from,
+		to:   to,
+		peer: peer,
+		taps: make(map[*Tap]struct{}),
+	}
+}
+
+// Name returns the peer name: the actors might tell the peers by name.
+func (e *edge) Name() string {
+	return e.to
+}
+
+func (e *edge) Receive(msg *core.Message) error {
+	atomic.AddUint64(&e.sent, 1)
+	msg.OnComplete(e.complete)
+	if trace, ok := msg.Meta(traceMetaKey{}); ok {
+		trace.(*msgTrace).add(e.from, e.to)
+	}
+	e.lock.RLock()
+	for tap := range e.taps {
+		tap.observe(msg)
+	}
+	e.lock.RUnlock()
+	return e.peer.Receive(msg)
+}
+
+func (e *edge) complete(status core.MsgStatus) {
+	switch status {
+	case core.MsgStatusDone, core.MsgStatusPartialSend:
+		atomic.AddUint64(&e.done, 1)
+	case core.MsgStatusThrottled:
+		atomic.AddUint64(&e.throttled, 1)
+	default:
+		atomic.AddUint64(&e.failed, 1)
+	}
+}
+
+// EdgeStats are the counters of the messages passed through an edge: Sent
+// is the number of the messages delivered to the peer, the rest are the
+// numbers of the messages completed by the peer with the corresponding
+// status (Failed covers all failure statuses, except throttling).
+type EdgeStats struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Sent      uint64 `json:"sent"`
+	Done      uint64 `json:"done"`
+	Throttled uint64 `json:"throttled"`
+	Failed    uint64 `json:"failed"`
+}
+
+func (e *edge) stats() EdgeStats {
+	return EdgeStats{
+		From:      e.from,
+		To:        e.to,
+		Sent:      atomic.LoadUint64(&e.sent),
+		Done:      atomic.LoadUint64(&e.done),
+		Throttled: atomic.LoadUint64(&e.throttled),
+		Failed:    atomic.LoadUint64(&e.failed),
+	}
+}
+
+// EdgeStats returns the edge counters sorted by the edge.
+func (p *Pipeline) EdgeStats() []EdgeStats {
+	res := make([]EdgeStats, 0, len(p.edges))
+	for _, e := range p.edges {
+		res = append(res, e.stats())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].From != res[j].From {
+			return res[i].From < res[j].From
+		}
+		return res[i].To < res[j].To
+	})
+	return res
+}
@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestPipelineEdgeStats(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	statuses := []core.MsgStatus{
+		core.MsgStatusDone,
+		core.MsgStatusPartialSend,
+		core.MsgStatusThrottled,
+		core.MsgStatusFailed,
+		core.MsgStatusTimedOut,
+	}
+	sink := p.actors["sink-1"].(*flowtest.TestActor)
+	sink.OnReceive(func(msg *core.Message) {
+		if len(statuses) > 0 {
+			msg.Complete(statuses[0])
+			statuses = statuses[1:]
+		}
+		sink.Flush()
+	})
+	e := p.edges[TopologyEdge{From: "buffer", To: "sink-1"}]
+	for i := 0; i < 5; i++ {
+		if err := e.Receive(core.NewMessage(nil)); err != nil {
+			t.Fatalf("failed to send the message: %s", err)
+		}
+	}
+	// A message awaiting the status is only counted as sent
+	if err := e.Receive(core.NewMessage(nil)); err != nil {
+		t.Fatalf("failed to send the message: %s", err)
+	}
+
+	want := []EdgeStats{
+		{From: "buffer", To: "sink-1", Sent: 6, Done: 2, Throttled: 1, Failed: 2},
+		{From: "buffer", To: "sink-2"},
+		{From: "receiver", To: "buffer"},
+	}
+	if got := p.EdgeStats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected edge stats: got: %+v, want: %+v", got, want)
+	}
+}
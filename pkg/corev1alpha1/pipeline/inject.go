@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	DefaultInjectTimeout = 5 * time.Second
+)
+
+// traceMetaKey is the meta key of the trace of an injected message. It's not
+// a string, so the actors looking up the string meta keys never see it. The
+// message copies share the trace.
+type traceMetaKey struct{}
+
+// msgTrace collects the edges an injected message has passed through.
+type msgTrace struct {
+	lock sync.Mutex
+	hops []TopologyEdge
+}
+
+func (t *msgTrace) add(from, to string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.hops = append(t.hops, TopologyEdge{From: from, To: to})
+}
+
+func (t *msgTrace) path() []TopologyEdge {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	res := make([]TopologyEdge, len(t.hops))
+	copy(res, t.hops)
+	return res
+}
+
+// InjectResult is the outcome of an injected message: the final status and
+// the edges it has passed through in the order of passing. If the message
+// has not been completed in time, the status is MsgStatusTimedOut and
+// TimedOut is set. Error is the error returned by the actor Receive.
+type InjectResult struct {
+	Actor    string
+	Status   core.MsgStatus
+	TimedOut bool
+	Error    error
+	Path     []TopologyEdge
+}
+
+// Inject delivers the message to the actor and waits for the message to be
+// completed up to the timeout.
+func (p *Pipeline) Inject(name string, msg *core.Message, timeout time.Duration) (*InjectResult, error) {
+	actor, ok := p.actors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown actor %q", name)
+	}
+	if timeout <= 0 {
+		timeout = DefaultInjectTimeout
+	}
+	trace := &msgTrace{}
+	msg.SetMeta(traceMetaKey{}, trace)
+
+	errs := make(chan error, 1)
+	go func() {
+		// Receive might block: a peer might be paused or stuck.
+		err := actor.Receive(msg)
+		if err != nil {
+			msg.Complete(core.MsgStatusFailed)
+		}
+		errs <- err
+	}()
+
+	res := &InjectResult{Actor: name}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res.Status = <-msg.AwaitChan():
+	case <-timer.C:
+		res.Status = core.MsgStatusTimedOut
+		res.TimedOut = true
+	}
+	select {
+	case res.Error = <-errs:
+	default:
+	}
+	res.Path = trace.path()
+
+	return res, nil
+}
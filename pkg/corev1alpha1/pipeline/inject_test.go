@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestPipelineInject(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	for _, conn := range []TopologyEdge{
+		{From: "receiver", To: "buffer"},
+		{From: "buffer", To: "sink-1"},
+	} {
+		if err := p.actors[conn.From].Connect(1, p.edges[conn]); err != nil {
+			t.Fatalf("failed to connect %s to %s: %s", conn.From, conn.To, err)
+		}
+	}
+	sink := p.actors["sink-1"].(*flowtest.TestActor)
+	sink.OnReceive(func(msg *core.Message) {
+		msg.Complete(core.MsgStatusDone)
+		sink.Flush()
+	})
+
+	res, err := p.Inject("receiver", core.NewMessage([]byte("hello")), time.Second)
+	if err != nil {
+		t.Fatalf("failed to inject the message: %s", err)
+	}
+	want := &InjectResult{
+		Actor:  "receiver",
+		Status: core.MsgStatusDone,
+		Path: []TopologyEdge{
+			{From: "receiver", To: "buffer"},
+			{From: "buffer", To: "sink-1"},
+		},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("unexpected inject result: got: %+v, want: %+v", res, want)
+	}
+
+	// sink-2 never completes the messages
+	res, err = p.Inject("sink-2", core.NewMessage([]byte("hello")), 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to inject the message: %s", err)
+	}
+	want = &InjectResult{
+		Actor:    "sink-2",
+		Status:   core.MsgStatusTimedOut,
+		TimedOut: true,
+		Path:     []TopologyEdge{},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("unexpected inject result: got: %+v, want: %+v", res, want)
+	}
+
+	if _, err := p.Inject("unknown", core.NewMessage(nil), time.Second); err == nil || err.Error() != `unknown actor "unknown"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+const (
+	ActorStateInitialized = "initialized"
+	ActorStateRunning     = "running"
+	ActorStateStopped     = "stopped"
+	ActorStatePaused      = "paused"
+	ActorStateDraining    = "draining"
+)
+
+// ActorInfo is a runtime snapshot of a pipeline actor. Threads is the number
+// of goroutines delivering the actor messages to its peers. QueueDepth and
+// QueueCapacity are only reported by the actors holding messages internally
+// (see core.QueueReporter), they are nil for the rest. Pausable tells if the actor
+// implements core.Pauser.
+type ActorInfo struct {
+	Name          string                 `json:"name"`
+	Module        string                 `json:"module"`
+	Params        map[string]types.Value `json:"params"`
+	State         string                 `json:"state"`
+	Peers         []string               `json:"peers"`
+	Threads       int                    `json:"threads"`
+	QueueDepth    *int                   `json:"queue_depth"`
+	QueueCapacity *int                   `json:"queue_capacity"`
+	Pausable      bool                   `json:"pausable"`
+}
+
+// TopologyEdge connects an actor to its peer.
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TopologyInfo is the pipeline graph: the actor names and the connections
+// between them.
+type TopologyInfo struct {
+	Nodes []string       `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+func (p *Pipeline) setState(name, state string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.states == nil {
+		p.states = make(map[string]string)
+	}
+	p.states[name] = state
+}
+
+func (p *Pipeline) state(name string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if state, ok := p.states[name]; ok {
+		return state
+	}
+	return ActorStateInitialized
+}
+
+// Topology returns the pipeline graph, the nodes and the edges are sorted.
+func (p *Pipeline) Topology() TopologyInfo {
+	res := TopologyInfo{
+		Nodes: make([]string, 0, len(p.topology.Nodes)),
+		Edges: make([]TopologyEdge, 0, len(p.topology.Edges)),
+	}
+	for node := range p.topology.Nodes {
+		res.Nodes = append(res.Nodes, node.(core.Namer).Name())
+	}
+	for edge := range p.topology.Edges {
+		res.Edges = append(res.Edges, TopologyEdge{
+			From: edge.From.(core.Namer).Name(),
+			To:   edge.To.(core.Namer).Name(),
+		})
+	}
+	sort.Strings(res.Nodes)
+	sort.Slice(res.Edges, func(i, j int) bool {
+		if res.Edges[i].From != res.Edges[j].From {
+			return res.Edges[i].From < res.Edges[j].From
+		}
+		return res.Edges[i].To < res.Edges[j].To
+	})
+	return res
+}
+
+// Actors returns the actor snapshots sorted by name.
+func (p *Pipeline) Actors() []ActorInfo {
+	res := make([]ActorInfo, 0, len(p.actors))
+	for name := range p.actors {
+		info, _ := p.Actor(name)
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Actor returns the snapshot of the actor or false if there is no actor
+// with this name.
+func (p *Pipeline) Actor(name string) (ActorInfo, bool) {
+	actor, ok := p.actors[name]
+	if !ok {
+		return ActorInfo{}, false
+	}
+	info := ActorInfo{
+		Name:   name,
+		Params: map[string]types.Value{},
+		State:  p.state(name),
+		Peers:  []string{},
+	}
+	if actblocks, ok := p.ctx.Config().Get(types.NewKey("actors")); ok {
+		if actorcfg, ok := actblocks.(map[string]types.CfgBlockActor)[name]; ok {
+			info.Module = actorcfg.Module
+			if actorcfg.Params != nil {
+				info.Params = actorcfg.Params
+			}
+		}
+	}
+	for edge := range p.topology.Edges {
+		if edge.From == actor {
+			info.Peers = append(info.Peers, edge.To.(core.Namer).Name())
+		}
+	}
+	sort.Strings(info.Peers)
+	if len(info.Peers) > 0 {
+		nthreads, _ := p.ctx.Config().Get(types.NewKey("system.maxprocs"))
+		if n, ok := nthreads.(int); ok {
+			info.Threads = n * len(info.Peers)
+		}
+	}
+	if reporter, ok := actor.(core.QueueReporter); ok {
+		depth, capacity := reporter.QueueLen(), reporter.QueueCap()
+		info.QueueDepth, info.QueueCapacity = &depth, &capacity
+	}
+	_, info.Pausable = actor.(core.Pauser)
+	return info, true
+}
+
+func (p *Pipeline) pauser(name string) (core.Pauser, error) {
+	actor, ok := p.actors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown actor %q", name)
+	}
+	pauser, ok := actor.(core.Pauser)
+	if !ok {
+		return nil, fmt.Errorf("actor %q can not be paused", name)
+	}
+	return pauser, nil
+}
+
+// PauseActor stops the actor from processing the inbound messages, what
+// happens to them is up to the actor.
+func (p *Pipeline) PauseActor(name string) error {
+	pauser, err := p.pauser(name)
+	if err != nil {
+		return err
+	}
+	if err := pauser.Pause(); err != nil {
+		return err
+	}
+	p.setState(name, ActorStatePaused)
+	return nil
+}
+
+// ResumeActor brings a paused actor back to the running state.
+func (p *Pipeline) ResumeActor(name string) error {
+	pauser, err := p.pauser(name)
+	if err != nil {
+		return err
+	}
+	if err := pauser.Resume(); err != nil {
+		return err
+	}
+	p.setState(name, ActorStateRunning)
+	return nil
+}
+
+// DrainActor pauses the actor and blocks until its in-flight messages are
+// completed or the timeout expires. The actor is reported as draining in the
+// meantime: it stays draining if the timeout expires.
+func (p *Pipeline) DrainActor(name string, timeout time.Duration) error {
+	pauser, err := p.pauser(name)
+	if err != nil {
+		return err
+	}
+	p.setState(name, ActorStateDraining)
+	if err := pauser.Drain(timeout); err != nil {
+		return err
+	}
+	p.setState(name, ActorStatePaused)
+	return nil
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awesome-flow/flow/pkg/cfg"
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/types"
+	"github.com/awesome-flow/flow/pkg/util/data"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+type queueTestActor struct {
+	core.Actor
+	qlen   int
+	events []string
+}
+
+func (a *queueTestActor) QueueLen() int {
+	return a.qlen
+}
+
+func (a *queueTestActor) QueueCap() int {
+	return 16
+}
+
+func (a *queueTestActor) Pause() error {
+	a.events = append(a.events, "pause")
+	return nil
+}
+
+func (a *queueTestActor) Resume() error {
+	a.events = append(a.events, "resume")
+	return nil
+}
+
+func (a *queueTestActor) Drain(time.Duration) error {
+	a.events = append(a.events, "drain")
+	return nil
+}
+
+func newInspectTestPipeline(t *testing.T) *Pipeline {
+	repo := cfg.NewRepository()
+	ctx, err := core.NewContext(core.NewConfig(repo))
+	if err != nil {
+		t.Fatalf("failed to create a context: %s", err)
+	}
+	for _, kv := range []*types.KeyValue{
+		{
+			Key: types.NewKey("actors"),
+			Value: map[string]types.CfgBlockActor{
+				"receiver": {Module: "core.receiver", Params: map[string]types.Value{"bind": "tcp://:3101"}},
+				"buffer":   {Module: "core.buffer"},
+				"sink-1":   {Module: "core.sink", Params: map[string]types.Value{"bind": "udp://127.0.0.1:7722"}},
+				"sink-2":   {Module: "core.sink", Params: map[string]types.Value{"bind": "udp://127.0.0.1:7722"}},
+			},
+		},
+		{Key: types.NewKey("system.maxprocs"), Value: 4},
+	} {
+		if _, err := cfg.NewScalarConfigProvider(kv, repo, 42); err != nil {
+			t.Fatalf("failed to create scalar provider: %s", err)
+		}
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+
+	actors := make(map[string]core.Actor)
+	for _, name := range []string{"receiver", "buffer", "sink-1", "sink-2"} {
+		act, err := flowtest.NewTestActor(name, ctx, nil)
+		if err != nil {
+			t.Fatalf("failed to create a test actor: %s", err)
+		}
+		actors[name] = act
+	}
+	actors["buffer"] = &queueTestActor{Actor: actors["buffer"], qlen: 7}
+
+	top := data.NewTopology()
+	for _, act := range actors {
+		top.AddNode(act)
+	}
+	edges := make(map[TopologyEdge]*edge)
+	for _, conn := range []TopologyEdge{
+		{From: "receiver", To: "buffer"},
+		{From: "buffer", To: "sink-1"},
+		{From: "buffer", To: "sink-2"},
+	} {
+		top.Connect(actors[conn.From], actors[conn.To])
+		edges[conn] = newEdge(conn.From, conn.To, actors[conn.To])
+	}
+
+	return &Pipeline{ctx: ctx, actors: actors, topology: top, edges: edges}
+}
+
+func TestPipelineTopology(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	want := TopologyInfo{
+		Nodes: []string{"buffer", "receiver", "sink-1", "sink-2"},
+		Edges: []TopologyEdge{
+			{From: "buffer", To: "sink-1"},
+			{From: "buffer", To: "sink-2"},
+			{From: "receiver", To: "buffer"},
+		},
+	}
+	if got := p.Topology(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected topology: got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestPipelineActors(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	depth, capacity := 7, 16
+	want := []ActorInfo{
+		{
+			Name:          "buffer",
+			Module:        "core.buffer",
+			Params:        map[string]types.Value{},
+			State:         ActorStateInitialized,
+			Peers:         []string{"sink-1", "sink-2"},
+			Threads:       8,
+			QueueDepth:    &depth,
+			QueueCapacity: &capacity,
+			Pausable:      true,
+		},
+		{
+			Name:    "receiver",
+			Module:  "core.receiver",
+			Params:  map[string]types.Value{"bind": "tcp://:3101"},
+			State:   ActorStateInitialized,
+			Peers:   []string{"buffer"},
+			Threads: 4,
+		},
+		{Name: "sink-1", Module: "core.sink", Params: map[string]types.Value{"bind": "udp://127.0.0.1:7722"}, State: ActorStateInitialized, Peers: []string{}},
+		{Name: "sink-2", Module: "core.sink", Params: map[string]types.Value{"bind": "udp://127.0.0.1:7722"}, State: ActorStateInitialized, Peers: []string{}},
+	}
+	if got := p.Actors(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected actors: got: %+v, want: %+v", got, want)
+	}
+
+	if _, ok := p.Actor("unknown"); ok {
+		t.Fatalf("expected unknown actor lookup to fail")
+	}
+}
+
+func TestPipelineActorState(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	for _, step := range []struct {
+		action func() error
+		want   string
+	}{
+		{p.Start, ActorStateRunning},
+		{p.Stop, ActorStateStopped},
+	} {
+		if err := step.action(); err != nil {
+			t.Fatalf("failed to change the pipeline state: %s", err)
+		}
+		for _, info := range p.Actors() {
+			if info.State != step.want {
+				t.Fatalf("unexpected state of %q: got: %s, want: %s", info.Name, info.State, step.want)
+			}
+		}
+	}
+}
+
+func TestPipelinePauseActor(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	for _, step := range []struct {
+		action func(string) error
+		want   string
+	}{
+		{p.PauseActor, ActorStatePaused},
+		{p.ResumeActor, ActorStateRunning},
+		{func(name string) error { return p.DrainActor(name, time.Second) }, ActorStatePaused},
+	} {
+		if err := step.action("buffer"); err != nil {
+			t.Fatalf("failed to change the actor state: %s", err)
+		}
+		if info, _ := p.Actor("buffer"); info.State != step.want {
+			t.Fatalf("unexpected actor state: got: %s, want: %s", info.State, step.want)
+		}
+	}
+	if events, want := p.actors["buffer"].(*queueTestActor).events, []string{"pause", "resume", "drain"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("unexpected actor events: got: %v, want: %v", events, want)
+	}
+
+	if err := p.PauseActor("receiver"); err == nil || err.Error() != `actor "receiver" can not be paused` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.DrainActor("unknown", time.Second); err == nil || err.Error() != `unknown actor "unknown"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
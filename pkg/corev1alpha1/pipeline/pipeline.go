@@ -3,6 +3,7 @@ package pipeline
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
 	"github.com/awesome-flow/flow/pkg/types"
@@ -13,7 +14,10 @@ type Pipeline struct {
 	ctx       *core.Context
 	actors    map[string]core.Actor
 	topology  *data.Topology
+	edges     map[TopologyEdge]*edge
 	factories map[string]ActorFactory
+	states    map[string]string
+	lock      sync.Mutex
 }
 
 var _ core.Runner = (*Pipeline)(nil)
@@ -34,7 +38,7 @@ func NewPipelineWithFactories(ctx *core.Context, factories map[string]ActorFacto
 		return nil, err
 	}
 
-	topology, err := buildTopology(ctx, actors)
+	topology, edges, err := buildTopology(ctx, actors)
 	if err != nil {
 		return nil, err
 	}
@@ -43,6 +47,7 @@ func NewPipelineWithFactories(ctx *core.Context, factories map[string]ActorFacto
 		ctx:       ctx,
 		actors:    actors,
 		topology:  topology,
+		edges:     edges,
 		factories: factories,
 	}
 
@@ -59,6 +64,7 @@ func (p *Pipeline) Start() error {
 		if err := actor.(core.Actor).Start(); err != nil {
 			return err
 		}
+		p.setState(actor.(core.Actor).Name(), ActorStateRunning)
 	}
 
 	return nil
@@ -78,6 +84,7 @@ func (p *Pipeline) Stop() error {
 		if err := actor.(core.Actor).Stop(); err != nil {
 			return err
 		}
+		p.setState(actor.(core.Actor).Name(), ActorStateStopped)
 	}
 
 	return nil
@@ -106,7 +113,8 @@ func buildActors(ctx *core.Context, factories map[string]ActorFactory) (map[stri
 			return nil, fmt.Errorf("failed to find an actor factory for key %s", factkey)
 		}
 
-		actor, err := factories[factkey].Build(name, ctx, &actorcfg)
+		actorctx := ctx.WithLogFields(core.LogFields{"actor": name})
+		actor, err := factories[factkey].Build(name, actorctx, &actorcfg)
 		if err != nil {
 			return nil, err
 		}
@@ -117,15 +125,16 @@ func buildActors(ctx *core.Context, factories map[string]ActorFactory) (map[stri
 	return actors, nil
 }
 
-func buildTopology(ctx *core.Context, actors map[string]core.Actor) (*data.Topology, error) {
+func buildTopology(ctx *core.Context, actors map[string]core.Actor) (*data.Topology, map[TopologyEdge]*edge, error) {
 	topology := data.NewTopology()
+	edges := make(map[TopologyEdge]*edge)
 	for _, actor := range actors {
 		topology.AddNode(actor)
 	}
 
 	pipeline, ok := ctx.Config().Get(types.NewKey("pipeline"))
 	if !ok {
-		return nil, fmt.Errorf("pipeline config is missing")
+		return nil, nil, fmt.Errorf("pipeline config is missing")
 	}
 
 	nthreads, _ := ctx.Config().Get(types.NewKey("system.maxprocs"))
@@ -133,23 +142,25 @@ func buildTopology(ctx *core.Context, actors map[string]core.Actor) (*data.Topol
 	for name, cfg := range pipeline.(map[string]types.CfgBlockPipeline) {
 		actor, ok := actors[name]
 		if !ok {
-			return nil, fmt.Errorf("unknown actor in the pipeline config: %s", name)
+			return nil, nil, fmt.Errorf("unknown actor in the pipeline config: %s", name)
 		}
 		if len(cfg.Connect) != 0 {
 			for _, connect := range cfg.Connect {
 				peer, ok := actors[connect]
 				if !ok {
-					return nil, fmt.Errorf("unknown peer in the pipeline config: %s", cfg.Connect)
+					return nil, nil, fmt.Errorf("unknown peer in the pipeline config: %s", cfg.Connect)
 				}
-				if err := actor.Connect(nthreads.(int), peer); err != nil {
-					return nil, err
+				e := newEdge(name, connect, peer)
+				if err := actor.Connect(nthreads.(int), e); err != nil {
+					return nil, nil, err
 				}
 				if err := topology.Connect(actor, peer); err != nil {
-					return nil, err
+					return nil, nil, err
 				}
+				edges[TopologyEdge{From: name, To: connect}] = e
 			}
 		}
 	}
 
-	return topology, nil
+	return topology, edges, nil
 }
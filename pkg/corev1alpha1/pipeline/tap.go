@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sync/atomic"
+	"time"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+)
+
+const (
+	DefaultTapBuffer = 256
+)
+
+// TapEvent is a snapshot of a message passed through a tapped edge.
+type TapEvent struct {
+	Time time.Time
+	Body []byte
+	Meta map[string]string
+}
+
+// TapConfig tells which messages a tap should observe. Sample is the share
+// of the messages to observe, 0 < Sample <= 1. Meta maps the meta keys to
+// path.Match patterns the values should match. Buffer is the number of the
+// events a tap holds for the reader, the events are dropped once it's full.
+type TapConfig struct {
+	Sample float64
+	Meta   map[string]string
+	Buffer int
+}
+
+// Tap is a temporary observer attached to an edge. The events are delivered
+// to C until the tap is removed with Pipeline.Untap. Tapping never blocks
+// the traffic: the events are dropped if the reader falls behind.
+type Tap struct {
+	dropped uint64
+	C       <-chan *TapEvent
+	events  chan *TapEvent
+	edge    *edge
+	sample  float64
+	meta    map[string]string
+}
+
+func (t *Tap) matches(msg *core.Message) bool {
+	for key, pattern := range t.meta {
+		v, ok := msg.Meta(key)
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, fmt.Sprintf("%v", v)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Tap) observe(msg *core.Message) {
+	if !t.matches(msg) || (t.sample < 1 && rand.Float64() >= t.sample) {
+		return
+	}
+	body := make([]byte, len(msg.Body()))
+	copy(body, msg.Body())
+	meta := make(map[string]string)
+	for _, k := range msg.MetaKeys() {
+		key, ok := k.(string)
+		if !ok {
+			continue
+		}
+		if v, ok := msg.Meta(key); ok {
+			meta[key] = fmt.Sprintf("%v", v)
+		}
+	}
+	select {
+	case t.events <- &TapEvent{Time: time.Now(), Body: body, Meta: meta}:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped returns the number of the events dropped because the reader has
+// fallen behind.
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Tap attaches a tap to the edge between the actor and its peer. The
+// messages are observed without being consumed.
+func (p *Pipeline) Tap(from, to string, cfg TapConfig) (*Tap, error) {
+	e, ok := p.edges[TopologyEdge{From: from, To: to}]
+	if !ok {
+		return nil, fmt.Errorf("unknown edge %q -> %q", from, to)
+	}
+	if cfg.Sample <= 0 || cfg.Sample > 1 {
+		return nil, fmt.Errorf("tap sample should be in (0, 1], got: %v", cfg.Sample)
+	}
+	for key, pattern := range cfg.Meta {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("malformed tap pattern for meta %q: %s", key, err)
+		}
+	}
+	buffer := cfg.Buffer
+	if buffer <= 0 {
+		buffer = DefaultTapBuffer
+	}
+	events := make(chan *TapEvent, buffer)
+	tap := &Tap{
+		C:      events,
+		events: events,
+		edge:   e,
+		sample: cfg.Sample,
+		meta:   cfg.Meta,
+	}
+	e.lock.Lock()
+	e.taps[tap] = struct{}{}
+	e.lock.Unlock()
+
+	return tap, nil
+}
+
+// Untap detaches the tap and closes its channel.
+func (p *Pipeline) Untap(tap *Tap) {
+	tap.edge.lock.Lock()
+	defer tap.edge.lock.Unlock()
+	if _, ok := tap.edge.taps[tap]; !ok {
+		return
+	}
+	delete(tap.edge.taps, tap)
+	close(tap.events)
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	flowtest "github.com/awesome-flow/flow/pkg/util/test/corev1alpha1"
+)
+
+func TestPipelineTap(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	received := make([]string, 0, 3)
+	sink := p.actors["sink-1"].(*flowtest.TestActor)
+	sink.OnReceive(func(msg *core.Message) {
+		received = append(received, string(msg.Body()))
+		sink.Flush()
+	})
+
+	tap, err := p.Tap("buffer", "sink-1", TapConfig{
+		Sample: 1,
+		Meta:   map[string]string{"sendto": "sink-*"},
+		Buffer: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to tap the edge: %s", err)
+	}
+	e := p.edges[TopologyEdge{From: "buffer", To: "sink-1"}]
+	for _, testCase := range []struct {
+		body   string
+		sendto string
+	}{
+		{"first", "sink-1"},
+		{"other", "elsewhere"},
+		{"second", "sink-1"},
+	} {
+		msg := core.NewMessage([]byte(testCase.body))
+		msg.SetMeta("sendto", testCase.sendto)
+		if err := e.Receive(msg); err != nil {
+			t.Fatalf("failed to send the message: %s", err)
+		}
+	}
+
+	// The tap observes the traffic without consuming it
+	if want := []string{"first", "other", "second"}; !reflect.DeepEqual(received, want) {
+		t.Fatalf("unexpected messages received by the peer: got: %v, want: %v", received, want)
+	}
+	event := <-tap.C
+	if string(event.Body) != "first" || !reflect.DeepEqual(event.Meta, map[string]string{"sendto": "sink-1"}) {
+		t.Fatalf("unexpected tap event: %q %v", event.Body, event.Meta)
+	}
+	// The second matching message didn't fit into the buffer
+	if dropped := tap.Dropped(); dropped != 1 {
+		t.Fatalf("unexpected number of dropped events: got: %d, want: 1", dropped)
+	}
+
+	p.Untap(tap)
+	if _, ok := <-tap.C; ok {
+		t.Fatalf("expected the tap channel to be closed")
+	}
+	p.Untap(tap)
+}
+
+func TestPipelineTapMalformed(t *testing.T) {
+	p := newInspectTestPipeline(t)
+	defer p.ctx.Stop()
+
+	tests := []struct {
+		from    string
+		to      string
+		cfg     TapConfig
+		wanterr error
+	}{
+		{"receiver", "sink-1", TapConfig{Sample: 1}, fmt.Errorf("unknown edge \"receiver\" -> \"sink-1\"")},
+		{"receiver", "buffer", TapConfig{Sample: 0}, fmt.Errorf("tap sample should be in (0, 1], got: 0")},
+		{"receiver", "buffer", TapConfig{Sample: 1.5}, fmt.Errorf("tap sample should be in (0, 1], got: 1.5")},
+		{"receiver", "buffer", TapConfig{Sample: 1, Meta: map[string]string{"sendto": "["}}, fmt.Errorf("malformed tap pattern for meta \"sendto\": syntax error in pattern")},
+	}
+	for _, testCase := range tests {
+		if _, err := p.Tap(testCase.from, testCase.to, testCase.cfg); err == nil || err.Error() != testCase.wanterr.Error() {
+			t.Fatalf("unexpected error for %s -> %s: got: %v, want: %v", testCase.from, testCase.to, err, testCase.wanterr)
+		}
+	}
+}
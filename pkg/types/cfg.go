@@ -9,18 +9,59 @@ type Cfg struct {
 }
 
 // CfgBlockSystem represents the system part of the config: the block
-// representing settings for admin interface, metrics collection, threadiness
-// etc.
+// representing settings for admin interface, logging, metrics collection,
+// threadiness etc.
 type CfgBlockSystem struct {
 	Admin    CfgBlockSystemAdmin
+	Log      CfgBlockSystemLog
 	Maxprocs int
 	Metrics  CfgBlockSystemMetrics
 }
 
 // CfgBlockSystemAdmin represents settings for admin interface.
 type CfgBlockSystemAdmin struct {
+	Auth    CfgBlockSystemAdminAuth
 	Bind    string
 	Enabled bool
+	TLS     CfgBlockSystemAdminTLS
+}
+
+// CfgBlockSystemAdminAuth represents admin interface authentication
+// settings: basic auth users and bearer tokens (both as `principal:secret`
+// entries), client certificate authentication and the list of principals
+// restricted to the read-only role.
+type CfgBlockSystemAdminAuth struct {
+	Basic    []string
+	Certs    bool
+	Readonly []string
+	Tokens   []string
+}
+
+// CfgBlockSystemAdminTLS represents admin interface TLS settings: the server
+// certificate, the key and the CA client certificates are verified against.
+type CfgBlockSystemAdminTLS struct {
+	CA   string
+	Cert string
+	Key  string
+}
+
+// CfgBlockSystemLog represents system logger settings: the minimal severity,
+// the line format (text or json), the output (stdout, stderr, syslog or a
+// file path) and the sampling policy.
+type CfgBlockSystemLog struct {
+	Format   string
+	Level    string
+	Output   string
+	Sampling CfgBlockSystemLogSampling
+}
+
+// CfgBlockSystemLogSampling represents logger sampling settings: every second
+// the first Initial entries with the same severity and the same message
+// template are logged, afterwards every Thereafter-th one. Zero Initial
+// disables sampling.
+type CfgBlockSystemLogSampling struct {
+	Initial    int
+	Thereafter int
 }
 
 // CfgBlockSystemMetrics represents system metrics module settings: sending
@@ -0,0 +1,62 @@
+package explain
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"text/template"
+)
+
+// TopologyDotTmpl renders the live pipeline graph: the names and the labels
+// are quoted, so they might carry any characters.
+const TopologyDotTmpl = `digraph Flow{
+  node [style=filled]
+{{- range .Nodes}}
+  {{printf "%q" .Name}} [label={{printf "%q" .Label}}, fillcolor={{printf "%q" .Color}}]
+{{- end}}
+{{- range .Edges}}
+  {{printf "%q" .From}} -> {{printf "%q" .To}} [label={{printf "%q" .Label}}, color={{printf "%q" .Color}}, penwidth={{printf "%.2f" .Width}}]
+{{- end}}
+}`
+
+type TopologyNode struct {
+	Name  string
+	Label string
+	Color string
+}
+
+type TopologyEdge struct {
+	From  string
+	To    string
+	Label string
+	Color string
+	Width float64
+}
+
+type TopologyGraph struct {
+	Nodes []TopologyNode
+	Edges []TopologyEdge
+}
+
+// Topology renders *TopologyGraph: unlike Pipeline, it carries the node and
+// the edge attributes.
+type Topology struct{}
+
+var _ Explainer = (*Topology)(nil)
+
+func (t *Topology) Explain(in interface{}) ([]byte, error) {
+	graph, ok := in.(*TopologyGraph)
+	if !ok {
+		return nil, fmt.Errorf("unexpected input type: %s", reflect.TypeOf(in))
+	}
+	tmpl, err := template.New("topology-dot").Parse(TopologyDotTmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %s", err.Error())
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, graph); err != nil {
+		return nil, fmt.Errorf("failed to render data: %s", err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
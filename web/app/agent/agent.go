@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 )
 
 type WebAgent interface {
@@ -47,7 +48,7 @@ func (dws *DummyWebAgent) Stop() error {
 	return nil
 }
 
-type WebAgentRegistrator func(*core.Context) (WebAgent, error)
+type WebAgentRegistrator func(*core.Context, *pipeline.Pipeline) (WebAgent, error)
 type WebAgentRegistrators []WebAgentRegistrator
 
 var (
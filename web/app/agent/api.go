@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+const (
+	APIPrefix = "/api/v1alpha1/"
+
+	DefaultAPITapDuration   = 5 * time.Minute
+	DefaultAPITapKeepalive  = 15 * time.Second
+	DefaultAPIInjectMaxBody = 1 << 20
+	DefaultAPIDrainTimeout  = 30 * time.Second
+)
+
+var apiMsgStatusNames = map[core.MsgStatus]string{
+	core.MsgStatusNew:         "new",
+	core.MsgStatusDone:        "done",
+	core.MsgStatusPartialSend: "partial_send",
+	core.MsgStatusInvalid:     "invalid",
+	core.MsgStatusFailed:      "failed",
+	core.MsgStatusTimedOut:    "timedout",
+	core.MsgStatusUnroutable:  "unroutable",
+	core.MsgStatusThrottled:   "throttled",
+}
+
+// APIError is the error schema of the admin API: every non-2xx response
+// carries `{"error": {"code": ..., "message": ...}}`.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIActorsResponse struct {
+	Actors []pipeline.ActorInfo `json:"actors"`
+}
+
+// APITapEvent is a tapped message. Binary bodies are base64-encoded, the
+// encoding is reported in this case. Dropped is the number of the events
+// the tap has dropped so far.
+type APITapEvent struct {
+	Time     time.Time         `json:"time"`
+	Body     string            `json:"body"`
+	Encoding string            `json:"encoding,omitempty"`
+	Meta     map[string]string `json:"meta"`
+	Dropped  uint64            `json:"dropped"`
+}
+
+func respondWithAPI(rw http.ResponseWriter, code int, data interface{}) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		code = http.StatusInternalServerError
+		js, _ = json.Marshal(&APIErrorResponse{APIError{code, err.Error()}})
+	}
+	rw.Header().Set(HdrContentType, ContentTypeJson)
+	rw.WriteHeader(code)
+	rw.Write(js)
+}
+
+func respondWithAPIError(rw http.ResponseWriter, code int, format string, a ...interface{}) {
+	respondWithAPI(rw, code, &APIErrorResponse{APIError{code, fmt.Sprintf(format, a...)}})
+}
+
+// APIWebAgent serves the versioned JSON admin API:
+//
+//	GET /api/v1alpha1/actors        the actor list
+//	GET /api/v1alpha1/actors/:name  a single actor
+//	GET /api/v1alpha1/topology      the pipeline graph
+//	GET /api/v1alpha1/tap           the messages passing through an edge,
+//	                                streamed as server-sent events
+//
+//	POST /api/v1alpha1/actors/:name/pause   stop processing the messages
+//	POST /api/v1alpha1/actors/:name/resume  resume a paused actor
+//	POST /api/v1alpha1/actors/:name/drain   pause once the in-flight
+//	                                        messages are completed, the
+//	                                        `timeout` query param limits the
+//	                                        wait (in milliseconds)
+//	POST /api/v1alpha1/actors/:name/inject  deliver a message to the actor
+//
+// The sensitive actor params are redacted the same way the config page
+// does it.
+type APIWebAgent struct {
+	ppl *pipeline.Pipeline
+}
+
+func NewAPIWebAgent(ppl *pipeline.Pipeline) *APIWebAgent {
+	return &APIWebAgent{ppl: ppl}
+}
+
+func (a *APIWebAgent) GetPath() string {
+	return APIPrefix
+}
+
+func (a *APIWebAgent) GetHandler() http.Handler {
+	return http.HandlerFunc(a.serve)
+}
+
+func (a *APIWebAgent) Start() error {
+	return nil
+}
+
+func (a *APIWebAgent) Stop() error {
+	return nil
+}
+
+func redactActorInfo(info pipeline.ActorInfo) pipeline.ActorInfo {
+	info.Params = redactConfig(info.Params, false).(map[string]types.Value)
+	return info
+}
+
+func (a *APIWebAgent) serve(rw http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, APIPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	if len(parts) == 3 && parts[0] == "actors" {
+		a.serveActorAction(rw, req, parts[1], parts[2])
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		respondWithAPIError(rw, http.StatusMethodNotAllowed, "method %s is not allowed", req.Method)
+		return
+	}
+
+	switch {
+	case path == "actors":
+		actors := a.ppl.Actors()
+		for i := range actors {
+			actors[i] = redactActorInfo(actors[i])
+		}
+		respondWithAPI(rw, http.StatusOK, &APIActorsResponse{Actors: actors})
+	case len(parts) == 2 && parts[0] == "actors":
+		info, ok := a.ppl.Actor(parts[1])
+		if !ok {
+			respondWithAPIError(rw, http.StatusNotFound, "unknown actor %q", parts[1])
+			return
+		}
+		respondWithAPI(rw, http.StatusOK, redactActorInfo(info))
+	case path == "topology":
+		respondWithAPI(rw, http.StatusOK, a.ppl.Topology())
+	case path == "tap":
+		a.serveTap(rw, req)
+	default:
+		respondWithAPIError(rw, http.StatusNotFound, "unknown endpoint %s", req.URL.Path)
+	}
+}
+
+// serveActorAction changes the actor state and responds with the updated
+// actor snapshot.
+func (a *APIWebAgent) serveActorAction(rw http.ResponseWriter, req *http.Request, name, action string) {
+	var do func(string) error
+	drainTimeout := DefaultAPIDrainTimeout
+	switch action {
+	case "pause":
+		do = a.ppl.PauseActor
+	case "resume":
+		do = a.ppl.ResumeActor
+	case "drain":
+		do = func(name string) error {
+			return a.ppl.DrainActor(name, drainTimeout)
+		}
+	case "inject":
+	default:
+		respondWithAPIError(rw, http.StatusNotFound, "unknown endpoint %s", req.URL.Path)
+		return
+	}
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		respondWithAPIError(rw, http.StatusMethodNotAllowed, "method %s is not allowed", req.Method)
+		return
+	}
+	info, ok := a.ppl.Actor(name)
+	if !ok {
+		respondWithAPIError(rw, http.StatusNotFound, "unknown actor %q", name)
+		return
+	}
+	if action == "inject" {
+		a.serveInject(rw, req, name)
+		return
+	}
+	if !info.Pausable {
+		respondWithAPIError(rw, http.StatusConflict, "actor %q can not be paused", name)
+		return
+	}
+	if v := req.URL.Query().Get("timeout"); action == "drain" && len(v) > 0 {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			respondWithAPIError(rw, http.StatusBadRequest, "malformed timeout %q", v)
+			return
+		}
+		drainTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if err := do(name); err != nil {
+		if err == core.DrainTimedOutErr {
+			respondWithAPIError(rw, http.StatusGatewayTimeout, "actor %q is paused but still draining: %s", name, err)
+			return
+		}
+		respondWithAPIError(rw, http.StatusInternalServerError, "failed to %s actor %q: %s", action, name, err)
+		return
+	}
+	info, _ = a.ppl.Actor(name)
+	respondWithAPI(rw, http.StatusOK, redactActorInfo(info))
+}
+
+// serveTap attaches a tap to the edge and streams the events until the
+// client goes away or the tap duration is over. The query params are:
+//
+//	from, to   the edge to tap
+//	sample     the share of the messages to observe, 1 by default
+//	meta       key=pattern filter, might be repeated
+//	duration   the tap lifetime in seconds, 300 by default
+func (a *APIWebAgent) serveTap(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	known := false
+	for _, e := range a.ppl.Topology().Edges {
+		if e.From == from && e.To == to {
+			known = true
+			break
+		}
+	}
+	if !known {
+		respondWithAPIError(rw, http.StatusNotFound, "unknown edge %q -> %q", from, to)
+		return
+	}
+	cfg := pipeline.TapConfig{Sample: 1, Meta: make(map[string]string)}
+	if v := query.Get("sample"); len(v) > 0 {
+		sample, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			respondWithAPIError(rw, http.StatusBadRequest, "malformed sample %q", v)
+			return
+		}
+		cfg.Sample = sample
+	}
+	for _, filter := range query["meta"] {
+		ix := strings.IndexByte(filter, '=')
+		if ix <= 0 {
+			respondWithAPIError(rw, http.StatusBadRequest, "malformed meta filter %q, want: key=pattern", filter)
+			return
+		}
+		cfg.Meta[filter[:ix]] = filter[ix+1:]
+	}
+	duration := DefaultAPITapDuration
+	if v := query.Get("duration"); len(v) > 0 {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			respondWithAPIError(rw, http.StatusBadRequest, "malformed duration %q", v)
+			return
+		}
+		duration = time.Duration(secs) * time.Second
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		respondWithAPIError(rw, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	tap, err := a.ppl.Tap(from, to, cfg)
+	if err != nil {
+		respondWithAPIError(rw, http.StatusBadRequest, "%s", err)
+		return
+	}
+	defer a.ppl.Untap(tap)
+
+	rw.Header().Set(HdrContentType, "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	deadline := time.NewTimer(duration)
+	defer deadline.Stop()
+	keepalive := time.NewTicker(DefaultAPITapKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event := <-tap.C:
+			js, err := json.Marshal(newAPITapEvent(event, tap.Dropped()))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(rw, "data: %s\n\n", js)
+		case <-keepalive.C:
+			fmt.Fprint(rw, ": keepalive\n\n")
+		case <-deadline.C:
+			fmt.Fprint(rw, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func newAPITapEvent(event *pipeline.TapEvent, dropped uint64) *APITapEvent {
+	res := &APITapEvent{
+		Time:    event.Time,
+		Body:    string(event.Body),
+		Meta:    event.Meta,
+		Dropped: dropped,
+	}
+	if !utf8.Valid(event.Body) {
+		res.Body = base64.StdEncoding.EncodeToString(event.Body)
+		res.Encoding = "base64"
+	}
+	return res
+}
+
+// APIInjectRequest is the message to inject. The body might be
+// base64-encoded, the encoding should be set then. Timeout is the number of
+// milliseconds to wait for the message to be completed.
+type APIInjectRequest struct {
+	Body     string            `json:"body"`
+	Encoding string            `json:"encoding"`
+	Meta     map[string]string `json:"meta"`
+	Timeout  int               `json:"timeout"`
+}
+
+// APIInjectResponse reports the final status of an injected message and the
+// edges it has passed through.
+type APIInjectResponse struct {
+	Actor        string                  `json:"actor"`
+	Status       core.MsgStatus          `json:"status"`
+	StatusText   string                  `json:"status_text"`
+	TimedOut     bool                    `json:"timed_out"`
+	ReceiveError string                  `json:"receive_error,omitempty"`
+	Path         []pipeline.TopologyEdge `json:"path"`
+}
+
+func (a *APIWebAgent) serveInject(rw http.ResponseWriter, req *http.Request, name string) {
+	var injreq APIInjectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, DefaultAPIInjectMaxBody)).Decode(&injreq); err != nil {
+		respondWithAPIError(rw, http.StatusBadRequest, "malformed inject request: %s", err)
+		return
+	}
+	body := []byte(injreq.Body)
+	switch injreq.Encoding {
+	case "":
+	case "base64":
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(injreq.Body); err != nil {
+			respondWithAPIError(rw, http.StatusBadRequest, "malformed base64 body: %s", err)
+			return
+		}
+	default:
+		respondWithAPIError(rw, http.StatusBadRequest, "unknown body encoding %q", injreq.Encoding)
+		return
+	}
+	if injreq.Timeout < 0 {
+		respondWithAPIError(rw, http.StatusBadRequest, "timeout should be a non-negative integer, got: %d", injreq.Timeout)
+		return
+	}
+	msg := core.NewMessage(body)
+	for k, v := range injreq.Meta {
+		msg.SetMeta(k, v)
+	}
+	res, err := a.ppl.Inject(name, msg, time.Duration(injreq.Timeout)*time.Millisecond)
+	if err != nil {
+		respondWithAPIError(rw, http.StatusInternalServerError, "failed to inject the message: %s", err)
+		return
+	}
+	resp := &APIInjectResponse{
+		Actor:      res.Actor,
+		Status:     res.Status,
+		StatusText: apiMsgStatusNames[res.Status],
+		TimedOut:   res.TimedOut,
+		Path:       res.Path,
+	}
+	if res.Error != nil {
+		resp.ReceiveError = res.Error.Error()
+	}
+	respondWithAPI(rw, http.StatusOK, resp)
+}
+
+func init() {
+	RegisterWebAgent(
+		func(_ *core.Context, ppl *pipeline.Pipeline) (WebAgent, error) {
+			if ppl == nil {
+				return nil, fmt.Errorf("admin api requires a pipeline")
+			}
+			return NewAPIWebAgent(ppl), nil
+		},
+	)
+}
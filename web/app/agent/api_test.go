@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+func newTestPipeline(t *testing.T) *pipeline.Pipeline {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.maxprocs": 1,
+		"actors": map[string]types.CfgBlockActor{
+			"receiver": {
+				Module: "core.receiver",
+				Params: map[string]types.Value{
+					"bind":        "http://127.0.0.1:0",
+					"auth_tokens": []interface{}{"ci:s3cr3t"},
+				},
+			},
+			"sink": {
+				Module: "core.sink",
+				Params: map[string]types.Value{"bind": "udp://127.0.0.1:7722"},
+			},
+		},
+		"pipeline": map[string]types.CfgBlockPipeline{
+			"receiver": {Connect: []string{"sink"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+	if err := ctx.Start(); err != nil {
+		t.Fatalf("failed to start context: %s", err)
+	}
+	ppl, err := pipeline.NewPipeline(ctx)
+	if err != nil {
+		t.Fatalf("failed to create pipeline: %s", err)
+	}
+	return ppl
+}
+
+func TestAPIWebAgentActorsRedacted(t *testing.T) {
+	handler := NewAPIWebAgent(newTestPipeline(t)).GetHandler()
+
+	wantparams := map[string]interface{}{
+		"bind":        "http://127.0.0.1:0",
+		"auth_tokens": []interface{}{"<redacted>"},
+	}
+	checkParams := func(info pipeline.ActorInfo) {
+		for k, want := range wantparams {
+			got, _ := json.Marshal(info.Params[k])
+			wantjs, _ := json.Marshal(want)
+			if string(got) != string(wantjs) {
+				t.Fatalf("unexpected %q param: got: %s, want: %s", k, got, wantjs)
+			}
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, APIPrefix+"actors", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, http.StatusOK)
+	}
+	var actors APIActorsResponse
+	if err := json.NewDecoder(rw.Body).Decode(&actors); err != nil {
+		t.Fatalf("failed to decode the response: %s", err)
+	}
+	found := false
+	for _, info := range actors.Actors {
+		if info.Name == "receiver" {
+			found = true
+			checkParams(info)
+		}
+	}
+	if !found {
+		t.Fatalf("the receiver is missing in the actor list: %+v", actors.Actors)
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, APIPrefix+"actors/receiver", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, http.StatusOK)
+	}
+	var info pipeline.ActorInfo
+	if err := json.NewDecoder(rw.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode the response: %s", err)
+	}
+	checkParams(info)
+}
+
+func TestAPIWebAgentDrain(t *testing.T) {
+	handler := NewAPIWebAgent(newTestPipeline(t)).GetHandler()
+
+	tests := []struct {
+		path     string
+		wantcode int
+	}{
+		{APIPrefix + "actors/sink/drain?timeout=0", http.StatusBadRequest},
+		{APIPrefix + "actors/sink/drain?timeout=soon", http.StatusBadRequest},
+		{APIPrefix + "actors/receiver/drain", http.StatusConflict},
+		{APIPrefix + "actors/sink/drain?timeout=100", http.StatusOK},
+		{APIPrefix + "actors/sink/drain", http.StatusOK},
+	}
+	for _, testCase := range tests {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, testCase.path, nil))
+		if rw.Code != testCase.wantcode {
+			t.Fatalf("unexpected response code for %s: got: %d, want: %d", testCase.path, rw.Code, testCase.wantcode)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, APIPrefix+"actors/sink", nil))
+	var info pipeline.ActorInfo
+	if err := json.NewDecoder(rw.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode the response: %s", err)
+	}
+	if info.State != pipeline.ActorStatePaused {
+		t.Fatalf("unexpected actor state: got: %q, want: %q", info.State, pipeline.ActorStatePaused)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
+	"github.com/awesome-flow/flow/pkg/types"
 )
 
 type ConfigPage struct {
@@ -12,25 +14,82 @@ type ConfigPage struct {
 	Config string
 }
 
+// sensitiveConfigKeys are the config keys the values of which are never
+// exposed: both the admin interface credentials and the actor ones.
+var sensitiveConfigKeys = map[string]bool{
+	"basic":          true,
+	"tokens":         true,
+	"token":          true,
+	"password":       true,
+	"auth_tokens":    true,
+	"auth_hmac_keys": true,
+}
+
+// redactConfig returns a copy of the explained config with the sensitive
+// values replaced. The original values are shared with the config
+// providers, they are never modified.
+func redactConfig(v interface{}, sensitive bool) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(vv))
+		for k, sub := range vv {
+			if k == "provider_name" || k == "provider_weight" {
+				res[k] = sub
+				continue
+			}
+			res[k] = redactConfig(sub, sensitive || sensitiveConfigKeys[k])
+		}
+		return res
+	case map[string]types.Value:
+		res := make(map[string]types.Value, len(vv))
+		for k, sub := range vv {
+			res[k] = redactConfig(sub, sensitive || sensitiveConfigKeys[k])
+		}
+		return res
+	case []map[string]interface{}:
+		res := make([]interface{}, 0, len(vv))
+		for _, sub := range vv {
+			res = append(res, redactConfig(sub, sensitive))
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, 0, len(vv))
+		for _, sub := range vv {
+			res = append(res, redactConfig(sub, sensitive))
+		}
+		return res
+	}
+	if sensitive {
+		return "<redacted>"
+	}
+	return v
+}
+
+// NewConfigWebAgent serves the active config with the sensitive values
+// redacted.
+func NewConfigWebAgent(ctx *core.Context) *DummyWebAgent {
+	return NewDummyWebAgent(
+		"/config",
+		func(rw http.ResponseWriter, req *http.Request) {
+			cfgdata := redactConfig(ctx.Config().Explain(), false)
+			js, err := json.Marshal(cfgdata)
+			if err != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				rw.Write([]byte(err.Error()))
+				return
+			}
+			respondWith(rw, RespHtml, "config", &ConfigPage{
+				Title:  "Flow active config",
+				Config: string(js),
+			})
+		},
+	)
+}
+
 func init() {
 	RegisterWebAgent(
-		func(ctx *core.Context) (WebAgent, error) {
-			return NewDummyWebAgent(
-				"/config",
-				func(rw http.ResponseWriter, req *http.Request) {
-					cfgdata := ctx.Config().Explain()
-					js, err := json.Marshal(cfgdata)
-					if err != nil {
-						rw.WriteHeader(http.StatusInternalServerError)
-						rw.Write([]byte(err.Error()))
-						return
-					}
-					respondWith(rw, RespHtml, "config", &ConfigPage{
-						Title:  "Flow active config",
-						Config: string(js),
-					})
-				},
-			), nil
+		func(ctx *core.Context, _ *pipeline.Pipeline) (WebAgent, error) {
+			return NewConfigWebAgent(ctx), nil
 		},
 	)
 }
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+func TestRedactConfig(t *testing.T) {
+	explained := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"__value__": []map[string]interface{}{
+				{"provider_name": "yaml", "provider_weight": 10, "value": v},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		input interface{}
+		want  interface{}
+	}{
+		{
+			name: "explained admin credentials",
+			input: map[string]interface{}{
+				"system": map[string]interface{}{
+					"admin": map[string]interface{}{
+						"bind": explained(":4101"),
+						"auth": map[string]interface{}{
+							"basic":    explained([]interface{}{"admin:secret"}),
+							"tokens":   explained([]interface{}{"ci:token"}),
+							"readonly": explained([]interface{}{"viewer"}),
+						},
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"admin": map[string]interface{}{
+						"bind": map[string]interface{}{
+							"__value__": []interface{}{
+								map[string]interface{}{"provider_name": "yaml", "provider_weight": 10, "value": ":4101"},
+							},
+						},
+						"auth": map[string]interface{}{
+							"basic": map[string]interface{}{
+								"__value__": []interface{}{
+									map[string]interface{}{"provider_name": "yaml", "provider_weight": 10, "value": []interface{}{"<redacted>"}},
+								},
+							},
+							"tokens": map[string]interface{}{
+								"__value__": []interface{}{
+									map[string]interface{}{"provider_name": "yaml", "provider_weight": 10, "value": []interface{}{"<redacted>"}},
+								},
+							},
+							"readonly": map[string]interface{}{
+								"__value__": []interface{}{
+									map[string]interface{}{"provider_name": "yaml", "provider_weight": 10, "value": []interface{}{"viewer"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "actor params",
+			input: map[string]types.Value{
+				"bind":           "http://127.0.0.1:8080",
+				"password":       "secret",
+				"token":          "secret",
+				"auth_tokens":    []interface{}{"ci:secret"},
+				"auth_hmac_keys": map[string]interface{}{"ci": "secret"},
+			},
+			want: map[string]types.Value{
+				"bind":           "http://127.0.0.1:8080",
+				"password":       "<redacted>",
+				"token":          "<redacted>",
+				"auth_tokens":    []interface{}{"<redacted>"},
+				"auth_hmac_keys": map[string]interface{}{"ci": "<redacted>"},
+			},
+		},
+		{
+			name:  "scalar",
+			input: 42,
+			want:  42,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := redactConfig(testCase.input, false)
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("unexpected redacted config: got: %#v, want: %#v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestRedactConfigKeepsOriginal(t *testing.T) {
+	input := map[string]interface{}{
+		"password": "secret",
+		"tokens":   []interface{}{"ci:secret"},
+	}
+	redactConfig(input, false)
+	want := map[string]interface{}{
+		"password": "secret",
+		"tokens":   []interface{}{"ci:secret"},
+	}
+	if !reflect.DeepEqual(input, want) {
+		t.Fatalf("the original config was modified: got: %#v, want: %#v", input, want)
+	}
+}
+
+func TestConfigWebAgentRedacted(t *testing.T) {
+	ctx, err := coretest.NewContextWithConfig(map[string]interface{}{
+		"system.admin.bind":                  "127.0.0.1:4101",
+		"system.admin.auth.basic":            []interface{}{"admin:s3cr3t-basic"},
+		"system.admin.auth.tokens":           []interface{}{"ci:s3cr3t-token"},
+		"actors.receiver.module":             "core.receiver",
+		"actors.receiver.params.bind":        "http://127.0.0.1:8080",
+		"actors.receiver.params.auth_tokens": []interface{}{"ci:s3cr3t-actor"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create context: %s", err)
+	}
+
+	rw := httptest.NewRecorder()
+	NewConfigWebAgent(ctx).GetHandler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, http.StatusOK)
+	}
+	body, err := ioutil.ReadAll(rw.Body)
+	if err != nil {
+		t.Fatalf("failed to read the response: %s", err)
+	}
+	if strings.Contains(string(body), "s3cr3t") {
+		t.Fatalf("the config page exposes a secret: %s", body)
+	}
+	for _, want := range []string{"redacted", "127.0.0.1:4101", "http://127.0.0.1:8080"} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("the config page is missing %q: %s", want, body)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 )
 
 type ExpvarWebAgent struct {
@@ -33,7 +34,7 @@ func (eva *ExpvarWebAgent) Stop() error {
 
 func init() {
 	RegisterWebAgent(
-		func(ctx *core.Context) (WebAgent, error) {
+		func(ctx *core.Context, _ *pipeline.Pipeline) (WebAgent, error) {
 			return NewExpvarWebAgent("/expvar"), nil
 		},
 	)
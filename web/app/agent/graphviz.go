@@ -2,40 +2,201 @@ package agent
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
-	"github.com/awesome-flow/flow/pkg/types"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 	explain "github.com/awesome-flow/flow/pkg/util/explain"
 )
 
+const (
+	DefaultGraphvizSampleInterval = 2 * time.Second
+
+	// The share of the completed messages making an edge alarming
+	GraphvizAlarmShare = 0.05
+	// The queue saturation levels making a node alarming
+	GraphvizWarnSaturation  = 0.5
+	GraphvizAlarmSaturation = 0.8
+)
+
 type DescribePage struct {
 	Title    string
 	GraphViz string
+	Refresh  int
+}
+
+// edgeRates are the edge counters deltas over the last sample interval,
+// turned into per-second rates.
+type edgeRates struct {
+	sent      float64
+	done      float64
+	throttled float64
+	failed    float64
+}
+
+// GraphvizWebAgent renders the live pipeline graph: the edges are labelled
+// with the message rates and colored by the failure and the throttle shares,
+// the nodes are colored by the queue saturation. The rates are sampled in
+// the background.
+type GraphvizWebAgent struct {
+	ppl      *pipeline.Pipeline
+	interval time.Duration
+	lock     sync.Mutex
+	prev     map[pipeline.TopologyEdge]pipeline.EdgeStats
+	prevts   time.Time
+	rates    map[pipeline.TopologyEdge]edgeRates
+	done     chan struct{}
+}
+
+func NewGraphvizWebAgent(ppl *pipeline.Pipeline, interval time.Duration) *GraphvizWebAgent {
+	return &GraphvizWebAgent{
+		ppl:      ppl,
+		interval: interval,
+		rates:    make(map[pipeline.TopologyEdge]edgeRates),
+		done:     make(chan struct{}),
+	}
+}
+
+func (g *GraphvizWebAgent) GetPath() string {
+	return "/pipeline/describe"
+}
+
+func (g *GraphvizWebAgent) GetHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		dot, err := g.render()
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(fmt.Sprintf("Internal Server Error: %s", err)))
+			return
+		}
+		if req.URL.Query().Get("format") == "dot" {
+			rw.Header().Set(HdrContentType, "text/vnd.graphviz")
+			rw.Write(dot)
+			return
+		}
+		respondWith(rw, RespHtml, "graphviz", &DescribePage{
+			Title:    "Flow Pipeline",
+			GraphViz: string(dot),
+			Refresh:  int(g.interval / time.Millisecond),
+		})
+	})
+}
+
+func (g *GraphvizWebAgent) Start() error {
+	g.sample()
+	go func() {
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				g.sample()
+			case <-g.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (g *GraphvizWebAgent) Stop() error {
+	close(g.done)
+	return nil
+}
+
+func (g *GraphvizWebAgent) sample() {
+	now := time.Now()
+	stats := g.ppl.EdgeStats()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	curr := make(map[pipeline.TopologyEdge]pipeline.EdgeStats, len(stats))
+	for _, s := range stats {
+		e := pipeline.TopologyEdge{From: s.From, To: s.To}
+		curr[e] = s
+		prev, ok := g.prev[e]
+		if !ok {
+			continue
+		}
+		secs := now.Sub(g.prevts).Seconds()
+		g.rates[e] = edgeRates{
+			sent:      float64(s.Sent-prev.Sent) / secs,
+			done:      float64(s.Done-prev.Done) / secs,
+			throttled: float64(s.Throttled-prev.Throttled) / secs,
+			failed:    float64(s.Failed-prev.Failed) / secs,
+		}
+	}
+	g.prev, g.prevts = curr, now
+}
+
+func (g *GraphvizWebAgent) render() ([]byte, error) {
+	graph := &explain.TopologyGraph{}
+	for _, info := range g.ppl.Actors() {
+		graph.Nodes = append(graph.Nodes, graphvizNode(info))
+	}
+	g.lock.Lock()
+	for _, e := range g.ppl.Topology().Edges {
+		graph.Edges = append(graph.Edges, graphvizEdge(e, g.rates[e]))
+	}
+	g.lock.Unlock()
+	return new(explain.Topology).Explain(graph)
+}
+
+func graphvizNode(info pipeline.ActorInfo) explain.TopologyNode {
+	node := explain.TopologyNode{Name: info.Name, Label: info.Name, Color: "white"}
+	if info.QueueDepth == nil || info.QueueCapacity == nil || *info.QueueCapacity == 0 {
+		return node
+	}
+	saturation := float64(*info.QueueDepth) / float64(*info.QueueCapacity)
+	node.Label = fmt.Sprintf("%s\nqueue %.0f%%", info.Name, 100*saturation)
+	switch {
+	case saturation >= GraphvizAlarmSaturation:
+		node.Color = "#f8d7da"
+	case saturation >= GraphvizWarnSaturation:
+		node.Color = "#fff3cd"
+	}
+	return node
+}
+
+func graphvizEdge(e pipeline.TopologyEdge, rates edgeRates) explain.TopologyEdge {
+	edge := explain.TopologyEdge{
+		From:  e.From,
+		To:    e.To,
+		Label: fmt.Sprintf("%.1f/s", rates.sent),
+		Color: "gray",
+		Width: 1 + math.Log10(1+rates.sent),
+	}
+	if rates.sent > 0 {
+		edge.Color = "darkgreen"
+	}
+	completed := rates.done + rates.throttled + rates.failed
+	if completed == 0 {
+		return edge
+	}
+	if share := rates.failed / completed; share > 0 {
+		edge.Label += fmt.Sprintf("\nfailed %.0f%%", 100*share)
+		if share >= GraphvizAlarmShare {
+			edge.Color = "red"
+		}
+	}
+	if share := rates.throttled / completed; share > 0 {
+		edge.Label += fmt.Sprintf("\nthrottled %.0f%%", 100*share)
+		if share >= GraphvizAlarmShare && edge.Color != "red" {
+			edge.Color = "orange"
+		}
+	}
+	return edge
 }
 
 func init() {
 	RegisterWebAgent(
-		func(ctx *core.Context) (WebAgent, error) {
-			cfgppl, ok := ctx.Config().Get(types.NewKey("pipeline"))
-			if !ok {
-				return nil, fmt.Errorf("failed to get `pipeline` config")
-			}
-			e := new(explain.Pipeline)
-			expl, err := e.Explain(cfgppl)
-			if err != nil {
-				return nil, err
+		func(_ *core.Context, ppl *pipeline.Pipeline) (WebAgent, error) {
+			if ppl == nil {
+				return nil, fmt.Errorf("pipeline graph requires a pipeline")
 			}
-
-			return NewDummyWebAgent(
-				"/pipeline/describe",
-				func(rw http.ResponseWriter, req *http.Request) {
-					respondWith(rw, RespHtml, "graphviz", &DescribePage{
-						Title:    "Flow Pipeline",
-						GraphViz: string(expl),
-					})
-				},
-			), nil
+			return NewGraphvizWebAgent(ppl, DefaultGraphvizSampleInterval), nil
 		},
 	)
 }
@@ -6,26 +6,40 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sync"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 )
 
 var (
-	tmpl *template.Template
+	tmpl     *template.Template
+	tmplErr  error
+	tmplOnce sync.Once
 )
 
-func init() {
-	var err error
-	tmpl, err = template.ParseFiles(
-		"web/template/layout.tmpl",
-		"web/template/page/config.tmpl",
-		"web/template/page/index.tmpl",
-		"web/template/page/graphviz.tmpl",
-		"web/template/page/pprof.tmpl",
-	)
-	if err != nil {
-		panic(err.Error())
-	}
+// templates parses the page templates on the first use. The paths are
+// relative to the working directory, which is expected to be the project
+// root.
+func templates() (*template.Template, error) {
+	tmplOnce.Do(func() {
+		tmpl, tmplErr = template.ParseFiles(
+			"web/template/layout.tmpl",
+			"web/template/page/config.tmpl",
+			"web/template/page/index.tmpl",
+			"web/template/page/graphviz.tmpl",
+			"web/template/page/pprof.tmpl",
+			"web/template/page/tap.tmpl",
+		)
+	})
+	return tmpl, tmplErr
+}
+
+// LoadTemplates parses the page templates upfront, so a missing template
+// fails the start rather than the first page request.
+func LoadTemplates() error {
+	_, err := templates()
+	return err
 }
 
 type Page struct {
@@ -65,6 +79,10 @@ func respondWith(rw http.ResponseWriter, format ResponseFormat, tmplName string,
 }
 
 func respondWithHtml(rw http.ResponseWriter, tmplName string, data interface{}) error {
+	tmpl, err := templates()
+	if err != nil {
+		return err
+	}
 	rw.Header().Add(HdrContentType, ContentTypeHtml)
 	bw := bytes.NewBuffer(nil)
 	if err := tmpl.ExecuteTemplate(bw, tmplName, data); err != nil {
@@ -94,7 +112,7 @@ type IndexPage struct {
 
 func init() {
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/",
 				func(rw http.ResponseWriter, req *http.Request) {
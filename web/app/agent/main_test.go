@@ -0,0 +1,14 @@
+package agent
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The page templates are looked up relative to the project root
+	if err := os.Chdir("../../.."); err != nil {
+		panic(err.Error())
+	}
+	os.Exit(m.Run())
+}
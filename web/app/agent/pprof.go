@@ -5,6 +5,7 @@ import (
 	"net/http/pprof"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 )
 
 type PprofPage struct {
@@ -13,7 +14,7 @@ type PprofPage struct {
 
 func init() {
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/pprof/",
 				//pprof.Index,
@@ -25,7 +26,7 @@ func init() {
 	)
 
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return &DummyWebAgent{
 				path:    "/pprof/heap",
 				handler: pprof.Handler("heap"),
@@ -34,7 +35,7 @@ func init() {
 	)
 
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/pprof/cmdline",
 				pprof.Cmdline,
@@ -43,7 +44,7 @@ func init() {
 	)
 
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/pprof/profile",
 				pprof.Profile,
@@ -52,7 +53,7 @@ func init() {
 	)
 
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/pprof/symbol",
 				pprof.Symbol,
@@ -61,7 +62,7 @@ func init() {
 	)
 
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return NewDummyWebAgent(
 				"/pprof/trace",
 				pprof.Trace,
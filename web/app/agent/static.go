@@ -4,11 +4,12 @@ import (
 	"net/http"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 )
 
 func init() {
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return &DummyWebAgent{
 				"/static/",
 				http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static"))),
@@ -16,7 +17,7 @@ func init() {
 		},
 	)
 	RegisterWebAgent(
-		func(*core.Context) (WebAgent, error) {
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
 			return &DummyWebAgent{
 				"/favicon.ico",
 				http.FileServer(http.Dir("./web/static/img")),
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"net/http"
+
+	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
+)
+
+type TapPage struct {
+	Title string
+	From  string
+	To    string
+}
+
+func init() {
+	RegisterWebAgent(
+		func(*core.Context, *pipeline.Pipeline) (WebAgent, error) {
+			return NewDummyWebAgent(
+				"/pipeline/tap",
+				func(rw http.ResponseWriter, req *http.Request) {
+					query := req.URL.Query()
+					respondWith(rw, RespHtml, "tap", &TapPage{
+						Title: "Flow Pipeline Tap",
+						From:  query.Get("from"),
+						To:    query.Get("to"),
+					})
+				},
+			), nil
+		},
+	)
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+type adminCredential struct {
+	principal string
+	secret    []byte
+	hashed    bool
+}
+
+// matches compares the secret in constant time. Hashed secrets are stored
+// as `sha256:hex`.
+func (c *adminCredential) matches(secret string) bool {
+	if c.hashed {
+		sum := sha256.Sum256([]byte(secret))
+		return subtle.ConstantTimeCompare(c.secret, sum[:]) == 1
+	}
+	return subtle.ConstantTimeCompare(c.secret, []byte(secret)) == 1
+}
+
+func parseAdminCredentials(entries []string) ([]adminCredential, error) {
+	res := make([]adminCredential, 0, len(entries))
+	for _, entry := range entries {
+		ix := strings.IndexByte(entry, ':')
+		if ix <= 0 || ix == len(entry)-1 {
+			return nil, fmt.Errorf("malformed credential %q, want: principal:secret", entry)
+		}
+		cred := adminCredential{principal: entry[:ix], secret: []byte(entry[ix+1:])}
+		if secret := entry[ix+1:]; strings.HasPrefix(secret, "sha256:") {
+			sum, err := hex.DecodeString(secret[len("sha256:"):])
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("malformed sha256 hash for principal %q", cred.principal)
+			}
+			cred.secret, cred.hashed = sum, true
+		}
+		res = append(res, cred)
+	}
+	return res, nil
+}
+
+// AdminAuth guards the admin interface. A request is authenticated with
+// basic auth, a bearer token or a verified client certificate (the common
+// name is the principal). Principals listed as read-only (`*` stands for
+// everyone) are only allowed to use safe methods: GET, HEAD and OPTIONS.
+type AdminAuth struct {
+	basic    []adminCredential
+	tokens   []adminCredential
+	certs    bool
+	readonly map[string]bool
+}
+
+// NewAdminAuth returns nil if no authentication method is configured.
+func NewAdminAuth(cfg types.CfgBlockSystemAdminAuth) (*AdminAuth, error) {
+	if len(cfg.Basic) == 0 && len(cfg.Tokens) == 0 && !cfg.Certs {
+		if len(cfg.Readonly) > 0 {
+			return nil, fmt.Errorf("read-only principals require an authentication method")
+		}
+		return nil, nil
+	}
+	basic, err := parseAdminCredentials(cfg.Basic)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parseAdminCredentials(cfg.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	readonly := make(map[string]bool, len(cfg.Readonly))
+	for _, principal := range cfg.Readonly {
+		readonly[principal] = true
+	}
+	return &AdminAuth{
+		basic:    basic,
+		tokens:   tokens,
+		certs:    cfg.Certs,
+		readonly: readonly,
+	}, nil
+}
+
+func findAdminPrincipal(creds []adminCredential, principal, secret string) (string, bool) {
+	found := ""
+	for i := range creds {
+		// Not breaking out of the loop keeps the check time constant
+		if (len(principal) == 0 || creds[i].principal == principal) && creds[i].matches(secret) && len(found) == 0 {
+			found = creds[i].principal
+		}
+	}
+	return found, len(found) > 0
+}
+
+// Authenticate returns the request principal.
+func (a *AdminAuth) Authenticate(req *http.Request) (string, bool) {
+	if auth := req.Header.Get("Authorization"); len(auth) > 0 {
+		if strings.HasPrefix(auth, "Bearer ") {
+			return findAdminPrincipal(a.tokens, "", strings.TrimSpace(auth[len("Bearer "):]))
+		}
+		if user, pass, ok := req.BasicAuth(); ok {
+			return findAdminPrincipal(a.basic, user, pass)
+		}
+		return "", false
+	}
+	if a.certs && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		if cn := req.TLS.VerifiedChains[0][0].Subject.CommonName; len(cn) > 0 {
+			return cn, true
+		}
+	}
+	return "", false
+}
+
+// IsReadonly tells if the principal is restricted to the read-only role.
+func (a *AdminAuth) IsReadonly(principal string) bool {
+	return a.readonly[principal] || a.readonly["*"]
+}
+
+// Handler wraps the handler with the authentication and the read-only role
+// checks.
+func (a *AdminAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		principal, ok := a.Authenticate(req)
+		if !ok {
+			if len(a.basic) > 0 {
+				rw.Header().Set("WWW-Authenticate", `Basic realm="flow admin"`)
+			} else {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="flow admin"`)
+			}
+			http.Error(rw, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if a.IsReadonly(principal) {
+				http.Error(rw, fmt.Sprintf("Principal %q is read-only", principal), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// buildAdminTLSConfig returns nil if no certificate is configured. Client
+// certificates are verified against the CA if one is provided: they are
+// required unless other authentication methods are configured.
+func buildAdminTLSConfig(cfg types.CfgBlockSystemAdmin) (*tls.Config, error) {
+	tlscfg, authcfg := cfg.TLS, cfg.Auth
+	if len(tlscfg.Cert) == 0 && len(tlscfg.Key) == 0 {
+		if len(tlscfg.CA) > 0 || authcfg.Certs {
+			return nil, fmt.Errorf("client certificates require `tls.cert` and `tls.key` config")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(tlscfg.Cert, tlscfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if len(tlscfg.CA) == 0 {
+		if authcfg.Certs {
+			return nil, fmt.Errorf("client certificate authentication requires `tls.ca` config")
+		}
+		return conf, nil
+	}
+	pem, err := ioutil.ReadFile(tlscfg.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %q", tlscfg.CA)
+	}
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(authcfg.Basic) > 0 || len(authcfg.Tokens) > 0 {
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return conf, nil
+}
@@ -0,0 +1,418 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	coretest "github.com/awesome-flow/flow/pkg/corev1alpha1/test"
+	"github.com/awesome-flow/flow/pkg/types"
+)
+
+func newTestCert(t *testing.T, dir string, cn string) (certfile, keyfile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	certfile = filepath.Join(dir, cn+".pem")
+	keyfile = filepath.Join(dir, cn+".key")
+	if err := ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err := ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+	return certfile, keyfile, cert
+}
+
+func sha256Secret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestNewAdminAuthMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     types.CfgBlockSystemAdminAuth
+		wanterr error
+	}{
+		{
+			name:    "read-only without auth",
+			cfg:     types.CfgBlockSystemAdminAuth{Readonly: []string{"*"}},
+			wanterr: fmt.Errorf("read-only principals require an authentication method"),
+		},
+		{
+			name:    "missing secret",
+			cfg:     types.CfgBlockSystemAdminAuth{Basic: []string{"admin"}},
+			wanterr: fmt.Errorf("malformed credential \"admin\", want: principal:secret"),
+		},
+		{
+			name:    "empty secret",
+			cfg:     types.CfgBlockSystemAdminAuth{Tokens: []string{"ci:"}},
+			wanterr: fmt.Errorf("malformed credential \"ci:\", want: principal:secret"),
+		},
+		{
+			name:    "malformed sha256",
+			cfg:     types.CfgBlockSystemAdminAuth{Tokens: []string{"ci:sha256:beef"}},
+			wanterr: fmt.Errorf("malformed sha256 hash for principal \"ci\""),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := NewAdminAuth(testCase.cfg); !coretest.EqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+			}
+		})
+	}
+
+	auth, err := NewAdminAuth(types.CfgBlockSystemAdminAuth{})
+	if err != nil || auth != nil {
+		t.Fatalf("unexpected auth for an empty config: got: %v, %v, want: nil", auth, err)
+	}
+}
+
+func TestAdminAuthAuthenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-admin-auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	_, _, cert := newTestCert(t, dir, "monitor")
+
+	auth, err := NewAdminAuth(types.CfgBlockSystemAdminAuth{
+		Basic:  []string{"admin:secret", "ops:" + sha256Secret("opspass")},
+		Tokens: []string{"ci:token", "bot:" + sha256Secret("bottoken")},
+		Certs:  true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create auth: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		setup         func(*http.Request)
+		wantprincipal string
+		wantok        bool
+	}{
+		{
+			name:          "basic",
+			setup:         func(req *http.Request) { req.SetBasicAuth("admin", "secret") },
+			wantprincipal: "admin",
+			wantok:        true,
+		},
+		{
+			name:   "basic wrong password",
+			setup:  func(req *http.Request) { req.SetBasicAuth("admin", "wrong") },
+			wantok: false,
+		},
+		{
+			name:   "basic password of another principal",
+			setup:  func(req *http.Request) { req.SetBasicAuth("ops", "secret") },
+			wantok: false,
+		},
+		{
+			name:          "basic sha256",
+			setup:         func(req *http.Request) { req.SetBasicAuth("ops", "opspass") },
+			wantprincipal: "ops",
+			wantok:        true,
+		},
+		{
+			name:   "basic sha256 hash as password",
+			setup:  func(req *http.Request) { req.SetBasicAuth("ops", sha256Secret("opspass")) },
+			wantok: false,
+		},
+		{
+			name:   "basic with a token",
+			setup:  func(req *http.Request) { req.SetBasicAuth("ci", "token") },
+			wantok: false,
+		},
+		{
+			name:          "bearer",
+			setup:         func(req *http.Request) { req.Header.Set("Authorization", "Bearer token") },
+			wantprincipal: "ci",
+			wantok:        true,
+		},
+		{
+			name:          "bearer sha256",
+			setup:         func(req *http.Request) { req.Header.Set("Authorization", "Bearer bottoken") },
+			wantprincipal: "bot",
+			wantok:        true,
+		},
+		{
+			name:   "bearer wrong token",
+			setup:  func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret") },
+			wantok: false,
+		},
+		{
+			name:   "unknown scheme",
+			setup:  func(req *http.Request) { req.Header.Set("Authorization", "Digest token") },
+			wantok: false,
+		},
+		{
+			name: "client certificate",
+			setup: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			},
+			wantprincipal: "monitor",
+			wantok:        true,
+		},
+		{
+			name: "unverified client certificate",
+			setup: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			},
+			wantok: false,
+		},
+		{
+			name:   "no credentials",
+			setup:  func(*http.Request) {},
+			wantok: false,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			testCase.setup(req)
+			principal, ok := auth.Authenticate(req)
+			if ok != testCase.wantok || principal != testCase.wantprincipal {
+				t.Fatalf("unexpected authentication result: got: %q, %t, want: %q, %t", principal, ok, testCase.wantprincipal, testCase.wantok)
+			}
+		})
+	}
+}
+
+func TestAdminAuthHandler(t *testing.T) {
+	auth, err := NewAdminAuth(types.CfgBlockSystemAdminAuth{
+		Basic:    []string{"admin:secret", "viewer:view"},
+		Readonly: []string{"viewer"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create auth: %s", err)
+	}
+	served := 0
+	handler := auth.Handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		served++
+	}))
+
+	tests := []struct {
+		method   string
+		path     string
+		user     string
+		pass     string
+		wantcode int
+	}{
+		{http.MethodGet, "/api/v1alpha1/actors", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1alpha1/actors", "viewer", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1alpha1/actors", "viewer", "view", http.StatusOK},
+		{http.MethodHead, "/api/v1alpha1/actors", "viewer", "view", http.StatusOK},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/pause", "viewer", "view", http.StatusForbidden},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/resume", "viewer", "view", http.StatusForbidden},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/drain", "viewer", "view", http.StatusForbidden},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/inject", "viewer", "view", http.StatusForbidden},
+		{http.MethodDelete, "/api/v1alpha1/actors/sink", "viewer", "view", http.StatusForbidden},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/pause", "admin", "secret", http.StatusOK},
+		{http.MethodPost, "/api/v1alpha1/actors/sink/inject", "admin", "secret", http.StatusOK},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.method+" "+testCase.path+" as "+testCase.user, func(t *testing.T) {
+			served = 0
+			req := httptest.NewRequest(testCase.method, testCase.path, nil)
+			if len(testCase.user) > 0 {
+				req.SetBasicAuth(testCase.user, testCase.pass)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			if rw.Code != testCase.wantcode {
+				t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, testCase.wantcode)
+			}
+			if wantserved := testCase.wantcode == http.StatusOK; (served > 0) != wantserved {
+				t.Fatalf("unexpected handler call: got: %t, want: %t", served > 0, wantserved)
+			}
+			if rw.Code == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") != `Basic realm="flow admin"` {
+				t.Fatalf("unexpected WWW-Authenticate header: %q", rw.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAdminAuthHandlerReadonlyEveryone(t *testing.T) {
+	auth, err := NewAdminAuth(types.CfgBlockSystemAdminAuth{
+		Tokens:   []string{"ci:token"},
+		Readonly: []string{"*"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create auth: %s", err)
+	}
+	handler := auth.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1alpha1/actors/sink/drain", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1alpha1/actors", nil)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected response code: got: %d, want: %d", rw.Code, http.StatusUnauthorized)
+	}
+	if got := rw.Header().Get("WWW-Authenticate"); got != `Bearer realm="flow admin"` {
+		t.Fatalf("unexpected WWW-Authenticate header: %q", got)
+	}
+}
+
+func TestBuildAdminTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow-admin-tls")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certfile, keyfile, _ := newTestCert(t, dir, "localhost")
+	cafile, _, _ := newTestCert(t, dir, "ca")
+
+	tests := []struct {
+		name       string
+		cfg        types.CfgBlockSystemAdmin
+		wantnil    bool
+		wantauth   tls.ClientAuthType
+		wantclicas bool
+		wanterr    error
+	}{
+		{
+			name:    "no tls",
+			cfg:     types.CfgBlockSystemAdmin{},
+			wantnil: true,
+		},
+		{
+			name: "ca without certificate",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS: types.CfgBlockSystemAdminTLS{CA: cafile},
+			},
+			wanterr: fmt.Errorf("client certificates require `tls.cert` and `tls.key` config"),
+		},
+		{
+			name: "cert auth without certificate",
+			cfg: types.CfgBlockSystemAdmin{
+				Auth: types.CfgBlockSystemAdminAuth{Certs: true},
+			},
+			wanterr: fmt.Errorf("client certificates require `tls.cert` and `tls.key` config"),
+		},
+		{
+			name: "server certificate only",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS: types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile},
+			},
+			wantauth: tls.NoClientCert,
+		},
+		{
+			name: "cert auth without ca",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS:  types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile},
+				Auth: types.CfgBlockSystemAdminAuth{Certs: true},
+			},
+			wanterr: fmt.Errorf("client certificate authentication requires `tls.ca` config"),
+		},
+		{
+			name: "client certificates only",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS:  types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile, CA: cafile},
+				Auth: types.CfgBlockSystemAdminAuth{Certs: true},
+			},
+			wantauth:   tls.RequireAndVerifyClientCert,
+			wantclicas: true,
+		},
+		{
+			name: "client certificates or basic auth",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS:  types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile, CA: cafile},
+				Auth: types.CfgBlockSystemAdminAuth{Certs: true, Basic: []string{"admin:secret"}},
+			},
+			wantauth:   tls.VerifyClientCertIfGiven,
+			wantclicas: true,
+		},
+		{
+			name: "client certificates or tokens",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS:  types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile, CA: cafile},
+				Auth: types.CfgBlockSystemAdminAuth{Certs: true, Tokens: []string{"ci:token"}},
+			},
+			wantauth:   tls.VerifyClientCertIfGiven,
+			wantclicas: true,
+		},
+		{
+			name: "malformed ca",
+			cfg: types.CfgBlockSystemAdmin{
+				TLS: types.CfgBlockSystemAdminTLS{Cert: certfile, Key: keyfile, CA: keyfile},
+			},
+			wanterr: fmt.Errorf("no certificates found in %q", keyfile),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			conf, err := buildAdminTLSConfig(testCase.cfg)
+			if !coretest.EqErr(err, testCase.wanterr) {
+				t.Fatalf("unexpected error: got: %s, want: %s", err, testCase.wanterr)
+			}
+			if err != nil {
+				return
+			}
+			if testCase.wantnil {
+				if conf != nil {
+					t.Fatalf("unexpected tls config: got: %+v, want: nil", conf)
+				}
+				return
+			}
+			if conf == nil {
+				t.Fatalf("expected a tls config, got nil")
+			}
+			if len(conf.Certificates) != 1 {
+				t.Fatalf("unexpected number of server certificates: got: %d, want: 1", len(conf.Certificates))
+			}
+			if conf.ClientAuth != testCase.wantauth {
+				t.Fatalf("unexpected client auth: got: %v, want: %v", conf.ClientAuth, testCase.wantauth)
+			}
+			if (conf.ClientCAs != nil) != testCase.wantclicas {
+				t.Fatalf("unexpected client CAs: got: %v, want set: %t", conf.ClientCAs, testCase.wantclicas)
+			}
+		})
+	}
+}
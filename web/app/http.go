@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	core "github.com/awesome-flow/flow/pkg/corev1alpha1"
+	"github.com/awesome-flow/flow/pkg/corev1alpha1/pipeline"
 	"github.com/awesome-flow/flow/pkg/types"
 	"github.com/awesome-flow/flow/web/app/agent"
 )
@@ -18,18 +19,22 @@ type HttpMux struct {
 
 var _ core.Runner = (*HttpMux)(nil)
 
-func NewHttpMux(ctx *core.Context) (*HttpMux, error) {
+func NewHttpMux(ctx *core.Context, ppl *pipeline.Pipeline) (*HttpMux, error) {
 	syscfg, ok := ctx.Config().Get(types.NewKey("system"))
 	if !ok {
 		return nil, fmt.Errorf("failed to get system config from the pipeline context")
 	}
 
+	if err := agent.LoadTemplates(); err != nil {
+		return nil, fmt.Errorf("failed to load admin templates: %s", err)
+	}
+
 	srvMx := http.NewServeMux()
 	regs := agent.AllAgentRegistrators()
 	agents := make(agent.WebAgents, 0, len(regs))
 
 	for _, ar := range regs {
-		wa, err := ar(ctx)
+		wa, err := ar(ctx, ppl)
 		if err != nil {
 			return nil, err
 		}
@@ -37,9 +42,26 @@ func NewHttpMux(ctx *core.Context) (*HttpMux, error) {
 		agents = append(agents, wa)
 	}
 
+	admincfg := syscfg.(types.CfgBlockSystem).Admin
+	tlsconf, err := buildAdminTLSConfig(admincfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure admin tls: %s", err)
+	}
+	auth, err := NewAdminAuth(admincfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure admin auth: %s", err)
+	}
+	var handler http.Handler = srvMx
+	if auth != nil {
+		handler = auth.Handler(srvMx)
+	} else {
+		ctx.Logger().Warn("admin interface on %s is not protected by any authentication", admincfg.Bind)
+	}
+
 	server := &http.Server{
-		Addr:    syscfg.(types.CfgBlockSystem).Admin.Bind,
-		Handler: srvMx,
+		Addr:      admincfg.Bind,
+		Handler:   handler,
+		TLSConfig: tlsconf,
 	}
 
 	return &HttpMux{
@@ -57,7 +79,13 @@ func (h *HttpMux) Start() error {
 		}
 	}
 	go func() {
-		if err := h.server.ListenAndServe(); err != nil {
+		var err error
+		if h.server.TLSConfig != nil {
+			err = h.server.ListenAndServeTLS("", "")
+		} else {
+			err = h.server.ListenAndServe()
+		}
+		if err != nil {
 			switch err {
 			case http.ErrServerClosed:
 				h.ctx.Logger().Info("admin server closed")